@@ -285,7 +285,7 @@ func (m *MemData) ListFlags(req engine.FlagRequest) (res []interface{}, err erro
 // and all site's details listing under the same function (and not to extend engine interface by two separate functions).
 func (m *MemData) UserDetail(req engine.UserDetailRequest) ([]engine.UserDetailEntry, error) {
 	switch req.Detail {
-	case engine.UserEmail:
+	case engine.UserEmail, engine.UserLinked:
 		if req.UserID == "" {
 			return nil, errors.New("userid cannot be empty in request for single detail")
 		}
@@ -359,6 +359,41 @@ func (m *MemData) deleteComment(loc store.Locator, id string, mode store.DeleteM
 	return m.updateComment(comments[0])
 }
 
+// Merge moves comments, votes, details and flags of user to another one
+func (m *MemData) Merge(req engine.MergeRequest) error {
+	if req.FromUserID == "" || req.ToUserID == "" || req.FromUserID == req.ToUserID {
+		return errors.Errorf("invalid merge request %+v", req)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	comments := m.posts[req.Locator.SiteID]
+	for i := range comments {
+		engine.MergeComment(&comments[i], req.FromUserID, req.ToUserID)
+	}
+
+	from, ok := m.metaUsers[req.FromUserID]
+	if !ok || from.SiteID != req.Locator.SiteID {
+		from = metaUser{}
+	}
+	to, ok := m.metaUsers[req.ToUserID]
+	if !ok || to.SiteID != req.Locator.SiteID {
+		to = metaUser{UserID: req.ToUserID, SiteID: req.Locator.SiteID}
+	}
+	if from.Blocked && from.BlockedUntil.After(to.BlockedUntil) {
+		to.Blocked, to.BlockedUntil = true, from.BlockedUntil
+	}
+	to.Verified = to.Verified || from.Verified
+	linked := to.Details.Linked
+	to.Details = engine.MergeUserDetails(to.Details, from.Details)
+	to.Details.UserID, to.Details.Linked = req.ToUserID, linked
+	m.metaUsers[req.ToUserID] = to
+	m.metaUsers[req.FromUserID] = metaUser{UserID: req.FromUserID, SiteID: req.Locator.SiteID,
+		Details: engine.UserDetailEntry{UserID: req.FromUserID, Linked: req.ToUserID}}
+	return nil
+}
+
 // Close store
 func (m *MemData) Close() error {
 	return nil
@@ -443,6 +478,8 @@ func (m *MemData) getUserDetail(req engine.UserDetailRequest) ([]engine.UserDeta
 		switch req.Detail {
 		case engine.UserEmail:
 			return []engine.UserDetailEntry{{UserID: req.UserID, Email: meta.Details.Email}}, nil
+		case engine.UserLinked:
+			return []engine.UserDetailEntry{{UserID: req.UserID, Linked: meta.Details.Linked}}, nil
 		}
 	}
 
@@ -460,19 +497,24 @@ func (m *MemData) setUserDetail(req engine.UserDetailRequest) ([]engine.UserDeta
 		entry = meta
 	}
 
-	if entry == (metaUser{}) {
+	if entry.UserID == "" {
 		entry = metaUser{
 			UserID:  req.UserID,
 			SiteID:  req.Locator.SiteID,
 			Details: engine.UserDetailEntry{UserID: req.UserID},
 		}
 	}
+	entry.Details.UserID = req.UserID
 
 	switch req.Detail {
 	case engine.UserEmail:
 		entry.Details.Email = req.Update
 		m.metaUsers[req.UserID] = entry
 		return []engine.UserDetailEntry{{UserID: req.UserID, Email: req.Update}}, nil
+	case engine.UserLinked:
+		entry.Details.Linked = req.Update
+		m.metaUsers[req.UserID] = entry
+		return []engine.UserDetailEntry{{UserID: req.UserID, Linked: req.Update}}, nil
 	}
 
 	return []engine.UserDetailEntry{}, nil
@@ -501,7 +543,7 @@ func (m *MemData) deleteUserDetail(locator store.Locator, userID string, userDet
 		entry = meta
 	}
 
-	if entry == (metaUser{}) || entry.Details == (engine.UserDetailEntry{}) {
+	if entry.UserID == "" || (entry.Details.Email == "" && entry.Details.Linked == "") {
 		// absent entry means that we should not do anything
 		return nil
 	}
@@ -509,11 +551,13 @@ func (m *MemData) deleteUserDetail(locator store.Locator, userID string, userDet
 	switch userDetail {
 	case engine.UserEmail:
 		entry.Details.Email = ""
+	case engine.UserLinked:
+		entry.Details.Linked = ""
 	case engine.AllUserDetails:
 		entry.Details = engine.UserDetailEntry{UserID: userID}
 	}
 
-	if entry.Details == (engine.UserDetailEntry{UserID: userID}) {
+	if entry.Details.Email == "" && entry.Details.Linked == "" {
 		// no user details are stored, empty details entry altogether
 		entry.Details = engine.UserDetailEntry{}
 	}
@@ -751,6 +751,32 @@ func TestMemAdmin_DeleteUserSoft(t *testing.T) {
 	assert.Equal(t, 0, len(comments), "nothing left")
 }
 
+func TestMemData_Merge(t *testing.T) {
+	b := prepMem(t)
+	site := store.Locator{SiteID: "radio-t"}
+	_, err := b.Flag(engine.FlagRequest{Flag: engine.Verified, Locator: site, UserID: "user1", Update: engine.FlagTrue})
+	require.NoError(t, err)
+	_, err = b.UserDetail(engine.UserDetailRequest{Locator: site, UserID: "user1", Detail: engine.UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Merge(engine.MergeRequest{Locator: site, FromUserID: "user1", ToUserID: "user2"}))
+
+	comments, err := b.Find(engine.FindRequest{Locator: site, UserID: "user2", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments), "comments of user1 moved")
+	verified, err := b.Flag(engine.FlagRequest{Flag: engine.Verified, Locator: site, UserID: "user2"})
+	require.NoError(t, err)
+	assert.True(t, verified)
+	res, err := b.UserDetail(engine.UserDetailRequest{Locator: site, UserID: "user2", Detail: engine.UserEmail})
+	require.NoError(t, err)
+	assert.Equal(t, []engine.UserDetailEntry{{UserID: "user2", Email: "u1@example.com"}}, res)
+	res, err = b.UserDetail(engine.UserDetailRequest{Locator: site, UserID: "user1", Detail: engine.UserLinked})
+	require.NoError(t, err)
+	assert.Equal(t, []engine.UserDetailEntry{{UserID: "user1", Linked: "user2"}}, res)
+
+	assert.Error(t, b.Merge(engine.MergeRequest{Locator: site, FromUserID: "user2", ToUserID: "user2"}))
+}
+
 func prepMem(t *testing.T) *MemData {
 
 	m := NewMemData()
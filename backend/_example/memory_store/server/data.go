@@ -116,6 +116,16 @@ func (s *RPC) deleteHndl(id uint64, params json.RawMessage) (rr jrpc.Response) {
 	return jrpc.EncodeResponse(id, nil, err)
 }
 
+// merge user into another one
+func (s *RPC) mergeHndl(id uint64, params json.RawMessage) (rr jrpc.Response) {
+	req := engine.MergeRequest{}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return jrpc.Response{Error: err.Error()}
+	}
+	err := s.eng.Merge(req)
+	return jrpc.EncodeResponse(id, nil, err)
+}
+
 // close store
 func (s *RPC) closeHndl(_ uint64, _ json.RawMessage) (rr jrpc.Response) {
 	if err := s.eng.Close(); err != nil {
@@ -43,6 +43,7 @@ func (s *RPC) addHandlers() {
 		"list_flags":  s.listFlagsHndl,
 		"user_detail": s.userDetailHndl,
 		"delete":      s.deleteHndl,
+		"merge":       s.mergeHndl,
 		"close":       s.closeHndl,
 	})
 
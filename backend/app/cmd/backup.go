@@ -19,14 +19,18 @@ type BackupCommand struct {
 	ExportFile  string        `short:"f" long:"file" default:"userbackup-{{.SITE}}-{{.TS}}.gz" description:"file name"`
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"export (backup) timeout"`
-	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" description:"admin basic auth password"`
+	AdminToken  string        `long:"admin-token" env:"ADMIN_TOKEN" description:"api token, used instead of admin password"`
 	CommonOpts
 }
 
 // Execute runs export with ExportCommand parameters, entry point for "export" command
 func (ec *BackupCommand) Execute(_ []string) error {
 	log.Printf("[INFO] export to %s, site %s", ec.ExportPath, ec.Site)
-	resetEnv("SECRET", "ADMIN_PASSWD")
+	resetEnv("SECRET", "ADMIN_PASSWD", "ADMIN_TOKEN")
+	if err := checkAdminAuth(ec.AdminPasswd, ec.AdminToken); err != nil {
+		return err
+	}
 
 	fp := fileParser{site: ec.Site, path: ec.ExportPath, file: ec.ExportFile}
 	fname, err := fp.parse(time.Now())
@@ -45,7 +49,7 @@ func (ec *BackupCommand) Execute(_ []string) error {
 	if err != nil {
 		return errors.Wrapf(err, "can't make export request for %s", exportURL)
 	}
-	req.SetBasicAuth("admin", ec.AdminPasswd)
+	setAdminAuth(req, ec.AdminPasswd, ec.AdminToken)
 
 	// get with timeout
 	resp, err := client.Do(req.WithContext(ctx))
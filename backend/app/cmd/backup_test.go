@@ -73,3 +73,29 @@ func TestBackup_ExecuteFailedWrite(t *testing.T) {
 	err = cmd.Execute(nil)
 	assert.EqualError(t, err, `can't create backup file /tmp/no-such-dir/remark-test.export: open /tmp/no-such-dir/remark-test.export: no such file or directory`)
 }
+
+func TestBackup_ExecuteWithToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "token", user)
+		assert.Equal(t, "id1.secret", passwd)
+		fmt.Fprint(w, "blah\n")
+	}))
+	defer ts.Close()
+
+	cmd := BackupCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--path=/tmp", "--file={{.SITE}}-token.export", "--admin-token=id1.secret"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+	defer os.Remove("/tmp/remark-token.export")
+
+	cmd = BackupCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=/tmp", "--file={{.SITE}}-token.export"})
+	require.NoError(t, err)
+	assert.EqualError(t, cmd.Execute(nil), "admin password or api token required")
+}
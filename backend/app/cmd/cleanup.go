@@ -22,7 +22,8 @@ type CleanupCommand struct {
 	To          string   `long:"to" description:"from yyyymmdd"`
 	BadWords    []string `short:"w" long:"bword" description:"bad word(s)"`
 	BadUsers    []string `short:"u" long:"buser" description:"bad user(s)"`
-	AdminPasswd string   `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	AdminPasswd string   `long:"admin-passwd" env:"ADMIN_PASSWD" description:"admin basic auth password"`
+	AdminToken  string   `long:"admin-token" env:"ADMIN_TOKEN" description:"api token, used instead of admin password"`
 	SetTitle    bool     `long:"title" description:"title mode, will not remove comments, but reset titles to page's title'"`
 	CommonOpts
 }
@@ -36,6 +37,9 @@ var (
 // This command uses provided flags to detect and remove junk comments
 func (cc *CleanupCommand) Execute(_ []string) error {
 	log.Printf("[INFO] cleanup for site %s", cc.Site)
+	if err := checkAdminAuth(cc.AdminPasswd, cc.AdminToken); err != nil {
+		return err
+	}
 
 	posts, err := cc.postsInRange(cc.From, cc.To)
 	if err != nil {
@@ -197,7 +201,7 @@ func (cc *CleanupCommand) deleteComment(c store.Comment) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to make delete request for comment %s, %s", c.ID, c.Locator.URL)
 	}
-	req.SetBasicAuth("admin", cc.AdminPasswd)
+	setAdminAuth(req, cc.AdminPasswd, cc.AdminToken)
 
 	client := http.Client{}
 	r, err := client.Do(req)
@@ -219,7 +223,7 @@ func (cc *CleanupCommand) setTitle(c store.Comment) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to make title request for comment %s, %s", c.ID, c.Locator.URL)
 	}
-	req.SetBasicAuth("admin", cc.AdminPasswd)
+	setAdminAuth(req, cc.AdminPasswd, cc.AdminToken)
 
 	client := http.Client{}
 	r, err := client.Do(req)
@@ -101,6 +101,23 @@ func resetEnv(envs ...string) {
 	}
 }
 
+// checkAdminAuth verifies credentials for admin api, either admin password or api token required
+func checkAdminAuth(passwd, apiToken string) error {
+	if passwd == "" && apiToken == "" {
+		return errors.New("admin password or api token required")
+	}
+	return nil
+}
+
+// setAdminAuth sets basic auth of the request to admin api, api token preferred over admin password
+func setAdminAuth(req *http.Request, passwd, apiToken string) {
+	if apiToken != "" {
+		req.SetBasicAuth("token", apiToken)
+		return
+	}
+	req.SetBasicAuth("admin", passwd)
+}
+
 // responseError returns error with status and response body
 func responseError(resp *http.Response) error {
 	body, e := ioutil.ReadAll(resp.Body)
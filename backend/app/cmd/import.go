@@ -21,14 +21,18 @@ type ImportCommand struct {
 	Provider    string        `short:"p" long:"provider" default:"disqus" choice:"disqus" choice:"wordpress" description:"import format"` //nolint
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"import timeout"`
-	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" description:"admin basic auth password"`
+	AdminToken  string        `long:"admin-token" env:"ADMIN_TOKEN" description:"api token, used instead of admin password"`
 	CommonOpts
 }
 
 // Execute runs import with ImportCommand parameters, entry point for "import" command
 func (ic *ImportCommand) Execute(_ []string) error {
 	log.Printf("[INFO] import %s (%s), site %s", ic.InputFile, ic.Provider, ic.Site)
-	resetEnv("SECRET", "ADMIN_PASSWD")
+	resetEnv("SECRET", "ADMIN_PASSWD", "ADMIN_TOKEN")
+	if err := checkAdminAuth(ic.AdminPasswd, ic.AdminToken); err != nil {
+		return err
+	}
 
 	reader, err := ic.reader(ic.InputFile)
 	if err != nil {
@@ -43,7 +47,7 @@ func (ic *ImportCommand) Execute(_ []string) error {
 	if err != nil {
 		return errors.Wrapf(err, "can't make import request for %s", importURL)
 	}
-	req.SetBasicAuth("admin", ic.AdminPasswd)
+	setAdminAuth(req, ic.AdminPasswd, ic.AdminToken)
 
 	resp, err := client.Do(req.WithContext(ctx)) // closes request's reader
 	if err != nil {
@@ -17,7 +17,8 @@ import (
 type RemapCommand struct {
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
 	InputFile   string        `short:"f" long:"file" description:"input file name" required:"true"`
-	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" description:"admin basic auth password"`
+	AdminToken  string        `long:"admin-token" env:"ADMIN_TOKEN" description:"api token, used instead of admin password"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"remap timeout"`
 	CommonOpts
 }
@@ -25,7 +26,10 @@ type RemapCommand struct {
 // Execute runs (re)mapper with RemapCommand parameters, entry point for "remap" command
 func (rc *RemapCommand) Execute(_ []string) error {
 	log.Printf("[INFO] start remap, site %s, file with rules %s", rc.Site, rc.InputFile)
-	resetEnv("SECRET", "ADMIN_PASSWD")
+	resetEnv("SECRET", "ADMIN_PASSWD", "ADMIN_TOKEN")
+	if err := checkAdminAuth(rc.AdminPasswd, rc.AdminToken); err != nil {
+		return err
+	}
 
 	rulesReader, err := os.Open(rc.InputFile)
 	if err != nil {
@@ -40,7 +44,7 @@ func (rc *RemapCommand) Execute(_ []string) error {
 	if err != nil {
 		return errors.Wrapf(err, "can't make remap request for %s", remapURL)
 	}
-	req.SetBasicAuth("admin", rc.AdminPasswd)
+	setAdminAuth(req, rc.AdminPasswd, rc.AdminToken)
 
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
@@ -13,7 +13,8 @@ type RestoreCommand struct {
 
 	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
 	Timeout     time.Duration `long:"timeout" default:"15m" description:"import timeout"`
-	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" description:"admin basic auth password"`
+	AdminToken  string        `long:"admin-token" env:"ADMIN_TOKEN" description:"api token, used instead of admin password"`
 	CommonOpts
 }
 
@@ -21,7 +22,7 @@ type RestoreCommand struct {
 // uses ImportCommand with constructed full file name
 func (rc *RestoreCommand) Execute(args []string) error {
 	log.Printf("[INFO] restore %s, site %s", rc.ImportFile, rc.Site)
-	resetEnv("SECRET", "ADMIN_PASSWD")
+	resetEnv("SECRET", "ADMIN_PASSWD", "ADMIN_TOKEN")
 
 	fp := fileParser{site: rc.Site, path: rc.ImportPath, file: rc.ImportFile}
 	fname, err := fp.parse(time.Now())
@@ -34,6 +35,7 @@ func (rc *RestoreCommand) Execute(args []string) error {
 		Provider:    "native",
 		Timeout:     rc.Timeout,
 		AdminPasswd: rc.AdminPasswd,
+		AdminToken:  rc.AdminToken,
 		CommonOpts:  rc.CommonOpts,
 	}
 	return importer.Execute(args)
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -10,6 +11,7 @@ import (
 	"os/signal"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -24,6 +26,7 @@ import (
 
 	"github.com/go-pkgz/auth"
 	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/middleware"
 	"github.com/go-pkgz/auth/provider"
 	"github.com/go-pkgz/auth/provider/sender"
 	"github.com/go-pkgz/auth/token"
@@ -32,12 +35,17 @@ import (
 	"github.com/umputun/remark42/backend/app/migrator"
 	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest/api"
+	"github.com/umputun/remark42/backend/app/rest/mastodon"
+	"github.com/umputun/remark42/backend/app/rest/oidc"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/apitoken"
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/store/spam"
+	"github.com/umputun/remark42/backend/app/store/trust"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -53,6 +61,10 @@ type ServerCommand struct {
 	Image      ImageGroup      `group:"image" namespace:"image" env-namespace:"IMAGE"`
 	SSL        SSLGroup        `group:"ssl" namespace:"ssl" env-namespace:"SSL"`
 	ImageProxy ImageProxyGroup `group:"image-proxy" namespace:"image-proxy" env-namespace:"IMAGE_PROXY"`
+	Trust      TrustGroup      `group:"trust" namespace:"trust" env-namespace:"TRUST"`
+	Spam       SpamGroup       `group:"spam" namespace:"spam" env-namespace:"SPAM"`
+	Reactions  ReactionsGroup  `group:"reactions" namespace:"reactions" env-namespace:"REACTIONS"`
+	APITokens  APITokensGroup  `group:"api-tokens" namespace:"api-tokens" env-namespace:"API_TOKENS"`
 
 	Sites            []string      `long:"site" env:"SITE" default:"remark" description:"site names" env-delim:","`
 	AnonymousVote    bool          `long:"anon-vote" env:"ANON_VOTE" description:"enable anonymous votes (works only with VOTES_IP enabled)"`
@@ -70,6 +82,7 @@ type ServerCommand struct {
 	ReadOnlyAge      int           `long:"read-age" env:"READONLY_AGE" default:"0" description:"read-only age of comments, days"`
 	EditDuration     time.Duration `long:"edit-time" env:"EDIT_TIME" default:"5m" description:"edit window"`
 	AdminEdit        bool          `long:"admin-edit" env:"ADMIN_EDIT" description:"unlimited edit for admins"`
+	LastSeenTTL      time.Duration `long:"last-seen-ttl" env:"LAST_SEEN_TTL" default:"2160h" description:"how long to keep user's last visit of the post"`
 	Port             int           `long:"port" env:"REMARK_PORT" default:"8080" description:"port"`
 	Address          string        `long:"address" env:"REMARK_ADDRESS" default:"" description:"listening address"`
 	WebRoot          string        `long:"web-root" env:"REMARK_WEB_ROOT" default:"./web" description:"web root directory"`
@@ -98,6 +111,7 @@ type ServerCommand struct {
 		Twitter   AuthGroup `group:"twitter" namespace:"twitter" env-namespace:"TWITTER" description:"Twitter OAuth"`
 		Dev       bool      `long:"dev" env:"DEV" description:"enable dev (local) oauth2"`
 		Anonymous bool      `long:"anon" env:"ANON" description:"enable anonymous login"`
+		OIDC      string    `long:"oidc" env:"OIDC" description:"JSON file with OpenID Connect providers"`
 		Email     struct {
 			Enable       bool          `long:"enable" env:"ENABLE" description:"enable auth via email"`
 			From         string        `long:"from" env:"FROM" description:"from email address"`
@@ -111,12 +125,22 @@ type ServerCommand struct {
 			TimeOut      time.Duration `long:"timeout" env:"TIMEOUT" default:"10s" description:"[deprecated, use --smtp.timeout] SMTP TCP connection timeout"`
 			MsgTemplate  string        `long:"template" env:"TEMPLATE" description:"[deprecated] message template file" default:"email_confirmation_login.html.tmpl"`
 		} `group:"email" namespace:"email" env-namespace:"EMAIL"`
+		Mastodon struct {
+			Enable    bool     `long:"enable" env:"ENABLE" description:"enable login with Mastodon instances"`
+			Instances []string `long:"instance" env:"INSTANCES" description:"instances allowed for login, any if not set" env-delim:","`
+			File      string   `long:"file" env:"FILE" default:"./var/mastodon.db" description:"registered mastodon apps file location"`
+		} `group:"mastodon" namespace:"mastodon" env-namespace:"MASTODON"`
+		SSO struct {
+			Enable bool          `long:"enable" env:"ENABLE" description:"enable login with tokens issued by sites"`
+			MaxAge time.Duration `long:"max-age" env:"MAX_AGE" default:"5m" description:"max lifetime of tokens issued by sites"`
+		} `group:"sso" namespace:"sso" env-namespace:"SSO"`
 	} `group:"auth" namespace:"auth" env-namespace:"AUTH"`
 
 	CommonOpts
 
 	emailMsgTemplatePath          string // used only in tests
 	emailVerificationTemplatePath string // used only in tests
+	emailDigestTemplatePath       string // used only in tests
 }
 
 // ImageProxyGroup defines options group for image proxy
@@ -125,6 +149,44 @@ type ImageProxyGroup struct {
 	CacheExternal bool `long:"cache-external" env:"CACHE_EXTERNAL" description:"enable caching for external images"`
 }
 
+// TrustGroup defines options group for users trust levels
+type TrustGroup struct {
+	Enabled       bool          `long:"enabled" env:"ENABLED" description:"enable trust levels"`
+	Premoderation bool          `long:"premoderation" env:"PREMODERATION" description:"premoderate comments from new users"`
+	EditDuration  time.Duration `long:"edit-time" env:"EDIT_TIME" default:"1h" description:"edit window for trusted users"`
+	Bolt          struct {
+		File string `long:"file" env:"FILE" default:"./var/trust.db" description:"trust levels bolt file location"`
+	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+}
+
+// SpamGroup defines options group for spam classifier
+type SpamGroup struct {
+	Enabled   bool          `long:"enabled" env:"ENABLED" description:"enable spam classifier"`
+	Threshold float64       `long:"threshold" env:"THRESHOLD" default:"0.9" description:"spam probability to hold comment for review"`
+	MinDocs   int           `long:"min-docs" env:"MIN_DOCS" default:"10" description:"min number of spam and ham comments to start classification"`
+	HamAge    time.Duration `long:"ham-age" env:"HAM_AGE" default:"168h" description:"age of not deleted comment to train as ham"`
+	Bolt      struct {
+		File string `long:"file" env:"FILE" default:"./var/spam.db" description:"spam classifier bolt file location"`
+	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+}
+
+// APITokensGroup defines options group for api tokens
+type APITokensGroup struct {
+	Enabled   bool          `long:"enabled" env:"ENABLED" description:"enable api tokens for admin automation"`
+	MaxTTL    time.Duration `long:"max-ttl" env:"MAX_TTL" default:"8760h" description:"max lifetime of api token"`
+	AuditSize int           `long:"audit-size" env:"AUDIT_SIZE" default:"10000" description:"max number of audit records per site"`
+	Bolt      struct {
+		File string `long:"file" env:"FILE" default:"./var/tokens.db" description:"api tokens bolt file location"`
+	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+}
+
+// ReactionsGroup defines options group for emoji reactions
+type ReactionsGroup struct {
+	Emoji []string          `long:"emoji" env:"EMOJI" description:"reactions allowed for all sites" env-delim:","`
+	Sites map[string]string `long:"site" env:"SITE" description:"reactions for the site, site-id:emoji,emoji" env-delim:";"`
+	Max   int               `long:"max" env:"MAX" default:"3" description:"max number of reactions from a user per comment"`
+}
+
 // AuthGroup defines options group for auth params
 type AuthGroup struct {
 	CID  string `long:"cid" env:"CID" description:"OAuth client ID"`
@@ -210,25 +272,62 @@ type SMTPGroup struct {
 
 // NotifyGroup defines options for notification
 type NotifyGroup struct {
-	Type      []string `long:"type" env:"TYPE" description:"[deprecated, use user and admin types instead] types of notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" default:"none" env-delim:","` //nolint
-	Users     []string `long:"users" env:"USERS" description:"types of user notifications" choice:"none" choice:"email" default:"none" env-delim:","`                                                                          //nolint
-	Admins    []string `long:"admins" env:"ADMINS" description:"types of admin notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" default:"none" env-delim:","`                                      //nolint
-	QueueSize int      `long:"queue" env:"QUEUE" description:"size of notification queue" default:"100"`
-	Telegram  struct {
+	Type         []string      `long:"type" env:"TYPE" description:"[deprecated, use user and admin types instead] types of notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" default:"none" env-delim:","`              //nolint
+	Users        []string      `long:"users" env:"USERS" description:"types of user notifications" choice:"none" choice:"email" choice:"telegram" choice:"webpush" choice:"inbox" default:"none" env-delim:","`                                     //nolint
+	Admins       []string      `long:"admins" env:"ADMINS" description:"types of admin notifications" choice:"none" choice:"telegram" choice:"email" choice:"slack" choice:"webhook" choice:"discord" choice:"matrix" default:"none" env-delim:","` //nolint
+	QueueSize    int           `long:"queue" env:"QUEUE" description:"size of notification queue" default:"100"`
+	QueueFile    string        `long:"queue_file" env:"QUEUE_FILE" description:"persistent notification queue bolt file location, in-memory queue used if empty"`
+	Retries      int           `long:"retries" env:"RETRIES" default:"5" description:"delivery attempts for persistent queue before notification marked failed"`
+	RetryBackoff time.Duration `long:"retry_backoff" env:"RETRY_BACKOFF" default:"30s" description:"delay before the first retry of failed notification, doubled for each next one"`
+	Filters      string        `long:"filters" env:"FILTERS" description:"JSON file with admin notification filter rules per destination"`
+	Telegram     struct {
 		Channel string        `long:"chan" env:"CHAN" description:"telegram channel for admin notifications"`
 		API     string        `long:"api" env:"API" default:"https://api.telegram.org/bot" description:"[deprecated, not used] telegram api prefix"`
 		Token   string        `long:"token" env:"TOKEN" description:"[deprecated, use --telegram.token] telegram token"`
 		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"[deprecated, use --telegram.timeout] telegram timeout"`
+		Buttons bool          `long:"buttons" env:"BUTTONS" description:"add moderation buttons to admin notifications"`
+		Admins  []string      `long:"admin" env:"ADMIN" description:"telegram user allowed to moderate as site admin, telegram-id:user-id" env-delim:","`
+		File    string        `long:"file" env:"FILE" default:"./var/telegram.db" description:"telegram moderation buttons and audit bolt file location"`
 	} `group:"telegram" namespace:"telegram" env-namespace:"TELEGRAM"`
 	Email struct {
 		From                string `long:"from_address" env:"FROM" description:"from email address"`
 		VerificationSubject string `long:"verification_subj" env:"VERIFICATION_SUBJ" description:"verification message subject"`
 		AdminNotifications  bool   `long:"notify_admin" env:"ADMIN" description:"[deprecated, use --notify.admins=email] notify admin on new comments via ADMIN_SHARED_EMAIL"`
+		DigestFile          string `long:"digest_file" env:"DIGEST_FILE" default:"./var/digest.db" description:"pending email digests bolt file location"`
+		TemplatesDir        string `long:"templates_dir" env:"TEMPLATES_DIR" description:"directory with email templates overriding the default ones per site and locale"`
 	} `group:"email" namespace:"email" env-namespace:"EMAIL"`
 	Slack struct {
 		Token   string `long:"token" env:"TOKEN" description:"slack token"`
 		Channel string `long:"chan" env:"CHAN" description:"slack channel"`
 	} `group:"slack" namespace:"slack" env-namespace:"SLACK"`
+	Discord struct {
+		Webhook string        `long:"webhook" env:"WEBHOOK" description:"discord channel incoming webhook url"`
+		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"discord request timeout"`
+	} `group:"discord" namespace:"discord" env-namespace:"DISCORD"`
+	Matrix struct {
+		Server  string        `long:"server" env:"SERVER" description:"matrix homeserver url"`
+		Token   string        `long:"token" env:"TOKEN" description:"matrix access token of the bot user"`
+		Room    string        `long:"room" env:"ROOM" description:"matrix room id or alias for admin notifications"`
+		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"matrix request timeout"`
+	} `group:"matrix" namespace:"matrix" env-namespace:"MATRIX"`
+	Inbox struct {
+		File string `long:"file" env:"FILE" default:"./var/inbox.db" description:"users' notifications inbox bolt file location"`
+		Max  int    `long:"max" env:"MAX" default:"500" description:"max notifications kept per user"`
+	} `group:"inbox" namespace:"inbox" env-namespace:"INBOX"`
+	WebPush struct {
+		PublicKey  string        `long:"public_key" env:"PUBLIC_KEY" description:"VAPID public key, generated by vapid command"`
+		PrivateKey string        `long:"private_key" env:"PRIVATE_KEY" description:"VAPID private key, generated by vapid command"`
+		Subject    string        `long:"subject" env:"SUBJECT" description:"contact for push services, mailto: or https: url, remark url if not set"`
+		TTL        time.Duration `long:"ttl" env:"TTL" default:"24h" description:"time for push service to keep undelivered notification"`
+		Timeout    time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"web push request timeout"`
+		Hosts      []string      `long:"host" env:"HOST" env-delim:"," default:"fcm.googleapis.com" default:"updates.push.services.mozilla.com" default:"push.apple.com" default:"notify.windows.com" description:"push service allowed as subscription endpoint, with subdomains"` //nolint
+	} `group:"webpush" namespace:"webpush" env-namespace:"WEBPUSH"`
+	Webhook struct {
+		Hooks   []string      `long:"hook" env:"HOOK" env-delim:"," description:"webhook as site:event1+event2:url, * for all sites or events"`
+		Secret  string        `long:"secret" env:"SECRET" description:"secret key for HMAC-SHA256 signature of webhook requests"`
+		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"webhook request timeout"`
+		Retries int           `long:"retries" env:"RETRIES" default:"3" description:"attempts for each webhook request, single attempt with the notification queue"`
+	} `group:"webhook" namespace:"webhook" env-namespace:"WEBHOOK"`
 }
 
 // SSLGroup defines options group for server ssl params
@@ -266,10 +365,17 @@ type serverApp struct {
 	dataService   *service.DataStore
 	avatarStore   avatar.Store
 	notifyService *notify.Service
+	inboxStore    *notify.BoltInbox
+	apiTokens     *apitoken.Service
+	mastodonApps  *mastodon.BoltApps
 	imageService  *image.Service
 	authenticator *auth.Service
 	terminated    chan struct{}
 
+	telegramService   *notify.Telegram       // set when telegram notifications enabled
+	telegramModerator *api.TelegramModerator // handles presses of telegram moderation buttons
+	telegramStore     *notify.BoltTelegram   // comments with telegram moderation buttons and audit of presses
+
 	authRefreshCache *authRefreshCache // stored only to close it properly on shutdown
 }
 
@@ -416,6 +522,16 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 	}
 	log.Printf("[DEBUG] image service for url=%s, EditDuration=%v", imageService.ImageAPI, imageService.EditDuration)
 
+	trustService, err := s.makeTrust()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make trust service")
+	}
+
+	spamService, err := s.makeSpam()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make spam classifier")
+	}
+
 	dataService := &service.DataStore{
 		Engine:                 storeEngine,
 		EditDuration:           s.EditDuration,
@@ -425,6 +541,11 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		MaxVotes:               s.MaxVotes,
 		PositiveScore:          s.PositiveScore,
 		ImageService:           imageService,
+		Trust:                  trustService,
+		Spam:                   spamService,
+		ReactionsLister:        s.makeReactionsLister(),
+		MaxUserReactions:       s.Reactions.Max,
+		LastSeenTTL:            s.LastSeenTTL,
 		TitleExtractor:         service.NewTitleExtractor(http.Client{Timeout: time.Second * 5}),
 		RestrictedWordsMatcher: service.NewRestrictedWordsMatcher(service.StaticRestrictedWordsLister{Words: s.RestrictedWords}),
 	}
@@ -442,8 +563,28 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		_ = dataService.Close()
 		return nil, errors.Wrap(err, "failed to make avatar store")
 	}
-	authRefreshCache := newAuthRefreshCache()
-	authenticator, err := s.makeAuthenticator(dataService, avatarStore, adminStore, authRefreshCache)
+	var ssoService *api.SSO
+	if s.Auth.SSO.Enable {
+		ssoService = api.NewSSO(adminStore, s.Auth.SSO.MaxAge)
+		log.Printf("[INFO] sso enabled, max token age %v", s.Auth.SSO.MaxAge)
+	}
+	apiTokens, err := s.makeAPITokens()
+	if err != nil {
+		_ = dataService.Close()
+		return nil, errors.Wrap(err, "failed to make api tokens")
+	}
+	authRefreshCache, err := s.makeAuthRefreshCache()
+	if err != nil {
+		_ = dataService.Close()
+		return nil, errors.Wrap(err, "failed to make auth refresh cache")
+	}
+	mastodonApps, err := s.makeMastodonApps()
+	if err != nil {
+		_ = dataService.Close()
+		return nil, errors.Wrap(err, "failed to make mastodon apps store")
+	}
+	authenticator, err := s.makeAuthenticator(dataService, avatarStore, adminStore, authRefreshCache, ssoService, apiTokens,
+		mastodonApps)
 	if err != nil {
 		_ = dataService.Close()
 		return nil, errors.Wrap(err, "failed to make authenticator")
@@ -461,8 +602,14 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		KeyStore:          adminStore,
 	}
 
+	inboxStore, err := s.makeInbox()
+	if err != nil {
+		_ = dataService.Close()
+		return nil, errors.Wrap(err, "failed to make notifications inbox")
+	}
+
 	var emailNotifications bool
-	notifyService, err := s.makeNotify(dataService, authenticator)
+	notifyService, notifyDests, err := s.makeNotify(dataService, authenticator, inboxStore)
 
 	if contains("email", s.Notify.Users) {
 		emailNotifications = true
@@ -473,6 +620,7 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		notifyService = notify.NopService // disable notifier
 		emailNotifications = false        // email notifications are not available in this case
 	}
+	telegramService := notifyDests.telegram
 
 	imgProxy := &proxy.Image{
 		HTTP2HTTPS:    s.ImageProxy.HTTP2HTTPS,
@@ -485,7 +633,7 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 	if s.EnableEmoji {
 		emojiFmt = func(text string) string { return emoji.Sprint(text) }
 	}
-	commentFormatter := store.NewCommentFormatter(imgProxy, emojiFmt)
+	commentFormatter := store.NewCommentFormatter(imgProxy, emojiFmt).WithMentions(dataService)
 
 	sslConfig, err := s.makeSSLConfig()
 	if err != nil {
@@ -516,9 +664,40 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		ProxyCORS:          s.ProxyCORS,
 		AllowedAncestors:   s.AllowedHosts,
 		SendJWTHeader:      s.Auth.SendJWTHeader,
+		SSO:                ssoService,
+		Sessions:           authRefreshCache,
 	}
 
 	srv.ScoreThresholds.Low, srv.ScoreThresholds.Critical = s.LowScore, s.CriticalScore
+	if telegramService != nil && telegramService.UserNotifications {
+		srv.TelegramService = telegramService
+	}
+	if notifyService != notify.NopService && contains("webpush", s.Notify.Users) {
+		srv.WebPushPublicKey = s.Notify.WebPush.PublicKey
+		srv.WebPushHosts = s.Notify.WebPush.Hosts
+	}
+	if notifyService != notify.NopService && inboxStore != nil {
+		srv.Inbox = inboxStore
+	}
+	if notifyService != notify.NopService && notifyDests.email != nil {
+		srv.EmailPreview = notifyDests.email
+	}
+	if apiTokens != nil {
+		srv.APITokens = apiTokens
+	}
+	if notifyDests.telegramStore != nil {
+		srv.TelegramAudit = notifyDests.telegramStore
+	}
+
+	var telegramModerator *api.TelegramModerator
+	if telegramService != nil && telegramService.AdminButtons {
+		tgAdmins, errAdmins := s.telegramAdmins()
+		if errAdmins != nil {
+			_ = dataService.Close()
+			return nil, errors.Wrap(errAdmins, "can't make telegram moderator")
+		}
+		telegramModerator = api.NewTelegramModerator(dataService, loadingCache, notifyService, tgAdmins)
+	}
 
 	var devAuth *provider.DevAuthServer
 	if s.Auth.Dev {
@@ -539,10 +718,17 @@ func (s *ServerCommand) newServerApp() (*serverApp, error) {
 		dataService:      dataService,
 		avatarStore:      avatarStore,
 		notifyService:    notifyService,
+		inboxStore:       inboxStore,
+		apiTokens:        apiTokens,
+		mastodonApps:     mastodonApps,
 		imageService:     imageService,
 		authenticator:    authenticator,
 		terminated:       make(chan struct{}),
 		authRefreshCache: authRefreshCache,
+
+		telegramService:   telegramService,
+		telegramModerator: telegramModerator,
+		telegramStore:     notifyDests.telegramStore,
 	}, nil
 }
 
@@ -569,8 +755,29 @@ func (a *serverApp) run(ctx context.Context) error {
 		log.Printf("[WARN] failed to resubmit comments with staging images, %s", e)
 	}
 
+	// trust stats could miss comments imported or made before trust levels were enabled, rebuilt only if outdated
+	if e := a.dataService.RebuildTrust(a.Sites); e != nil {
+		log.Printf("[WARN] failed to rebuild trust stats, %s", e)
+	}
+
+	// comments survived long enough are the only source of ham for spam classifier, scans all comments in background
+	go func() {
+		if e := a.dataService.TrainSpam(ctx, a.Sites, a.Spam.HamAge); e != nil {
+			log.Printf("[WARN] failed to train spam classifier, %s", e)
+		}
+	}()
+
 	go a.imageService.Cleanup(ctx) // pictures cleanup for staging images
 
+	if a.telegramService != nil && (a.telegramService.UserNotifications || a.telegramService.AdminButtons) {
+		// single reader of bot updates, for account links of users and moderation buttons of admin notifications
+		var moderator notify.TelegramModerator
+		if a.telegramModerator != nil {
+			moderator = a.telegramModerator
+		}
+		go a.telegramService.Run(ctx, moderator)
+	}
+
 	a.restSrv.Run(a.Address, a.Port)
 
 	// shutdown procedures after HTTP server is stopped
@@ -590,6 +797,26 @@ func (a *serverApp) run(ctx context.Context) error {
 		log.Printf("[WARN] failed to close auth authRefreshCache, %s", e)
 	}
 	a.notifyService.Close()
+	if a.inboxStore != nil {
+		if e := a.inboxStore.Close(); e != nil {
+			log.Printf("[WARN] failed to close notifications inbox, %s", e)
+		}
+	}
+	if a.telegramStore != nil {
+		if e := a.telegramStore.Close(); e != nil {
+			log.Printf("[WARN] failed to close telegram store, %s", e)
+		}
+	}
+	if a.apiTokens != nil {
+		if e := a.apiTokens.Close(); e != nil {
+			log.Printf("[WARN] failed to close api tokens, %s", e)
+		}
+	}
+	if a.mastodonApps != nil {
+		if e := a.mastodonApps.Close(); e != nil {
+			log.Printf("[WARN] failed to close mastodon apps, %s", e)
+		}
+	}
 	// call potentially infinite loop with cancellation after a minute as a safeguard
 	minuteCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
@@ -703,6 +930,83 @@ func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 	return nil, errors.Errorf("unsupported pictures store type %s", s.Image.Type)
 }
 
+// makeTrust creates trust levels service, nil if trust levels disabled
+func (s *ServerCommand) makeTrust() (*trust.Service, error) {
+	if !s.Trust.Enabled {
+		return nil, nil
+	}
+	log.Printf("[INFO] make trust service, premoderation=%v", s.Trust.Premoderation)
+	if err := makeDirs(path.Dir(s.Trust.Bolt.File)); err != nil {
+		return nil, errors.Wrap(err, "failed to create trust store")
+	}
+	trustStore, err := trust.NewBoltStorage(s.Trust.Bolt.File, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &trust.Service{
+		Store:         trustStore,
+		Premoderation: s.Trust.Premoderation,
+		EditDuration:  s.Trust.EditDuration,
+	}, nil
+}
+
+// makeAPITokens creates api tokens service, nil if api tokens disabled
+func (s *ServerCommand) makeAPITokens() (*apitoken.Service, error) {
+	if !s.APITokens.Enabled {
+		return nil, nil
+	}
+	log.Printf("[INFO] make api tokens service, max ttl=%v", s.APITokens.MaxTTL)
+	if err := makeDirs(path.Dir(s.APITokens.Bolt.File)); err != nil {
+		return nil, errors.Wrap(err, "failed to create api tokens store")
+	}
+	tokensStore, err := apitoken.NewBoltStorage(s.APITokens.Bolt.File, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	tokensStore.AuditSize = s.APITokens.AuditSize
+	return &apitoken.Service{Store: tokensStore, MaxTTL: s.APITokens.MaxTTL}, nil
+}
+
+// makeMastodonApps creates store of apps registered on mastodon instances, nil if mastodon login disabled
+func (s *ServerCommand) makeMastodonApps() (*mastodon.BoltApps, error) {
+	if !s.Auth.Mastodon.Enable {
+		return nil, nil
+	}
+	if err := makeDirs(path.Dir(s.Auth.Mastodon.File)); err != nil {
+		return nil, errors.Wrap(err, "failed to create mastodon apps store")
+	}
+	return mastodon.NewBoltApps(s.Auth.Mastodon.File, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+}
+
+// makeSpam creates spam classifier, nil if disabled
+func (s *ServerCommand) makeSpam() (*spam.Service, error) {
+	if !s.Spam.Enabled {
+		return nil, nil
+	}
+	log.Printf("[INFO] make spam classifier, threshold=%.2f", s.Spam.Threshold)
+	if err := makeDirs(path.Dir(s.Spam.Bolt.File)); err != nil {
+		return nil, errors.Wrap(err, "failed to create spam store")
+	}
+	spamStore, err := spam.NewBoltStorage(s.Spam.Bolt.File, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &spam.Service{Store: spamStore, Threshold: s.Spam.Threshold, MinDocs: s.Spam.MinDocs}, nil
+}
+
+// makeReactionsLister creates lister of allowed reactions, nil if no reactions configured
+func (s *ServerCommand) makeReactionsLister() service.ReactionsLister {
+	if len(s.Reactions.Emoji) == 0 && len(s.Reactions.Sites) == 0 {
+		return nil
+	}
+	lister := service.StaticReactionsLister{Default: s.Reactions.Emoji, Sites: map[string][]string{}}
+	for siteID, emoji := range s.Reactions.Sites {
+		lister.Sites[siteID] = strings.Split(emoji, ",")
+	}
+	log.Printf("[INFO] reactions %v, per site %v", lister.Default, lister.Sites)
+	return lister
+}
+
 func (s *ServerCommand) makeAdminStore() (admin.Store, error) {
 	log.Printf("[INFO] make admin store, type=%s", s.Admin.Type)
 
@@ -730,6 +1034,19 @@ func (s *ServerCommand) makeAdminStore() (admin.Store, error) {
 	}
 }
 
+// makeAuthRefreshCache makes cache for authenticator, sessions revocations shared with redis pub/sub if used for cache
+func (s *ServerCommand) makeAuthRefreshCache() (*authRefreshCache, error) {
+	var bus eventbus.PubSub = &eventbus.NopPubSub{}
+	if s.Cache.Type == "redis_pub_sub" {
+		redisPubSub, err := eventbus.NewRedisPubSub(s.Cache.RedisAddr, "remark42-auth")
+		if err != nil {
+			return nil, errors.Wrap(err, "auth cache initialization, redis PubSub initialisation")
+		}
+		bus = redisPubSub
+	}
+	return newAuthRefreshCache(bus)
+}
+
 func (s *ServerCommand) makeCache() (LoadingCache, error) {
 	log.Printf("[INFO] make cache, type=%s", s.Cache.Type)
 	switch s.Cache.Type {
@@ -757,7 +1074,7 @@ func (s *ServerCommand) makeCache() (LoadingCache, error) {
 	return nil, errors.Errorf("unsupported cache type %s", s.Cache.Type)
 }
 
-func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
+func (s *ServerCommand) addAuthProviders(authenticator *auth.Service, mastodonApps *mastodon.BoltApps) error {
 
 	providers := 0
 	if s.Auth.Google.CID != "" && s.Auth.Google.CSEC != "" {
@@ -785,6 +1102,42 @@ func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
 		providers++
 	}
 
+	if s.Auth.OIDC != "" {
+		oidcProviders, err := oidc.Load(s.Auth.OIDC)
+		if err != nil {
+			return errors.Wrap(err, "failed to load oidc providers")
+		}
+		for _, p := range oidcProviders {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			opts, err := p.HandlerOpt(ctx, &http.Client{Timeout: 30 * time.Second})
+			cancel()
+			if err != nil {
+				return errors.Wrapf(err, "failed to make oidc provider %s", p.Name)
+			}
+			authenticator.AddCustomProvider(p.Name, auth.Client{Cid: p.CID, Csecret: p.CSEC}, opts)
+			log.Printf("[INFO] oidc provider %s enabled for %s", p.Name, p.Issuer)
+			providers++
+		}
+	}
+
+	if s.Auth.Mastodon.Enable {
+		params := mastodon.Params{
+			URL:        strings.TrimSuffix(s.RemarkURL, "/"),
+			Issuer:     "remark42",
+			JwtService: authenticator.TokenService(),
+			Instances:  s.Auth.Mastodon.Instances,
+		}
+		if mastodonApps != nil {
+			params.Store = mastodonApps
+		}
+		if avatarProxy := authenticator.AvatarProxy(); avatarProxy != nil {
+			params.AvatarSaver = avatarProxy
+		}
+		authenticator.AddCustomHandler(mastodon.New(params))
+		log.Printf("[INFO] mastodon login enabled, instances %v", s.Auth.Mastodon.Instances)
+		providers++
+	}
+
 	if s.Auth.Dev {
 		log.Print("[INFO] dev access enabled")
 		authenticator.AddProvider("dev", "", "")
@@ -868,43 +1221,140 @@ func (s *ServerCommand) loadEmailTemplate() (string, error) {
 	return string(file), nil
 }
 
-func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *auth.Service) (*notify.Service, error) {
+// makeInbox makes store of users' notifications inbox, nil if inbox is not enabled
+func (s *ServerCommand) makeInbox() (*notify.BoltInbox, error) {
+	if !contains("inbox", s.Notify.Users) {
+		return nil, nil
+	}
+	if err := makeDirs(path.Dir(s.Notify.Inbox.File)); err != nil {
+		return nil, errors.Wrap(err, "failed to create inbox store")
+	}
+	return notify.NewBoltInbox(s.Notify.Inbox.File, s.Notify.Inbox.Max, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+}
+
+// notifyDestinations keeps notification destinations used by rest server besides the notifier itself
+type notifyDestinations struct {
+	telegram      *notify.Telegram
+	telegramStore *notify.BoltTelegram // set when telegram moderation buttons enabled
+	email         *notify.Email
+}
+
+func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *auth.Service,
+	inbox notify.InboxStore) (*notify.Service, notifyDestinations, error) {
 	var notifyService *notify.Service
 	var destinations []notify.Destination
+
+	// single telegram bot serves both admin channel and users linked their accounts
+	var dests notifyDestinations
+	if contains("telegram", s.Notify.Admins) || contains("telegram", s.Notify.Users) {
+		telegramParams := notify.TelegramParams{
+			Token:             s.Telegram.Token,
+			Timeout:           s.Telegram.Timeout,
+			UserNotifications: contains("telegram", s.Notify.Users),
+		}
+		if contains("telegram", s.Notify.Admins) {
+			telegramParams.AdminChannelID = s.Notify.Telegram.Channel
+			telegramParams.AdminButtons = s.Notify.Telegram.Buttons
+		}
+		var err error
+		if telegramParams.AdminButtons {
+			if err = makeDirs(path.Dir(s.Notify.Telegram.File)); err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create telegram store")
+			}
+			opts := bolt.Options{Timeout: s.Store.Bolt.Timeout}
+			if dests.telegramStore, err = notify.NewBoltTelegram(s.Notify.Telegram.File, opts); err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create telegram store")
+			}
+			telegramParams.Store = dests.telegramStore
+		}
+		if dests.telegram, err = notify.NewTelegram(telegramParams); err != nil {
+			if dests.telegramStore != nil {
+				_ = dests.telegramStore.Close()
+			}
+			return nil, notifyDestinations{}, errors.Wrap(err, "failed to create telegram notification destination")
+		}
+		destinations = append(destinations, dests.telegram)
+	}
+
 	for _, t := range s.Notify.Admins {
 		switch t {
 		case "slack":
 			slack, err := notify.NewSlack(s.Notify.Slack.Token, s.Notify.Slack.Channel)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to create slack notification destination")
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create slack notification destination")
 			}
 			destinations = append(destinations, slack)
 		case "telegram":
-			telegramParams := notify.TelegramParams{
-				AdminChannelID: s.Notify.Telegram.Channel,
-				Token:          s.Telegram.Token,
-				Timeout:        s.Telegram.Timeout,
+		case "discord":
+			discord, err := notify.NewDiscord(notify.DiscordParams{WebhookURL: s.Notify.Discord.Webhook, Timeout: s.Notify.Discord.Timeout})
+			if err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create discord notification destination")
 			}
-			tg, err := notify.NewTelegram(telegramParams)
+			destinations = append(destinations, discord)
+		case "matrix":
+			matrixParams := notify.MatrixParams{
+				Server:  s.Notify.Matrix.Server,
+				Token:   s.Notify.Matrix.Token,
+				Room:    s.Notify.Matrix.Room,
+				Timeout: s.Notify.Matrix.Timeout,
+			}
+			matrix, err := notify.NewMatrix(matrixParams)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to create telegram notification destination")
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create matrix notification destination")
+			}
+			destinations = append(destinations, matrix)
+		case "webhook":
+			webhookParams := notify.WebhookParams{Secret: s.Notify.Webhook.Secret, Timeout: s.Notify.Webhook.Timeout,
+				Retries: s.Notify.Webhook.Retries}
+			if s.Notify.QueueFile != "" {
+				webhookParams.Retries = 1 // failed requests retried by the queue with backoff
+			}
+			for _, h := range s.Notify.Webhook.Hooks {
+				hook, err := notify.ParseHook(h)
+				if err != nil {
+					return nil, notifyDestinations{}, errors.Wrap(err, "failed to parse webhook")
+				}
+				webhookParams.Hooks = append(webhookParams.Hooks, hook)
 			}
-			destinations = append(destinations, tg)
+			wh, err := notify.NewWebhook(webhookParams)
+			if err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create webhook notification destination")
+			}
+			destinations = append(destinations, wh)
 		case "email":
 		case "none":
 			notifyService = notify.NopService
 		default:
-			return nil, errors.Errorf("unsupported admin notification type %q", s.Notify.Type)
+			return nil, notifyDestinations{}, errors.Errorf("unsupported admin notification type %q", s.Notify.Type)
 		}
 	}
 
 	for _, t := range s.Notify.Users {
 		switch t {
-		case "email":
+		case "webpush":
+			webPushParams := notify.WebPushParams{
+				PublicKey:  s.Notify.WebPush.PublicKey,
+				PrivateKey: s.Notify.WebPush.PrivateKey,
+				Subject:    s.Notify.WebPush.Subject,
+				TTL:        s.Notify.WebPush.TTL,
+				Timeout:    s.Notify.WebPush.Timeout,
+				Store:      dataStore,
+			}
+			if webPushParams.Subject == "" {
+				webPushParams.Subject = s.RemarkURL
+			}
+			webPush, err := notify.NewWebPush(webPushParams)
+			if err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create web push notification destination")
+			}
+			destinations = append(destinations, webPush)
+		case "inbox":
+			destinations = append(destinations, notify.NewInbox(inbox))
+		case "email", "telegram":
 		case "none":
 			notifyService = notify.NopService
 		default:
-			return nil, errors.Errorf("unsupported user notification type %q", s.Notify.Type)
+			return nil, notifyDestinations{}, errors.Errorf("unsupported user notification type %q", s.Notify.Type)
 		}
 	}
 
@@ -915,6 +1365,8 @@ func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *
 		emailParams := notify.EmailParams{
 			MsgTemplatePath:          s.emailMsgTemplatePath,
 			VerificationTemplatePath: s.emailVerificationTemplatePath, From: s.Notify.Email.From,
+			DigestTemplatePath:  s.emailDigestTemplatePath,
+			TemplatesDir:        s.Notify.Email.TemplatesDir,
 			VerificationSubject: s.Notify.Email.VerificationSubject,
 			UnsubscribeURL:      s.RemarkURL + "/email/unsubscribe.html",
 			// TODO: uncomment after #560 frontend part is ready and URL is known
@@ -939,6 +1391,16 @@ func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *
 		if contains("email", s.Notify.Admins) {
 			emailParams.AdminEmails = s.Admin.Shared.Email
 		}
+		if contains("email", s.Notify.Users) {
+			if err := makeDirs(path.Dir(s.Notify.Email.DigestFile)); err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to create digests store")
+			}
+			digests, err := notify.NewBoltDigests(s.Notify.Email.DigestFile, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+			if err != nil {
+				return nil, notifyDestinations{}, errors.Wrap(err, "failed to make digests store")
+			}
+			emailParams.Digests = digests
+		}
 		smtpParams := notify.SMTPParams{
 			Host:     s.SMTP.Host,
 			Port:     s.SMTP.Port,
@@ -949,16 +1411,56 @@ func (s *ServerCommand) makeNotify(dataStore *service.DataStore, authenticator *
 		}
 		emailService, err := notify.NewEmail(emailParams, smtpParams)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create email notification destination")
+			return nil, notifyDestinations{}, errors.Wrap(err, "failed to create email notification destination")
 		}
+		dests.email = emailService
 		destinations = append(destinations, emailService)
 	}
 
+	var filter *notify.Filter
+	if s.Notify.Filters != "" {
+		var err error
+		makeReviewer := func(words []string) notify.ReviewMatcher {
+			return service.NewRestrictedWordsMatcher(service.StaticRestrictedWordsLister{Words: words})
+		}
+		if filter, err = notify.LoadFilter(s.Notify.Filters, dataStore, makeReviewer); err != nil {
+			return nil, notifyDestinations{}, errors.Wrap(err, "failed to load notification filters")
+		}
+	}
+
 	if len(destinations) > 0 {
 		log.Printf("[INFO] make notify, for users: %s, for admins: %s", s.Notify.Users, s.Notify.Admins)
-		notifyService = notify.NewService(dataStore, s.Notify.QueueSize, destinations...)
+		if s.Notify.QueueFile == "" {
+			return notify.NewService(dataStore, s.Notify.QueueSize, destinations...).WithFilter(filter), dests, nil
+		}
+		if err := makeDirs(path.Dir(s.Notify.QueueFile)); err != nil {
+			return nil, notifyDestinations{}, errors.Wrap(err, "failed to create notification queue")
+		}
+		queue, err := notify.NewBoltQueue(s.Notify.QueueFile, bolt.Options{Timeout: s.Store.Bolt.Timeout})
+		if err != nil {
+			return nil, notifyDestinations{}, errors.Wrap(err, "failed to make notification queue")
+		}
+		notifyService = notify.NewQueuedService(dataStore,
+			notify.QueueParams{Store: queue, MaxAttempts: s.Notify.Retries, MinBackoff: s.Notify.RetryBackoff}, destinations...).WithFilter(filter)
 	}
-	return notifyService, nil
+	return notifyService, dests, nil
+}
+
+// telegramAdmins parses telegram-id:user-id pairs of telegram users moderating as site admins
+func (s *ServerCommand) telegramAdmins() (map[int64]string, error) {
+	res := map[int64]string{}
+	for _, a := range s.Notify.Telegram.Admins {
+		elems := strings.SplitN(a, ":", 2)
+		if len(elems) != 2 || elems[1] == "" {
+			return nil, errors.Errorf("invalid telegram admin %q, should be telegram-id:user-id", a)
+		}
+		id, err := strconv.ParseInt(elems[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid telegram id in %q", a)
+		}
+		res[id] = elems[1]
+	}
+	return res, nil
 }
 
 func (s *ServerCommand) makeSSLConfig() (config api.SSLConfig, err error) {
@@ -991,7 +1493,13 @@ func (s *ServerCommand) makeSSLConfig() (config api.SSLConfig, err error) {
 	return config, err
 }
 
-func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Store, admns admin.Store, authRefreshCache *authRefreshCache) (*auth.Service, error) {
+func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Store, admns admin.Store,
+	authRefreshCache *authRefreshCache, sso *api.SSO, apiTokens *apitoken.Service,
+	mastodonApps *mastodon.BoltApps) (*auth.Service, error) {
+	var basicAuthChecker middleware.BasicAuthFunc
+	if apiTokens != nil { // replaces admin password check, both admin and api tokens accepted
+		basicAuthChecker = api.NewBasicAuthChecker(s.AdminPasswd, apiTokens)
+	}
 	authenticator := auth.NewService(auth.Opts{
 		URL:            strings.TrimSuffix(s.RemarkURL, "/"),
 		Issuer:         "remark42",
@@ -1007,7 +1515,12 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 			if c.User == nil {
 				return c
 			}
-			c.User.SetAdmin(ds.IsAdmin(c.Audience, c.User.ID))
+			if id := ds.CanonicalUserID(c.Audience, c.User.ID); id != c.User.ID { // user linked to another account
+				c.User.SetStrAttr(api.LinkedFromAttr, c.User.ID)
+				c.User.ID = id
+			}
+			c.User.SetAdmin(ds.IsAdmin(c.Audience, c.User.ID) || oidc.IsAdmin(c.User, c.Audience) ||
+				c.User.BoolAttr(api.SSOAdminAttr))
 			c.User.SetBoolAttr("blocked", ds.IsBlocked(c.Audience, c.User.ID))
 			var err error
 			c.User.Email, err = ds.GetUserEmail(c.Audience, c.User.ID)
@@ -1015,6 +1528,14 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 				log.Printf("[WARN] can't read email for %s, %v", c.User.ID, err)
 			}
 
+			if c.Id != "" && c.Handshake == nil { // register session on login and update it on each refresh
+				now := time.Now()
+				sess := store.Session{ID: c.Id, Created: now, Refreshed: now, Expires: now.Add(s.Auth.TTL.Cookie)}
+				if err = ds.RegisterSession(c.Audience, c.User.ID, sess); err != nil {
+					log.Printf("[WARN] can't register session for %s, %v", c.User.ID, err)
+				}
+			}
+
 			// don't allow anonymous and email with admins names
 			// exclude admin from impersonation detection over email, it prevents a valid admin to login with RestrictedNames
 			if strings.HasPrefix(c.User.ID, "anonymous_") || (strings.HasPrefix(c.User.ID, "email_") && !c.User.IsAdmin()) {
@@ -1029,7 +1550,8 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 
 			return c
 		}),
-		AdminPasswd: s.AdminPasswd,
+		AdminPasswd:      s.AdminPasswd,
+		BasicAuthChecker: basicAuthChecker,
 		Validator: token.ValidatorFunc(func(token string, claims token.Claims) bool { // check on each auth call (in middleware)
 			if claims.User == nil {
 				return false
@@ -1037,6 +1559,16 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 			if claims.User.Audience == "" { // reject empty aud, made with old (pre 0.8.x) version of auth package
 				return false
 			}
+			if strings.HasPrefix(api.ProviderUserID(*claims.User), "sso_") && sso == nil {
+				return false // sso disabled
+			}
+			revoked := authRefreshCache.SessionsRevoked(claims.Audience, claims.User.ID, func() time.Time {
+				return ds.SessionsRevoked(claims.Audience, claims.User.ID)
+			})
+			active := func() bool { return ds.IsSessionActive(claims.Audience, claims.User.ID, claims.Id) }
+			if sessionRevoked(claims, revoked, active) {
+				return false // session revoked by the user, admin or sso logout of the site
+			}
 			return !claims.User.BoolAttr("blocked")
 		}),
 		JWTQuery:          "jwt", // change default from "token" as it used for deleteme
@@ -1048,7 +1580,7 @@ func (s *ServerCommand) makeAuthenticator(ds *service.DataStore, avas avatar.Sto
 		UseGravatar:       true,
 	})
 
-	if err := s.addAuthProviders(authenticator); err != nil {
+	if err := s.addAuthProviders(authenticator, mastodonApps); err != nil {
 		return nil, err
 	}
 
@@ -1070,14 +1602,30 @@ func (s *ServerCommand) parseSameSite(ss string) http.SameSite {
 	}
 }
 
-// authRefreshCache used by authenticator to minimize repeatable token refreshes
+// sessionRevoked checks if the session issued before the revocation. Both times are in seconds, so session issued
+// in the same second as the revocation is valid only if still active, i.e. registered after it as a new or re-issued one
+func sessionRevoked(claims token.Claims, revoked time.Time, active func() bool) bool {
+	if revoked.IsZero() || claims.IssuedAt > revoked.Unix() {
+		return false
+	}
+	return claims.IssuedAt < revoked.Unix() || !active()
+}
+
+// authRefreshCache used by authenticator to minimize repeatable token refreshes.
+// It also keeps revocation time of users' sessions checked on each request, invalidated on all instances with bus
 type authRefreshCache struct {
 	cache.LoadingCache
+	revoked cache.LoadingCache
+	bus     eventbus.PubSub
 }
 
-func newAuthRefreshCache() *authRefreshCache {
+func newAuthRefreshCache(bus eventbus.PubSub) (*authRefreshCache, error) {
 	expirableCache, _ := cache.NewExpirableCache(cache.TTL(5 * time.Minute))
-	return &authRefreshCache{LoadingCache: expirableCache}
+	revokedCache, err := cache.NewExpirableCache(cache.TTL(5*time.Minute), cache.MaxKeys(10000), cache.EventBus(bus))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make sessions revocation cache")
+	}
+	return &authRefreshCache{LoadingCache: expirableCache, revoked: revokedCache, bus: bus}, nil
 }
 
 // Get implements cache getter with key converted to string
@@ -1085,6 +1633,43 @@ func (c *authRefreshCache) Get(key interface{}) (interface{}, bool) {
 	return c.LoadingCache.Peek(key.(string))
 }
 
+// SessionsRevoked returns time user's sessions revoked at, loaded with fn if not cached
+func (c *authRefreshCache) SessionsRevoked(siteID, userID string, fn func() time.Time) time.Time {
+	res, err := c.revoked.Get(revokedKey(siteID, userID), func() (interface{}, error) { return fn(), nil })
+	if err != nil {
+		return fn()
+	}
+	return res.(time.Time)
+}
+
+// ResetSessions drops cached revocation time of the user's sessions, on this instance and all others sharing the bus
+func (c *authRefreshCache) ResetSessions(siteID, userID string) {
+	key := revokedKey(siteID, userID)
+	c.revoked.Delete(key)
+	// published explicitly as deletion of the key missing locally doesn't notify others
+	if err := c.bus.Publish("auth-sessions", key); err != nil {
+		log.Printf("[WARN] can't publish sessions revocation of %s, %v", userID, err)
+	}
+}
+
+// Close caches and the bus
+func (c *authRefreshCache) Close() error {
+	err := c.LoadingCache.Close()
+	if e := c.revoked.Close(); e != nil {
+		err = e
+	}
+	if closer, ok := c.bus.(io.Closer); ok {
+		if e := closer.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func revokedKey(siteID, userID string) string {
+	return "revoked:" + siteID + ":" + userID
+}
+
 // Set implements cache setter with key converted to string
 func (c *authRefreshCache) Set(key, value interface{}) {
 	_, _ = c.LoadingCache.Get(key.(string), func() (interface{}, error) { return value, nil })
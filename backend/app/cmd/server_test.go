@@ -11,6 +11,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -213,6 +214,63 @@ func getAuthFromCookie(t *testing.T, app *serverApp, resp *http.Response) (tkn s
 	return tkn, claims
 }
 
+func TestServerApp_WithTrust(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Trust.Enabled = true
+		o.Trust.Premoderation = true
+		o.Trust.Bolt.File = fmt.Sprintf("/tmp/%d/trust.db", port)
+		return o
+	})
+	defer os.RemoveAll(fmt.Sprintf("/tmp/%d", port))
+	require.NotNil(t, app.dataService.Trust)
+	assert.True(t, app.dataService.Trust.Premoderation)
+	assert.Equal(t, time.Hour, app.dataService.Trust.EditDuration)
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	cancel()
+	app.Wait()
+}
+
+func TestServerApp_WithSpam(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Spam.Enabled = true
+		o.Spam.Bolt.File = fmt.Sprintf("/tmp/%d/spam.db", port)
+		return o
+	})
+	defer os.RemoveAll(fmt.Sprintf("/tmp/%d", port))
+	require.NotNil(t, app.dataService.Spam)
+	assert.Equal(t, 0.9, app.dataService.Spam.Threshold)
+	assert.Equal(t, 10, app.dataService.Spam.MinDocs)
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	cancel()
+	app.Wait()
+}
+
+func TestServerCommand_makeReactionsLister(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.makeReactionsLister(), "no reactions configured")
+
+	cmd.Reactions.Emoji = []string{"👍", "🎉"}
+	cmd.Reactions.Sites = map[string]string{"radio-t": "👍,👀"}
+	lister := cmd.makeReactionsLister()
+	require.NotNil(t, lister)
+	r, err := lister.List("remark")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"👍", "🎉"}, r)
+	r, err = lister.List("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"👍", "👀"}, r)
+}
+
 func TestServerApp_WithSSL(t *testing.T) {
 	opts := ServerCommand{}
 	sslPort := chooseRandomUnusedPort()
@@ -579,6 +637,67 @@ func TestServerAuthHooks(t *testing.T) {
 	client.CloseIdleConnections()
 }
 
+func TestAuthRefreshCache_Sessions(t *testing.T) {
+	bus := &memPubSub{}
+	c1, err := newAuthRefreshCache(bus)
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := newAuthRefreshCache(bus)
+	require.NoError(t, err)
+	defer c2.Close()
+
+	revoked := map[string]time.Time{}
+	load := func() time.Time { return revoked["dev"] }
+	assert.True(t, c1.SessionsRevoked("remark", "dev", load).IsZero())
+	assert.True(t, c2.SessionsRevoked("remark", "dev", load).IsZero())
+
+	ts := time.Now().Truncate(time.Second)
+	revoked["dev"] = ts
+	assert.True(t, c2.SessionsRevoked("remark", "dev", load).IsZero(), "cached")
+
+	c1.ResetSessions("remark", "dev")
+	assert.Equal(t, ts, c1.SessionsRevoked("remark", "dev", load))
+	assert.Eventually(t, func() bool { return c2.SessionsRevoked("remark", "dev", load).Equal(ts) },
+		time.Second, 10*time.Millisecond, "reset on another instance")
+}
+
+func TestServerApp_sessionRevoked(t *testing.T) {
+	ts := time.Now().Truncate(time.Second)
+	claims := func(issued time.Time) token.Claims {
+		return token.Claims{StandardClaims: jwt.StandardClaims{Id: "sess", IssuedAt: issued.Unix()}}
+	}
+	active := func() bool { return true }
+	inactive := func() bool { return false }
+
+	assert.False(t, sessionRevoked(claims(ts.Add(-time.Hour)), time.Time{}, inactive), "never revoked")
+	assert.True(t, sessionRevoked(claims(ts.Add(-time.Second)), ts, active), "issued before the revocation")
+	assert.False(t, sessionRevoked(claims(ts.Add(time.Second)), ts, inactive), "issued after the revocation")
+	assert.True(t, sessionRevoked(claims(ts), ts, inactive), "issued in the same second, dropped by the revocation")
+	assert.False(t, sessionRevoked(claims(ts), ts, active), "issued in the same second, registered after the revocation")
+}
+
+// memPubSub delivers events to all subscribers in the same process, asynchronously as redis does
+type memPubSub struct {
+	lock sync.Mutex
+	subs []func(fromID, key string)
+}
+
+func (m *memPubSub) Subscribe(fn func(fromID, key string)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.subs = append(m.subs, fn)
+	return nil
+}
+
+func (m *memPubSub) Publish(fromID, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, fn := range m.subs {
+		go fn(fromID, key)
+	}
+	return nil
+}
+
 func TestServer_loadEmailTemplate(t *testing.T) {
 	cmd := ServerCommand{}
 	cmd.Auth.Email.MsgTemplate = "testdata/email.tmpl"
@@ -615,6 +734,22 @@ func TestServerCommand_parseSameSite(t *testing.T) {
 	}
 }
 
+func TestServerCommand_telegramAdmins(t *testing.T) {
+	cmd := ServerCommand{}
+	cmd.Notify.Telegram.Admins = []string{"12345:github_abc", "67890:telegram_def"}
+	res, err := cmd.telegramAdmins()
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{12345: "github_abc", 67890: "telegram_def"}, res)
+
+	cmd.Notify.Telegram.Admins = []string{"12345"}
+	_, err = cmd.telegramAdmins()
+	assert.EqualError(t, err, `invalid telegram admin "12345", should be telegram-id:user-id`)
+
+	cmd.Notify.Telegram.Admins = []string{"abc:github_abc"}
+	_, err = cmd.telegramAdmins()
+	assert.Error(t, err)
+}
+
 func chooseRandomUnusedPort() (port int) {
 	for i := 0; i < 10; i++ {
 		port = 40000 + int(rand.Int31n(10000))
@@ -686,6 +821,9 @@ func prepServerApp(t *testing.T, fn func(o ServerCommand) ServerCommand) (*serve
 	cmd.RestrictedNames = []string{"umputun", "bobuk"}
 	cmd.emailMsgTemplatePath = "../../templates/email_reply.html.tmpl"
 	cmd.emailVerificationTemplatePath = "../../templates/email_confirmation_subscription.html.tmpl"
+	cmd.emailDigestTemplatePath = "../../templates/email_digest.html.tmpl"
+	cmd.Notify.Email.DigestFile = fmt.Sprintf("/tmp/%d/digest.db", cmd.Port)
+	cmd.Notify.QueueFile = fmt.Sprintf("/tmp/%d/notify.db", cmd.Port)
 	cmd = fn(cmd)
 
 	os.Remove(cmd.Store.Bolt.Path + "/remark.db")
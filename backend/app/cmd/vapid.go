@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/umputun/remark42/backend/app/notify"
+)
+
+// VAPIDCommand generates key pair for web push notifications
+type VAPIDCommand struct {
+	CommonOpts
+
+	out io.Writer // changed only in tests
+}
+
+// Execute prints new VAPID keys in the form of env variables for server command, entry point for "vapid" command
+func (vc *VAPIDCommand) Execute(_ []string) error {
+	publicKey, privateKey, err := notify.GenerateVAPIDKeys()
+	if err != nil {
+		return err
+	}
+	out := vc.out
+	if out == nil {
+		out = os.Stdout
+	}
+	_, err = fmt.Fprintf(out, "NOTIFY_WEBPUSH_PUBLIC_KEY=%s\nNOTIFY_WEBPUSH_PRIVATE_KEY=%s\n", publicKey, privateKey)
+	return err
+}
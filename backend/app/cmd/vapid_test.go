@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/notify"
+)
+
+func TestVAPID_Execute(t *testing.T) {
+	buf := bytes.Buffer{}
+	cmd := VAPIDCommand{out: &buf}
+	require.NoError(t, cmd.Execute(nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, 2, len(lines))
+	require.True(t, strings.HasPrefix(lines[0], "NOTIFY_WEBPUSH_PUBLIC_KEY="))
+	require.True(t, strings.HasPrefix(lines[1], "NOTIFY_WEBPUSH_PRIVATE_KEY="))
+
+	_, err := notify.NewWebPush(notify.WebPushParams{
+		PublicKey:  strings.TrimPrefix(lines[0], "NOTIFY_WEBPUSH_PUBLIC_KEY="),
+		PrivateKey: strings.TrimPrefix(lines[1], "NOTIFY_WEBPUSH_PRIVATE_KEY="),
+		Subject:    "mailto:admin@example.com",
+	})
+	assert.NoError(t, err, "generated keys accepted by web push notifier")
+}
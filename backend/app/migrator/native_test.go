@@ -26,6 +26,10 @@ func TestNative_Export(t *testing.T) {
 	assert.NoError(t, b.SetReadOnly(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, true))
 	assert.NoError(t, b.SetVerified("radio-t", "user1", true))
 	assert.NoError(t, b.SetBlock("radio-t", "user2", true, time.Hour))
+	b.ReactionsLister = service.StaticReactionsLister{Default: []string{"👍"}}
+	_, err := b.React(service.ReactReq{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		CommentID: "efbc17f177ee1a1c0ee6e1e025749966ec071adc", UserID: "user2", Emoji: "👍"})
+	require.NoError(t, err)
 	r := Native{DataStore: b}
 
 	buf := &bytes.Buffer{}
@@ -65,6 +69,8 @@ func TestNative_Export(t *testing.T) {
 	assert.Error(t, dec.Decode(&comments[2]), "EOF")
 
 	assert.Equal(t, "some text, <a href=\"http://radio-t.com\" rel=\"nofollow\">link</a>", comments[0].Text)
+	assert.Equal(t, map[string]int{"👍": 1}, comments[0].Reactions)
+	assert.Equal(t, map[string][]string{"👍": {"user2"}}, comments[0].Reactors, "reactors exported")
 }
 
 func TestNative_Import(t *testing.T) {
@@ -72,7 +78,7 @@ func TestNative_Import(t *testing.T) {
 	defer teardown()
 
 	inp := `{"version":1,"users":[{"id":"user1","blocked":{"status":false,"until":"0001-01-01T00:00:00Z"},"verified":true},{"id":"user2","blocked":{"status":true,"until":"2018-12-23T02:55:22.472041-06:00"},"verified":false}],"posts":[{"url":"https://radio-t.com","read_only":true}]}
-	{"id":"efbc17f177ee1a1c0ee6e1e025749966ec071adc","pid":"","text":"some text, <a href=\"http://radio-t.com\" rel=\"nofollow\">link</a>","user":{"name":"user name","id":"user1","picture":"","ip":"293ec5b0cf154855258824ec7fac5dc63d176915","admin":false},"locator":{"site":"radio-t","url":"https://radio-t.com"},"score":0,"votes":{},"time":"2017-12-20T15:18:22-06:00"}
+	{"id":"efbc17f177ee1a1c0ee6e1e025749966ec071adc","pid":"","text":"some text, <a href=\"http://radio-t.com\" rel=\"nofollow\">link</a>","user":{"name":"user name","id":"user1","picture":"","ip":"293ec5b0cf154855258824ec7fac5dc63d176915","admin":false},"locator":{"site":"radio-t","url":"https://radio-t.com"},"score":0,"votes":{},"reactions":{"👍":1},"reactors":{"👍":["user2"]},"time":"2017-12-20T15:18:22-06:00"}
 	{"id":"f863bd79-fec6-4a75-b308-61fe5dd02aa1","pid":"1234","text":"some text2","user":{"name":"user name","id":"user2","picture":"","ip":"293ec5b0cf154855258824ec7fac5dc63d176915","admin":false},"locator":{"site":"radio-t","url":"https://radio-t.com/2"},"score":0,"votes":{},"time":"2017-12-20T15:18:23-06:00","imported":false}`
 
 	b.AdminStore = admin.NewStaticStore("12345", nil, []string{}, "")
@@ -93,6 +99,7 @@ func TestNative_Import(t *testing.T) {
 	assert.Equal(t, "https://radio-t.com", comments[1].Locator.URL)
 	assert.Equal(t, true, b.IsReadOnly(comments[1].Locator))
 	assert.True(t, comments[1].Imported)
+	assert.Equal(t, map[string]int{"👍": 1}, comments[1].Reactions, "reactions imported")
 
 	assert.Equal(t, false, b.IsBlocked("radio-t", "user1"))
 	assert.Equal(t, true, b.IsVerified("radio-t", "user1"))
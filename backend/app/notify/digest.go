@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"time"
+)
+
+// digestPeriods lists supported periods of digest delivery, users without period notified immediately
+var digestPeriods = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// digestCheckInterval defines how often pending digests checked for delivery
+const digestCheckInterval = time.Minute
+
+// IsDigestPeriod checks if period is one of supported digest delivery periods
+func IsDigestPeriod(period string) bool {
+	_, ok := digestPeriods[period]
+	return ok
+}
+
+// DigestItem is a single notification accumulated for the digest
+type DigestItem struct {
+	Kind      string    `json:"kind"` // reply, mention or follow
+	PostURL   string    `json:"post_url"`
+	PostTitle string    `json:"post_title,omitempty"`
+	UserName  string    `json:"user_name"`
+	Text      string    `json:"text"`
+	Link      string    `json:"link"`
+	Timestamp time.Time `json:"time"`
+}
+
+// Digest keeps notifications pending for delivery to the user's email
+type Digest struct {
+	SiteID string       `json:"site"`
+	UserID string       `json:"user_id"`
+	Email  string       `json:"email"`
+	Period string       `json:"period"`
+	Since  time.Time    `json:"since"` // time the oldest pending notification added
+	Items  []DigestItem `json:"items"`
+	Locale string       `json:"locale,omitempty"` // recipient's locale at the time of the last added item
+}
+
+// Due checks if the digest should be delivered at the given time
+func (d Digest) Due(now time.Time) bool {
+	return !d.Since.Add(digestPeriods[d.Period]).After(now)
+}
+
+// DigestStore keeps pending digests persistently, one per site and email
+type DigestStore interface {
+	Add(d Digest) error                   // merges items into pending digest, creates it if missing
+	Take(now time.Time) ([]Digest, error) // removes and returns digests due at the given time
+	Close() error
+}
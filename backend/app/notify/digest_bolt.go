@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltDigests implements DigestStore with bolt DB. All pending digests kept in a single bucket
+// with site and email as the key.
+type BoltDigests struct {
+	fileName string
+	db       *bolt.DB
+}
+
+const digestsBucketName = "digests"
+
+// NewBoltDigests makes bolt digests store
+func NewBoltDigests(fileName string, options bolt.Options) (*BoltDigests, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(digestsBucketName))
+		return errors.Wrapf(e, "failed to create bucket %s", digestsBucketName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltDigests{db: db, fileName: fileName}, nil
+}
+
+// Add merges items into pending digest for the site and email, period and user updated to the latest ones
+func (b *BoltDigests) Add(d Digest) error {
+	key := []byte(d.SiteID + "::" + d.Email)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(digestsBucketName))
+		if data := bkt.Get(key); data != nil {
+			pending := Digest{}
+			if err := json.Unmarshal(data, &pending); err != nil {
+				return errors.Wrapf(err, "can't unmarshal digest %s", key)
+			}
+			if pending.Since.Before(d.Since) {
+				d.Since = pending.Since
+			}
+			d.Items = append(pending.Items, d.Items...)
+		}
+		data, err := json.Marshal(d)
+		if err != nil {
+			return errors.Wrapf(err, "can't marshal digest %s", key)
+		}
+		return errors.Wrapf(bkt.Put(key, data), "can't put digest %s", key)
+	})
+}
+
+// Take removes and returns digests due at the given time
+func (b *BoltDigests) Take(now time.Time) (res []Digest, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(digestsBucketName))
+		var keys [][]byte
+		err = bkt.ForEach(func(k, v []byte) error {
+			d := Digest{}
+			if e := json.Unmarshal(v, &d); e != nil {
+				return errors.Wrapf(e, "can't unmarshal digest %s", k)
+			}
+			if d.Due(now) {
+				res = append(res, d)
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if e := bkt.Delete(k); e != nil {
+				return errors.Wrapf(e, "can't delete digest %s", k)
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Close bolt store
+func (b *BoltDigests) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltDigests_AddTake(t *testing.T) {
+	fileName := os.TempDir() + "/test-digests.db"
+	defer os.Remove(fileName)
+	b, err := NewBoltDigests(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	ts := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, b.Add(Digest{SiteID: "site", UserID: "u1", Email: "u1@example.com", Period: "hourly", Since: ts,
+		Items: []DigestItem{{Kind: "reply", Text: "c1"}}}))
+	require.NoError(t, b.Add(Digest{SiteID: "site", UserID: "u1", Email: "u1@example.com", Period: "hourly", Since: ts.Add(30 * time.Minute),
+		Items: []DigestItem{{Kind: "follow", Text: "c2"}}}))
+	require.NoError(t, b.Add(Digest{SiteID: "site", UserID: "u2", Email: "u2@example.com", Period: "daily", Since: ts,
+		Items: []DigestItem{{Kind: "mention", Text: "c3"}}}))
+
+	res, err := b.Take(ts.Add(59 * time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, res, "nothing due yet")
+
+	// reopen to check pending digests kept
+	require.NoError(t, b.Close())
+	b, err = NewBoltDigests(fileName, bolt.Options{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	res, err = b.Take(ts.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, Digest{SiteID: "site", UserID: "u1", Email: "u1@example.com", Period: "hourly", Since: ts,
+		Items: []DigestItem{{Kind: "reply", Text: "c1"}, {Kind: "follow", Text: "c2"}}}, res[0], "merged, the oldest since kept")
+
+	res, err = b.Take(ts.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, res, "taken digest removed")
+
+	res, err = b.Take(ts.Add(24 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "u2@example.com", res[0].Email)
+}
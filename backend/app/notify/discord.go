@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// DiscordParams contain settings for discord notifications
+type DiscordParams struct {
+	WebhookURL string        // incoming webhook of the channel, https://discord.com/api/webhooks/{id}/{token}
+	Timeout    time.Duration // http client timeout
+	MaxWait    time.Duration // max time to wait for rate limit reset, error returned for longer waits
+}
+
+// Discord implements notify.Destination for discord incoming webhook
+type Discord struct {
+	DiscordParams
+	client http.Client
+
+	lock      sync.Mutex
+	nextAfter time.Time // requests not allowed before this time due to exhausted rate limit
+}
+
+const (
+	discordTimeOut     = 5 * time.Second
+	discordMaxWait     = 30 * time.Second
+	discordRetries     = 3
+	discordColor       = 0x4fbbd6
+	discordTitleLen    = 256
+	discordTextLen     = 4096
+	discordParentQuote = 300
+)
+
+// NewDiscord makes discord destination
+func NewDiscord(params DiscordParams) (*Discord, error) {
+	if !strings.HasPrefix(params.WebhookURL, "http://") && !strings.HasPrefix(params.WebhookURL, "https://") {
+		return nil, errors.New("discord webhook url should start with http(s)://")
+	}
+	if params.Timeout == 0 {
+		params.Timeout = discordTimeOut
+	}
+	if params.MaxWait == 0 {
+		params.MaxWait = discordMaxWait
+	}
+	log.Printf("[DEBUG] create new discord notifier, timeout=%s", params.Timeout)
+	return &Discord{DiscordParams: params, client: http.Client{Timeout: params.Timeout}}, nil
+}
+
+// Send comment to discord channel as embed
+func (d *Discord) Send(ctx context.Context, req Request) error {
+	if req.adminFiltered {
+		return nil
+	}
+	log.Printf("[DEBUG] send discord notification, comment id %s", req.Comment.ID)
+	body, err := buildDiscordMessage(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to make discord message body")
+	}
+
+	for i := 0; i < discordRetries; i++ {
+		wait, err := d.post(ctx, body)
+		if err != nil {
+			return errors.Wrapf(err, "failed to send discord notification about %s", req.Comment.ID)
+		}
+		if wait == 0 {
+			return nil
+		}
+		if wait > d.MaxWait {
+			return errors.Errorf("discord rate limited for %s", wait)
+		}
+		log.Printf("[DEBUG] discord rate limited, retry in %s", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.Errorf("discord rate limited, comment id %s not sent after %d attempts", req.Comment.ID, discordRetries)
+}
+
+// post sends message and returns time to wait before retry if rate limited
+func (d *Discord) post(ctx context.Context, body []byte) (time.Duration, error) {
+	if err := d.waitRateLimit(ctx); err != nil {
+		return 0, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, d.WebhookURL+"?wait=true", bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to make discord request")
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get discord response")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		d.setRateLimit(parseSeconds(resp.Header.Get("X-RateLimit-Reset-After")))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		rateResp := struct {
+			RetryAfter float64 `json:"retry_after"`
+		}{}
+		wait := parseSeconds(resp.Header.Get("Retry-After"))
+		if err = json.NewDecoder(resp.Body).Decode(&rateResp); err == nil && rateResp.RetryAfter > 0 {
+			wait = time.Duration(rateResp.RetryAfter * float64(time.Second))
+		}
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return wait, nil
+	case resp.StatusCode >= 300:
+		return 0, errors.Errorf("unexpected discord status code %d", resp.StatusCode)
+	}
+	return 0, nil
+}
+
+// waitRateLimit blocks till the rate limit reported by the previous response is reset
+func (d *Discord) waitRateLimit(ctx context.Context) error {
+	d.lock.Lock()
+	wait := time.Until(d.nextAfter)
+	d.lock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if wait > d.MaxWait {
+		return errors.Errorf("discord rate limited for %s", wait)
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Discord) setRateLimit(resetAfter time.Duration) {
+	d.lock.Lock()
+	d.nextAfter = time.Now().Add(resetAfter)
+	d.lock.Unlock()
+}
+
+// parseSeconds parses duration in seconds with optional fraction, as discord sends in rate limit headers
+func parseSeconds(s string) time.Duration {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func buildDiscordMessage(req Request) ([]byte, error) {
+	type author struct {
+		Name    string `json:"name"`
+		IconURL string `json:"icon_url,omitempty"`
+	}
+	type embed struct {
+		Author      author `json:"author"`
+		Title       string `json:"title"`
+		URL         string `json:"url"`
+		Description string `json:"description"`
+		Timestamp   string `json:"timestamp,omitempty"`
+		Color       int    `json:"color"`
+	}
+
+	from := req.Comment.User.Name
+	text := html.UnescapeString(req.Comment.Orig)
+	if req.Comment.ParentID != "" {
+		from += " → " + req.parent.User.Name
+		if quote := quoteParent(html.UnescapeString(req.parent.Orig)); quote != "" {
+			text = quote + "\n\n" + text
+		}
+	}
+	title := "original comment"
+	if req.Comment.PostTitle != "" {
+		title = req.Comment.PostTitle
+	}
+	e := embed{
+		Author:      author{Name: from, IconURL: req.Comment.User.Picture},
+		Title:       truncate(title, discordTitleLen),
+		URL:         req.Comment.Locator.URL + uiNav + req.Comment.ID,
+		Description: truncate(text, discordTextLen),
+		Color:       discordColor,
+	}
+	if !req.Comment.Timestamp.IsZero() {
+		e.Timestamp = req.Comment.Timestamp.Format(time.RFC3339)
+	}
+	return json.Marshal(struct {
+		Embeds []embed `json:"embeds"`
+	}{Embeds: []embed{e}})
+}
+
+// quoteParent makes markdown quote from the beginning of parent comment
+func quoteParent(text string) string {
+	text = strings.TrimSpace(truncate(text, discordParentQuote))
+	if text == "" {
+		return ""
+	}
+	return "> " + strings.Replace(text, "\n", "\n> ", -1)
+}
+
+// truncate limits text to max runes, with ellipsis at the end of truncated text
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// SendVerification is not implemented for discord
+func (d *Discord) SendVerification(_ context.Context, _ VerificationRequest) error {
+	return nil
+}
+
+// String doesn't show webhook url as it contains the token
+func (d *Discord) String() string {
+	return "discord"
+}
@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestDiscord_New(t *testing.T) {
+	_, err := NewDiscord(DiscordParams{WebhookURL: "discord.com/api/webhooks/1/token"})
+	assert.EqualError(t, err, "discord webhook url should start with http(s)://")
+
+	d, err := NewDiscord(DiscordParams{WebhookURL: "https://discord.com/api/webhooks/1/token"})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, d.Timeout)
+	assert.Equal(t, 30*time.Second, d.MaxWait)
+	assert.Equal(t, "discord", d.String(), "token not exposed")
+	assert.NoError(t, d.SendVerification(context.Background(), VerificationRequest{}))
+}
+
+func TestDiscord_Send(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/webhooks/1/token", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("wait"))
+		body := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		b := strings.Builder{}
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		require.NoError(t, enc.Encode(body))
+		lock.Lock()
+		bodies = append(bodies, strings.TrimSpace(b.String()))
+		lock.Unlock()
+	}))
+	defer ts.Close()
+
+	d, err := NewDiscord(DiscordParams{WebhookURL: ts.URL + "/api/webhooks/1/token"})
+	require.NoError(t, err)
+
+	c := store.Comment{ID: "c2", ParentID: "c1", Orig: "some &lt;text&gt;", PostTitle: "Post title",
+		Locator:   store.Locator{URL: "https://example.com/post"},
+		User:      store.User{Name: "user2", Picture: "https://example.com/pic.png"},
+		Timestamp: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}
+	parent := store.Comment{ID: "c1", Orig: "parent line 1\nparent line 2", User: store.User{Name: "user1"}}
+	require.NoError(t, d.Send(context.Background(), Request{Comment: c, parent: parent}))
+	require.NoError(t, d.Send(context.Background(), Request{Comment: store.Comment{ID: "c3", Orig: "text",
+		Locator: store.Locator{URL: "https://example.com/post"}, User: store.User{Name: "user3"}}}))
+
+	require.Equal(t, 2, len(bodies))
+	assert.Equal(t, `{"embeds":[{"author":{"icon_url":"https://example.com/pic.png","name":"user2 → user1"},"color":5225430,`+
+		`"description":"> parent line 1\n> parent line 2\n\nsome <text>","timestamp":"2021-01-02T03:04:05Z",`+
+		`"title":"Post title","url":"https://example.com/post#remark42__comment-c2"}]}`, bodies[0])
+	assert.Equal(t, `{"embeds":[{"author":{"name":"user3"},"color":5225430,"description":"text",`+
+		`"title":"original comment","url":"https://example.com/post#remark42__comment-c3"}]}`, bodies[1])
+}
+
+func TestDiscord_RateLimit(t *testing.T) {
+	var lock sync.Mutex
+	var calls []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, time.Now())
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/limited") && len(calls) == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.1, "global": false}`))
+		case strings.HasSuffix(r.URL.Path, "/exhausted"):
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "0.2")
+		case strings.HasSuffix(r.URL.Path, "/long"):
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case strings.HasSuffix(r.URL.Path, "/broken"):
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+	reset := func() {
+		lock.Lock()
+		calls = nil
+		lock.Unlock()
+	}
+
+	d, err := NewDiscord(DiscordParams{WebhookURL: ts.URL + "/limited"})
+	require.NoError(t, err)
+	require.NoError(t, d.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}}))
+	require.Equal(t, 2, len(calls), "retried after 429")
+	assert.True(t, calls[1].Sub(calls[0]) >= 100*time.Millisecond, "retry_after from body used")
+	assert.True(t, calls[1].Sub(calls[0]) < time.Second, "retry_after from body used")
+
+	reset()
+	d, err = NewDiscord(DiscordParams{WebhookURL: ts.URL + "/exhausted"})
+	require.NoError(t, err)
+	require.NoError(t, d.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}}))
+	require.NoError(t, d.Send(context.Background(), Request{Comment: store.Comment{ID: "c2"}}))
+	require.Equal(t, 2, len(calls))
+	assert.True(t, calls[1].Sub(calls[0]) >= 200*time.Millisecond, "waited for the limit reset")
+
+	reset()
+	d, err = NewDiscord(DiscordParams{WebhookURL: ts.URL + "/long", MaxWait: time.Second})
+	require.NoError(t, err)
+	err = d.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}})
+	assert.EqualError(t, err, "discord rate limited for 1m0s")
+	assert.Equal(t, 1, len(calls))
+
+	d, err = NewDiscord(DiscordParams{WebhookURL: ts.URL + "/broken"})
+	require.NoError(t, err)
+	err = d.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}})
+	assert.EqualError(t, err, "failed to send discord notification about c1: unexpected discord status code 400")
+}
+
+func TestDiscord_Truncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "абв…", truncate("абвгдеж", 4))
+	assert.Equal(t, "> line1\n> line2", quoteParent(" line1\nline2\n"))
+	assert.Equal(t, "", quoteParent(" \n"))
+}
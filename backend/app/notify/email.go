@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"mime/multipart"
 	"mime/quotedprintable"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"net/url"
 	"text/template"
 	"time"
 
@@ -18,6 +21,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 
+	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -32,6 +36,11 @@ type EmailParams struct {
 	UnsubscribeURL           string   // full unsubscribe handler URL
 
 	TokenGenFn func(userID, email, site string) (string, error) // Unsubscribe token generation function
+
+	DigestTemplatePath string      // path to digest message template
+	Digests            DigestStore // optional, keeps notifications for users with digest delivery
+
+	TemplatesDir string // optional, directory with templates overriding the default ones per site and locale
 }
 
 // SMTPParams contain settings for smtp server connection
@@ -52,6 +61,8 @@ type Email struct {
 	smtp       smtpClientCreator
 	msgTmpl    *template.Template // parsed request message template
 	verifyTmpl *template.Template // parsed verification message template
+	digestTmpl *template.Template // parsed digest message template, only with digests enabled
+	overrides  templateOverrides  // templates from TemplatesDir
 }
 
 // default email client implementation
@@ -94,6 +105,25 @@ type msgTmplData struct {
 	Email             string
 	UnsubscribeLink   string
 	ForAdmin          bool
+	ForMention        bool
+	ForFollow         bool
+	FollowSite        bool // follower subscribed to the whole site
+}
+
+// digestTmplData store data for digest message template execution
+type digestTmplData struct {
+	Email           string
+	Period          string
+	Count           int
+	Posts           []digestPost
+	UnsubscribeLink string
+}
+
+// digestPost keeps digest items of a single post
+type digestPost struct {
+	URL   string
+	Title string
+	Items []DigestItem
 }
 
 // verifyTmplData store data for verification message template execution
@@ -110,6 +140,7 @@ const (
 	defaultEmailTimeout                  = 10 * time.Second
 	defaultEmailTemplatePath             = "email_reply.html.tmpl"
 	defaultEmailVerificationTemplatePath = "email_confirmation_subscription.html.tmpl"
+	defaultEmailDigestTemplatePath       = "email_digest.html.tmpl"
 )
 
 // NewEmail makes new Email object, returns error in case of e.MsgTemplate or e.VerificationTemplate parsing error
@@ -164,10 +195,23 @@ func (e *Email) setTemplates() error {
 		return errors.Wrapf(err, "can't parse verification template")
 	}
 
+	if e.Digests != nil {
+		if e.DigestTemplatePath == "" {
+			e.DigestTemplatePath = defaultEmailDigestTemplatePath
+		}
+		digestTmplFile, err := fs.ReadFile(e.DigestTemplatePath)
+		if err != nil {
+			return errors.Wrapf(err, "can't read digest template")
+		}
+		if e.digestTmpl, err = template.New("digestTmpl").Parse(string(digestTmplFile)); err != nil {
+			return errors.Wrapf(err, "can't parse digest template")
+		}
+	}
+
 	return nil
 }
 
-// Send email about comment reply to Request.Emails and Email.AdminEmails
+// Send email about comment reply to Request.Emails, mentioned users, followers and Email.AdminEmails
 // if they're set.
 // Thread safe
 func (e *Email) Send(ctx context.Context, req Request) error {
@@ -178,15 +222,63 @@ func (e *Email) Send(ctx context.Context, req Request) error {
 	}
 
 	result := new(multierror.Error)
+	// send delivers to the recipient unless it got the notification in the previous attempt of the queued request
+	send := func(recipient string, fn func() error) {
+		if delivered(ctx, recipient) {
+			return
+		}
+		if err := fn(); err != nil {
+			result = multierror.Append(result, err)
+			return
+		}
+		markDelivered(ctx, recipient)
+	}
 
 	for _, email := range req.Emails {
-		err := e.buildAndSendMessage(ctx, req, email, false)
-		result = multierror.Append(errors.Wrapf(err, "problem sending user email notification to %q", email))
+		email := email
+		send("reply:"+email, func() error {
+			if e.addToDigest(req, email, req.replyUsers[email], "reply") {
+				return nil
+			}
+			err := e.buildAndSendMessage(ctx, req, email, false)
+			return errors.Wrapf(err, "problem sending user email notification to %q", email)
+		})
+	}
+
+	for _, m := range req.Mentions {
+		m := m
+		send("mention:"+m.Email, func() error {
+			if e.addToDigest(req, m.Email, m.UserID, "mention") {
+				return nil
+			}
+			err := e.buildAndSendMention(ctx, req, m)
+			return errors.Wrapf(err, "problem sending mention email notification to %q", m.Email)
+		})
+	}
+
+	for _, f := range req.Followers {
+		if f.Email == "" {
+			continue
+		}
+		f := f
+		send("follow:"+f.Email, func() error {
+			if e.addToDigest(req, f.Email, f.UserID, "follow") {
+				return nil
+			}
+			err := e.buildAndSendFollow(ctx, req, f)
+			return errors.Wrapf(err, "problem sending follower email notification to %q", f.Email)
+		})
 	}
 
 	for _, email := range e.AdminEmails {
-		err := e.buildAndSendMessage(ctx, req, email, true)
-		result = multierror.Append(errors.Wrapf(err, "problem sending admin email notification to %q", email))
+		if req.adminFiltered {
+			break
+		}
+		email := email
+		send("admin:"+email, func() error {
+			err := e.buildAndSendMessage(ctx, req, email, true)
+			return errors.Wrapf(err, "problem sending admin email notification to %q", email)
+		})
 	}
 
 	return result.ErrorOrNil()
@@ -199,6 +291,101 @@ func (e *Email) buildAndSendMessage(ctx context.Context, req Request, email stri
 		return err
 	}
 
+	return e.sendWithRepeat(ctx, email, msg)
+}
+
+func (e *Email) buildAndSendMention(ctx context.Context, req Request, m Mention) error {
+	log.Printf("[DEBUG] send mention notification via %s, comment id %s", e, req.Comment.ID)
+	msg, err := e.buildMentionMessage(req, m)
+	if err != nil {
+		return err
+	}
+	return e.sendWithRepeat(ctx, m.Email, msg)
+}
+
+func (e *Email) buildAndSendFollow(ctx context.Context, req Request, f store.Follower) error {
+	log.Printf("[DEBUG] send follower notification via %s, comment id %s", e, req.Comment.ID)
+	msg, err := e.buildFollowMessage(req, f)
+	if err != nil {
+		return err
+	}
+	return e.sendWithRepeat(ctx, f.Email, msg)
+}
+
+// addToDigest puts notification to the pending digest if the recipient chose digest delivery.
+// Returns false if notification should be sent immediately, including the case of digest store failure.
+func (e *Email) addToDigest(req Request, email, userID, kind string) bool {
+	period := req.Digests[email]
+	if e.Digests == nil || period == "" {
+		return false
+	}
+	item := DigestItem{
+		Kind:      kind,
+		PostURL:   req.Comment.Locator.URL,
+		PostTitle: req.Comment.PostTitle,
+		UserName:  req.Comment.User.Name,
+		Text:      req.Comment.Text,
+		Link:      req.Comment.Locator.URL + uiNav + req.Comment.ID,
+		Timestamp: req.Comment.Timestamp,
+	}
+	d := Digest{SiteID: req.Comment.Locator.SiteID, UserID: userID, Email: email, Period: period,
+		Since: time.Now(), Items: []DigestItem{item}, Locale: req.Locales[email]}
+	if err := e.Digests.Add(d); err != nil {
+		log.Printf("[WARN] can't add comment %s to digest for %s, %v", req.Comment.ID, email, err)
+		return false
+	}
+	return true
+}
+
+// RunDigests sends due digests periodically till ctx canceled, closes digests store on exit.
+// Pending digests kept in the store, so the schedule survives restarts.
+func (e *Email) RunDigests(ctx context.Context) {
+	if e.Digests == nil {
+		return
+	}
+	defer func() {
+		if err := e.Digests.Close(); err != nil {
+			log.Printf("[WARN] can't close digests store, %v", err)
+		}
+	}()
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := e.SendDigests(ctx, now); err != nil {
+				log.Printf("[WARN] failed to send digests, %v", err)
+			}
+		}
+	}
+}
+
+// SendDigests sends all digests due at the given time. Digests failed to send returned to the store.
+func (e *Email) SendDigests(ctx context.Context, now time.Time) error {
+	digests, err := e.Digests.Take(now)
+	if err != nil {
+		return errors.Wrap(err, "can't get due digests")
+	}
+	result := new(multierror.Error)
+	for _, d := range digests {
+		log.Printf("[DEBUG] send %s digest via %s to %s, %d items", d.Period, e, d.Email, len(d.Items))
+		msg, err := e.buildDigestMessage(d)
+		if err == nil {
+			err = e.sendWithRepeat(ctx, d.Email, msg)
+		}
+		if err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "problem sending digest to %q", d.Email))
+			if addErr := e.Digests.Add(d); addErr != nil {
+				result = multierror.Append(result, errors.Wrapf(addErr, "can't return digest for %q", d.Email))
+			}
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (e *Email) sendWithRepeat(ctx context.Context, email, msg string) error {
 	return repeater.NewDefault(5, time.Millisecond*250).Do(
 		ctx,
 		func() error {
@@ -220,7 +407,7 @@ func (e *Email) SendVerification(ctx context.Context, req VerificationRequest) e
 	}
 
 	log.Printf("[DEBUG] send verification via %s, user %s", e, req.User)
-	msg, err := e.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	msg, err := e.buildVerificationMessage(req)
 	if err != nil {
 		return err
 	}
@@ -233,20 +420,31 @@ func (e *Email) SendVerification(ctx context.Context, req VerificationRequest) e
 }
 
 // buildVerificationMessage generates verification email message based on given input
-func (e *Email) buildVerificationMessage(user, email, token, site string) (string, error) {
-	subject := e.VerificationSubject
-	msg := bytes.Buffer{}
-	err := e.verifyTmpl.Execute(&msg, verifyTmplData{
-		User:         user,
-		Token:        token,
-		Email:        email,
-		Site:         site,
+func (e *Email) buildVerificationMessage(req VerificationRequest) (string, error) {
+	content, err := e.renderVerification(req)
+	if err != nil {
+		return "", err
+	}
+	return e.buildMessage(content, req.Email, "text/html", "")
+}
+
+// renderVerification renders verification message with the template for the site and locale of the request
+func (e *Email) renderVerification(req VerificationRequest) (emailContent, error) {
+	tmpl, err := e.template(e.verifyTmpl, e.VerificationTemplatePath, req.SiteID, req.Locale)
+	if err != nil {
+		return emailContent{}, err
+	}
+	content, err := renderEmail(tmpl, e.VerificationSubject, verifyTmplData{
+		User:         req.User,
+		Token:        req.Token,
+		Email:        req.Email,
+		Site:         req.SiteID,
 		SubscribeURL: e.SubscribeURL,
 	})
 	if err != nil {
-		return "", errors.Wrapf(err, "error executing template to build verification message")
+		return emailContent{}, errors.Wrapf(err, "error executing template to build verification message")
 	}
-	return e.buildMessage(subject, msg.String(), email, "text/html", "")
+	return content, nil
 }
 
 // buildMessageFromRequest generates email message based on Request using e.MsgTemplate
@@ -255,32 +453,102 @@ func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool
 	if forAdmin {
 		subject = "New comment to your site"
 	}
-	if req.Comment.PostTitle != "" {
-		subject += fmt.Sprintf(" for %q", req.Comment.PostTitle)
+	return e.buildCommentMessage(req, subject, email, req.parent.User.ID, msgTmplData{ForAdmin: forAdmin})
+}
+
+// buildMentionMessage generates message about the comment mentioning the user
+func (e *Email) buildMentionMessage(req Request, m Mention) (string, error) {
+	return e.buildCommentMessage(req, "You were mentioned in a comment", m.Email, m.UserID, msgTmplData{ForMention: true})
+}
+
+// buildFollowMessage generates message about new comment for the follower of the post or the site
+func (e *Email) buildFollowMessage(req Request, f store.Follower) (string, error) {
+	return e.buildCommentMessage(req, "New comment", f.Email, f.UserID, msgTmplData{ForFollow: true, FollowSite: f.FollowSite})
+}
+
+// buildDigestMessage generates digest message with notifications grouped by post
+func (e *Email) buildDigestMessage(d Digest) (string, error) {
+	token, err := e.TokenGenFn(d.UserID, d.Email, d.SiteID)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating token for unsubscribe link")
+	}
+	unsubscribeLink := e.UnsubscribeURL + "?site=" + d.SiteID + "&tkn=" + token
+	content, err := e.renderDigest(d, unsubscribeLink)
+	if err != nil {
+		return "", err
 	}
+	return e.buildMessage(content, d.Email, "text/html", unsubscribeLink)
+}
 
-	token, err := e.TokenGenFn(req.parent.User.ID, email, req.Comment.Locator.SiteID)
+// renderDigest renders digest message with the template for the site and locale of the digest
+func (e *Email) renderDigest(d Digest, unsubscribeLink string) (emailContent, error) {
+	tmplData := digestTmplData{
+		Email:           d.Email,
+		Period:          d.Period,
+		Count:           len(d.Items),
+		UnsubscribeLink: unsubscribeLink,
+	}
+	posts := map[string]int{} // index of the post in tmplData.Posts
+	for _, item := range d.Items {
+		i, ok := posts[item.PostURL]
+		if !ok {
+			i = len(tmplData.Posts)
+			posts[item.PostURL] = i
+			tmplData.Posts = append(tmplData.Posts, digestPost{URL: item.PostURL, Title: item.PostTitle})
+		}
+		tmplData.Posts[i].Items = append(tmplData.Posts[i].Items, item)
+	}
+
+	tmpl, err := e.template(e.digestTmpl, e.DigestTemplatePath, d.SiteID, d.Locale)
+	if err != nil {
+		return emailContent{}, err
+	}
+	subject := fmt.Sprintf("Your %s digest, %d new comments", d.Period, len(d.Items))
+	content, err := renderEmail(tmpl, subject, tmplData)
+	if err != nil {
+		return emailContent{}, errors.Wrapf(err, "error executing template to build digest message")
+	}
+	return content, nil
+}
+
+// buildCommentMessage generates message about the comment, tmplData keeps the kind of message
+func (e *Email) buildCommentMessage(req Request, subject, email, userID string, tmplData msgTmplData) (string, error) {
+	token, err := e.TokenGenFn(userID, email, req.Comment.Locator.SiteID)
 	if err != nil {
 		return "", errors.Wrapf(err, "error creating token for unsubscribe link")
 	}
 	unsubscribeLink := e.UnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
-	if forAdmin {
+	switch {
+	case tmplData.ForAdmin:
 		unsubscribeLink = ""
+	case tmplData.FollowSite: // followers unsubscribe from the followed post or site, not from all notifications
+		unsubscribeLink += "&unfollow=site"
+	case tmplData.ForFollow:
+		unsubscribeLink += "&unfollow=post&url=" + url.QueryEscape(req.Comment.Locator.URL)
 	}
 
-	commentURLPrefix := req.Comment.Locator.URL + uiNav
-	msg := bytes.Buffer{}
-	tmplData := msgTmplData{
-		UserName:        req.Comment.User.Name,
-		UserPicture:     req.Comment.User.Picture,
-		CommentText:     req.Comment.Text,
-		CommentLink:     commentURLPrefix + req.Comment.ID,
-		CommentDate:     req.Comment.Timestamp,
-		PostTitle:       req.Comment.PostTitle,
-		Email:           email,
-		UnsubscribeLink: unsubscribeLink,
-		ForAdmin:        forAdmin,
+	content, err := e.renderComment(req, subject, email, unsubscribeLink, tmplData)
+	if err != nil {
+		return "", err
 	}
+	return e.buildMessage(content, email, "text/html", unsubscribeLink)
+}
+
+// renderComment renders message about the comment with the template for the site and recipient's locale
+func (e *Email) renderComment(req Request, subject, email, unsubscribeLink string, tmplData msgTmplData) (emailContent, error) {
+	if req.Comment.PostTitle != "" {
+		subject += fmt.Sprintf(" for %q", req.Comment.PostTitle)
+	}
+
+	commentURLPrefix := req.Comment.Locator.URL + uiNav
+	tmplData.UserName = req.Comment.User.Name
+	tmplData.UserPicture = req.Comment.User.Picture
+	tmplData.CommentText = req.Comment.Text
+	tmplData.CommentLink = commentURLPrefix + req.Comment.ID
+	tmplData.CommentDate = req.Comment.Timestamp
+	tmplData.PostTitle = req.Comment.PostTitle
+	tmplData.Email = email
+	tmplData.UnsubscribeLink = unsubscribeLink
 	// in case of message to admin, parent message might be empty
 	if req.Comment.ParentID != "" {
 		tmplData.ParentUserName = req.parent.User.Name
@@ -289,27 +557,46 @@ func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool
 		tmplData.ParentCommentLink = commentURLPrefix + req.parent.ID
 		tmplData.ParentCommentDate = req.parent.Timestamp
 	}
-	err = e.msgTmpl.Execute(&msg, tmplData)
+
+	tmpl, err := e.template(e.msgTmpl, e.MsgTemplatePath, req.Comment.Locator.SiteID, req.Locales[email])
+	if err != nil {
+		return emailContent{}, err
+	}
+	content, err := renderEmail(tmpl, subject, tmplData)
 	if err != nil {
-		return "", errors.Wrapf(err, "error executing template to build comment reply message")
+		return emailContent{}, errors.Wrapf(err, "error executing template to build comment reply message")
 	}
-	return e.buildMessage(subject, msg.String(), email, "text/html", unsubscribeLink)
+	return content, nil
 }
 
-// buildMessage generates email message to send using net/smtp.Data()
-func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink string) (message string, err error) {
+// buildMessage generates email message to send using net/smtp.Data(). Message with plain text
+// alternative of the body sent as multipart/alternative.
+func (e *Email) buildMessage(content emailContent, to, contentType, unsubscribeLink string) (message string, err error) {
 	addHeader := func(msg, h, v string) string {
 		msg += fmt.Sprintf("%s: %s\n", h, v)
 		return msg
 	}
 	message = addHeader(message, "From", e.From)
 	message = addHeader(message, "To", to)
-	message = addHeader(message, "Subject", mime.BEncoding.Encode("utf-8", subject))
-	message = addHeader(message, "Content-Transfer-Encoding", "quoted-printable")
+	message = addHeader(message, "Subject", mime.BEncoding.Encode("utf-8", content.subject))
 
-	if contentType != "" {
+	var body string
+	if content.text != "" && contentType != "" {
+		var boundary string
+		if body, boundary, err = multipartBody(content, contentType); err != nil {
+			return "", err
+		}
 		message = addHeader(message, "MIME-version", "1.0")
-		message = addHeader(message, "Content-Type", contentType+`; charset="UTF-8"`)
+		message = addHeader(message, "Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	} else {
+		if body, err = quotedPrintable(content.body); err != nil {
+			return "", err
+		}
+		message = addHeader(message, "Content-Transfer-Encoding", "quoted-printable")
+		if contentType != "" {
+			message = addHeader(message, "MIME-version", "1.0")
+			message = addHeader(message, "Content-Type", contentType+`; charset="UTF-8"`)
+		}
 	}
 
 	if unsubscribeLink != "" {
@@ -319,7 +606,40 @@ func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink str
 	}
 
 	message = addHeader(message, "Date", time.Now().Format(time.RFC1123Z))
+	message += "\n" + body
+	return message, nil
+}
 
+// multipartBody makes multipart/alternative body with plain text and contentType parts, the last one
+// is the preferred by mail clients
+func multipartBody(content emailContent, contentType string) (body, boundary string, err error) {
+	buff := &bytes.Buffer{}
+	mw := multipart.NewWriter(buff)
+	parts := []struct{ contentType, body string }{{"text/plain", content.text}, {contentType, content.body}}
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.contentType+`; charset="UTF-8"`)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, e := mw.CreatePart(h)
+		if e != nil {
+			return "", "", errors.Wrap(e, "can't make multipart part")
+		}
+		qpBody, e := quotedPrintable(p.body)
+		if e != nil {
+			return "", "", e
+		}
+		if _, e = io.WriteString(pw, qpBody); e != nil {
+			return "", "", errors.Wrap(e, "can't write multipart part")
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return "", "", errors.Wrap(err, "can't close multipart writer")
+	}
+	return buff.String(), mw.Boundary(), nil
+}
+
+// quotedPrintable encodes body for quoted-printable Content-Transfer-Encoding
+func quotedPrintable(body string) (string, error) {
 	buff := &bytes.Buffer{}
 	qp := quotedprintable.NewWriter(buff)
 	if _, err := qp.Write([]byte(body)); err != nil {
@@ -329,9 +649,7 @@ func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink str
 	if err := qp.Close(); err != nil {
 		return "", fmt.Errorf("quotedprintable Write failed: %w", err)
 	}
-	m := buff.String()
-	message += "\n" + m
-	return message, nil
+	return buff.String(), nil
 }
 
 // sendMessage sends messages to server in a new connection, closing the connection after finishing.
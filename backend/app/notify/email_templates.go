@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// emailContent is the rendered email, text is optional plain text alternative of the body
+type emailContent struct {
+	subject string
+	body    string
+	text    string
+}
+
+// templateOverrides keeps templates loaded from EmailParams.TemplatesDir by file path.
+// Missing files cached as well, so templates changed or added to the directory picked up after restart only.
+type templateOverrides struct {
+	lock  sync.Mutex
+	cache map[string]*template.Template // nil for missing file
+}
+
+var reLocale = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})?$`)
+
+// IsLocale checks if the value is the locale usable for templates lookup, like "de" or "pt-BR"
+func IsLocale(locale string) bool {
+	return reLocale.MatchString(locale)
+}
+
+// template returns the most specific template with the name of the default one for the site and locale.
+// Templates looked up in TemplatesDir as <site>/<locale>/<name>, <site>/<lang>/<name>, <site>/<name>,
+// <locale>/<name>, <lang>/<name> and <name>, default template returned if none found.
+func (e *Email) template(def *template.Template, path, siteID, locale string) (*template.Template, error) {
+	if e.TemplatesDir == "" || path == "" {
+		return def, nil
+	}
+	for _, p := range templateCandidates(e.TemplatesDir, filepath.Base(path), siteID, locale) {
+		tmpl, err := e.overrides.get(p)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl != nil {
+			return tmpl, nil
+		}
+	}
+	return def, nil
+}
+
+// templateCandidates returns paths of the template from the most specific to the least one.
+// Site and locale not safe to use as path elements are ignored.
+func templateCandidates(dir, name, siteID, locale string) []string {
+	var locales []string
+	if IsLocale(locale) {
+		locale = strings.ToLower(strings.Replace(locale, "_", "-", 1))
+		locales = append(locales, locale)
+		if i := strings.Index(locale, "-"); i > 0 {
+			locales = append(locales, locale[:i])
+		}
+	}
+	var res []string
+	if siteID != "" && siteID != "." && siteID != ".." && !strings.ContainsAny(siteID, `/\`) {
+		for _, l := range locales {
+			res = append(res, filepath.Join(dir, siteID, l, name))
+		}
+		res = append(res, filepath.Join(dir, siteID, name))
+	}
+	for _, l := range locales {
+		res = append(res, filepath.Join(dir, l, name))
+	}
+	return append(res, filepath.Join(dir, name))
+}
+
+// get returns parsed template from the file, nil if file doesn't exist
+func (o *templateOverrides) get(path string) (*template.Template, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if tmpl, ok := o.cache[path]; ok {
+		return tmpl, nil
+	}
+	if o.cache == nil {
+		o.cache = map[string]*template.Template{}
+	}
+	data, err := ioutil.ReadFile(path) //nolint:gosec // path made of validated site and locale
+	if os.IsNotExist(err) {
+		o.cache[path] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read template %s", path)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse template %s", path)
+	}
+	o.cache[path] = tmpl
+	return tmpl, nil
+}
+
+// renderEmail executes the template with data. Subject and plain text alternative of the body rendered
+// from "subject" and "text" templates if the template defines them, default subject used otherwise.
+func renderEmail(tmpl *template.Template, subject string, data interface{}) (emailContent, error) {
+	res := emailContent{subject: subject}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return emailContent{}, err
+	}
+	res.body = buf.String()
+	if t := tmpl.Lookup("subject"); t != nil {
+		buf.Reset()
+		if err := t.Execute(&buf, data); err != nil {
+			return emailContent{}, err
+		}
+		if s := strings.Join(strings.Fields(buf.String()), " "); s != "" {
+			res.subject = s
+		}
+	}
+	if t := tmpl.Lookup("text"); t != nil {
+		buf.Reset()
+		if err := t.Execute(&buf, data); err != nil {
+			return emailContent{}, err
+		}
+		res.text = strings.TrimSpace(buf.String())
+	}
+	return res, nil
+}
+
+// EmailPreview is the email rendered with sample data
+type EmailPreview struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text,omitempty"`
+}
+
+// Preview renders email of the given type, one of reply, mention, follow, admin, digest and verification,
+// with sample data and the template for the site and locale
+func (e *Email) Preview(kind, siteID, locale string) (EmailPreview, error) {
+	const sampleEmail = "user@example.com"
+	unsubscribeLink := e.UnsubscribeURL + "?site=" + siteID + "&tkn=sample-token"
+	req := samplePreviewRequest(siteID)
+	req.Locales = map[string]string{sampleEmail: locale}
+
+	var content emailContent
+	var err error
+	switch kind {
+	case "reply":
+		content, err = e.renderComment(req, "New reply to your comment", sampleEmail, unsubscribeLink, msgTmplData{})
+	case "mention":
+		content, err = e.renderComment(req, "You were mentioned in a comment", sampleEmail, unsubscribeLink,
+			msgTmplData{ForMention: true})
+	case "follow":
+		content, err = e.renderComment(req, "New comment", sampleEmail, unsubscribeLink+"&unfollow=site",
+			msgTmplData{ForFollow: true, FollowSite: true})
+	case "admin":
+		content, err = e.renderComment(req, "New comment to your site", sampleEmail, "", msgTmplData{ForAdmin: true})
+	case "digest":
+		if e.digestTmpl == nil {
+			return EmailPreview{}, errors.New("email digests disabled")
+		}
+		d := Digest{SiteID: siteID, Email: sampleEmail, Period: "daily", Locale: locale, Since: req.Comment.Timestamp}
+		for _, c := range []store.Comment{req.parent, req.Comment} {
+			d.Items = append(d.Items, DigestItem{Kind: "follow", PostURL: c.Locator.URL, PostTitle: c.PostTitle,
+				UserName: c.User.Name, Text: c.Text, Link: c.Locator.URL + uiNav + c.ID, Timestamp: c.Timestamp})
+		}
+		content, err = e.renderDigest(d, unsubscribeLink)
+	case "verification":
+		content, err = e.renderVerification(VerificationRequest{SiteID: siteID, User: "User Name", Email: sampleEmail,
+			Token: "sample-token", Locale: locale})
+	default:
+		return EmailPreview{}, errors.Errorf("unknown email type %q", kind)
+	}
+	if err != nil {
+		return EmailPreview{}, err
+	}
+	return EmailPreview{Subject: content.subject, HTML: content.body, Text: content.text}, nil
+}
+
+// samplePreviewRequest makes reply to the comment, used for emails preview
+func samplePreviewRequest(siteID string) Request {
+	ts := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	locator := store.Locator{SiteID: siteID, URL: "https://example.com/sample-post"}
+	parent := store.Comment{ID: "sample-parent", Locator: locator, PostTitle: "Sample post", Timestamp: ts,
+		User: store.User{Name: "Parent Author"}, Text: "<p>Text of the comment replied to.</p>"}
+	comment := store.Comment{ID: "sample-comment", ParentID: parent.ID, Locator: locator, PostTitle: "Sample post",
+		Timestamp: ts.Add(30 * time.Minute), User: store.User{Name: "Reply Author"}, Text: "<p>Text of the reply.</p>"}
+	return Request{Comment: comment, parent: parent}
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestEmail_TemplatesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "email-templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeTmpl := func(path, body string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, path), []byte(body), 0o600))
+	}
+	writeTmpl("msg.html.tmpl", "root {{.UserName}}")
+	writeTmpl("de/msg.html.tmpl", `{{define "subject"}}Neue Antwort von {{.UserName}}{{end}}de {{.UserName}}`)
+	writeTmpl("site1/pt/msg.html.tmpl", `site1 pt{{define "text"}}plain {{.UserName}}{{end}}`)
+	writeTmpl("site1/verification.html.tmpl", `{{define "subject"}}Confirm {{.Site}}{{end}}verify {{.Token}}`)
+
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TemplatesDir:             dir,
+	}, SMTPParams{})
+	require.NoError(t, err)
+	email.TokenGenFn = TokenGenFn
+
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1",
+			Locator: store.Locator{SiteID: "site1"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Locales: map[string]string{"de@example.org": "de_AT", "pt@example.org": "pt-BR", "fr@example.org": "fr",
+			"bad@example.org": "../x"},
+	}
+
+	tbl := []struct {
+		site, email, subject, body, text string
+	}{
+		{"site1", "de@example.org", "Neue Antwort von test_user", "de test_user", ""},
+		{"site1", "pt@example.org", "New reply to your comment", "site1 pt", "plain test_user"},
+		{"site1", "fr@example.org", "New reply to your comment", "root test_user", ""},
+		{"site2", "pt@example.org", "New reply to your comment", "root test_user", ""},
+		{"site1", "bad@example.org", "New reply to your comment", "root test_user", ""},
+	}
+	for i, tt := range tbl {
+		req.Comment.Locator.SiteID = tt.site
+		c, err := email.renderComment(req, "New reply to your comment", tt.email, "", msgTmplData{})
+		require.NoError(t, err, i)
+		assert.Equal(t, emailContent{subject: tt.subject, body: tt.body, text: tt.text}, c, i)
+	}
+
+	c, err := email.renderVerification(VerificationRequest{SiteID: "site1", Token: "tkn", Locale: "en"})
+	require.NoError(t, err)
+	assert.Equal(t, emailContent{subject: "Confirm site1", body: "verify tkn"}, c)
+
+	writeTmpl("broken/msg.html.tmpl", "{{.Bad")
+	req.Comment.Locator.SiteID = "broken"
+	_, err = email.renderComment(req, "subj", "fr@example.org", "", msgTmplData{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't parse template")
+}
+
+func TestEmail_templateCandidates(t *testing.T) {
+	assert.Equal(t, []string{"d/s/pt-br/m", "d/s/pt/m", "d/s/m", "d/pt-br/m", "d/pt/m", "d/m"},
+		templateCandidates("d", "m", "s", "pt_BR"))
+	assert.Equal(t, []string{"d/s/m", "d/m"}, templateCandidates("d", "m", "s", "../.."))
+	assert.Equal(t, []string{"d/de/m", "d/m"}, templateCandidates("d", "m", "../s", "de"))
+	assert.Equal(t, []string{"d/m"}, templateCandidates("d", "m", "", ""))
+
+	assert.True(t, IsLocale("en"))
+	assert.True(t, IsLocale("zh-Hant"))
+	assert.False(t, IsLocale("english"))
+	assert.False(t, IsLocale("en/../x"))
+}
+
+func TestEmail_BuildMultipartMessage(t *testing.T) {
+	e := Email{EmailParams: EmailParams{From: "from@example.org"}}
+	msg, err := e.buildMessage(emailContent{subject: "subj", body: "<p>html body</p>", text: "text body"},
+		"to@example.org", "text/html", "https://example.org/unsubscribe")
+	require.NoError(t, err)
+
+	m, err := mail.ReadMessage(strings.NewReader(msg))
+	require.NoError(t, err)
+	assert.Equal(t, "", m.Header.Get("Content-Transfer-Encoding"))
+	assert.Equal(t, "<https://example.org/unsubscribe>", m.Header.Get("List-Unsubscribe"))
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(p) // quoted-printable decoded by the reader
+		require.NoError(t, err)
+		parts = append(parts, p.Header.Get("Content-Type")+" "+string(body))
+	}
+	assert.Equal(t, []string{`text/plain; charset="UTF-8" text body`, `text/html; charset="UTF-8" <p>html body</p>`}, parts)
+}
+
+func TestEmail_Preview(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		UnsubscribeURL:           "https://remark42.com/api/v1/email/unsubscribe",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	email.TokenGenFn = func(string, string, string) (string, error) {
+		t.Fatal("preview should not generate tokens")
+		return "", nil
+	}
+
+	p, err := email.Preview("reply", "remark", "")
+	require.NoError(t, err)
+	assert.Equal(t, `New reply to your comment for "Sample post"`, p.Subject)
+	assert.NotEmpty(t, p.HTML)
+
+	p, err = email.Preview("admin", "remark", "de")
+	require.NoError(t, err)
+	assert.Equal(t, `New comment to your site for "Sample post"`, p.Subject)
+
+	p, err = email.Preview("verification", "remark", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Email verification", p.Subject)
+	assert.Contains(t, p.HTML, "sample-token")
+
+	for _, kind := range []string{"mention", "follow"} {
+		_, err = email.Preview(kind, "remark", "")
+		assert.NoError(t, err, kind)
+	}
+
+	_, err = email.Preview("digest", "remark", "")
+	assert.EqualError(t, err, "email digests disabled")
+	_, err = email.Preview("bad", "remark", "")
+	assert.EqualError(t, err, `unknown email type "bad"`)
+}
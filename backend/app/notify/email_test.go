@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net/smtp"
+	"os"
 	"sync"
 	"testing"
 	"text/template"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/umputun/remark42/backend/app/store"
 )
@@ -250,6 +252,130 @@ Content-Type: text/html; charset="UTF-8"
 Date: `)
 }
 
+func TestEmail_SendMention(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = func(userID, email, site string) (string, error) { return "token-" + userID, nil }
+	email.UnsubscribeURL = "https://remark42.com/api/v1/email/unsubscribe"
+	req := Request{
+		Comment:  store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "test_title"},
+		Mentions: []Mention{{UserID: "2", Email: "mentioned@example.org"}},
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, "mentioned@example.org", fakeSMTP.readRcpt())
+	assert.Equal(t, 1, fakeSMTP.readQuitCount())
+
+	res, err := email.buildMentionMessage(req, req.Mentions[0])
+	require.NoError(t, err)
+	assert.Contains(t, res, `To: mentioned@example.org
+Subject: You were mentioned in a comment for "test_title"`)
+	assert.Contains(t, res, "List-Unsubscribe: <https://remark42.com/api/v1/email/unsubscribe?site=&tkn=token-2>")
+	assert.Contains(t, res, "test_user mentioned you in a comment to =C2=ABtest_title=C2=BB")
+}
+
+func TestEmail_SendFollow(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = func(userID, email, site string) (string, error) { return "token-" + userID, nil }
+	email.UnsubscribeURL = "https://remark42.com/api/v1/email/unsubscribe"
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "test_title",
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post?id=1"}},
+		Followers: []store.Follower{{UserID: "2", Email: "follower@example.org"}, {UserID: "3", Email: "site@example.org", FollowSite: true}},
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 2, fakeSMTP.readQuitCount())
+
+	res, err := email.buildFollowMessage(req, req.Followers[0])
+	require.NoError(t, err)
+	assert.Contains(t, res, `To: follower@example.org
+Subject: New comment for "test_title"`)
+	assert.Contains(t, res, "List-Unsubscribe: <https://remark42.com/api/v1/email/unsubscribe?site=remark&tkn=token-2"+
+		"&unfollow=post&url=https%3A%2F%2Fexample.com%2Fpost%3Fid%3D1>")
+	assert.Contains(t, res, "New comment from test_user on the post you follow, =C2=ABtest_title=C2=BB")
+
+	res, err = email.buildFollowMessage(req, req.Followers[1])
+	require.NoError(t, err)
+	assert.Contains(t, res, "List-Unsubscribe: <https://remark42.com/api/v1/email/unsubscribe?site=remark&tkn=token-3&unfollow=site>")
+	assert.Contains(t, res, "on the site you follow")
+}
+
+func TestEmail_SendDigest(t *testing.T) {
+	fileName := os.TempDir() + "/test-email-digests.db"
+	defer os.Remove(fileName)
+	digests, err := NewBoltDigests(fileName, bolt.Options{})
+	require.NoError(t, err)
+	defer digests.Close()
+
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		DigestTemplatePath:       "testdata/digest.html.tmpl",
+		Digests:                  digests,
+	}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = func(userID, email, site string) (string, error) { return "token-" + userID, nil }
+	email.UnsubscribeURL = "https://remark42.com/api/v1/email/unsubscribe"
+
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	reply := Request{
+		Comment:    store.Comment{ID: "c1", ParentID: "p1", Text: "reply text", User: store.User{ID: "1", Name: "user1"}, Locator: locator, PostTitle: "post one"},
+		parent:     store.Comment{ID: "p1", User: store.User{ID: "2"}},
+		Emails:     []string{"u2@example.org", "u3@example.org"},
+		Digests:    map[string]string{"u2@example.org": "daily"},
+		replyUsers: map[string]string{"u2@example.org": "2", "u3@example.org": "3"},
+	}
+	require.NoError(t, email.Send(context.TODO(), reply))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "only u3 notified immediately")
+	assert.Equal(t, "u3@example.org", fakeSMTP.readRcpt())
+
+	follow := Request{
+		Comment:   store.Comment{ID: "c2", Text: "follow text", User: store.User{ID: "1", Name: "user1"}, Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post2"}},
+		Followers: []store.Follower{{UserID: "2", Email: "u2@example.org"}, {UserID: "4"}},
+		Digests:   map[string]string{"u2@example.org": "daily"},
+	}
+	require.NoError(t, email.Send(context.TODO(), follow))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "follower without email skipped")
+
+	require.NoError(t, email.SendDigests(context.TODO(), time.Now()))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "digest is not due yet")
+
+	fakeSMTP.buff.Reset()
+	require.NoError(t, email.SendDigests(context.TODO(), time.Now().Add(24*time.Hour)))
+	assert.Equal(t, 2, fakeSMTP.readQuitCount())
+	assert.Equal(t, "u2@example.org", fakeSMTP.readRcpt())
+	msg := fakeSMTP.buff.String()
+	assert.Contains(t, msg, `Subject: Your daily digest, 2 new comments`)
+	assert.Contains(t, msg, "List-Unsubscribe: <https://remark42.com/api/v1/email/unsubscribe?site=remark&tkn=token-2>")
+	assert.Contains(t, msg, "Post: post one\r\n\treply from user1: reply text https://example.com/post1#")
+	assert.Contains(t, msg, "Post: https://example.com/post2\r\n\tfollow from user1: follow text https://example.com/post2#")
+
+	// failed digest returned to the store
+	require.NoError(t, email.Send(context.TODO(), follow))
+	fakeSMTP.fail = map[string]bool{"data": true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // skip retries
+	assert.Error(t, email.SendDigests(ctx, time.Now().Add(24*time.Hour)))
+	res, err := digests.Take(time.Now().Add(24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(res))
+}
+
 func TestEmail_SendWithUnicodeInSubject(t *testing.T) {
 	email, err := NewEmail(EmailParams{
 		From:                     "from@example.org",
@@ -317,7 +443,7 @@ func TestEmail_SendVerification(t *testing.T) {
 	assert.EqualError(t, email.SendVerification(ctx, req), "sending message to \"test_username\" aborted due to canceled context")
 
 	// test buildVerificationMessage separately for message text
-	res, err := email.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	res, err := email.buildVerificationMessage(req)
 	assert.NoError(t, err)
 	assert.Contains(t, res, `From: from@example.org
 To: test@example.org
@@ -329,7 +455,7 @@ Date: `)
 	assert.Contains(t, res, `secret_`)
 	assert.NotContains(t, res, `https://example.org/`)
 	email.SubscribeURL = "https://example.org/subscribe.html?token="
-	res, err = email.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	res, err = email.buildVerificationMessage(req)
 	assert.NoError(t, err)
 	assert.Contains(t, res, `From: from@example.org
 To: test@example.org
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// EventType defines the kind of change in comments, users or posts
+type EventType string
+
+// enum of all event types
+const (
+	EventCommentCreated EventType = "comment.created"
+	EventCommentEdited  EventType = "comment.edited"
+	EventCommentDeleted EventType = "comment.deleted"
+	EventCommentVoted   EventType = "comment.voted"
+	EventCommentPinned  EventType = "comment.pinned"
+	EventUserBlocked    EventType = "user.blocked"
+	EventUserVerified   EventType = "user.verified"
+	EventPostReadOnly   EventType = "post.readonly"
+)
+
+// EventTypes lists all supported event types
+var EventTypes = []EventType{EventCommentCreated, EventCommentEdited, EventCommentDeleted, EventCommentVoted,
+	EventCommentPinned, EventUserBlocked, EventUserVerified, EventPostReadOnly}
+
+// Event is a change of the comment, user or post, delivered to destinations supporting events only
+type Event struct {
+	Type    EventType      `json:"type"`
+	Time    time.Time      `json:"time"`
+	SiteID  string         `json:"site"`
+	URL     string         `json:"url,omitempty"`     // post url
+	Comment *store.Comment `json:"comment,omitempty"` // for comment events
+	UserID  string         `json:"user_id,omitempty"` // user changed by admin or the voter
+	Status  bool           `json:"status"`            // new status for block, verify, readonly, pin and vote (up) events
+}
+
+// eventDestination is implemented by destinations delivering events in addition to new comment notifications
+type eventDestination interface {
+	SendEvent(ctx context.Context, e Event) error
+}
+
+// SubmitEvent to the persistent queue or to internal channel if not busy, drop if can't send.
+// Ignored if none of destinations supports events.
+func (s *Service) SubmitEvent(e Event) {
+	if atomic.LoadUint32(&s.closed) != 0 || !s.hasEventDestinations() {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if s.jobs.Store != nil {
+		s.enqueue(Job{Event: &e})
+		return
+	}
+	select {
+	case s.events <- e:
+	default:
+		log.Printf("[WARN] can't send event to queue, %s for %s", e.Type, e.SiteID)
+	}
+}
+
+func (s *Service) hasEventDestinations() bool {
+	for _, d := range s.destinations {
+		if _, ok := d.(eventDestination); ok {
+			return true
+		}
+	}
+	return false
+}
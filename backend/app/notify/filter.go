@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Filter selects admin notifications sent to each destination. Destination with rules gets notifications
+// matching any of its rules only, destination without rules gets all of them. Filter doesn't affect
+// notifications of users, so email and telegram destinations still deliver replies to users.
+type Filter struct {
+	ReviewLists  map[string][]string     `json:"review_lists,omitempty"` // named lists of words for FilterRule.Review
+	Destinations map[string][]FilterRule `json:"destinations"`           // rules by destination type, like slack or email
+
+	counter   UserCounter
+	reviewers map[string]ReviewMatcher
+}
+
+// FilterRule matches comments and events with all the set conditions
+type FilterRule struct {
+	Name        string   `json:"name"`
+	Sites       []string `json:"sites,omitempty"`        // site ids, any site if empty
+	URLPrefixes []string `json:"url_prefixes,omitempty"` // post url prefixes, any post if empty
+	TopLevel    *bool    `json:"top_level,omitempty"`    // true for top-level comments only, false for replies only
+	NewUsers    int      `json:"new_users,omitempty"`    // authors with fewer prior comments than the value only
+	HasLinks    bool     `json:"has_links,omitempty"`    // comments with links only
+	Review      string   `json:"review,omitempty"`       // comments with words from the named review list only
+	// vote events leaving comment's score below the value only. Only destinations getting events, like webhook,
+	// are notified about votes, so the rule never matches for chat destinations, like slack or telegram
+	ScoreBelow *int `json:"score_below,omitempty"`
+}
+
+// FilterMatch is the result of filter check for the destination
+type FilterMatch struct {
+	Destination string `json:"destination"`
+	Send        bool   `json:"send"`
+	Rule        string `json:"rule,omitempty"` // the first matched rule, empty for destination without rules
+}
+
+// UserCounter returns the number of user's comments, used by FilterRule.NewUsers
+type UserCounter interface {
+	UserCount(siteID, userID string) (int, error)
+}
+
+// ReviewMatcher checks the text contains any word of the review list, used by FilterRule.Review
+type ReviewMatcher interface {
+	Match(siteID, text string) bool
+}
+
+// ReviewMatcherMaker makes matcher for the words of the review list
+type ReviewMatcherMaker func(words []string) ReviewMatcher
+
+var reLink = regexp.MustCompile(`(?i)<a\s[^>]*href=`)
+
+// LoadFilter reads filter rules from JSON file, counter is used by rules for new users
+// and makeReviewer makes matchers for review lists
+func LoadFilter(fileName string, counter UserCounter, makeReviewer ReviewMatcherMaker) (*Filter, error) {
+	data, err := ioutil.ReadFile(fileName) //nolint:gosec // file name set by server's admin
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read notification filter %s", fileName)
+	}
+	res := Filter{}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrapf(err, "can't parse notification filter %s", fileName)
+	}
+	return NewFilter(res.Destinations, res.ReviewLists, counter, makeReviewer)
+}
+
+// NewFilter makes filter with rules per destination type and checks the rules are valid
+func NewFilter(rules map[string][]FilterRule, reviewLists map[string][]string, counter UserCounter,
+	makeReviewer ReviewMatcherMaker) (*Filter, error) {
+	if len(reviewLists) > 0 && makeReviewer == nil {
+		return nil, errors.New("review lists need matcher")
+	}
+	res := Filter{ReviewLists: reviewLists, Destinations: rules, counter: counter, reviewers: map[string]ReviewMatcher{}}
+	for name, words := range reviewLists {
+		res.reviewers[name] = makeReviewer(words)
+	}
+	for dest, dr := range rules {
+		names := map[string]bool{}
+		for _, r := range dr {
+			if r.Name == "" || names[r.Name] {
+				return nil, errors.Errorf("rule of %s should have unique name, got %q", dest, r.Name)
+			}
+			names[r.Name] = true
+			if r.Review != "" && res.reviewers[r.Review] == nil {
+				return nil, errors.Errorf("unknown review list %q in rule %s of %s", r.Review, r.Name, dest)
+			}
+			if r.NewUsers > 0 && counter == nil {
+				return nil, errors.Errorf("rule %s of %s needs user counter", r.Name, dest)
+			}
+		}
+	}
+	return &res, nil
+}
+
+// filterSubject is the comment or the event checked by filter rules
+type filterSubject struct {
+	siteID  string
+	url     string
+	comment *store.Comment // nil for events not related to a comment
+	event   *Event         // nil for new comment
+}
+
+func requestSubject(req Request) filterSubject {
+	c := req.Comment
+	return filterSubject{siteID: c.Locator.SiteID, url: c.Locator.URL, comment: &c}
+}
+
+func eventSubject(e Event) filterSubject {
+	return filterSubject{siteID: e.SiteID, url: e.URL, comment: e.Comment, event: &e}
+}
+
+// match returns the first rule of the destination type matching the subject,
+// send is false if the destination has rules and none of them matched
+func (f *Filter) match(dest string, s filterSubject) (rule string, send bool) {
+	rules, ok := f.Destinations[dest]
+	if !ok {
+		return "", true
+	}
+	for _, r := range rules {
+		if f.matchRule(r, s) {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+func (f *Filter) matchRule(r FilterRule, s filterSubject) bool {
+	if len(r.Sites) > 0 && !contains(s.siteID, r.Sites) {
+		return false
+	}
+	if len(r.URLPrefixes) > 0 && !hasAnyPrefix(s.url, r.URLPrefixes) {
+		return false
+	}
+	if r.ScoreBelow != nil && (s.event == nil || s.event.Type != EventCommentVoted || s.comment == nil ||
+		s.comment.Score >= *r.ScoreBelow) {
+		return false
+	}
+	needComment := r.TopLevel != nil || r.NewUsers > 0 || r.HasLinks || r.Review != ""
+	if !needComment {
+		return true
+	}
+	c := s.comment
+	if c == nil {
+		return false
+	}
+	if r.TopLevel != nil && (c.ParentID == "") != *r.TopLevel {
+		return false
+	}
+	if r.HasLinks && !reLink.MatchString(c.Text) {
+		return false
+	}
+	if r.Review != "" && !f.reviewers[r.Review].Match(s.siteID, c.Orig+" "+c.Text) {
+		return false
+	}
+	if r.NewUsers > 0 {
+		count, err := f.counter.UserCount(s.siteID, c.User.ID)
+		if err != nil {
+			log.Printf("[WARN] can't get comments count of %s for rule %s, %v", c.User.ID, r.Name, err)
+			return false
+		}
+		if count-1 >= r.NewUsers { // the comment itself already counted
+			return false
+		}
+	}
+	return true
+}
+
+// destinationType returns the type of destination, like slack or email, used as the key of filter rules
+func destinationType(d Destination) string {
+	name := d.String()
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func contains(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestFilter_Match(t *testing.T) {
+	topLevel, reply, low := true, false, -2
+	counter := mockUserCounter{"new": 2, "old": 10, "bad": -1}
+	f, err := NewFilter(map[string][]FilterRule{
+		"slack": {
+			{Name: "blog", Sites: []string{"remark"}, URLPrefixes: []string{"https://example.com/blog/"}, TopLevel: &topLevel},
+			{Name: "new-users", NewUsers: 3},
+			{Name: "links", HasLinks: true, TopLevel: &reply},
+			{Name: "review", Review: "ads"},
+			{Name: "low-score", ScoreBelow: &low},
+		},
+		"email": {},
+	}, map[string][]string{"ads": {"casino", "crypto*"}}, counter, newMockReviewer)
+	require.NoError(t, err)
+
+	loc := store.Locator{SiteID: "remark", URL: "https://example.com/blog/post1"}
+	tbl := []struct {
+		name string
+		s    filterSubject
+		rule string
+		send bool
+	}{
+		{"top-level in blog", requestSubject(Request{Comment: store.Comment{Locator: loc, User: store.User{ID: "old"}}}), "blog", true},
+		{"reply in blog", requestSubject(Request{Comment: store.Comment{Locator: loc, ParentID: "p1", User: store.User{ID: "old"}}}), "", false},
+		{"new user", requestSubject(Request{Comment: store.Comment{Locator: store.Locator{SiteID: "other"}, User: store.User{ID: "new"}}}), "new-users", true},
+		{"counter error", requestSubject(Request{Comment: store.Comment{User: store.User{ID: "bad"}}}), "", false},
+		{"reply with link", requestSubject(Request{Comment: store.Comment{ParentID: "p1", User: store.User{ID: "old"},
+			Text: `<p>see <a href="https://example.com">this</a></p>`}}), "links", true},
+		{"top-level with link", requestSubject(Request{Comment: store.Comment{User: store.User{ID: "old"},
+			Text: `<p>see <a href="https://example.com">this</a></p>`}}), "", false},
+		{"review words", requestSubject(Request{Comment: store.Comment{User: store.User{ID: "old"}, Orig: "Best Cryptocurrency deals"}}), "review", true},
+		{"low score vote", eventSubject(Event{Type: EventCommentVoted, Comment: &store.Comment{Score: -3, User: store.User{ID: "old"}}}), "low-score", true},
+		{"ok score vote", eventSubject(Event{Type: EventCommentVoted, Comment: &store.Comment{Score: -1, User: store.User{ID: "old"}}}), "", false},
+		{"user event", eventSubject(Event{Type: EventUserBlocked, SiteID: "remark", URL: "https://example.com/blog/post1"}), "", false},
+	}
+	for _, tt := range tbl {
+		rule, send := f.match("slack", tt.s)
+		assert.Equal(t, tt.rule, rule, tt.name)
+		assert.Equal(t, tt.send, send, tt.name)
+	}
+
+	_, send := f.match("email", tbl[0].s)
+	assert.False(t, send, "empty rules filter out all")
+	rule, send := f.match("discord", tbl[1].s)
+	assert.True(t, send, "no rules for destination")
+	assert.Equal(t, "", rule)
+}
+
+func TestFilter_New(t *testing.T) {
+	_, err := NewFilter(map[string][]FilterRule{"slack": {{Name: "a"}, {Name: "a"}}}, nil, nil, nil)
+	assert.EqualError(t, err, `rule of slack should have unique name, got "a"`)
+	_, err = NewFilter(map[string][]FilterRule{"slack": {{Name: "a", Review: "bad"}}}, nil, nil, nil)
+	assert.EqualError(t, err, `unknown review list "bad" in rule a of slack`)
+	_, err = NewFilter(map[string][]FilterRule{"slack": {{Name: "a", NewUsers: 1}}}, nil, nil, nil)
+	assert.EqualError(t, err, "rule a of slack needs user counter")
+	_, err = NewFilter(map[string][]FilterRule{"slack": {{Name: "a"}}}, map[string][]string{"ads": {"casino"}}, nil, nil)
+	assert.EqualError(t, err, "review lists need matcher")
+
+	f, err := ioutil.TempFile("", "filter")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"review_lists": {"ads": ["casino"]},
+		"destinations": {"slack": [{"name": "ads", "review": "ads"}, {"name": "low", "score_below": -5}]}}`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	filter, err := LoadFilter(f.Name(), nil, newMockReviewer)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(filter.Destinations["slack"]))
+	assert.Equal(t, -5, *filter.Destinations["slack"][1].ScoreBelow)
+
+	_, err = LoadFilter("/tmp/no-such-filter.json", nil, newMockReviewer)
+	assert.Error(t, err)
+}
+
+func TestService_Filter(t *testing.T) {
+	slack, inbox := &namedDest{name: "slack: #comments"}, &namedDest{name: "inbox"}
+	f, err := NewFilter(map[string][]FilterRule{"slack": {{Name: "remark", Sites: []string{"remark"}}}}, nil, nil, nil)
+	require.NoError(t, err)
+	s := NewService(nil, 10, slack, inbox).WithFilter(f)
+
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark"}}})
+	s.Submit(Request{Comment: store.Comment{ID: "c2", Locator: store.Locator{SiteID: "other"}}})
+	s.SubmitEvent(Event{Type: EventCommentVoted, SiteID: "other"})
+	s.SubmitEvent(Event{Type: EventCommentVoted, SiteID: "remark"})
+	assert.Eventually(t, func() bool { return len(inbox.events()) == 2 && len(inbox.filtered()) == 2 },
+		time.Second, 10*time.Millisecond)
+	s.Close()
+
+	assert.Equal(t, map[string]bool{"c1": false, "c2": true}, slack.filtered())
+	assert.Equal(t, map[string]bool{"c1": false, "c2": false}, inbox.filtered())
+	assert.Equal(t, []string{"remark"}, slack.events())
+
+	assert.Equal(t, []FilterMatch{{Destination: "slack", Send: true, Rule: "remark"}, {Destination: "inbox", Send: true}},
+		s.CheckFilter(store.Comment{Locator: store.Locator{SiteID: "remark"}}))
+	assert.Equal(t, []FilterMatch{{Destination: "slack", Send: false}, {Destination: "inbox", Send: true}},
+		s.CheckFilter(store.Comment{Locator: store.Locator{SiteID: "other"}}))
+}
+
+type mockUserCounter map[string]int
+
+func (m mockUserCounter) UserCount(_, userID string) (int, error) {
+	if m[userID] < 0 {
+		return 0, errors.New("failed")
+	}
+	return m[userID], nil
+}
+
+// mockReviewer matches words case-insensitive, with trailing * as a prefix of the word
+type mockReviewer []string
+
+func newMockReviewer(words []string) ReviewMatcher { return mockReviewer(words) }
+
+func (m mockReviewer) Match(_, text string) bool {
+	for _, tw := range strings.Fields(strings.ToLower(text)) {
+		for _, w := range m {
+			w = strings.ToLower(w)
+			if tw == w || (strings.HasSuffix(w, "*") && strings.HasPrefix(tw, strings.TrimSuffix(w, "*"))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// namedDest records admin filtering of requests and sites of events
+type namedDest struct {
+	name      string
+	lock      sync.Mutex
+	requests  map[string]bool
+	eventSite []string
+}
+
+func (n *namedDest) Send(_ context.Context, req Request) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.requests == nil {
+		n.requests = map[string]bool{}
+	}
+	n.requests[req.Comment.ID] = req.adminFiltered
+	return nil
+}
+
+func (n *namedDest) SendEvent(_ context.Context, e Event) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.eventSite = append(n.eventSite, e.SiteID)
+	return nil
+}
+
+func (n *namedDest) SendVerification(context.Context, VerificationRequest) error { return nil }
+
+func (n *namedDest) String() string { return n.name }
+
+func (n *namedDest) filtered() map[string]bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.requests
+}
+
+func (n *namedDest) events() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.eventSite
+}
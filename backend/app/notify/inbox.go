@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// types of inbox notifications
+const (
+	InboxReply   = "reply"
+	InboxMention = "mention"
+	InboxFollow  = "follow"  // new comment on the post or the site user follows
+	InboxPinned  = "pinned"  // user's comment pinned by admin
+	InboxDeleted = "deleted" // user's comment deleted by admin
+)
+
+// InboxItem is the notification kept in user's inbox
+type InboxItem struct {
+	ID        string    `json:"id"` // type and comment id, so repeated notification about the same comment replaces the old one
+	Type      string    `json:"type"`
+	PostURL   string    `json:"post_url"`
+	PostTitle string    `json:"post_title,omitempty"`
+	CommentID string    `json:"comment_id"`
+	UserName  string    `json:"user_name,omitempty"` // author of the reply or the mention
+	Text      string    `json:"text,omitempty"`      // beginning of the reply or the mention
+	Link      string    `json:"link"`
+	Time      time.Time `json:"time"`
+	Read      bool      `json:"read"`
+}
+
+// InboxStore keeps notifications of users persistently
+type InboxStore interface {
+	Add(siteID, userID string, item InboxItem) error                                  // adds or replaces item, the oldest dropped over the limit
+	List(siteID, userID string, limit int) (items []InboxItem, unread int, err error) // newest first, limit ignored if not positive
+	MarkRead(siteID, userID string, ids []string) (unread int, err error)             // marks all items if ids empty
+	Delete(siteID, userID string) error                                               // removes all items of the user
+	Merge(siteID, fromID, toID string) error                                          // moves items of fromID to toID
+	Close() error
+}
+
+// Inbox implements notify.Destination keeping replies, mentions and admin actions with user's comments
+// in per-user inbox, read by the users with the API
+type Inbox struct {
+	Store InboxStore
+}
+
+const inboxTextLen = 200
+
+// NewInbox makes inbox destination with the store
+func NewInbox(st InboxStore) *Inbox {
+	return &Inbox{Store: st}
+}
+
+// Send adds the comment to inboxes of the users replied to, the users mentioned in it, except opted-out of mentions,
+// and followers of the post or the site
+func (i *Inbox) Send(_ context.Context, req Request) error {
+	errs := new(multierror.Error)
+	notified := map[string]bool{req.Comment.User.ID: true}
+	for _, userID := range req.Replied {
+		if notified[userID] {
+			continue
+		}
+		notified[userID] = true
+		errs = multierror.Append(errs, i.add(userID, InboxReply, req))
+	}
+	for _, userID := range req.Mentioned {
+		if notified[userID] {
+			continue
+		}
+		notified[userID] = true
+		errs = multierror.Append(errs, i.add(userID, InboxMention, req))
+	}
+	for _, f := range req.Followers {
+		if notified[f.UserID] {
+			continue
+		}
+		notified[f.UserID] = true
+		errs = multierror.Append(errs, i.add(f.UserID, InboxFollow, req))
+	}
+	return errs.ErrorOrNil()
+}
+
+func (i *Inbox) add(userID, kind string, req Request) error {
+	c := req.Comment
+	item := InboxItem{
+		ID:        kind + "-" + c.ID,
+		Type:      kind,
+		PostURL:   c.Locator.URL,
+		PostTitle: c.PostTitle,
+		CommentID: c.ID,
+		UserName:  c.User.Name,
+		Text:      truncate(strings.TrimSpace(html.UnescapeString(c.Orig)), inboxTextLen),
+		Link:      c.Locator.URL + uiNav + c.ID,
+		Time:      c.Timestamp,
+	}
+	if item.Time.IsZero() {
+		item.Time = time.Now()
+	}
+	return errors.Wrapf(i.Store.Add(c.Locator.SiteID, userID, item), "can't add %s to inbox of %s", kind, userID)
+}
+
+// SendEvent adds pin and admin's deletion of the comment to the inbox of the comment's author
+func (i *Inbox) SendEvent(_ context.Context, e Event) error {
+	if e.Comment == nil || e.Comment.User.ID == "" {
+		return nil
+	}
+	var kind string
+	switch {
+	case e.Type == EventCommentPinned && e.Status:
+		kind = InboxPinned
+	case e.Type == EventCommentDeleted && e.UserID != e.Comment.User.ID: // deleted not by the author
+		kind = InboxDeleted
+	default:
+		return nil
+	}
+	item := InboxItem{
+		ID:        kind + "-" + e.Comment.ID,
+		Type:      kind,
+		PostURL:   e.URL,
+		PostTitle: e.Comment.PostTitle,
+		CommentID: e.Comment.ID,
+		Link:      e.URL + uiNav + e.Comment.ID,
+		Time:      e.Time,
+	}
+	if kind == InboxDeleted {
+		item.Link = e.URL // comment is gone
+	}
+	userID := e.Comment.User.ID
+	return errors.Wrapf(i.Store.Add(e.SiteID, userID, item), "can't add %s to inbox of %s", kind, userID)
+}
+
+// SendVerification is not implemented for inbox
+func (i *Inbox) SendVerification(_ context.Context, _ VerificationRequest) error {
+	return nil
+}
+
+func (i *Inbox) String() string {
+	return "inbox"
+}
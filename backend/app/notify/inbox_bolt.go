@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltInbox implements InboxStore with bolt DB. Each user has nested bucket in the top-level inbox bucket,
+// with site and user id as the key, and items keyed by their ids.
+type BoltInbox struct {
+	fileName string
+	db       *bolt.DB
+	maxItems int
+}
+
+const (
+	inboxBucketName = "inbox"
+	defaultInboxMax = 500
+)
+
+// NewBoltInbox makes bolt inbox store keeping up to maxItems notifications per user, 500 if not positive
+func NewBoltInbox(fileName string, maxItems int, options bolt.Options) (*BoltInbox, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(inboxBucketName))
+		return errors.Wrapf(e, "failed to create bucket %s", inboxBucketName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if maxItems <= 0 {
+		maxItems = defaultInboxMax
+	}
+	return &BoltInbox{db: db, fileName: fileName, maxItems: maxItems}, nil
+}
+
+// Add puts item to user's inbox as unread, and drops the oldest items over the limit.
+// Item with the same id replaced keeping its read status.
+func (b *BoltInbox) Add(siteID, userID string, item InboxItem) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.Bucket([]byte(inboxBucketName)).CreateBucketIfNotExists(inboxKey(siteID, userID))
+		if err != nil {
+			return errors.Wrapf(err, "can't make inbox bucket for %s", userID)
+		}
+		item.Read = false
+		if data := bkt.Get([]byte(item.ID)); data != nil {
+			old := InboxItem{}
+			if e := json.Unmarshal(data, &old); e == nil {
+				item.Read = old.Read
+			}
+		}
+		if err = b.save(bkt, item); err != nil {
+			return err
+		}
+		items, err := b.load(bkt)
+		if err != nil {
+			return err
+		}
+		for i := b.maxItems; i < len(items); i++ {
+			if err = bkt.Delete([]byte(items[i].ID)); err != nil {
+				return errors.Wrapf(err, "can't delete inbox item %s", items[i].ID)
+			}
+		}
+		return nil
+	})
+}
+
+// List returns user's items, newest first, and the number of unread ones
+func (b *BoltInbox) List(siteID, userID string, limit int) (items []InboxItem, unread int, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(inboxBucketName)).Bucket(inboxKey(siteID, userID))
+		if bkt == nil {
+			return nil
+		}
+		var e error
+		items, e = b.load(bkt)
+		return e
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, item := range items {
+		if !item.Read {
+			unread++
+		}
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, unread, nil
+}
+
+// MarkRead marks user's items with given ids as read, all items if ids empty, and returns the number of unread ones
+func (b *BoltInbox) MarkRead(siteID, userID string, ids []string) (unread int, err error) {
+	marked := map[string]bool{}
+	for _, id := range ids {
+		marked[id] = true
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(inboxBucketName)).Bucket(inboxKey(siteID, userID))
+		if bkt == nil {
+			return nil
+		}
+		items, e := b.load(bkt)
+		if e != nil {
+			return e
+		}
+		for _, item := range items {
+			if item.Read {
+				continue
+			}
+			if len(ids) > 0 && !marked[item.ID] {
+				unread++
+				continue
+			}
+			item.Read = true
+			if e = b.save(bkt, item); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	return unread, err
+}
+
+// Delete removes all items of the user
+func (b *BoltInbox) Delete(siteID, userID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		top := tx.Bucket([]byte(inboxBucketName))
+		if top.Bucket(inboxKey(siteID, userID)) == nil {
+			return nil
+		}
+		return errors.Wrapf(top.DeleteBucket(inboxKey(siteID, userID)), "can't delete inbox of %s", userID)
+	})
+}
+
+// Merge moves items of fromID to toID, keeping items toID already has and dropping the oldest items over the limit
+func (b *BoltInbox) Merge(siteID, fromID, toID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		top := tx.Bucket([]byte(inboxBucketName))
+		fromBkt := top.Bucket(inboxKey(siteID, fromID))
+		if fromBkt == nil {
+			return nil
+		}
+		toBkt, err := top.CreateBucketIfNotExists(inboxKey(siteID, toID))
+		if err != nil {
+			return errors.Wrapf(err, "can't make inbox bucket for %s", toID)
+		}
+		err = fromBkt.ForEach(func(k, v []byte) error {
+			if toBkt.Get(k) != nil {
+				return nil
+			}
+			return errors.Wrapf(toBkt.Put(k, v), "can't put inbox item %s", k)
+		})
+		if err != nil {
+			return err
+		}
+		if err = top.DeleteBucket(inboxKey(siteID, fromID)); err != nil {
+			return errors.Wrapf(err, "can't delete inbox of %s", fromID)
+		}
+		items, err := b.load(toBkt)
+		if err != nil {
+			return err
+		}
+		for i := b.maxItems; i < len(items); i++ {
+			if err = toBkt.Delete([]byte(items[i].ID)); err != nil {
+				return errors.Wrapf(err, "can't delete inbox item %s", items[i].ID)
+			}
+		}
+		return nil
+	})
+}
+
+// Close bolt store
+func (b *BoltInbox) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
+
+func (b *BoltInbox) save(bkt *bolt.Bucket, item InboxItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal inbox item %s", item.ID)
+	}
+	return errors.Wrapf(bkt.Put([]byte(item.ID), data), "can't put inbox item %s", item.ID)
+}
+
+// load returns all items of the bucket, newest first
+func (b *BoltInbox) load(bkt *bolt.Bucket) (items []InboxItem, err error) {
+	err = bkt.ForEach(func(k, v []byte) error {
+		item := InboxItem{}
+		if e := json.Unmarshal(v, &item); e != nil {
+			return errors.Wrapf(e, "can't unmarshal inbox item %s", k)
+		}
+		items = append(items, item)
+		return nil
+	})
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+	return items, err
+}
+
+func inboxKey(siteID, userID string) []byte {
+	return []byte(siteID + "::" + userID)
+}
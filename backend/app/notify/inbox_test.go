@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestInbox_Send(t *testing.T) {
+	st := prepInbox(t, 0)
+	inbox := NewInbox(st)
+	assert.Equal(t, "inbox", inbox.String())
+
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	c := store.Comment{ID: "c2", ParentID: "c1", Orig: "some **text** &amp; more", PostTitle: "Post title",
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}, User: store.User{ID: "u2", Name: "user2"},
+		Mentions: []string{"u1", "u2", "u3"}, Timestamp: ts}
+	require.NoError(t, inbox.Send(context.Background(), Request{Comment: c, Replied: []string{"u1"}, Mentioned: []string{"u1", "u3"},
+		Followers: []store.Follower{{UserID: "u1"}, {UserID: "u4", FollowSite: true}}}))
+
+	items, unread, err := st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, unread)
+	assert.Equal(t, []InboxItem{{ID: "reply-c2", Type: InboxReply, PostURL: "https://example.com/post", PostTitle: "Post title",
+		CommentID: "c2", UserName: "user2", Text: "some **text** & more", Link: "https://example.com/post#remark42__comment-c2",
+		Time: ts}}, items, "replied user notified about the reply only, not about the mention")
+
+	items, _, err = st.List("remark", "u3", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, InboxMention, items[0].Type)
+
+	items, _, err = st.List("remark", "u4", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items), "follower without email notified")
+	assert.Equal(t, InboxFollow, items[0].Type)
+
+	items, _, err = st.List("remark", "u2", 0)
+	require.NoError(t, err)
+	assert.Empty(t, items, "author not notified")
+
+	c.ID = "c3"
+	require.NoError(t, inbox.Send(context.Background(), Request{Comment: c}))
+	items, _, err = st.List("remark", "u3", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(items), "mentions in the comment without mentioned users ignored, e.g. opted-out")
+}
+
+func TestInbox_SendEvent(t *testing.T) {
+	st := prepInbox(t, 0)
+	inbox := NewInbox(st)
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	c := &store.Comment{ID: "c1", User: store.User{ID: "u1"}, PostTitle: "Post title"}
+
+	events := []Event{
+		{Type: EventCommentPinned, Time: ts, SiteID: "remark", URL: "https://example.com/post", Comment: c, Status: true},
+		{Type: EventCommentPinned, Time: ts, SiteID: "remark", URL: "https://example.com/post", Comment: c, Status: false},
+		{Type: EventCommentDeleted, Time: ts.Add(time.Minute), SiteID: "remark", URL: "https://example.com/post", Comment: c},
+		{Type: EventCommentDeleted, Time: ts, SiteID: "remark", URL: "https://example.com/post", Comment: c, UserID: "u1"},
+		{Type: EventCommentVoted, Time: ts, SiteID: "remark", URL: "https://example.com/post", Comment: c, UserID: "u2"},
+		{Type: EventUserBlocked, Time: ts, SiteID: "remark", UserID: "u1"},
+	}
+	for _, e := range events {
+		require.NoError(t, inbox.SendEvent(context.Background(), e))
+	}
+
+	items, unread, err := st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread)
+	assert.Equal(t, []InboxItem{
+		{ID: "deleted-c1", Type: InboxDeleted, PostURL: "https://example.com/post", PostTitle: "Post title", CommentID: "c1",
+			Link: "https://example.com/post", Time: ts.Add(time.Minute)},
+		{ID: "pinned-c1", Type: InboxPinned, PostURL: "https://example.com/post", PostTitle: "Post title", CommentID: "c1",
+			Link: "https://example.com/post#remark42__comment-c1", Time: ts},
+	}, items, "deletion by the author and unpin ignored")
+}
+
+func TestBoltInbox(t *testing.T) {
+	st := prepInbox(t, 3)
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, id := range []string{"i1", "i2", "i3", "i4"} {
+		require.NoError(t, st.Add("remark", "u1", InboxItem{ID: id, Time: ts.Add(time.Duration(i) * time.Minute)}))
+	}
+	require.NoError(t, st.Add("remark", "u2", InboxItem{ID: "i1", Time: ts}))
+	require.NoError(t, st.Add("other", "u1", InboxItem{ID: "i1", Time: ts}))
+
+	items, unread, err := st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, unread)
+	require.Equal(t, 3, len(items), "the oldest dropped over the limit")
+	assert.Equal(t, "i4", items[0].ID)
+	assert.Equal(t, "i2", items[2].ID)
+
+	items, unread, err = st.List("remark", "u1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, unread, "all unread counted")
+	assert.Equal(t, 2, len(items))
+
+	unread, err = st.MarkRead("remark", "u1", []string{"i3", "bad"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread)
+	items, _, err = st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, false}, []bool{items[0].Read, items[1].Read, items[2].Read})
+
+	require.NoError(t, st.Add("remark", "u1", InboxItem{ID: "i3", Time: ts.Add(time.Hour)}))
+	items, unread, err = st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread, "replaced item keeps read status")
+	assert.Equal(t, "i3", items[0].ID)
+	assert.True(t, items[0].Read)
+
+	unread, err = st.MarkRead("remark", "u1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, unread)
+	_, unread, err = st.List("remark", "u2", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, unread, "other user not affected")
+
+	items, unread, err = st.List("remark", "unknown", 0)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+	assert.Equal(t, 0, unread)
+	unread, err = st.MarkRead("remark", "unknown", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, unread)
+
+	require.NoError(t, st.Delete("remark", "u1"))
+	require.NoError(t, st.Delete("remark", "unknown"))
+	items, _, err = st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Empty(t, items, "deleted")
+	items, _, err = st.List("other", "u1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(items), "other site not affected")
+}
+
+func TestBoltInbox_Merge(t *testing.T) {
+	st := prepInbox(t, 3)
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, st.Add("remark", "u1", InboxItem{ID: "i1", Time: ts, Text: "from u1"}))
+	require.NoError(t, st.Add("remark", "u1", InboxItem{ID: "i2", Time: ts.Add(time.Minute)}))
+	require.NoError(t, st.Add("remark", "u1", InboxItem{ID: "i4", Time: ts.Add(3 * time.Minute)}))
+	require.NoError(t, st.Add("remark", "u2", InboxItem{ID: "i1", Time: ts, Text: "from u2"}))
+	require.NoError(t, st.Add("remark", "u2", InboxItem{ID: "i3", Time: ts.Add(2 * time.Minute)}))
+	_, err := st.MarkRead("remark", "u2", []string{"i1"})
+	require.NoError(t, err)
+
+	require.NoError(t, st.Merge("remark", "u1", "u2"))
+	require.NoError(t, st.Merge("remark", "unknown", "u2"))
+	items, unread, err := st.List("remark", "u2", 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(items), "the oldest dropped over the limit")
+	assert.Equal(t, []string{"i4", "i3", "i2"}, []string{items[0].ID, items[1].ID, items[2].ID})
+	assert.Equal(t, 3, unread)
+	items, _, err = st.List("remark", "u1", 0)
+	require.NoError(t, err)
+	assert.Empty(t, items, "items of merged user moved")
+
+	require.NoError(t, st.Add("remark", "u3", InboxItem{ID: "i1", Time: ts, Text: "from u3"}))
+	require.NoError(t, st.Add("remark", "u4", InboxItem{ID: "i1", Time: ts, Text: "from u4"}))
+	require.NoError(t, st.Merge("remark", "u3", "u4"))
+	items, _, err = st.List("remark", "u4", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, "from u4", items[0].Text, "existing item kept")
+}
+
+func prepInbox(t *testing.T, maxItems int) *BoltInbox {
+	fileName := os.TempDir() + "/test-inbox.db"
+	_ = os.Remove(fileName)
+	st, err := NewBoltInbox(fileName, maxItems, bolt.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, st.Close())
+		_ = os.Remove(fileName)
+	})
+	return st
+}
@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/repeater"
+	"github.com/pkg/errors"
+)
+
+// MatrixParams contain settings for matrix notifications
+type MatrixParams struct {
+	Server  string        // homeserver url, like https://matrix.example.com
+	Token   string        // access token of the bot user, joined to the room
+	Room    string        // room id (!id:server) or alias (#alias:server)
+	Timeout time.Duration // http client timeout
+}
+
+// Matrix implements notify.Destination for matrix room, via client-server API
+type Matrix struct {
+	MatrixParams
+	client http.Client
+
+	lock   sync.Mutex
+	roomID string // resolved on the first send for room alias
+}
+
+const matrixTimeOut = 5 * time.Second
+const matrixAPIPrefix = "/_matrix/client/r0"
+
+// errMatrixRejected stops retries of requests the homeserver rejected, real error returned by retry
+var errMatrixRejected = errors.New("matrix request rejected")
+
+// matrixStatusError is an unexpected status of the homeserver response
+type matrixStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *matrixStatusError) Error() string { return e.msg }
+
+// NewMatrix makes matrix destination, room alias resolved to the room id on the first send
+func NewMatrix(params MatrixParams) (*Matrix, error) {
+	if params.Server == "" || params.Token == "" || params.Room == "" {
+		return nil, errors.New("matrix server, token and room should be set")
+	}
+	params.Server = strings.TrimSuffix(params.Server, "/")
+	if params.Timeout == 0 {
+		params.Timeout = matrixTimeOut
+	}
+	res := Matrix{MatrixParams: params, client: http.Client{Timeout: params.Timeout}}
+	if !strings.HasPrefix(params.Room, "#") {
+		res.roomID = params.Room
+	}
+	log.Printf("[DEBUG] create new matrix notifier for %s, room %s, timeout=%s", res.Server, res.Room, res.Timeout)
+	return &res, nil
+}
+
+// Send comment to matrix room. Transaction id made from the comment id, so the homeserver ignores
+// repeated sends of the same comment.
+func (m *Matrix) Send(ctx context.Context, req Request) error {
+	if req.adminFiltered {
+		return nil
+	}
+	roomID, err := m.room(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] send matrix notification to %s, comment id %s", roomID, req.Comment.ID)
+	msg := buildMatrixMessage(req)
+	txnID := url.PathEscape("remark42-" + req.Comment.Locator.SiteID + "-" + req.Comment.ID)
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), txnID)
+	err = m.retry(ctx, 3, func() error { return m.request(ctx, http.MethodPut, path, msg, nil) })
+	return errors.Wrapf(err, "failed to send matrix notification about %s", req.Comment.ID)
+}
+
+// room returns id of the room, resolving the room alias once
+func (m *Matrix) room(ctx context.Context) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.roomID != "" {
+		return m.roomID, nil
+	}
+	alias := struct {
+		RoomID string `json:"room_id"`
+	}{}
+	err := m.retry(ctx, 5, func() error {
+		return m.request(ctx, http.MethodGet, "/directory/room/"+url.PathEscape(m.Room), nil, &alias)
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "can't resolve matrix room alias %s", m.Room)
+	}
+	m.roomID = alias.RoomID
+	return m.roomID, nil
+}
+
+// retry repeats fn on network errors, 5xx and 429 responses. Other 4xx responses returned right away,
+// as repeating the same request won't help.
+func (m *Matrix) retry(ctx context.Context, repeats int, fn func() error) error {
+	var rejected error
+	err := repeater.NewDefault(repeats, time.Millisecond*250).Do(ctx, func() error {
+		err := fn()
+		var se *matrixStatusError
+		if errors.As(err, &se) && se.code >= 400 && se.code < 500 && se.code != http.StatusTooManyRequests {
+			rejected = err
+			return errMatrixRejected
+		}
+		return err
+	}, errMatrixRejected)
+	if rejected != nil {
+		return rejected
+	}
+	return err
+}
+
+// request makes authorized call to the homeserver's client-server API, with JSON body and response
+func (m *Matrix) request(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "can't marshal matrix request")
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, m.Server+matrixAPIPrefix+path, reqBody)
+	if err != nil {
+		return errors.Wrap(err, "failed to make matrix request")
+	}
+	r.Header.Set("Authorization", "Bearer "+m.Token)
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to get matrix response")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		mErr := struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}{}
+		if e := json.NewDecoder(resp.Body).Decode(&mErr); e == nil && mErr.ErrCode != "" {
+			return &matrixStatusError{code: resp.StatusCode,
+				msg: fmt.Sprintf("unexpected matrix status code %d, %s: %s", resp.StatusCode, mErr.ErrCode, mErr.Error)}
+		}
+		return &matrixStatusError{code: resp.StatusCode, msg: fmt.Sprintf("unexpected matrix status code %d", resp.StatusCode)}
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(result), "can't decode matrix response")
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// buildMatrixMessage makes message with html body and plain text fallback
+func buildMatrixMessage(req Request) matrixMessage {
+	from := req.Comment.User.Name
+	if req.Comment.ParentID != "" {
+		from += " → " + req.parent.User.Name
+	}
+	link := req.Comment.Locator.URL + uiNav + req.Comment.ID
+	title := "original comment"
+	if req.Comment.PostTitle != "" {
+		title = req.Comment.PostTitle
+	}
+	text := req.Comment.Text
+	if text == "" {
+		text = html.EscapeString(req.Comment.Orig)
+	}
+	return matrixMessage{
+		MsgType: "m.text",
+		Body:    fmt.Sprintf("%s\n\n%s\n\n↦ %s: %s", from, html.UnescapeString(req.Comment.Orig), title, link),
+		Format:  "org.matrix.custom.html",
+		FormattedBody: fmt.Sprintf("<b>%s</b><br/><br/>%s<br/><br/>↦ <a href=\"%s\">%s</a>",
+			html.EscapeString(from), text, html.EscapeString(link), html.EscapeString(title)),
+	}
+}
+
+// SendVerification is not implemented for matrix
+func (m *Matrix) SendVerification(_ context.Context, _ VerificationRequest) error {
+	return nil
+}
+
+func (m *Matrix) String() string {
+	return "matrix: " + m.Room
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestMatrix_New(t *testing.T) {
+	ts := mockMatrixServer(t, nil)
+	defer ts.Close()
+
+	_, err := NewMatrix(MatrixParams{Server: ts.URL, Token: "token"})
+	assert.EqualError(t, err, "matrix server, token and room should be set")
+
+	m, err := NewMatrix(MatrixParams{Server: ts.URL + "/", Token: "token", Room: "!room1:example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "!room1:example.com", m.roomID)
+	assert.Equal(t, ts.URL, m.Server)
+	assert.Equal(t, 5*time.Second, m.Timeout)
+	assert.Equal(t, "matrix: !room1:example.com", m.String())
+
+	m, err = NewMatrix(MatrixParams{Server: ts.URL, Token: "token", Room: "#comments:example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "", m.roomID, "alias resolved on the first send")
+	roomID, err := m.room(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "!resolved:example.com", roomID)
+	assert.Equal(t, "!resolved:example.com", m.roomID)
+
+	m, err = NewMatrix(MatrixParams{Server: ts.URL, Token: "bad", Room: "#comments:example.com"})
+	require.NoError(t, err, "homeserver not called")
+	err = m.Send(context.Background(), Request{Comment: store.Comment{ID: "c1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't resolve matrix room alias #comments:example.com: unexpected matrix status code 401, "+
+		"M_UNKNOWN_TOKEN: Invalid access token")
+}
+
+func TestMatrix_Retries(t *testing.T) {
+	var lock sync.Mutex
+	calls := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls[r.URL.Path]++
+		switch {
+		case strings.Contains(r.URL.Path, "/rooms/!busy:example.com/") && calls[r.URL.Path] == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests"}`))
+		case strings.Contains(r.URL.Path, "/rooms/!busy:example.com/"):
+			_, _ = w.Write([]byte(`{"event_id":"$event:example.com"}`))
+		case strings.Contains(r.URL.Path, "/rooms/!down:example.com/"):
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Room not found"}`))
+		}
+	}))
+	defer ts.Close()
+
+	send := func(room string) error {
+		m, err := NewMatrix(MatrixParams{Server: ts.URL, Token: "token", Room: room})
+		require.NoError(t, err)
+		return m.Send(context.Background(), Request{Comment: store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark"}}})
+	}
+	count := func(path string) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return calls["/_matrix/client/r0"+path]
+	}
+
+	assert.NoError(t, send("!busy:example.com"))
+	assert.Equal(t, 2, count("/rooms/!busy:example.com/send/m.room.message/remark42-remark-c1"), "retried on 429")
+
+	assert.Error(t, send("!down:example.com"))
+	assert.Equal(t, 3, count("/rooms/!down:example.com/send/m.room.message/remark42-remark-c1"), "retried on 5xx")
+
+	err := send("!gone:example.com")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected matrix status code 404, M_NOT_FOUND: Room not found")
+	assert.Equal(t, 1, count("/rooms/!gone:example.com/send/m.room.message/remark42-remark-c1"), "not retried on 404")
+
+	assert.Error(t, send("#gone:example.com"))
+	assert.Equal(t, 1, count("/directory/room/#gone:example.com"), "alias resolution not retried on 404")
+}
+
+func TestMatrix_Send(t *testing.T) {
+	var lock sync.Mutex
+	sent := map[string]matrixMessage{}
+	ts := mockMatrixServer(t, func(txn string, msg matrixMessage) {
+		lock.Lock()
+		sent[txn] = msg
+		lock.Unlock()
+	})
+	defer ts.Close()
+
+	m, err := NewMatrix(MatrixParams{Server: ts.URL, Token: "token", Room: "!room1:example.com"})
+	require.NoError(t, err)
+
+	c := store.Comment{ID: "c2", ParentID: "c1", Orig: "some **text** & more", Text: "<p>some <strong>text</strong> &amp; more</p>",
+		PostTitle: "Post <title>", Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}, User: store.User{Name: "user2"}}
+	req := Request{Comment: c, parent: store.Comment{ID: "c1", User: store.User{Name: "user1"}}}
+	require.NoError(t, m.Send(context.Background(), req))
+	require.NoError(t, m.Send(context.Background(), req), "repeated send with the same transaction")
+	require.Equal(t, 1, len(sent))
+	assert.Equal(t, matrixMessage{
+		MsgType: "m.text",
+		Body:    "user2 → user1\n\nsome **text** & more\n\n↦ Post <title>: https://example.com/post#remark42__comment-c2",
+		Format:  "org.matrix.custom.html",
+		FormattedBody: "<b>user2 → user1</b><br/><br/><p>some <strong>text</strong> &amp; more</p><br/><br/>" +
+			"↦ <a href=\"https://example.com/post#remark42__comment-c2\">Post &lt;title&gt;</a>",
+	}, sent["remark42-remark-c2"])
+
+	require.NoError(t, m.Send(context.Background(), Request{Comment: store.Comment{ID: "c3", Orig: "a<b",
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}, User: store.User{Name: "user3"}}}))
+	assert.Equal(t, "<b>user3</b><br/><br/>a&lt;b<br/><br/>↦ <a href=\"https://example.com/post#remark42__comment-c3\">original comment</a>",
+		sent["remark42-remark-c3"].FormattedBody)
+
+	m, err = NewMatrix(MatrixParams{Server: ts.URL, Token: "token", Room: "!forbidden:example.com"})
+	require.NoError(t, err)
+	err = m.Send(context.Background(), Request{Comment: store.Comment{ID: "c4"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to send matrix notification about c4: unexpected matrix status code 403, M_FORBIDDEN")
+	assert.NoError(t, m.SendVerification(context.Background(), VerificationRequest{}))
+}
+
+func mockMatrixServer(t *testing.T, onSend func(txn string, msg matrixMessage)) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/r0/directory/room/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid access token"}`))
+			return
+		}
+		assert.Equal(t, "/_matrix/client/r0/directory/room/#comments:example.com", r.URL.Path)
+		_, _ = w.Write([]byte(`{"room_id":"!resolved:example.com","servers":["example.com"]}`))
+	})
+	mux.HandleFunc("/_matrix/client/r0/rooms/!room1:example.com/send/m.room.message/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		msg := matrixMessage{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		txn := r.URL.Path[len("/_matrix/client/r0/rooms/!room1:example.com/send/m.room.message/"):]
+		onSend(txn, msg)
+		_, _ = w.Write([]byte(`{"event_id":"$event:example.com"}`))
+	})
+	mux.HandleFunc("/_matrix/client/r0/rooms/!forbidden:example.com/send/m.room.message/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errcode":"M_FORBIDDEN","error":"User not in room"}`))
+	})
+	return httptest.NewServer(mux)
+}
@@ -18,6 +18,14 @@ type Service struct {
 	destinations      []Destination
 	queue             chan Request
 	verificationQueue chan VerificationRequest
+	events            chan Event
+	filter            *Filter // selects admin notifications per destination, optional
+
+	jobs    QueueParams   // persistent queue, used instead of in-memory channels if Store is set
+	destIDs []string      // ids of destinations in the queued jobs, see destinationIDs
+	wake    chan struct{} // signals new job in the persistent queue
+	stop    chan struct{}
+	stopped chan struct{}
 
 	closed uint32 // non-zero means closed. uses uint instead of bool for atomic
 	ctx    context.Context
@@ -31,17 +39,53 @@ type Destination interface {
 	SendVerification(context.Context, VerificationRequest) error
 }
 
+// digestRunner is implemented by destinations delivering accumulated notifications on schedule
+type digestRunner interface {
+	RunDigests(ctx context.Context)
+}
+
 // Store defines the minimal interface accessing stored comments used by notifier
 type Store interface {
 	Get(locator store.Locator, id string, user store.User) (store.Comment, error)
 	GetUserEmail(siteID string, userID string) (string, error)
+	IsMentionsOff(siteID string, userID string) bool
+	Followers(locator store.Locator) ([]store.Follower, error)
+	DigestPeriod(siteID string, userID string) string
+	GetUserTelegram(siteID string, userID string) (string, error)
+	WebPush(siteID string, userID string) ([]store.PushSubscription, error)
+	Locale(siteID string, userID string) string
 }
 
 // Request notification for a Comment
 type Request struct {
-	Comment     store.Comment
-	parent      store.Comment
-	Emails      []string
+	Comment   store.Comment
+	parent    store.Comment
+	Emails    []string
+	Mentions  []Mention         // mentioned users, not notified about the reply already
+	Mentioned []string          // ids of mentioned users, except the comment's author and users opted-out of mentions
+	Followers []store.Follower  // users following the post or the site, not notified about the comment already, email may be empty
+	Digests   map[string]string // digest period per recipient's email, recipients without it notified immediately
+	Locales   map[string]string // locale per recipient's email, recipients without it get default templates
+	Telegrams []string          // telegram chats of users replied to, mentioned and followers, linked their telegram accounts
+	Pushes    []Push            // browser push subscriptions of users replied to, mentioned and followers
+	Replied   []string          // ids of users replied to, except the comment's author
+	Edited    bool              // comment edited, only users mentioned in it the first time notified
+
+	replyUsers    map[string]string // user id per email in Emails
+	adminFiltered bool              // admin notification filtered out for the destination, users still notified
+}
+
+// Push is the browser push subscription of the user notified about the comment
+type Push struct {
+	UserID       string
+	Kind         string // why the user notified, InboxReply, InboxMention or InboxFollow
+	Subscription store.PushSubscription
+}
+
+// Mention is the user mentioned in the comment
+type Mention struct {
+	UserID string
+	Email  string
 }
 
 // VerificationRequest notification for user
@@ -50,6 +94,7 @@ type VerificationRequest struct {
 	User   string
 	Email  string // if set, send email only
 	Token  string
+	Locale string // locale of the message, default templates used if empty
 }
 
 const defaultQueueSize = 100
@@ -65,6 +110,7 @@ func NewService(dataService Store, size int, destinations ...Destination) *Servi
 		dataService:       dataService,
 		queue:             make(chan Request, size),
 		verificationQueue: make(chan VerificationRequest, size),
+		events:            make(chan Event, size),
 		destinations:      destinations,
 		ctx:               ctx,
 		cancel:            cancel,
@@ -72,21 +118,55 @@ func NewService(dataService Store, size int, destinations ...Destination) *Servi
 	if len(destinations) > 0 {
 		go res.do()
 	}
+	res.runDigests()
 	log.Printf("[INFO] create notifier service, queue size=%d, destinations=%d", size, len(destinations))
 	return &res
 }
 
-// Submit Request to internal channel if not busy, drop if can't send
+// runDigests starts scheduled delivery for destinations supporting it
+func (s *Service) runDigests() {
+	for _, d := range s.destinations {
+		if dr, ok := d.(digestRunner); ok {
+			go dr.RunDigests(s.ctx)
+		}
+	}
+}
+
+// Submit Request to the persistent queue or to internal channel if not busy, drop if can't send
 func (s *Service) Submit(req Request) {
 	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
 		return
 	}
-	if s.dataService != nil && req.Comment.ParentID != "" {
+	if s.dataService != nil && req.Comment.ParentID != "" && !req.Edited {
 		if p, err := s.dataService.Get(req.Comment.Locator, req.Comment.ParentID, store.User{}); err == nil {
 			req.parent = p
-			req.Emails = deduplicateStrings(s.getNotificationEmails(req, p))
+			req.replyUsers = map[string]string{}
+			req.Emails = deduplicateStrings(s.getNotificationEmails(req, p, req.replyUsers))
+			req.Replied = deduplicateStrings(s.getReplyAuthors(req, p))
 		}
 	}
+	if s.dataService != nil && len(req.Comment.Mentions) > 0 {
+		req.Mentioned = s.getMentioned(req)
+		req.Mentions = s.getMentions(req)
+	}
+	if s.dataService != nil && !req.Edited {
+		req.Followers = s.getFollowers(req)
+	}
+	if s.dataService != nil {
+		req.Telegrams, req.Pushes = s.getUserChannels(req)
+	}
+	if s.dataService != nil {
+		req.Digests = s.getDigests(req)
+		req.Locales = s.getLocales(req)
+	}
+	if s.jobs.Store != nil {
+		job := Job{Request: &req, ReplyUsers: req.replyUsers}
+		if req.parent.ID != "" {
+			job.Parent = &req.parent
+		}
+		s.enqueue(job)
+		return
+	}
 	select {
 	case s.queue <- req:
 	default:
@@ -94,9 +174,9 @@ func (s *Service) Submit(req Request) {
 	}
 }
 
-// getNotificationEmails returns list of emails for notifications for provided comment.
+// getNotificationEmails returns list of emails for notifications for provided comment, users filled with user id per email.
 // Emails is not added to the returned list in case original message is from the same user as the notification receiver.
-func (s *Service) getNotificationEmails(req Request, notifyComment store.Comment) (result []string) {
+func (s *Service) getNotificationEmails(req Request, notifyComment store.Comment, users map[string]string) (result []string) {
 	// add current user email only if the user is not the one who wrote the original comment
 	if notifyComment.User.ID != req.Comment.User.ID {
 		email, err := s.dataService.GetUserEmail(req.Comment.Locator.SiteID, notifyComment.User.ID)
@@ -105,21 +185,195 @@ func (s *Service) getNotificationEmails(req Request, notifyComment store.Comment
 		}
 		if email != "" {
 			result = append(result, email)
+			users[email] = notifyComment.User.ID
+		}
+	}
+	if notifyComment.ParentID != "" {
+		if p, err := s.dataService.Get(req.Comment.Locator, notifyComment.ParentID, store.User{}); err == nil {
+			result = append(result, s.getNotificationEmails(req, p, users)...)
 		}
 	}
+	return result
+}
+
+// getReplyAuthors returns ids of authors of provided comment and all its parents, except the comment's author
+func (s *Service) getReplyAuthors(req Request, notifyComment store.Comment) (result []string) {
+	if notifyComment.User.ID != req.Comment.User.ID {
+		result = append(result, notifyComment.User.ID)
+	}
 	if notifyComment.ParentID != "" {
 		if p, err := s.dataService.Get(req.Comment.Locator, notifyComment.ParentID, store.User{}); err == nil {
-			result = append(result, s.getNotificationEmails(req, p)...)
+			result = append(result, s.getReplyAuthors(req, p)...)
+		}
+	}
+	return result
+}
+
+// getUserChannels returns telegram chats and browser push subscriptions of the users replied to, mentioned
+// and followers, each user notified once, for the first reason of these
+func (s *Service) getUserChannels(req Request) (telegrams []string, pushes []Push) {
+	notified := map[string]bool{}
+	add := func(userIDs []string, kind string) {
+		for _, userID := range userIDs {
+			if notified[userID] {
+				continue
+			}
+			notified[userID] = true
+			if chat := s.getNotificationTelegram(req, userID); chat != "" {
+				telegrams = append(telegrams, chat)
+			}
+			pushes = append(pushes, s.getNotificationPushes(req, userID, kind)...)
 		}
 	}
+	add(req.Replied, InboxReply)
+	add(req.Mentioned, InboxMention)
+	followers := make([]string, 0, len(req.Followers))
+	for _, f := range req.Followers {
+		followers = append(followers, f.UserID)
+	}
+	add(followers, InboxFollow)
+	return deduplicateStrings(telegrams), pushes
+}
+
+// getNotificationTelegram returns telegram chat of the user, empty if the user didn't link telegram account
+func (s *Service) getNotificationTelegram(req Request, userID string) string {
+	chat, err := s.dataService.GetUserTelegram(req.Comment.Locator.SiteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't read telegram for %s, %v", userID, err)
+	}
+	return chat
+}
+
+// getNotificationPushes returns browser push subscriptions of the user
+func (s *Service) getNotificationPushes(req Request, userID, kind string) (result []Push) {
+	subs, err := s.dataService.WebPush(req.Comment.Locator.SiteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't read push subscriptions for %s, %v", userID, err)
+	}
+	for _, sub := range subs {
+		result = append(result, Push{UserID: userID, Kind: kind, Subscription: sub})
+	}
 	return result
 }
 
-// SubmitVerification to internal channel if not busy, drop if can't send
+// getMentioned returns ids of mentioned users, except the comment's author and users opted-out of mentions
+func (s *Service) getMentioned(req Request) (result []string) {
+	for _, userID := range req.Comment.Mentions {
+		if userID == req.Comment.User.ID || s.dataService.IsMentionsOff(req.Comment.Locator.SiteID, userID) {
+			continue
+		}
+		result = append(result, userID)
+	}
+	return result
+}
+
+// getMentions returns mentioned users subscribed by email, except users already notified about the reply
+func (s *Service) getMentions(req Request) (result []Mention) {
+	notified := map[string]bool{}
+	for _, email := range req.Emails {
+		notified[email] = true
+	}
+	for _, userID := range req.Mentioned {
+		email, err := s.dataService.GetUserEmail(req.Comment.Locator.SiteID, userID)
+		if err != nil {
+			log.Printf("[WARN] can't read email for %s, %v", userID, err)
+			continue
+		}
+		if email == "" || notified[email] {
+			continue
+		}
+		notified[email] = true
+		result = append(result, Mention{UserID: userID, Email: email})
+	}
+	return result
+}
+
+// getFollowers returns followers of the post, except the comment's author and users already notified
+// about the comment as a reply or a mention. Followers without email kept for other destinations.
+func (s *Service) getFollowers(req Request) (result []store.Follower) {
+	followers, err := s.dataService.Followers(req.Comment.Locator)
+	if err != nil {
+		log.Printf("[WARN] can't get followers of %s, %v", req.Comment.Locator.URL, err)
+		return nil
+	}
+	notifiedUsers := map[string]bool{req.Comment.User.ID: true}
+	for _, userID := range append(append([]string{}, req.Replied...), req.Mentioned...) {
+		notifiedUsers[userID] = true
+	}
+	notifiedEmails := map[string]bool{}
+	for _, email := range req.Emails {
+		notifiedEmails[email] = true
+	}
+	for _, m := range req.Mentions {
+		notifiedEmails[m.Email] = true
+	}
+	for _, f := range followers {
+		if notifiedUsers[f.UserID] || (f.Email != "" && notifiedEmails[f.Email]) {
+			continue
+		}
+		notifiedUsers[f.UserID] = true
+		if f.Email != "" {
+			notifiedEmails[f.Email] = true
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// getDigests returns digest period per email for recipients who chose digest delivery
+func (s *Service) getDigests(req Request) map[string]string {
+	var result map[string]string
+	for userID, email := range recipients(req) {
+		if period := s.dataService.DigestPeriod(req.Comment.Locator.SiteID, userID); IsDigestPeriod(period) {
+			if result == nil {
+				result = map[string]string{}
+			}
+			result[email] = period
+		}
+	}
+	return result
+}
+
+// getLocales returns locale per email for recipients who set it
+func (s *Service) getLocales(req Request) map[string]string {
+	var result map[string]string
+	for userID, email := range recipients(req) {
+		if locale := s.dataService.Locale(req.Comment.Locator.SiteID, userID); locale != "" {
+			if result == nil {
+				result = map[string]string{}
+			}
+			result[email] = locale
+		}
+	}
+	return result
+}
+
+// recipients returns email per user id for all email recipients of the request
+func recipients(req Request) map[string]string {
+	users := map[string]string{}
+	for email, userID := range req.replyUsers {
+		users[userID] = email
+	}
+	for _, m := range req.Mentions {
+		users[m.UserID] = m.Email
+	}
+	for _, f := range req.Followers {
+		if f.Email != "" {
+			users[f.UserID] = f.Email
+		}
+	}
+	return users
+}
+
+// SubmitVerification to the persistent queue or to internal channel if not busy, drop if can't send
 func (s *Service) SubmitVerification(req VerificationRequest) {
 	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
 		return
 	}
+	if s.jobs.Store != nil {
+		s.enqueue(Job{Verification: &req})
+		return
+	}
 	select {
 	case s.verificationQueue <- req:
 	default:
@@ -127,12 +381,27 @@ func (s *Service) SubmitVerification(req VerificationRequest) {
 	}
 }
 
-// Close queue channel and wait for completion
+// Close queue channel and wait for completion. Persistent queue makes the last delivery attempt
+// of pending jobs and closed after it.
 func (s *Service) Close() {
+	if s.jobs.Store != nil {
+		if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+			return
+		}
+		log.Print("[DEBUG] close queued notifier")
+		close(s.stop)
+		<-s.stopped
+		s.cancel()
+		if err := s.jobs.Store.Close(); err != nil {
+			log.Printf("[WARN] can't close notification queue, %v", err)
+		}
+		return
+	}
 	if s.queue != nil {
 		log.Print("[DEBUG] close notifier")
 		close(s.queue)
 		close(s.verificationQueue)
+		close(s.events)
 		s.cancel()
 		<-s.ctx.Done()
 	}
@@ -151,7 +420,7 @@ func (s *Service) do() {
 			wg.Add(len(s.destinations))
 			for _, dest := range s.destinations {
 				go func(d Destination) {
-					if err := d.Send(s.ctx, c); err != nil {
+					if err := d.Send(s.ctx, s.filterRequest(d, c)); err != nil {
 						log.Printf("[WARN] failed to send to %s, %s", d, err)
 					}
 					wg.Done()
@@ -172,12 +441,78 @@ func (s *Service) do() {
 				}(dest)
 			}
 			wg.Wait()
+		case e, ok := <-s.events:
+			if !ok {
+				return
+			}
+			for _, dest := range s.destinations {
+				ed, ok := dest.(eventDestination)
+				if !ok || !s.filterEvent(dest, e) {
+					continue
+				}
+				wg.Add(1)
+				go func(d Destination, ed eventDestination) {
+					if err := ed.SendEvent(s.ctx, e); err != nil {
+						log.Printf("[WARN] failed to send event to %s, %s", d, err)
+					}
+					wg.Done()
+				}(dest, ed)
+			}
+			wg.Wait()
 		case <-s.ctx.Done():
 			return
 		}
 	}
 }
 
+// WithFilter sets filter of admin notifications, should be called before submitting notifications
+func (s *Service) WithFilter(f *Filter) *Service {
+	s.filter = f
+	return s
+}
+
+// CheckFilter returns the result of admin notifications filter for the new comment per destination
+func (s *Service) CheckFilter(comment store.Comment) []FilterMatch {
+	res := make([]FilterMatch, 0, len(s.destinations))
+	for _, d := range s.destinations {
+		m := FilterMatch{Destination: destinationType(d), Send: true}
+		if s.filter != nil {
+			m.Rule, m.Send = s.filter.match(m.Destination, requestSubject(Request{Comment: comment}))
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// filterRequest marks request as filtered out for admin notifications of the destination.
+// Admins notified about the edited comment when it was posted, so it's always filtered out.
+func (s *Service) filterRequest(d Destination, req Request) Request {
+	if req.Edited {
+		req.adminFiltered = true
+		return req
+	}
+	if s.filter == nil {
+		return req
+	}
+	if _, send := s.filter.match(destinationType(d), requestSubject(req)); !send {
+		log.Printf("[DEBUG] admin notification about %s filtered out for %s", req.Comment.ID, d)
+		req.adminFiltered = true
+	}
+	return req
+}
+
+// filterEvent checks if the event should be sent to the destination
+func (s *Service) filterEvent(d Destination, e Event) bool {
+	if s.filter == nil {
+		return true
+	}
+	_, send := s.filter.match(destinationType(d), eventSubject(e))
+	if !send {
+		log.Printf("[DEBUG] event %s for %s filtered out for %s", e.Type, e.SiteID, d)
+	}
+	return send
+}
+
 // NopService is do-nothing notifier, without destinations
 var NopService = &Service{}
 
@@ -196,6 +196,37 @@ func TestService_EmailRetrieval(t *testing.T) {
 	s.Close()
 }
 
+func TestService_TelegramRetrieval(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, telegrams: map[string]string{}}
+
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", ParentID: "p2", User: store.User{ID: "u1"}}
+	dataStore.data["p4"] = store.Comment{ID: "p4", ParentID: "p3", User: store.User{ID: "u3"}}
+	dataStore.telegrams["u1"] = "1001"
+	dataStore.telegrams["u2"] = "1002"
+
+	s := NewService(dataStore, 1, dest)
+	assert.NotNil(t, s)
+
+	s.Submit(Request{Comment: dataStore.data["p1"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Submit(Request{Comment: dataStore.data["p3"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Submit(Request{Comment: dataStore.data["p4"]})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 3, len(destRes), "three comments notified")
+	assert.Empty(t, destRes[0].Telegrams, "no parent, nobody to notify")
+	assert.Equal(t, []string{"1002"}, destRes[1].Telegrams, "author u1 not notified about own reply")
+	assert.ElementsMatch(t, []string{"1001", "1002"}, destRes[2].Telegrams, "all users in the thread notified once")
+	assert.Equal(t, []string{"u2"}, destRes[1].Replied)
+	assert.ElementsMatch(t, []string{"u1", "u2"}, destRes[2].Replied)
+}
+
 func TestService_Recursive(t *testing.T) {
 	dest := &MockDest{id: 1}
 	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{}}
@@ -269,6 +300,134 @@ func TestService_Recursive(t *testing.T) {
 	s.Close()
 }
 
+func TestService_Mentions(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{}, mentionsOff: map[string]bool{}}
+
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.emailData["u1"] = "u1@example.com"
+	dataStore.emailData["u2"] = "u2@example.com"
+	dataStore.emailData["u3"] = "u3@example.com"
+	dataStore.emailData["u4"] = ""
+	dataStore.mentionsOff["u3"] = true
+
+	s := NewService(dataStore, 1, dest)
+	assert.NotNil(t, s)
+
+	// reply to u1 mentioning u1, u2, u3 (opted-out), u4 (no email), u5 (unknown) and the author
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u6"},
+		Mentions: []string{"u1", "u2", "u3", "u4", "u5", "u6"}}})
+	time.Sleep(time.Millisecond * 110)
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, []string{"u1@example.com"}, destRes[0].Emails)
+	assert.Equal(t, []Mention{{UserID: "u2", Email: "u2@example.com"}}, destRes[0].Mentions, "u1 notified about the reply only")
+	assert.Equal(t, []string{"u1", "u2", "u4", "u5"}, destRes[0].Mentioned, "author and opted-out u3 excluded")
+	s.Close()
+}
+
+func TestService_MentionsChannels(t *testing.T) {
+	dest := &MockDest{id: 1}
+	sub := store.PushSubscription{Endpoint: "https://fcm.googleapis.com/u3"}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{}, mentionsOff: map[string]bool{},
+		telegrams: map[string]string{"u1": "1001", "u2": "1002", "u4": "1004"},
+		pushes:    map[string][]store.PushSubscription{"u1": {{Endpoint: "https://fcm.googleapis.com/u1"}}, "u3": {sub}}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.mentionsOff["u4"] = true
+
+	s := NewService(dataStore, 1, dest)
+	// reply to u1 mentioning u1, telegram-only u2, push-only u3 and opted-out u4
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u5"},
+		Mentions: []string{"u1", "u2", "u3", "u4"}}})
+	time.Sleep(time.Millisecond * 110)
+	// mention without reply
+	s.Submit(Request{Comment: store.Comment{ID: "c2", User: store.User{ID: "u5"}, Mentions: []string{"u2"}}})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 2, len(destRes))
+	assert.ElementsMatch(t, []string{"1001", "1002"}, destRes[0].Telegrams, "replied and mentioned notified once")
+	assert.Equal(t, []Push{{UserID: "u1", Kind: InboxReply, Subscription: store.PushSubscription{Endpoint: "https://fcm.googleapis.com/u1"}},
+		{UserID: "u3", Kind: InboxMention, Subscription: sub}}, destRes[0].Pushes)
+	assert.Equal(t, []string{"1002"}, destRes[1].Telegrams)
+	assert.Empty(t, destRes[1].Pushes)
+}
+
+func TestService_Followers(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{}, mentionsOff: map[string]bool{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.emailData["u1"] = "u1@example.com"
+	dataStore.emailData["u2"] = "u2@example.com"
+	dataStore.followers = []store.Follower{
+		{UserID: "u1", Email: "u1@example.com"},
+		{UserID: "u2", Email: "u2@example.com"},
+		{UserID: "u3", Email: "u3@example.com", FollowSite: true},
+		{UserID: "u4"},
+		{UserID: "u5", Email: "u5@example.com"},
+	}
+
+	s := NewService(dataStore, 1, dest)
+	assert.NotNil(t, s)
+
+	// u5 replies to u1 mentioning u2
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u5"}, Mentions: []string{"u2"}}})
+	time.Sleep(time.Millisecond * 110)
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, []store.Follower{{UserID: "u3", Email: "u3@example.com", FollowSite: true}, {UserID: "u4"}}, destRes[0].Followers,
+		"reply and mention recipients and author skipped")
+	s.Close()
+}
+
+func TestService_FollowersChannels(t *testing.T) {
+	dest := &MockDest{id: 1}
+	sub := store.PushSubscription{Endpoint: "https://fcm.googleapis.com/u3"}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{}, mentionsOff: map[string]bool{},
+		telegrams: map[string]string{"u1": "1001", "u2": "1002"},
+		pushes:    map[string][]store.PushSubscription{"u1": {{Endpoint: "https://fcm.googleapis.com/u1"}}, "u3": {sub}}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	// u1 replied to and follows, telegram-only u2 and push-only u3 follow the post
+	dataStore.followers = []store.Follower{{UserID: "u1"}, {UserID: "u2"}, {UserID: "u3", FollowSite: true}, {UserID: "u5"}}
+
+	s := NewService(dataStore, 1, dest)
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u5"}}})
+	time.Sleep(time.Millisecond * 110)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, []store.Follower{{UserID: "u2"}, {UserID: "u3", FollowSite: true}}, destRes[0].Followers)
+	assert.ElementsMatch(t, []string{"1001", "1002"}, destRes[0].Telegrams, "each user notified once")
+	assert.Equal(t, []Push{{UserID: "u1", Kind: InboxReply, Subscription: store.PushSubscription{Endpoint: "https://fcm.googleapis.com/u1"}},
+		{UserID: "u3", Kind: InboxFollow, Subscription: sub}}, destRes[0].Pushes)
+}
+
+func TestService_Digests(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{}, mentionsOff: map[string]bool{}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.emailData["u1"] = "u1@example.com"
+	dataStore.emailData["u2"] = "u2@example.com"
+	dataStore.followers = []store.Follower{{UserID: "u3", Email: "u3@example.com"}}
+	dataStore.digests = map[string]string{"u1": "daily", "u2": "bad", "u3": "weekly"}
+	dataStore.locales = map[string]string{"u2": "de", "u3": "pt-br"}
+
+	s := NewService(dataStore, 1, dest)
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u5"}, Mentions: []string{"u2"}}})
+	time.Sleep(time.Millisecond * 110)
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, map[string]string{"u1@example.com": "daily", "u3@example.com": "weekly"}, destRes[0].Digests,
+		"unknown period means immediate delivery")
+	assert.Equal(t, map[string]string{"u2@example.com": "de", "u3@example.com": "pt-br"}, destRes[0].Locales)
+	s.Close()
+}
+
 func TestService_Nop(t *testing.T) {
 	s := NopService
 	s.Submit(Request{Comment: store.Comment{}})
@@ -277,8 +436,14 @@ func TestService_Nop(t *testing.T) {
 }
 
 type mockStore struct {
-	data      map[string]store.Comment
-	emailData map[string]string
+	data        map[string]store.Comment
+	emailData   map[string]string
+	mentionsOff map[string]bool
+	followers   []store.Follower
+	digests     map[string]string
+	telegrams   map[string]string
+	pushes      map[string][]store.PushSubscription
+	locales     map[string]string
 }
 
 func (m mockStore) Get(_ store.Locator, id string, _ store.User) (store.Comment, error) {
@@ -296,3 +461,27 @@ func (m mockStore) GetUserEmail(_, userID string) (string, error) {
 	}
 	return email, nil
 }
+
+func (m mockStore) IsMentionsOff(_, userID string) bool {
+	return m.mentionsOff[userID]
+}
+
+func (m mockStore) Followers(store.Locator) ([]store.Follower, error) {
+	return m.followers, nil
+}
+
+func (m mockStore) DigestPeriod(_, userID string) string {
+	return m.digests[userID]
+}
+
+func (m mockStore) GetUserTelegram(_, userID string) (string, error) {
+	return m.telegrams[userID], nil
+}
+
+func (m mockStore) WebPush(_, userID string) ([]store.PushSubscription, error) {
+	return m.pushes[userID], nil
+}
+
+func (m mockStore) Locale(_, userID string) string {
+	return m.locales[userID]
+}
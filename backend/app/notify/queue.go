@@ -0,0 +1,360 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// QueueStore keeps notifications pending delivery and the dead-letter list of failed ones
+type QueueStore interface {
+	Put(job *Job) error            // adds or updates pending job, sets ID for the new one
+	Pending() ([]Job, error)       // all pending jobs, oldest first
+	Delete(id string) error        // removes delivered job
+	Bury(job Job) error            // moves job from pending to dead letters
+	Dead() ([]Job, error)          // all dead jobs, oldest first
+	Replay(id string) (Job, error) // moves dead job back to pending with failed deliveries reset
+	Close() error
+}
+
+// Job is a notification persisted in the queue with delivery state per destination
+type Job struct {
+	ID           string               `json:"id"`
+	Request      *Request             `json:"request,omitempty"`
+	Verification *VerificationRequest `json:"verification,omitempty"`
+	Event        *Event               `json:"event,omitempty"`
+	Parent       *store.Comment       `json:"parent,omitempty"`      // parent of the request's comment
+	ReplyUsers   map[string]string    `json:"reply_users,omitempty"` // user id per email in request's Emails
+	Created      time.Time            `json:"created"`
+	Deliveries   map[string]*Delivery `json:"deliveries"` // keyed by destination's id, see destinationIDs
+}
+
+// Delivery is the state of job's delivery to a single destination
+type Delivery struct {
+	Attempts  int       `json:"attempts"`
+	NextTry   time.Time `json:"next_try"`
+	LastError string    `json:"last_error,omitempty"`
+	Done      bool      `json:"done,omitempty"`
+	Failed    bool      `json:"failed,omitempty"` // all attempts used
+
+	Recipients []string `json:"recipients,omitempty"` // recipients already got the notification, skipped on retry
+}
+
+// deliveryRecipients tracks recipients of the job's delivery to a single destination,
+// passed to the destination in context by the queue
+type deliveryRecipients struct {
+	sync.Mutex
+	st *Delivery
+}
+
+type recipientsCtxKey struct{}
+
+// QueueParams defines persistent queue and retries of failed deliveries
+type QueueParams struct {
+	Store        QueueStore
+	MaxAttempts  int           // delivery attempts per destination before the job goes to dead letters
+	MinBackoff   time.Duration // delay before the first retry, doubled for each next one
+	MaxBackoff   time.Duration // max delay between retries
+	DrainTimeout time.Duration // time to deliver pending jobs on close
+}
+
+const (
+	defaultMaxAttempts  = 5
+	defaultMinBackoff   = 30 * time.Second
+	defaultMaxBackoff   = time.Hour
+	defaultDrainTimeout = 10 * time.Second
+	queueCheckInterval  = time.Second
+)
+
+// SiteID returns site of the job's comment or verification
+func (j Job) SiteID() string {
+	if j.Verification != nil {
+		return j.Verification.SiteID
+	}
+	if j.Event != nil {
+		return j.Event.SiteID
+	}
+	if j.Request != nil {
+		return j.Request.Comment.Locator.SiteID
+	}
+	return ""
+}
+
+// NewQueuedService makes notification service with the persistent queue. Notifications delivered to each destination
+// separately, failed deliveries retried with exponential backoff and jobs failed for any destination moved to dead letters.
+func NewQueuedService(dataService Store, params QueueParams, destinations ...Destination) *Service {
+	if params.MaxAttempts <= 0 {
+		params.MaxAttempts = defaultMaxAttempts
+	}
+	if params.MinBackoff <= 0 {
+		params.MinBackoff = defaultMinBackoff
+	}
+	if params.MaxBackoff < params.MinBackoff {
+		params.MaxBackoff = defaultMaxBackoff
+		if params.MaxBackoff < params.MinBackoff {
+			params.MaxBackoff = params.MinBackoff
+		}
+	}
+	if params.DrainTimeout <= 0 {
+		params.DrainTimeout = defaultDrainTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res := Service{
+		dataService:  dataService,
+		destinations: destinations,
+		jobs:         params,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		destIDs:      destinationIDs(destinations),
+	}
+	go res.doQueue()
+	res.runDigests()
+	log.Printf("[INFO] create queued notifier service, max attempts=%d, destinations=%d", params.MaxAttempts, len(destinations))
+	return &res
+}
+
+// Failed returns dead-letter jobs of the site
+func (s *Service) Failed(siteID string) ([]Job, error) {
+	if s.jobs.Store == nil {
+		return nil, errors.New("persistent notification queue is not enabled")
+	}
+	jobs, err := s.jobs.Store.Dead()
+	if err != nil {
+		return nil, err
+	}
+	res := []Job{}
+	for _, j := range jobs {
+		if j.SiteID() == siteID {
+			res = append(res, j)
+		}
+	}
+	return res, nil
+}
+
+// Replay moves dead-letter job of the site back to the queue for delivery to the failed destinations
+func (s *Service) Replay(siteID, id string) error {
+	if s.jobs.Store == nil {
+		return errors.New("persistent notification queue is not enabled")
+	}
+	jobs, err := s.Failed(siteID)
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		if j.ID != id {
+			continue
+		}
+		if _, err = s.jobs.Store.Replay(id); err != nil {
+			return err
+		}
+		s.wakeUp()
+		return nil
+	}
+	return errors.Errorf("failed notification %s not found", id)
+}
+
+// enqueue puts a new job to the persistent queue with pending delivery to all destinations
+func (s *Service) enqueue(job Job) {
+	job.Created = time.Now()
+	job.Deliveries = map[string]*Delivery{}
+	for i, d := range s.destinations {
+		if _, ok := d.(eventDestination); job.Event != nil && !ok {
+			continue
+		}
+		job.Deliveries[s.destIDs[i]] = &Delivery{}
+	}
+	if err := s.jobs.Store.Put(&job); err != nil {
+		log.Printf("[WARN] can't put notification to queue, %v", err)
+		return
+	}
+	s.wakeUp()
+}
+
+func (s *Service) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// doQueue delivers due jobs on wake up and periodically. On stop makes the last attempt limited by DrainTimeout,
+// jobs not delivered stay in the queue for the next start.
+func (s *Service) doQueue() {
+	defer close(s.stopped)
+	defer log.Print("[WARN] terminated queued notifier")
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.deliverPending(s.ctx, time.Now())
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		case <-s.stop:
+			ctx, cancel := context.WithTimeout(context.Background(), s.jobs.DrainTimeout)
+			s.deliverPending(ctx, time.Now())
+			cancel()
+			return
+		}
+	}
+}
+
+func (s *Service) deliverPending(ctx context.Context, now time.Time) {
+	jobs, err := s.jobs.Store.Pending()
+	if err != nil {
+		log.Printf("[WARN] can't get pending notifications, %v", err)
+		return
+	}
+	for i := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		s.deliver(ctx, &jobs[i], now)
+	}
+}
+
+// deliver sends the job to all destinations due at the given time and saves updated delivery state.
+// Delivered job removed from the queue, job with all attempts used by any destination moved to dead letters.
+func (s *Service) deliver(ctx context.Context, job *Job, now time.Time) {
+	var wg sync.WaitGroup
+	sent := false
+	for i, dest := range s.destinations {
+		st, ok := job.Deliveries[s.destIDs[i]]
+		if !ok || st.Done || st.Failed || st.NextTry.After(now) {
+			continue
+		}
+		sent = true
+		wg.Add(1)
+		go func(d Destination, st *Delivery) {
+			defer wg.Done()
+			st.Attempts++
+			err := s.send(context.WithValue(ctx, recipientsCtxKey{}, &deliveryRecipients{st: st}), d, *job)
+			if err == nil {
+				st.Done, st.LastError = true, ""
+				return
+			}
+			st.LastError = err.Error()
+			if st.Attempts >= s.jobs.MaxAttempts {
+				st.Failed = true
+				log.Printf("[WARN] failed to send notification %s to %s after %d attempts, %s", job.ID, d, st.Attempts, err)
+				return
+			}
+			st.NextTry = now.Add(s.backoff(st.Attempts))
+			log.Printf("[WARN] failed to send notification %s to %s, retry at %s, %s", job.ID, d, st.NextTry.Format(time.RFC3339), err)
+		}(dest, st)
+	}
+	wg.Wait()
+
+	pending, failed := false, false
+	for _, id := range s.destIDs {
+		if st, ok := job.Deliveries[id]; ok {
+			pending = pending || (!st.Done && !st.Failed)
+			failed = failed || st.Failed
+		}
+	}
+
+	var err error
+	switch {
+	case pending && sent:
+		err = s.jobs.Store.Put(job)
+	case pending:
+		return
+	case failed:
+		err = s.jobs.Store.Bury(*job)
+	default:
+		err = s.jobs.Store.Delete(job.ID)
+	}
+	if err != nil {
+		log.Printf("[WARN] can't update notification %s in queue, %v", job.ID, err)
+	}
+}
+
+func (s *Service) send(ctx context.Context, d Destination, job Job) error {
+	if job.Verification != nil {
+		return d.SendVerification(ctx, *job.Verification)
+	}
+	if job.Event != nil {
+		ed, ok := d.(eventDestination)
+		if !ok {
+			return errors.Errorf("%s doesn't support events", d)
+		}
+		if !s.filterEvent(d, *job.Event) {
+			return nil
+		}
+		return ed.SendEvent(ctx, *job.Event)
+	}
+	if job.Request == nil {
+		return errors.Errorf("empty notification %s", job.ID)
+	}
+	req := *job.Request
+	if job.Parent != nil {
+		req.parent = *job.Parent
+	}
+	req.replyUsers = job.ReplyUsers
+	return d.Send(ctx, s.filterRequest(d, req))
+}
+
+// backoff returns delay before the next delivery attempt, doubled with each failed attempt up to MaxBackoff
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.jobs.MinBackoff
+	for i := 1; i < attempts && delay < s.jobs.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.jobs.MaxBackoff {
+		delay = s.jobs.MaxBackoff
+	}
+	return delay
+}
+
+// destinationIDs returns ids keying deliveries of the queued jobs, stable across restarts with the same configuration.
+// Id is the kind of the destination, like "email" or "telegram", with the number for several destinations of the same kind.
+func destinationIDs(destinations []Destination) []string {
+	res := make([]string, 0, len(destinations))
+	seen := map[string]int{}
+	for _, d := range destinations {
+		kind := fmt.Sprintf("%T", d)
+		kind = strings.ToLower(kind[strings.LastIndex(kind, ".")+1:])
+		seen[kind]++
+		if seen[kind] > 1 {
+			kind = fmt.Sprintf("%s#%d", kind, seen[kind])
+		}
+		res = append(res, kind)
+	}
+	return res
+}
+
+// delivered checks if the recipient got the queued notification in the previous attempt,
+// always false for notifications sent without the queue
+func delivered(ctx context.Context, recipient string) bool {
+	dr, ok := ctx.Value(recipientsCtxKey{}).(*deliveryRecipients)
+	if !ok {
+		return false
+	}
+	dr.Lock()
+	defer dr.Unlock()
+	for _, r := range dr.st.Recipients {
+		if r == recipient {
+			return true
+		}
+	}
+	return false
+}
+
+// markDelivered records delivery of the queued notification to the recipient, to skip it on retry.
+// Destinations sending to several recipients call it for each successful one.
+func markDelivered(ctx context.Context, recipient string) {
+	if dr, ok := ctx.Value(recipientsCtxKey{}).(*deliveryRecipients); ok {
+		dr.Lock()
+		dr.st.Recipients = append(dr.st.Recipients, recipient)
+		dr.Unlock()
+	}
+}
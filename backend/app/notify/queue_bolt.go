@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltQueue implements QueueStore with bolt DB. Pending and dead jobs kept in separate buckets
+// with zero-padded sequence number as the key, so iteration goes from the oldest job.
+type BoltQueue struct {
+	fileName string
+	db       *bolt.DB
+}
+
+const (
+	queueBucketName = "queue"
+	deadBucketName  = "dead"
+)
+
+// NewBoltQueue makes bolt notification queue
+func NewBoltQueue(fileName string, options bolt.Options) (*BoltQueue, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range []string{queueBucketName, deadBucketName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create bucket %s", bktName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltQueue{db: db, fileName: fileName}, nil
+}
+
+// Put adds or updates pending job, ID set for the new one
+func (b *BoltQueue) Put(job *Job) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(queueBucketName))
+		if job.ID == "" {
+			seq, err := bkt.NextSequence()
+			if err != nil {
+				return errors.Wrap(err, "can't make job id")
+			}
+			job.ID = fmt.Sprintf("%020d", seq)
+		}
+		return b.save(bkt, *job)
+	})
+}
+
+// Pending returns all pending jobs
+func (b *BoltQueue) Pending() ([]Job, error) {
+	return b.list(queueBucketName)
+}
+
+// Delete removes pending job
+func (b *BoltQueue) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return errors.Wrapf(tx.Bucket([]byte(queueBucketName)).Delete([]byte(id)), "can't delete job %s", id)
+	})
+}
+
+// Bury moves job to dead letters
+func (b *BoltQueue) Bury(job Job) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(queueBucketName)).Delete([]byte(job.ID)); err != nil {
+			return errors.Wrapf(err, "can't delete job %s", job.ID)
+		}
+		return b.save(tx.Bucket([]byte(deadBucketName)), job)
+	})
+}
+
+// Dead returns all dead jobs
+func (b *BoltQueue) Dead() ([]Job, error) {
+	return b.list(deadBucketName)
+}
+
+// Replay moves dead job back to pending, failed deliveries get all attempts again and keep delivered recipients
+func (b *BoltQueue) Replay(id string) (job Job, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket([]byte(deadBucketName))
+		data := dead.Get([]byte(id))
+		if data == nil {
+			return errors.Errorf("no dead job %s", id)
+		}
+		if e := json.Unmarshal(data, &job); e != nil {
+			return errors.Wrapf(e, "can't unmarshal job %s", id)
+		}
+		for _, d := range job.Deliveries {
+			if d.Failed {
+				*d = Delivery{LastError: d.LastError, Recipients: d.Recipients}
+			}
+		}
+		if e := dead.Delete([]byte(id)); e != nil {
+			return errors.Wrapf(e, "can't delete dead job %s", id)
+		}
+		return b.save(tx.Bucket([]byte(queueBucketName)), job)
+	})
+	return job, err
+}
+
+// Close bolt store
+func (b *BoltQueue) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
+
+func (b *BoltQueue) save(bkt *bolt.Bucket, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal job %s", job.ID)
+	}
+	return errors.Wrapf(bkt.Put([]byte(job.ID), data), "can't put job %s", job.ID)
+}
+
+func (b *BoltQueue) list(bktName string) (res []Job, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bktName)).ForEach(func(k, v []byte) error {
+			job := Job{}
+			if e := json.Unmarshal(v, &job); e != nil {
+				return errors.Wrapf(e, "can't unmarshal job %s", k)
+			}
+			res = append(res, job)
+			return nil
+		})
+	})
+	return res, err
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBoltQueue_PutBuryReplay(t *testing.T) {
+	fileName := os.TempDir() + "/test-queue.db"
+	defer os.Remove(fileName)
+	b, err := NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	j1 := Job{Request: &Request{Comment: store.Comment{ID: "c1"}}, Deliveries: map[string]*Delivery{"d1": {}, "d2": {}}}
+	require.NoError(t, b.Put(&j1))
+	assert.Equal(t, "00000000000000000001", j1.ID)
+	j2 := Job{Verification: &VerificationRequest{SiteID: "remark", User: "u1"}, Deliveries: map[string]*Delivery{"d1": {}}}
+	require.NoError(t, b.Put(&j2))
+	assert.Equal(t, "00000000000000000002", j2.ID)
+
+	j1.Deliveries["d1"].Done = true
+	j1.Deliveries["d2"].Attempts = 1
+	require.NoError(t, b.Put(&j1))
+	assert.Equal(t, "00000000000000000001", j1.ID, "id kept on update")
+
+	pending, err := b.Pending()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(pending))
+	assert.Equal(t, "c1", pending[0].Request.Comment.ID)
+	assert.True(t, pending[0].Deliveries["d1"].Done)
+	assert.Equal(t, 1, pending[0].Deliveries["d2"].Attempts)
+	assert.Equal(t, "u1", pending[1].Verification.User)
+
+	require.NoError(t, b.Delete(j2.ID))
+	j1.Deliveries["d2"] = &Delivery{Attempts: 5, Failed: true, LastError: "smtp down", Recipients: []string{"reply:a@example.com"}}
+	require.NoError(t, b.Bury(j1))
+	pending, err = b.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	dead, err := b.Dead()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(dead))
+	assert.Equal(t, j1.ID, dead[0].ID)
+
+	_, err = b.Replay("bad")
+	assert.Error(t, err)
+	job, err := b.Replay(j1.ID)
+	require.NoError(t, err)
+	assert.True(t, job.Deliveries["d1"].Done, "delivered destination not reset")
+	assert.Equal(t, Delivery{LastError: "smtp down", Recipients: []string{"reply:a@example.com"}}, *job.Deliveries["d2"],
+		"delivered recipients kept")
+	dead, err = b.Dead()
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+	pending, err = b.Pending()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pending))
+	assert.Equal(t, job, pending[0])
+
+	require.NoError(t, b.Close())
+}
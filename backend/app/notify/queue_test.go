@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_QueuedRetries(t *testing.T) {
+	fileName := os.TempDir() + "/test-notify-queue.db"
+	defer os.Remove(fileName)
+	q, err := NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	ok, flaky := &MockDest{id: 1}, &failingDest{failures: 2}
+	s := NewQueuedService(nil, QueueParams{Store: q, MaxAttempts: 3, MinBackoff: 10 * time.Millisecond}, ok, flaky)
+	s.Submit(Request{Comment: store.Comment{ID: "100", Locator: store.Locator{SiteID: "remark"}}})
+	s.SubmitVerification(VerificationRequest{SiteID: "remark", User: "u1"})
+
+	assert.Eventually(t, func() bool { return len(flaky.get()) == 1 && len(flaky.getVerify()) == 1 },
+		5*time.Second, 10*time.Millisecond, "delivered after retries")
+	assert.Equal(t, 1, len(ok.Get()), "delivered once to healthy destination")
+	assert.Equal(t, 1, len(ok.GetVerify()))
+	assert.Eventually(t, func() bool { p, e := q.Pending(); return e == nil && len(p) == 0 }, time.Second, 10*time.Millisecond)
+	failed, err := s.Failed("remark")
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	s.Close()
+	s.Submit(Request{Comment: store.Comment{ID: "111"}}) // safe to send after close
+}
+
+func TestService_QueuedDeadLetters(t *testing.T) {
+	fileName := os.TempDir() + "/test-notify-queue.db"
+	defer os.Remove(fileName)
+	q, err := NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	ok, broken := &MockDest{id: 1}, &failingDest{failures: 2}
+	s := NewQueuedService(nil, QueueParams{Store: q, MaxAttempts: 2, MinBackoff: 10 * time.Millisecond}, ok, broken)
+	s.Submit(Request{Comment: store.Comment{ID: "100", Locator: store.Locator{SiteID: "remark"}}})
+
+	var failed []Job
+	assert.Eventually(t, func() bool { failed, err = s.Failed("remark"); return err == nil && len(failed) == 1 },
+		5*time.Second, 10*time.Millisecond, "moved to dead letters")
+	assert.Equal(t, "100", failed[0].Request.Comment.ID)
+	assert.True(t, failed[0].Deliveries["mockdest"].Done)
+	assert.True(t, failed[0].Deliveries["failingdest"].Failed)
+	assert.Equal(t, 2, failed[0].Deliveries["failingdest"].Attempts)
+	assert.Equal(t, "failed to send", failed[0].Deliveries["failingdest"].LastError)
+	other, err := s.Failed("other")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+
+	assert.Error(t, s.Replay("other", failed[0].ID), "job of another site")
+	require.NoError(t, s.Replay("remark", failed[0].ID))
+	assert.Eventually(t, func() bool { return len(broken.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, len(ok.Get()), "not sent again to delivered destination")
+	failed, err = s.Failed("remark")
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	s.Close()
+
+	_, err = NopService.Failed("remark")
+	assert.Error(t, err)
+	assert.Error(t, NopService.Replay("remark", "1"))
+}
+
+func TestService_QueuedRestart(t *testing.T) {
+	fileName := os.TempDir() + "/test-notify-queue.db"
+	defer os.Remove(fileName)
+	q, err := NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	d := &failingDest{failures: 1}
+	s := NewQueuedService(nil, QueueParams{Store: q, MinBackoff: time.Hour}, d)
+	s.Submit(Request{Comment: store.Comment{ID: "100"}})
+	assert.Eventually(t, func() bool { return d.attempts() == 1 }, time.Second, 10*time.Millisecond)
+	s.Close() // retry is not due, job kept in the queue
+	assert.Empty(t, d.get())
+
+	q, err = NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+	pending, err := q.Pending()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pending))
+	pending[0].Deliveries["failingdest"].NextTry = time.Time{}
+	require.NoError(t, q.Put(&pending[0]))
+
+	s = NewQueuedService(nil, QueueParams{Store: q}, d)
+	assert.Eventually(t, func() bool { return len(d.get()) == 1 }, time.Second, 10*time.Millisecond, "delivered after restart")
+	s.Close()
+	assert.Equal(t, "100", d.get()[0].Comment.ID)
+}
+
+func TestService_QueuedDrainOnClose(t *testing.T) {
+	fileName := os.TempDir() + "/test-notify-queue.db"
+	defer os.Remove(fileName)
+	q, err := NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	d := &MockDest{id: 1}
+	s := NewQueuedService(nil, QueueParams{Store: q}, d)
+	for _, id := range []string{"100", "101", "102", "103", "104"} {
+		s.Submit(Request{Comment: store.Comment{ID: id}})
+	}
+	s.Close()
+	assert.Equal(t, 5, len(d.Get()), "all submitted delivered on close")
+}
+
+func TestService_QueuedPartialDelivery(t *testing.T) {
+	fileName := os.TempDir() + "/test-notify-queue.db"
+	defer os.Remove(fileName)
+	q, err := NewBoltQueue(fileName, bolt.Options{})
+	require.NoError(t, err)
+
+	d := &recipientsDest{fail: map[string]int{"b@example.com": 1}}
+	s := NewQueuedService(nil, QueueParams{Store: q, MinBackoff: 10 * time.Millisecond}, d)
+	s.Submit(Request{Comment: store.Comment{ID: "100"}, Emails: []string{"a@example.com", "b@example.com"}})
+
+	assert.Eventually(t, func() bool { p, e := q.Pending(); return e == nil && len(p) == 0 }, 5*time.Second, 10*time.Millisecond)
+	s.Close()
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, d.get(), "retried for the failed recipient only")
+}
+
+func TestService_DestinationIDs(t *testing.T) {
+	ids := destinationIDs([]Destination{&MockDest{id: 1}, &Matrix{MatrixParams: MatrixParams{Room: "#room1"}}, &MockDest{id: 2}, &Matrix{MatrixParams: MatrixParams{Room: "#room2"}},
+		&failingDest{}})
+	assert.Equal(t, []string{"mockdest", "matrix", "mockdest#2", "matrix#2", "failingdest"}, ids)
+}
+
+func TestService_Backoff(t *testing.T) {
+	s := Service{jobs: QueueParams{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+	assert.Equal(t, time.Second, s.backoff(1))
+	assert.Equal(t, 2*time.Second, s.backoff(2))
+	assert.Equal(t, 8*time.Second, s.backoff(4))
+	assert.Equal(t, 10*time.Second, s.backoff(5))
+	assert.Equal(t, 10*time.Second, s.backoff(50))
+}
+
+// failingDest fails the first sends of each kind and records successful ones
+type failingDest struct {
+	failures     int
+	lock         sync.Mutex
+	sendCalls    int
+	verifyCalls  int
+	data         []Request
+	verification []VerificationRequest
+}
+
+func (f *failingDest) Send(_ context.Context, r Request) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sendCalls++
+	if f.sendCalls <= f.failures {
+		return errors.New("failed to send")
+	}
+	f.data = append(f.data, r)
+	return nil
+}
+
+func (f *failingDest) SendVerification(_ context.Context, v VerificationRequest) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.verifyCalls++
+	if f.verifyCalls <= f.failures {
+		return errors.New("failed to send")
+	}
+	f.verification = append(f.verification, v)
+	return nil
+}
+
+func (f *failingDest) get() []Request {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]Request{}, f.data...)
+}
+
+func (f *failingDest) getVerify() []VerificationRequest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]VerificationRequest{}, f.verification...)
+}
+
+func (f *failingDest) attempts() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.sendCalls
+}
+
+func (f *failingDest) String() string { return "failing" }
+
+// recipientsDest sends to request's emails, failing each recipient the given number of times
+type recipientsDest struct {
+	lock sync.Mutex
+	fail map[string]int
+	sent []string
+}
+
+func (r *recipientsDest) Send(ctx context.Context, req Request) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var err error
+	for _, email := range req.Emails {
+		if delivered(ctx, email) {
+			continue
+		}
+		if r.fail[email] > 0 {
+			r.fail[email]--
+			err = errors.New("failed to send")
+			continue
+		}
+		r.sent = append(r.sent, email)
+		markDelivered(ctx, email)
+	}
+	return err
+}
+
+func (r *recipientsDest) SendVerification(_ context.Context, _ VerificationRequest) error { return nil }
+
+func (r *recipientsDest) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.sent...)
+}
+
+func (r *recipientsDest) String() string { return "recipients" }
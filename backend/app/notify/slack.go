@@ -38,6 +38,9 @@ func NewSlack(token, channelName string, opts ...slack.Option) (*Slack, error) {
 
 // Send to Slack channel
 func (t *Slack) Send(ctx context.Context, req Request) error {
+	if req.adminFiltered {
+		return nil
+	}
 
 	log.Printf("[DEBUG] send slack notification, comment id %s", req.Comment.ID)
 
@@ -9,11 +9,15 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/go-pkgz/repeater"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
 )
 
 // TelegramParams contain settings for telegram notifications
@@ -22,21 +26,126 @@ type TelegramParams struct {
 	Token          string        // token for telegram bot API interactions
 	Timeout        time.Duration // http client timeout
 
+	UserNotifications bool          // send replies to users linked their telegram accounts with the bot
+	AdminButtons      bool          // add moderation buttons to admin notifications, presses handled by Run
+	Store             TelegramStore // comments sent with buttons and audit trail of presses, required for AdminButtons
+
 	apiPrefix string // changed only in tests
 }
 
 // Telegram implements notify.Destination for telegram
 type Telegram struct {
 	TelegramParams
+	botUsername string // bot username returned by getMe, used for deep links
+
+	lock         sync.Mutex
+	requests     map[string]tgRequest // pending account link requests by token
+	updateOffset int                  // offset of the next bot update to read
+}
+
+// TelegramAction is the moderation action requested by admin with the button of telegram notification
+type TelegramAction struct {
+	Type      string // one of TelegramAction* constants
+	Locator   store.Locator
+	CommentID string
+	UserID    string // author of the comment
+	AdminID   int64  // telegram id of the user pressed the button
+}
+
+// TelegramModerator performs moderation actions requested with buttons of admin notifications
+type TelegramModerator interface {
+	Moderate(act TelegramAction) error
+}
+
+// TelegramStore keeps comments sent to admin channel with buttons, to resolve button presses after restart,
+// and the audit trail of the presses
+type TelegramStore interface {
+	PutComment(c TelegramComment, ttl time.Duration) error // adds comment, drops ones sent more than ttl ago
+	GetComment(id string) (TelegramComment, error)
+	Audit(rec TelegramAuditRecord) error                   // adds record to audit trail of the site
+	AuditLog(siteID string) ([]TelegramAuditRecord, error) // audit trail of the site, from the latest record
+}
+
+// TelegramComment is the comment sent to admin channel with moderation buttons
+type TelegramComment struct {
+	ID      string        `json:"id"`
+	Locator store.Locator `json:"locator"`
+	UserID  string        `json:"user_id"` // author of the comment
+	Sent    time.Time     `json:"sent"`
+}
+
+// TelegramAuditRecord is an entry of the audit trail of moderation buttons presses
+type TelegramAuditRecord struct {
+	Time      time.Time     `json:"time"`
+	Action    string        `json:"action"`
+	Locator   store.Locator `json:"locator"`
+	CommentID string        `json:"comment_id"`
+	UserID    string        `json:"user_id"`  // author of the comment
+	AdminID   int64         `json:"admin_id"` // telegram id of the user pressed the button
+	Result    string        `json:"result"`   // result shown to the user pressed the button
+}
+
+// moderation actions of admin notification buttons
+const (
+	TelegramActionDelete   = "delete"
+	TelegramActionPin      = "pin"
+	TelegramActionBlockDay = "block1d"
+	TelegramActionBlock    = "block"
+)
+
+var tgActionResults = map[string]string{
+	TelegramActionDelete:   "comment deleted",
+	TelegramActionPin:      "comment pinned",
+	TelegramActionBlockDay: "user blocked for a day",
+	TelegramActionBlock:    "user blocked permanently",
+}
+
+type tgKeyboard struct {
+	InlineKeyboard [][]tgButton `json:"inline_keyboard"`
+}
+
+type tgButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type tgUpdate struct {
+	UpdateID int `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+	CallbackQuery *struct {
+		ID   string `json:"id"`
+		From struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+		Data string `json:"data"`
+	} `json:"callback_query"`
+}
+
+// tgRequest is a pending request to link user's telegram account, chatID set once the user started the bot with the token
+type tgRequest struct {
+	userID  string
+	siteID  string
+	chatID  string
+	expires time.Time
 }
 
 const telegramTimeOut = 5000 * time.Millisecond
 const telegramAPIPrefix = "https://api.telegram.org/bot"
+const telegramPollTimeout = 30 * time.Second  // long polling timeout of getUpdates
+const telegramButtonsTTL = 7 * 24 * time.Hour // buttons of older admin notifications not handled
 
 // NewTelegram makes telegram bot for notifications
 func NewTelegram(params TelegramParams) (*Telegram, error) {
-	res := Telegram{TelegramParams: params}
-	if _, err := strconv.ParseInt(res.AdminChannelID, 10, 64); err != nil {
+	if params.AdminButtons && params.Store == nil {
+		return nil, errors.New("telegram store is required for moderation buttons")
+	}
+	res := Telegram{TelegramParams: params, requests: map[string]tgRequest{}}
+	if _, err := strconv.ParseInt(res.AdminChannelID, 10, 64); err != nil && res.AdminChannelID != "" {
 		res.AdminChannelID = "@" + res.AdminChannelID // if channelID not a number enforce @ prefix
 	}
 
@@ -84,6 +193,7 @@ func NewTelegram(params TelegramParams) (*Telegram, error) {
 		if !tgResp.OK || !tgResp.Result.IsBot {
 			return errors.Errorf("unexpected telegram response %+v", tgResp)
 		}
+		res.botUsername = tgResp.Result.UserName
 		return nil
 	})
 
@@ -94,20 +204,58 @@ func NewTelegram(params TelegramParams) (*Telegram, error) {
 func (t *Telegram) Send(ctx context.Context, req Request) error {
 	var err error
 
-	if t.AdminChannelID != "" {
+	if t.AdminChannelID != "" && !req.adminFiltered && !delivered(ctx, t.AdminChannelID) {
 		err = t.sendAdminNotification(ctx, req)
 		if err != nil {
 			return errors.Wrapf(err, "problem sending admin telegram notification")
 		}
+		markDelivered(ctx, t.AdminChannelID)
+	}
+
+	if t.UserNotifications && len(req.Telegrams) > 0 {
+		err = t.sendUserNotifications(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "problem sending user telegram notification")
+		}
 	}
 
 	return nil
 }
 
+func (t *Telegram) sendUserNotifications(ctx context.Context, req Request) error {
+	log.Printf("[DEBUG] send user telegram notifications to %d chats, comment id %s", len(req.Telegrams), req.Comment.ID)
+
+	msg, err := buildTelegramMessage(req, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to make telegram message body")
+	}
+
+	errs := new(multierror.Error)
+	for _, chatID := range req.Telegrams {
+		if delivered(ctx, chatID) {
+			continue
+		}
+		if e := t.sendMessage(ctx, msg, chatID); e != nil {
+			errs = multierror.Append(errs, errors.Wrapf(e, "failed to send user notification about %s to %s", req.Comment.ID, chatID))
+			continue
+		}
+		markDelivered(ctx, chatID)
+	}
+	return errs.ErrorOrNil()
+}
+
 func (t *Telegram) sendAdminNotification(ctx context.Context, req Request) error {
 	log.Printf("[DEBUG] send admin telegram notification to %s, comment id %s", t.AdminChannelID, req.Comment.ID)
 
-	msg, err := buildTelegramMessage(req)
+	var keyboard *tgKeyboard
+	if t.AdminButtons {
+		keyboard = moderationKeyboard(req.Comment.ID)
+		c := TelegramComment{ID: req.Comment.ID, Locator: req.Comment.Locator, UserID: req.Comment.User.ID, Sent: time.Now()}
+		if err := t.Store.PutComment(c, telegramButtonsTTL); err != nil {
+			return errors.Wrapf(err, "can't keep comment %s for moderation buttons", req.Comment.ID)
+		}
+	}
+	msg, err := buildTelegramMessage(req, keyboard)
 	if err != nil {
 		return errors.Wrap(err, "failed to make telegram message body")
 	}
@@ -154,7 +302,18 @@ func (t *Telegram) sendMessage(ctx context.Context, b []byte, chatID string) err
 	return nil
 }
 
-func buildTelegramMessage(req Request) ([]byte, error) {
+// moderationKeyboard makes buttons with "action:comment-id" callback data, fits 64 bytes limit for uuid comment ids
+func moderationKeyboard(commentID string) *tgKeyboard {
+	button := func(text, action string) tgButton {
+		return tgButton{Text: text, CallbackData: action + ":" + commentID}
+	}
+	return &tgKeyboard{InlineKeyboard: [][]tgButton{
+		{button("Delete", TelegramActionDelete), button("Pin", TelegramActionPin)},
+		{button("Block for 1d", TelegramActionBlockDay), button("Block forever", TelegramActionBlock)},
+	}}
+}
+
+func buildTelegramMessage(req Request, keyboard *tgKeyboard) ([]byte, error) {
 	from := req.Comment.User.Name
 	if req.Comment.ParentID != "" {
 		from += " → " + req.parent.User.Name
@@ -168,8 +327,9 @@ func buildTelegramMessage(req Request) ([]byte, error) {
 	msg := fmt.Sprintf("%s\n\n%s\n\n%s", from, req.Comment.Orig, link)
 	msg = html.UnescapeString(msg)
 	body := struct {
-		Text string `json:"text"`
-	}{Text: msg}
+		Text        string      `json:"text"`
+		ReplyMarkup *tgKeyboard `json:"reply_markup,omitempty"`
+	}{Text: msg, ReplyMarkup: keyboard}
 	b, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
@@ -186,6 +346,214 @@ func escapeTitle(title string) string {
 	return res
 }
 
+// GetBotUsername returns username of the bot, users link their accounts by starting it with the token
+func (t *Telegram) GetBotUsername() string {
+	return t.botUsername
+}
+
+// AddToken registers request to link telegram account of the user, confirmed once the user
+// sends "/start <token>" to the bot, usually by following https://t.me/<bot>?start=<token> link
+func (t *Telegram) AddToken(token, userID, siteID string, expires time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for tkn, req := range t.requests {
+		if time.Now().After(req.expires) {
+			delete(t.requests, tkn)
+		}
+	}
+	t.requests[token] = tgRequest{userID: userID, siteID: siteID, expires: expires}
+}
+
+// CheckToken returns chat of the user confirmed the token and site of the request, error returned
+// if the token is unknown, expired, belongs to another user or not confirmed yet.
+// Tokens confirmed by the bot updates read by Run.
+func (t *Telegram) CheckToken(token, userID string) (chatID, siteID string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	req, ok := t.requests[token]
+	if !ok || req.userID != userID {
+		return "", "", errors.New("request is not found")
+	}
+	if time.Now().After(req.expires) {
+		delete(t.requests, token)
+		return "", "", errors.New("request expired")
+	}
+	if req.chatID == "" {
+		return "", "", errors.New("request is not verified yet")
+	}
+	delete(t.requests, token)
+	return req.chatID, req.siteID, nil
+}
+
+// Run reads bot updates with long polling till context cancelled. It is the only reader of the updates:
+// messages of users linking their accounts confirm tokens for CheckToken, presses of moderation buttons
+// of admin notifications handled by the moderator, nil moderator if buttons are not enabled.
+// Telegram allows the only consumer of bot updates, so the bot can't be used for anything else meanwhile.
+func (t *Telegram) Run(ctx context.Context, moderator TelegramModerator) {
+	log.Printf("[INFO] start telegram bot updates polling")
+
+	for {
+		updates, err := t.getUpdates(ctx, telegramPollTimeout)
+		if ctx.Err() != nil {
+			log.Printf("[INFO] telegram bot updates polling terminated")
+			return
+		}
+		if err != nil {
+			log.Printf("[WARN] can't get telegram updates, %v", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		for _, upd := range updates {
+			t.processUpdate(ctx, upd, moderator)
+		}
+	}
+}
+
+// getUpdates reads bot updates after the last read one, waiting up to timeout for new ones
+func (t *Telegram) getUpdates(ctx context.Context, timeout time.Duration) ([]tgUpdate, error) {
+	t.lock.Lock()
+	offset := t.updateOffset
+	t.lock.Unlock()
+
+	u := fmt.Sprintf("%s%s/getUpdates?offset=%d&timeout=%d&allowed_updates=%s", t.apiPrefix, t.Token, offset,
+		int(timeout.Seconds()), `["message","callback_query"]`)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make telegram request")
+	}
+	client := http.Client{Timeout: t.Timeout + timeout}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get telegram response")
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected telegram status code %d", resp.StatusCode)
+	}
+
+	tgResp := struct {
+		OK     bool       `json:"ok"`
+		Result []tgUpdate `json:"result"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&tgResp); err != nil {
+		return nil, errors.Wrap(err, "can't decode telegram response")
+	}
+	if !tgResp.OK {
+		return nil, errors.New("unexpected telegram response")
+	}
+
+	t.lock.Lock()
+	for _, upd := range tgResp.Result {
+		if upd.UpdateID >= t.updateOffset {
+			t.updateOffset = upd.UpdateID + 1
+		}
+	}
+	t.lock.Unlock()
+	return tgResp.Result, nil
+}
+
+// processUpdate sets chat for account link request started with "/start <token>" message
+// and performs moderation action of the pressed button
+func (t *Telegram) processUpdate(ctx context.Context, upd tgUpdate, moderator TelegramModerator) {
+	if upd.Message != nil {
+		elems := strings.Fields(upd.Message.Text)
+		if len(elems) != 2 || elems[0] != "/start" {
+			return
+		}
+		t.lock.Lock()
+		if req, ok := t.requests[elems[1]]; ok && req.chatID == "" {
+			req.chatID = strconv.FormatInt(upd.Message.Chat.ID, 10)
+			t.requests[elems[1]] = req
+		}
+		t.lock.Unlock()
+		return
+	}
+
+	if upd.CallbackQuery != nil {
+		text := t.moderate(upd.CallbackQuery.Data, upd.CallbackQuery.From.ID, moderator)
+		if err := t.answerCallback(ctx, upd.CallbackQuery.ID, text); err != nil {
+			log.Printf("[WARN] can't answer telegram callback, %v", err)
+		}
+	}
+}
+
+// moderate performs action of the button with "action:comment-id" data, returns the result shown to the admin.
+// Presses of known comments' buttons recorded to the audit trail of the site.
+func (t *Telegram) moderate(data string, adminID int64, moderator TelegramModerator) string {
+	elems := strings.SplitN(data, ":", 2)
+	if len(elems) != 2 || tgActionResults[elems[0]] == "" {
+		return "unknown action"
+	}
+	if moderator == nil || t.Store == nil {
+		return "moderation is not enabled"
+	}
+
+	c, err := t.Store.GetComment(elems[1])
+	if err != nil || time.Since(c.Sent) > telegramButtonsTTL {
+		return "comment is not known anymore, moderate it on the site"
+	}
+
+	act := TelegramAction{Type: elems[0], Locator: c.Locator, CommentID: c.ID, UserID: c.UserID, AdminID: adminID}
+	result := tgActionResults[act.Type]
+	if err = moderator.Moderate(act); err != nil {
+		log.Printf("[WARN] telegram moderation %s of %s by %d failed, %v", act.Type, act.CommentID, adminID, err)
+		result = "failed: " + err.Error()
+	}
+	rec := TelegramAuditRecord{Time: time.Now(), Action: act.Type, Locator: act.Locator, CommentID: act.CommentID,
+		UserID: act.UserID, AdminID: adminID, Result: result}
+	if err = t.Store.Audit(rec); err != nil {
+		log.Printf("[WARN] can't record telegram moderation %s of %s to audit, %v", act.Type, act.CommentID, err)
+	}
+	return result
+}
+
+// AuditLog returns audit trail of moderation buttons presses for the site, from the latest record
+func (t *Telegram) AuditLog(siteID string) ([]TelegramAuditRecord, error) {
+	if t.Store == nil {
+		return nil, errors.New("telegram moderation buttons are not enabled")
+	}
+	return t.Store.AuditLog(siteID)
+}
+
+// answerCallback shows the text to the admin pressed the button
+func (t *Telegram) answerCallback(ctx context.Context, callbackID, text string) error {
+	b, err := json.Marshal(struct {
+		CallbackQueryID string `json:"callback_query_id"`
+		Text            string `json:"text"`
+	}{CallbackQueryID: callbackID, Text: text})
+	if err != nil {
+		return errors.Wrap(err, "can't marshal telegram callback answer")
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, t.apiPrefix+t.Token+"/answerCallbackQuery", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "failed to make telegram request")
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	client := http.Client{Timeout: t.Timeout}
+	resp, err := client.Do(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to get telegram response")
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected telegram status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // SendVerification is not implemented for telegram
 func (t *Telegram) SendVerification(_ context.Context, _ VerificationRequest) error {
 	return nil
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltTelegram implements TelegramStore with bolt DB. Comments kept in a single bucket keyed by comment id,
+// audit trail of each site in the nested bucket keyed by sequence number.
+type BoltTelegram struct {
+	AuditSize int // max number of audit records kept for each site
+	fileName  string
+	db        *bolt.DB
+}
+
+const (
+	tgCommentsBucketName     = "comments"
+	tgAuditBucketName        = "audit"
+	defaultTelegramAuditSize = 10000
+)
+
+// NewBoltTelegram makes bolt store for telegram moderation buttons
+func NewBoltTelegram(fileName string, options bolt.Options) (*BoltTelegram, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bkt := range []string{tgCommentsBucketName, tgAuditBucketName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bkt)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bkt)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltTelegram{db: db, fileName: fileName, AuditSize: defaultTelegramAuditSize}, nil
+}
+
+// PutComment adds the comment and drops comments sent more than ttl ago
+func (b *BoltTelegram) PutComment(c TelegramComment, ttl time.Duration) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal comment %s", c.ID)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(tgCommentsBucketName))
+		expired := [][]byte{}
+		err := bkt.ForEach(func(k, v []byte) error {
+			old := TelegramComment{}
+			if e := json.Unmarshal(v, &old); e != nil || time.Since(old.Sent) > ttl {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "can't list comments")
+		}
+		for _, k := range expired {
+			if err = bkt.Delete(k); err != nil {
+				return errors.Wrapf(err, "can't delete expired comment %s", k)
+			}
+		}
+		return errors.Wrapf(bkt.Put([]byte(c.ID), data), "can't put comment %s", c.ID)
+	})
+}
+
+// GetComment returns the comment by id, error if not found
+func (b *BoltTelegram) GetComment(id string) (c TelegramComment, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(tgCommentsBucketName)).Get([]byte(id))
+		if data == nil {
+			return errors.Errorf("no comment %s", id)
+		}
+		return errors.Wrapf(json.Unmarshal(data, &c), "can't unmarshal comment %s", id)
+	})
+	return c, err
+}
+
+// Audit adds the record to audit trail of the site, the oldest record dropped if AuditSize reached
+func (b *BoltTelegram) Audit(rec TelegramAuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal audit record")
+	}
+	siteID := rec.Locator.SiteID
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, e := tx.Bucket([]byte(tgAuditBucketName)).CreateBucketIfNotExists([]byte(siteID))
+		if e != nil {
+			return errors.Wrapf(e, "can't create audit bucket for %s", siteID)
+		}
+		seq, e := bkt.NextSequence()
+		if e != nil {
+			return errors.Wrapf(e, "can't get sequence for %s", siteID)
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if e = bkt.Put(key, data); e != nil {
+			return errors.Wrapf(e, "can't put audit record for %s", siteID)
+		}
+		if b.AuditSize > 0 && seq > uint64(b.AuditSize) {
+			binary.BigEndian.PutUint64(key, seq-uint64(b.AuditSize))
+			return errors.Wrapf(bkt.Delete(key), "can't delete old audit record for %s", siteID)
+		}
+		return nil
+	})
+}
+
+// AuditLog returns audit trail of the site from the latest record
+func (b *BoltTelegram) AuditLog(siteID string) (res []TelegramAuditRecord, err error) {
+	res = []TelegramAuditRecord{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(tgAuditBucketName)).Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			rec := TelegramAuditRecord{}
+			if e := json.Unmarshal(v, &rec); e != nil {
+				return errors.Wrapf(e, "can't unmarshal audit record for %s", siteID)
+			}
+			res = append(res, rec)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Close bolt store
+func (b *BoltTelegram) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBoltTelegram_Comments(t *testing.T) {
+	b := prepTelegramStore(t)
+	loc := store.Locator{SiteID: "remark", URL: "https://example.com/post"}
+
+	require.NoError(t, b.PutComment(TelegramComment{ID: "c1", Locator: loc, UserID: "u1", Sent: time.Now().Add(-2 * time.Hour)}, time.Hour))
+	c, err := b.GetComment("c1")
+	require.NoError(t, err)
+	assert.Equal(t, "u1", c.UserID)
+	assert.Equal(t, loc, c.Locator)
+
+	require.NoError(t, b.PutComment(TelegramComment{ID: "c2", Locator: loc, UserID: "u2", Sent: time.Now()}, time.Hour))
+	_, err = b.GetComment("c1")
+	assert.EqualError(t, err, "no comment c1", "expired comment dropped")
+	c, err = b.GetComment("c2")
+	require.NoError(t, err)
+	assert.Equal(t, "u2", c.UserID)
+}
+
+func TestBoltTelegram_Audit(t *testing.T) {
+	b := prepTelegramStore(t)
+	b.AuditSize = 2
+
+	for _, id := range []string{"c1", "c2", "c3"} {
+		require.NoError(t, b.Audit(TelegramAuditRecord{Action: TelegramActionDelete, CommentID: id,
+			Locator: store.Locator{SiteID: "remark"}}))
+	}
+	require.NoError(t, b.Audit(TelegramAuditRecord{Action: TelegramActionPin, CommentID: "c4", Locator: store.Locator{SiteID: "other"}}))
+
+	recs, err := b.AuditLog("remark")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs), "the oldest record dropped")
+	assert.Equal(t, "c3", recs[0].CommentID)
+	assert.Equal(t, "c2", recs[1].CommentID)
+
+	recs, err = b.AuditLog("unknown")
+	require.NoError(t, err)
+	assert.Empty(t, recs)
+}
+
+func prepTelegramStore(t *testing.T) *BoltTelegram {
+	fileName := os.TempDir() + "/test-telegram.db"
+	_ = os.Remove(fileName)
+	st, err := NewBoltTelegram(fileName, bolt.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, st.Close())
+		_ = os.Remove(fileName)
+	})
+	return st
+}
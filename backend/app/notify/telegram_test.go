@@ -2,9 +2,13 @@ package notify
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -136,6 +140,187 @@ func TestTelegram_Send(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestTelegram_SendUser(t *testing.T) {
+	ts := mockTelegramServer()
+	defer ts.Close()
+
+	tb, err := NewTelegram(TelegramParams{
+		Token:             "good-token",
+		UserNotifications: true,
+		apiPrefix:         ts.URL + "/",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "", tb.AdminChannelID, "no @ for empty admin channel")
+	c := store.Comment{Text: "some text", ParentID: "1", ID: "999"}
+	c.User.Name = "from"
+	cp := store.Comment{Text: "some parent text"}
+	cp.User.Name = "to"
+
+	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp, Telegrams: []string{"1234", "5678"}})
+	assert.NoError(t, err)
+
+	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp, Telegrams: []string{"1234", "404"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to send user notification about 999 to 404")
+
+	tb.UserNotifications = false
+	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp, Telegrams: []string{"404"}})
+	assert.NoError(t, err, "user notifications disabled")
+}
+
+func TestTelegram_Token(t *testing.T) {
+	ts := mockTelegramServer()
+	defer ts.Close()
+
+	tb, err := NewTelegram(TelegramParams{
+		Token:             "good-token",
+		UserNotifications: true,
+		apiPrefix:         ts.URL + "/",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "remark42_test_bot", tb.GetBotUsername())
+
+	tb.AddToken("good-tkn", "user1", "site1", time.Now().Add(time.Minute))
+	tb.AddToken("other-tkn", "user2", "site1", time.Now().Add(time.Minute))
+	tb.AddToken("expired-tkn", "user3", "site1", time.Now().Add(-time.Minute))
+
+	_, _, err = tb.CheckToken("good-tkn", "user2")
+	assert.EqualError(t, err, "request is not found", "token of another user")
+	_, _, err = tb.CheckToken("expired-tkn", "user3")
+	assert.EqualError(t, err, "request expired")
+	_, _, err = tb.CheckToken("good-tkn", "user1")
+	assert.EqualError(t, err, "request is not verified yet", "updates are not read yet")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tb.Run(ctx, nil)
+
+	var chatID, siteID string
+	assert.Eventually(t, func() bool {
+		chatID, siteID, err = tb.CheckToken("good-tkn", "user1")
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "confirmed by the update read by Run")
+	assert.Equal(t, "1234", chatID)
+	assert.Equal(t, "site1", siteID)
+	tb.lock.Lock()
+	assert.Equal(t, 1002, tb.updateOffset)
+	tb.lock.Unlock()
+
+	_, _, err = tb.CheckToken("good-tkn", "user1")
+	assert.EqualError(t, err, "request is not found", "token used once")
+
+	_, _, err = tb.CheckToken("other-tkn", "user2")
+	assert.EqualError(t, err, "request is not verified yet")
+
+	tb.AddToken("third-tkn", "user3", "site1", time.Now().Add(time.Minute))
+	assert.Equal(t, 2, len(tb.requests), "expired request removed")
+}
+
+func TestTelegram_Moderation(t *testing.T) {
+	var lock sync.Mutex
+	var sent string
+	answers := map[string]string{}
+	router := chi.NewRouter()
+	router.Get("/good-token/getMe", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": true, "result": {"id": 707381019, "is_bot": true, "username": "remark42_test_bot"}}`))
+	})
+	router.Post("/good-token/sendMessage", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		sent = string(b)
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	})
+	router.Get("/good-token/getUpdates", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") != "0" {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"ok": true, "result": []}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok": true, "result": [
+			{"update_id": 1, "callback_query": {"id": "cb1", "from": {"id": 111}, "data": "delete:999"}},
+			{"update_id": 2, "callback_query": {"id": "cb2", "from": {"id": 222}, "data": "block1d:999"}},
+			{"update_id": 3, "callback_query": {"id": "cb3", "from": {"id": 111}, "data": "pin:888"}},
+			{"update_id": 4, "callback_query": {"id": "cb4", "from": {"id": 111}, "data": "bad"}}
+		]}`))
+	})
+	router.Post("/good-token/answerCallbackQuery", func(w http.ResponseWriter, r *http.Request) {
+		answer := struct {
+			ID   string `json:"callback_query_id"`
+			Text string `json:"text"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&answer)
+		lock.Lock()
+		answers[answer.ID] = answer.Text
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	_, err := NewTelegram(TelegramParams{Token: "good-token", AdminButtons: true, apiPrefix: ts.URL + "/"})
+	assert.EqualError(t, err, "telegram store is required for moderation buttons")
+
+	tgStore := prepTelegramStore(t)
+	tb, err := NewTelegram(TelegramParams{
+		AdminChannelID: "remark_test",
+		Token:          "good-token",
+		AdminButtons:   true,
+		Store:          tgStore,
+		apiPrefix:      ts.URL + "/",
+	})
+	require.NoError(t, err)
+
+	c := store.Comment{ID: "999", Orig: "some text", Locator: store.Locator{SiteID: "site1", URL: "https://example.com/post"}}
+	c.User.ID, c.User.Name = "user1", "from"
+	require.NoError(t, tb.Send(context.TODO(), Request{Comment: c}))
+	lock.Lock()
+	assert.Contains(t, sent, `"reply_markup":{"inline_keyboard":[[{"text":"Delete","callback_data":"delete:999"}`)
+	lock.Unlock()
+
+	// buttons handled by the new instance with the same store, i.e. after restart
+	tb, err = NewTelegram(TelegramParams{Token: "good-token", AdminButtons: true, Store: tgStore, apiPrefix: ts.URL + "/"})
+	require.NoError(t, err)
+	moderator := &mockModerator{fail: map[string]bool{TelegramActionBlockDay: true}}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	tb.Run(ctx, moderator)
+
+	lock.Lock()
+	assert.Equal(t, map[string]string{
+		"cb1": "comment deleted",
+		"cb2": "failed: can't block1d",
+		"cb3": "comment is not known anymore, moderate it on the site",
+		"cb4": "unknown action",
+	}, answers)
+	lock.Unlock()
+	require.Equal(t, 2, len(moderator.actions))
+	assert.Equal(t, TelegramAction{Type: TelegramActionDelete, Locator: c.Locator, CommentID: "999", UserID: "user1", AdminID: 111},
+		moderator.actions[0])
+	assert.Equal(t, int64(222), moderator.actions[1].AdminID)
+
+	recs, err := tb.AuditLog("site1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs), "presses of known comment's buttons recorded")
+	assert.Equal(t, TelegramActionBlockDay, recs[0].Action)
+	assert.Equal(t, "failed: can't block1d", recs[0].Result)
+	assert.Equal(t, TelegramAuditRecord{Time: recs[1].Time, Action: TelegramActionDelete, Locator: c.Locator, CommentID: "999",
+		UserID: "user1", AdminID: 111, Result: "comment deleted"}, recs[1])
+}
+
+type mockModerator struct {
+	actions []TelegramAction
+	fail    map[string]bool
+}
+
+func (m *mockModerator) Moderate(act TelegramAction) error {
+	m.actions = append(m.actions, act)
+	if m.fail[act.Type] {
+		return errors.New("can't " + act.Type)
+	}
+	return nil
+}
+
 func TestTelegram_SendVerification(t *testing.T) {
 	ts := mockTelegramServer()
 	defer ts.Close()
@@ -189,8 +374,25 @@ func mockTelegramServer() *httptest.Server {
 	})
 
 	router.Post("/good-token/sendMessage", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chat_id") == "404" {
+			w.WriteHeader(404)
+			return
+		}
 		_, _ = w.Write([]byte(`{"ok": true}`))
 	})
+	router.Get("/good-token/getUpdates", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") != "0" {
+			time.Sleep(50 * time.Millisecond) // long polling
+			_, _ = w.Write([]byte(`{"ok": true, "result": []}`))
+			return
+		}
+		s := `{"ok": true,
+				"result": [
+					{"update_id": 1000, "message": {"chat": {"id": 4321}, "text": "hello"}},
+					{"update_id": 1001, "message": {"chat": {"id": 1234}, "text": "/start good-tkn"}}
+				]}`
+		_, _ = w.Write([]byte(s))
+	})
 
 	return httptest.NewServer(router)
 }
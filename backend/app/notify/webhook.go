@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/repeater"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// WebhookParams contain settings for webhook notifications
+type WebhookParams struct {
+	Hooks   []Hook
+	Secret  string        // key for HMAC-SHA256 signature of the request body, no signature if empty
+	Timeout time.Duration // http client timeout
+	Retries int           // attempts for each request
+}
+
+// Hook is the URL receiving events of the site
+type Hook struct {
+	SiteID string // "*" for all sites
+	URL    string
+	Events []EventType // all events if empty
+}
+
+// Webhook implements notify.Destination posting events as JSON to the configured URLs
+type Webhook struct {
+	WebhookParams
+	client http.Client
+}
+
+const (
+	webhookTimeOut     = 5 * time.Second
+	webhookRetries     = 3
+	webhookSignHeader  = "X-Remark42-Signature"
+	webhookEventHeader = "X-Remark42-Event"
+)
+
+// NewWebhook makes webhook destination
+func NewWebhook(params WebhookParams) (*Webhook, error) {
+	for _, h := range params.Hooks {
+		if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+			return nil, errors.Errorf("invalid webhook url %q", h.URL)
+		}
+	}
+	if params.Timeout == 0 {
+		params.Timeout = webhookTimeOut
+	}
+	if params.Retries <= 0 {
+		params.Retries = webhookRetries
+	}
+	log.Printf("[DEBUG] create new webhook notifier for %d hooks, timeout=%s", len(params.Hooks), params.Timeout)
+	return &Webhook{WebhookParams: params, client: http.Client{Timeout: params.Timeout}}, nil
+}
+
+// ParseHook makes hook from "site:event1+event2:url" definition, with "*" for all sites and all events
+func ParseHook(def string) (Hook, error) {
+	elems := strings.SplitN(def, ":", 3)
+	if len(elems) != 3 || elems[0] == "" || elems[1] == "" {
+		return Hook{}, errors.Errorf("invalid webhook %q, should be site:events:url", def)
+	}
+	res := Hook{SiteID: elems[0], URL: elems[2]}
+	if elems[1] == "*" {
+		return res, nil
+	}
+	for _, e := range strings.Split(elems[1], "+") {
+		if !isEventType(EventType(e)) {
+			return Hook{}, errors.Errorf("unknown event %q in webhook %q", e, def)
+		}
+		res.Events = append(res.Events, EventType(e))
+	}
+	return res, nil
+}
+
+// Send posts comment.created event for the new comment
+func (w *Webhook) Send(ctx context.Context, req Request) error {
+	if req.adminFiltered {
+		return nil
+	}
+	c := req.Comment
+	return w.SendEvent(ctx, Event{Type: EventCommentCreated, Time: c.Timestamp, SiteID: c.Locator.SiteID,
+		URL: c.Locator.URL, Comment: &c, UserID: c.User.ID})
+}
+
+// SendVerification is not supported by webhook
+func (w *Webhook) SendVerification(_ context.Context, _ VerificationRequest) error {
+	return nil
+}
+
+// SendEvent posts event to all hooks of the site subscribed to it
+func (w *Webhook) SendEvent(ctx context.Context, e Event) error {
+	if e.Comment != nil {
+		c := *e.Comment
+		c.User.IP, c.Votes, c.VotedIPs = "", nil, nil
+		e.Comment = &c
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s event", e.Type)
+	}
+
+	result := new(multierror.Error)
+	for _, h := range w.Hooks {
+		if !h.match(e) || delivered(ctx, h.URL) {
+			continue
+		}
+		err = repeater.NewDefault(w.Retries, time.Millisecond*250).Do(ctx, func() error {
+			return w.post(ctx, h.URL, e.Type, body)
+		})
+		if err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "can't send %s event to %s", e.Type, h.URL))
+			continue
+		}
+		markDelivered(ctx, h.URL)
+	}
+	return result.ErrorOrNil()
+}
+
+func (w *Webhook) post(ctx context.Context, url string, event EventType, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "can't make request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, string(event))
+	if w.Secret != "" {
+		req.Header.Set(webhookSignHeader, "sha256="+Sign(w.Secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if e := resp.Body.Close(); e != nil {
+			log.Printf("[WARN] can't close webhook response body, %v", e)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns hex-encoded HMAC-SHA256 of the body, as sent in X-Remark42-Signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// String doesn't show hook urls as they may contain credentials
+func (w *Webhook) String() string {
+	return fmt.Sprintf("webhook: %d hooks", len(w.Hooks))
+}
+
+func (h Hook) match(e Event) bool {
+	if h.SiteID != "*" && h.SiteID != e.SiteID {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func isEventType(t EventType) bool {
+	for _, et := range EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
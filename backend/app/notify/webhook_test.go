@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestWebhook_ParseHook(t *testing.T) {
+	tbl := []struct {
+		def string
+		res Hook
+		err string
+	}{
+		{"remark:*:https://example.com/hook", Hook{SiteID: "remark", URL: "https://example.com/hook"}, ""},
+		{"*:comment.created+post.readonly:http://example.com:8080/hook?token=1",
+			Hook{SiteID: "*", URL: "http://example.com:8080/hook?token=1", Events: []EventType{EventCommentCreated, EventPostReadOnly}}, ""},
+		{"remark:comment.bad:https://example.com", Hook{}, `unknown event "comment.bad" in webhook "remark:comment.bad:https://example.com"`},
+		{"https://example.com", Hook{}, `invalid webhook "https://example.com", should be site:events:url`},
+		{"remark::https://example.com", Hook{}, `invalid webhook "remark::https://example.com", should be site:events:url`},
+	}
+	for i, tt := range tbl {
+		res, err := ParseHook(tt.def)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, "case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+}
+
+func TestWebhook_SendEvent(t *testing.T) {
+	var lock sync.Mutex
+	received := map[string][]Event{}
+	var fails int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "sha256="+Sign("secret", body), r.Header.Get("X-Remark42-Signature"))
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path == "/flaky" && fails < 2 {
+			fails++
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		e := Event{}
+		require.NoError(t, json.Unmarshal(body, &e))
+		assert.Equal(t, string(e.Type), r.Header.Get("X-Remark42-Event"))
+		received[r.URL.Path] = append(received[r.URL.Path], e)
+	}))
+	defer ts.Close()
+
+	_, err := NewWebhook(WebhookParams{Hooks: []Hook{{SiteID: "remark", URL: "ftp://example.com"}}})
+	assert.Error(t, err)
+
+	wh, err := NewWebhook(WebhookParams{Secret: "secret", Hooks: []Hook{
+		{SiteID: "remark", URL: ts.URL + "/all"},
+		{SiteID: "*", URL: ts.URL + "/flaky", Events: []EventType{EventCommentCreated}},
+		{SiteID: "other", URL: ts.URL + "/other"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "webhook: 3 hooks", wh.String())
+
+	c := store.Comment{ID: "c1", Text: "some text", Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"},
+		User: store.User{ID: "u1", Name: "user", IP: "127.0.0.1"}, Votes: map[string]bool{"u2": true},
+		Timestamp: time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)}
+	require.NoError(t, wh.Send(context.Background(), Request{Comment: c}))
+	require.NoError(t, wh.SendEvent(context.Background(), Event{Type: EventUserBlocked, SiteID: "remark", UserID: "u1", Status: true}))
+	require.NoError(t, wh.SendVerification(context.Background(), VerificationRequest{}))
+
+	lock.Lock()
+	require.Equal(t, 2, len(received["/all"]))
+	created := received["/all"][0]
+	assert.Equal(t, EventCommentCreated, created.Type)
+	assert.Equal(t, "https://example.com/post", created.URL)
+	assert.Equal(t, c.Timestamp, created.Time)
+	assert.Equal(t, "c1", created.Comment.ID)
+	assert.Equal(t, "", created.Comment.User.IP, "ip not sent")
+	assert.Empty(t, created.Comment.Votes, "votes not sent")
+	assert.Equal(t, Event{Type: EventUserBlocked, SiteID: "remark", UserID: "u1", Status: true}, received["/all"][1])
+	assert.Equal(t, 1, len(received["/flaky"]), "created only, after retries")
+	assert.Equal(t, 2, fails)
+	assert.Empty(t, received["/other"])
+	lock.Unlock()
+	assert.Equal(t, "127.0.0.1", c.User.IP, "original comment not changed")
+
+	wh, err = NewWebhook(WebhookParams{Secret: "secret", Retries: 2, Hooks: []Hook{{SiteID: "*", URL: ts.URL + "/broken"}}})
+	require.NoError(t, err)
+	err = wh.SendEvent(context.Background(), Event{Type: EventPostReadOnly, SiteID: "remark"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't send post.readonly event to "+ts.URL+"/broken: unexpected status 500")
+}
+
+func TestService_SubmitEvent(t *testing.T) {
+	var lock sync.Mutex
+	var received []Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		lock.Lock()
+		received = append(received, e)
+		lock.Unlock()
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookParams{Hooks: []Hook{{SiteID: "*", URL: ts.URL}}})
+	require.NoError(t, err)
+	d := &MockDest{id: 1}
+	s := NewService(nil, 10, d, wh)
+	s.SubmitEvent(Event{Type: EventCommentEdited, SiteID: "remark", Comment: &store.Comment{ID: "c1"}})
+	s.SubmitEvent(Event{Type: EventCommentVoted, SiteID: "remark", Comment: &store.Comment{ID: "c1"}, UserID: "u2", Status: true})
+	assert.Eventually(t, func() bool { lock.Lock(); defer lock.Unlock(); return len(received) == 2 }, time.Second, 10*time.Millisecond)
+	s.Close()
+	assert.Equal(t, EventCommentEdited, received[0].Type)
+	assert.False(t, received[0].Time.IsZero(), "time set on submit")
+	assert.Equal(t, EventCommentVoted, received[1].Type)
+	assert.Empty(t, d.Get(), "events not sent to destinations without events support")
+
+	s = NewService(nil, 10, d)
+	s.SubmitEvent(Event{Type: EventCommentEdited, SiteID: "remark"}) // ignored without event destinations
+	s.Close()
+	NopService.SubmitEvent(Event{Type: EventCommentEdited, SiteID: "remark"})
+}
@@ -0,0 +1,328 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// WebPushParams contain settings for browser push notifications
+type WebPushParams struct {
+	PublicKey  string        // VAPID public key, base64url encoded uncompressed P-256 point
+	PrivateKey string        // VAPID private key, base64url encoded
+	Subject    string        // contact of the server operator for push services, mailto: or https: url
+	TTL        time.Duration // how long push service keeps undelivered notification
+	Timeout    time.Duration // http client timeout
+	Store      PushStore     // optional, used to drop subscriptions gone from push service
+}
+
+// PushStore updates browser push subscriptions of the user
+type PushStore interface {
+	SetWebPush(siteID, userID string, sub store.PushSubscription, subscribe bool) error
+}
+
+// WebPush implements notify.Destination for browser push notifications, with VAPID identification
+// and message encryption defined by RFC 8292 and RFC 8291
+type WebPush struct {
+	WebPushParams
+	key    *ecdsa.PrivateKey
+	client http.Client
+}
+
+const (
+	webPushTimeOut    = 5 * time.Second
+	webPushTTL        = 24 * time.Hour
+	webPushJWTTTL     = 12 * time.Hour
+	webPushRecordSize = 4096
+	webPushTitleLen   = 100
+	webPushTextLen    = 200
+)
+
+// NewWebPush makes web push destination with VAPID keys made by GenerateVAPIDKeys
+func NewWebPush(params WebPushParams) (*WebPush, error) {
+	if params.PublicKey == "" || params.PrivateKey == "" || params.Subject == "" {
+		return nil, errors.New("web push keys and subject should be set")
+	}
+	key, err := parseVAPIDKeys(params.PublicKey, params.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if params.TTL == 0 {
+		params.TTL = webPushTTL
+	}
+	if params.Timeout == 0 {
+		params.Timeout = webPushTimeOut
+	}
+	log.Printf("[DEBUG] create new web push notifier, subject %s, ttl=%s, timeout=%s", params.Subject, params.TTL, params.Timeout)
+	return &WebPush{WebPushParams: params, key: key, client: http.Client{Timeout: params.Timeout}}, nil
+}
+
+// GenerateVAPIDKeys makes P-256 key pair for VAPID identification of the server, base64url encoded
+func GenerateVAPIDKeys() (publicKey, privateKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", errors.Wrap(err, "can't generate VAPID key")
+	}
+	publicKey = base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+	privateKey = base64.RawURLEncoding.EncodeToString(padBytes(key.D.Bytes(), 32))
+	return publicKey, privateKey, nil
+}
+
+// Send notification to browsers of the users replied to, mentioned and followers. Subscriptions rejected by push service
+// as gone are removed from the store.
+func (w *WebPush) Send(ctx context.Context, req Request) error {
+	if len(req.Pushes) == 0 {
+		return nil
+	}
+	payloads := map[string][]byte{} // message per kind of notification
+	errs := new(multierror.Error)
+	for _, p := range req.Pushes {
+		if delivered(ctx, p.Subscription.Endpoint) {
+			continue
+		}
+		payload, ok := payloads[p.Kind]
+		if !ok {
+			var err error
+			if payload, err = buildWebPushMessage(req, p.Kind); err != nil {
+				return errors.Wrap(err, "can't make web push message")
+			}
+			payloads[p.Kind] = payload
+		}
+		log.Printf("[DEBUG] send web push notification to %s, comment id %s", p.UserID, req.Comment.ID)
+		gone, err := w.push(ctx, p.Subscription, payload)
+		if err == nil {
+			markDelivered(ctx, p.Subscription.Endpoint)
+			continue
+		}
+		if gone && w.Store != nil {
+			log.Printf("[INFO] remove gone web push subscription of %s, %v", p.UserID, err)
+			if e := w.Store.SetWebPush(req.Comment.Locator.SiteID, p.UserID, p.Subscription, false); e != nil {
+				log.Printf("[WARN] can't remove web push subscription of %s, %v", p.UserID, e)
+			}
+			continue
+		}
+		errs = multierror.Append(errs, errors.Wrapf(err, "failed to send web push to %s", p.UserID))
+	}
+	return errs.ErrorOrNil()
+}
+
+// push sends encrypted message to the subscription endpoint, gone is true when push service
+// reports the subscription doesn't exist anymore
+func (w *WebPush) push(ctx context.Context, sub store.PushSubscription, payload []byte) (gone bool, err error) {
+	body, err := encryptWebPush(sub, payload)
+	if err != nil {
+		return false, err
+	}
+	auth, err := w.vapidAuth(sub.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to make web push request")
+	}
+	r.Header.Set("Authorization", auth)
+	r.Header.Set("Content-Encoding", "aes128gcm")
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Header.Set("TTL", strconv.Itoa(int(w.TTL.Seconds())))
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get web push response")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, errors.Errorf("web push subscription gone, status code %d", resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return false, errors.Errorf("unexpected web push status code %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// vapidAuth makes Authorization header value with JWT signed for push service origin, RFC 8292
+func (w *WebPush) vapidAuth(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", errors.Errorf("invalid web push endpoint %q", endpoint)
+	}
+	claims := jwt.StandardClaims{
+		Audience:  u.Scheme + "://" + u.Host,
+		ExpiresAt: time.Now().Add(webPushJWTTTL).Unix(),
+		Subject:   w.Subject,
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(w.key)
+	if err != nil {
+		return "", errors.Wrap(err, "can't sign VAPID token")
+	}
+	return "vapid t=" + signed + ", k=" + w.PublicKey, nil
+}
+
+// encryptWebPush encrypts payload for the subscription with aes128gcm content encoding, RFC 8291.
+// The result is single record with header containing salt and server's ephemeral public key.
+func encryptWebPush(sub store.PushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := decodeBase64URL(sub.Keys.P256dh)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode subscription key")
+	}
+	authSecret, err := decodeBase64URL(sub.Keys.Auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode subscription auth secret")
+	}
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid subscription key")
+	}
+
+	asKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't generate ephemeral key")
+	}
+	asPublic := elliptic.Marshal(curve, asKey.X, asKey.Y)
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asKey.D.Bytes())
+	ecdhSecret := padBytes(sharedX.Bytes(), 32)
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "can't make salt")
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make gcm")
+	}
+	if len(payload)+1+gcm.Overhead() > webPushRecordSize {
+		return nil, errors.Errorf("web push payload too large, %d bytes", len(payload))
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	rs := make([]byte, 4)
+	binary.BigEndian.PutUint32(rs, webPushRecordSize)
+	header = append(header, rs...)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	plain := append(append([]byte{}, payload...), 0x02) // delimiter of the last record, no padding
+	return gcm.Seal(header, nonce, plain, nil), nil
+}
+
+// hkdf derives key of length up to 32 bytes with HMAC-SHA256, RFC 5869
+func hkdf(salt, secret, info []byte, length int) []byte {
+	prk := hmac.New(sha256.New, salt)
+	_, _ = prk.Write(secret)
+	okm := hmac.New(sha256.New, prk.Sum(nil))
+	_, _ = okm.Write(info)
+	_, _ = okm.Write([]byte{0x01})
+	return okm.Sum(nil)[:length]
+}
+
+// parseVAPIDKeys makes private key from base64url encoded keys and checks they belong to each other
+func parseVAPIDKeys(publicKey, privateKey string) (*ecdsa.PrivateKey, error) {
+	d, err := decodeBase64URL(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("invalid VAPID private key")
+	}
+	pub, err := decodeBase64URL(publicKey)
+	if err != nil {
+		return nil, errors.New("invalid VAPID public key")
+	}
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d)
+	if !bytes.Equal(elliptic.Marshal(curve, key.X, key.Y), pub) {
+		return nil, errors.New("VAPID public key doesn't match private key")
+	}
+	return key, nil
+}
+
+// decodeBase64URL decodes base64url with or without padding, as browsers and tools differ here
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// padBytes left-pads big-endian number to size bytes
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	res := make([]byte, size)
+	copy(res[size-len(b):], b)
+	return res
+}
+
+type webPushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Tag   string `json:"tag"`
+}
+
+// buildWebPushMessage makes JSON message for the service worker showing the notification
+func buildWebPushMessage(req Request, kind string) ([]byte, error) {
+	title := req.Comment.User.Name + " replied to your comment"
+	switch kind {
+	case InboxMention:
+		title = req.Comment.User.Name + " mentioned you"
+	case InboxFollow:
+		title = req.Comment.User.Name + " commented"
+	}
+	if req.Comment.PostTitle != "" {
+		title += " on " + req.Comment.PostTitle
+	}
+	return json.Marshal(webPushMessage{
+		Title: truncate(title, webPushTitleLen),
+		Body:  truncate(strings.TrimSpace(html.UnescapeString(req.Comment.Orig)), webPushTextLen),
+		URL:   req.Comment.Locator.URL + uiNav + req.Comment.ID,
+		Tag:   "remark42-" + req.Comment.ID,
+	})
+}
+
+// SendVerification is not implemented for web push
+func (w *WebPush) SendVerification(_ context.Context, _ VerificationRequest) error {
+	return nil
+}
+
+func (w *WebPush) String() string {
+	return "webpush"
+}
@@ -0,0 +1,248 @@
+package notify
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestWebPush_New(t *testing.T) {
+	pub, priv, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 87, len(pub))
+	assert.Equal(t, 43, len(priv))
+
+	_, err = NewWebPush(WebPushParams{PublicKey: pub, PrivateKey: priv})
+	assert.EqualError(t, err, "web push keys and subject should be set")
+
+	pub2, _, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	_, err = NewWebPush(WebPushParams{PublicKey: pub2, PrivateKey: priv, Subject: "mailto:admin@example.com"})
+	assert.EqualError(t, err, "VAPID public key doesn't match private key")
+
+	_, err = NewWebPush(WebPushParams{PublicKey: pub, PrivateKey: "bad", Subject: "mailto:admin@example.com"})
+	assert.EqualError(t, err, "invalid VAPID private key")
+
+	wp, err := NewWebPush(WebPushParams{PublicKey: pub, PrivateKey: priv + "=", Subject: "mailto:admin@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, webPushTTL, wp.TTL)
+	assert.Equal(t, webPushTimeOut, wp.Timeout)
+	assert.Equal(t, "webpush", wp.String())
+}
+
+func TestWebPush_Send(t *testing.T) {
+	pub, priv, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	ps := newMockPushService(t, pub)
+	defer ps.Close()
+
+	st := &mockPushStore{}
+	wp, err := NewWebPush(WebPushParams{PublicKey: pub, PrivateKey: priv, Subject: "mailto:admin@example.com", Store: st})
+	require.NoError(t, err)
+
+	sub1, key1 := ps.subscription(t, "/push/u1")
+	sub2, _ := ps.subscription(t, "/push/gone")
+	sub3, _ := ps.subscription(t, "/push/fail")
+	c := store.Comment{ID: "c2", ParentID: "c1", Orig: "some **text** &amp; more", PostTitle: "Post title",
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}, User: store.User{Name: "user2"}}
+
+	require.NoError(t, wp.Send(context.Background(), Request{Comment: c}), "nothing to send")
+
+	err = wp.Send(context.Background(), Request{Comment: c,
+		Pushes: []Push{{UserID: "u1", Subscription: sub1}, {UserID: "u3", Subscription: sub2}, {UserID: "u4", Subscription: sub3}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to send web push to u4: unexpected web push status code 500")
+	assert.NotContains(t, err.Error(), "u3", "gone subscription is not an error")
+
+	assert.Equal(t, []pushStoreCall{{SiteID: "remark", UserID: "u3", Endpoint: sub2.Endpoint}}, st.calls,
+		"gone subscription removed")
+
+	received := ps.get("/push/u1")
+	require.Equal(t, 1, len(received))
+	assert.Equal(t, "86400", received[0].ttl)
+	msg := webPushMessage{}
+	require.NoError(t, json.Unmarshal(decryptWebPush(t, key1, sub1, received[0].body), &msg))
+	assert.Equal(t, webPushMessage{Title: "user2 replied to your comment on Post title", Body: "some **text** & more",
+		URL: "https://example.com/post#remark42__comment-c2", Tag: "remark42-c2"}, msg)
+}
+
+func TestWebPush_encryptErrors(t *testing.T) {
+	_, err := encryptWebPush(store.PushSubscription{}, []byte("msg"))
+	assert.EqualError(t, err, "invalid subscription key")
+
+	sub := store.PushSubscription{Endpoint: "https://example.com/push"}
+	sub.Keys.P256dh = "!!!"
+	_, err = encryptWebPush(sub, []byte("msg"))
+	assert.Contains(t, err.Error(), "can't decode subscription key")
+
+	sub, _ = makePushSubscription(t, "https://example.com/push")
+	_, err = encryptWebPush(sub, make([]byte, webPushRecordSize))
+	assert.EqualError(t, err, "web push payload too large, 4096 bytes")
+}
+
+func TestWebPush_buildMessage(t *testing.T) {
+	c := store.Comment{ID: "c2", Orig: strings.Repeat("x", 300), Locator: store.Locator{URL: "https://example.com/post"},
+		User: store.User{Name: "user2"}}
+	b, err := buildWebPushMessage(Request{Comment: c}, InboxReply)
+	require.NoError(t, err)
+	msg := webPushMessage{}
+	require.NoError(t, json.Unmarshal(b, &msg))
+	assert.Equal(t, "user2 replied to your comment", msg.Title)
+	assert.Equal(t, webPushTextLen, len([]rune(msg.Body)))
+	assert.True(t, strings.HasSuffix(msg.Body, "…"))
+
+	c.PostTitle = "post"
+	b, err = buildWebPushMessage(Request{Comment: c}, InboxMention)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &msg))
+	assert.Equal(t, "user2 mentioned you on post", msg.Title)
+	b, err = buildWebPushMessage(Request{Comment: c}, InboxFollow)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &msg))
+	assert.Equal(t, "user2 commented on post", msg.Title)
+}
+
+type pushStoreCall struct {
+	SiteID, UserID, Endpoint string
+	Subscribe                bool
+}
+
+type mockPushStore struct {
+	lock  sync.Mutex
+	calls []pushStoreCall
+}
+
+func (m *mockPushStore) SetWebPush(siteID, userID string, sub store.PushSubscription, subscribe bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls = append(m.calls, pushStoreCall{SiteID: siteID, UserID: userID, Endpoint: sub.Endpoint, Subscribe: subscribe})
+	return nil
+}
+
+type pushReceived struct {
+	ttl  string
+	body []byte
+}
+
+// mockPushService is a stand-in for browser vendor's push service, it checks VAPID authorization
+// and keeps received messages by path
+type mockPushService struct {
+	*httptest.Server
+	lock     sync.Mutex
+	received map[string][]pushReceived
+}
+
+func newMockPushService(t *testing.T, vapidPublic string) *mockPushService {
+	res := &mockPushService{received: map[string][]pushReceived{}}
+	vapidKey, err := decodeBase64URL(vapidPublic)
+	require.NoError(t, err)
+	x, y := elliptic.Unmarshal(elliptic.P256(), vapidKey)
+	require.NotNil(t, x)
+	verifyKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+	res.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		parts := strings.Split(strings.TrimPrefix(auth, "vapid "), ", ")
+		if len(parts) != 2 || parts[1] != "k="+vapidPublic {
+			t.Errorf("unexpected authorization %q", auth)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		claims := jwt.StandardClaims{}
+		_, err := jwt.ParseWithClaims(strings.TrimPrefix(parts[0], "t="), &claims, func(token *jwt.Token) (interface{}, error) {
+			return verifyKey, nil
+		})
+		if err != nil || claims.Audience != res.URL || claims.Subject != "mailto:admin@example.com" {
+			t.Errorf("invalid VAPID token, %v, %+v", err, claims)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+
+		switch r.URL.Path {
+		case "/push/gone":
+			w.WriteHeader(http.StatusGone)
+			return
+		case "/push/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		res.lock.Lock()
+		res.received[r.URL.Path] = append(res.received[r.URL.Path], pushReceived{ttl: r.Header.Get("TTL"), body: body})
+		res.lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return res
+}
+
+func (m *mockPushService) subscription(t *testing.T, path string) (store.PushSubscription, *ecdsa.PrivateKey) {
+	return makePushSubscription(t, m.URL+path)
+}
+
+func (m *mockPushService) get(path string) []pushReceived {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.received[path]
+}
+
+// makePushSubscription makes subscription the way browser does, with user agent's key pair and auth secret
+func makePushSubscription(t *testing.T, endpoint string) (store.PushSubscription, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authSecret := make([]byte, 16)
+	_, err = rand.Read(authSecret)
+	require.NoError(t, err)
+	sub := store.PushSubscription{Endpoint: endpoint}
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(authSecret)
+	return sub, key
+}
+
+// decryptWebPush decrypts aes128gcm message the way browser does, RFC 8291
+func decryptWebPush(t *testing.T, key *ecdsa.PrivateKey, sub store.PushSubscription, body []byte) []byte {
+	require.True(t, len(body) > 21)
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	assert.Equal(t, uint32(webPushRecordSize), rs)
+	asPublic := body[21 : 21+idLen]
+	x, y := elliptic.Unmarshal(elliptic.P256(), asPublic)
+	require.NotNil(t, x)
+	sharedX, _ := elliptic.P256().ScalarMult(x, y, key.D.Bytes())
+
+	uaPublic, err := decodeBase64URL(sub.Keys.P256dh)
+	require.NoError(t, err)
+	authSecret, err := decodeBase64URL(sub.Keys.Auth)
+	require.NoError(t, err)
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hkdf(authSecret, padBytes(sharedX.Bytes(), 32), keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	plain, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x02), plain[len(plain)-1], "last record delimiter")
+	return plain[:len(plain)-1]
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,9 +14,12 @@ import (
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 
+	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/store/trust"
 )
 
 // admin provides router for all requests available for admin users only
@@ -25,9 +29,21 @@ type admin struct {
 	authenticator *auth.Service
 	readOnlyAge   int
 	migrator      *Migrator
+	notifyService *notify.Service
+	emailPreview  emailPreviewer
+	apiTokens     apiTokenService
+	sessions      sessionsCache
+	telegramAudit telegramAuditor
+	inbox         inboxStore
+}
+
+// emailPreviewer renders email notifications with sample data
+type emailPreviewer interface {
+	Preview(kind, siteID, locale string) (notify.EmailPreview, error)
 }
 
 type adminStore interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
 	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
 	DeleteUser(siteID string, userID string, mode store.DeleteMode) error
 	DeleteUserDetail(siteID string, userID string, detail engine.UserDetail) error
@@ -40,6 +56,12 @@ type adminStore interface {
 	SetVerified(siteID string, userID string, status bool) error
 	SetReadOnly(locator store.Locator, status bool) error
 	SetPin(locator store.Locator, commentID string, status bool) error
+	Approve(locator store.Locator, commentID string) (store.Comment, error)
+	SetTrustLevel(siteID, userID string, level trust.Level) error
+	ResetTrustLevel(siteID, userID string) error
+	Suspicious(siteID string) ([]service.SuspiciousComment, error)
+	MergeUsers(siteID, fromID, toID string) error
+	RevokeSessions(siteID, userID string, ts time.Time) error
 }
 
 // DELETE /comment/{id}?site=siteID&url=post-url - removes comment
@@ -49,12 +71,10 @@ func (a *admin) deleteCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 	log.Printf("[INFO] delete comment %s", id)
 
-	err := a.dataService.Delete(locator, id, store.SoftDelete)
-	if err != nil {
+	if err := a.deleteComment(locator, id); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete comment", rest.ErrInternal)
 		return
 	}
-	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
 }
@@ -117,6 +137,25 @@ func (a *admin) deleteMeRequestCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = a.dataService.DeleteUserDetail(claims.Audience, claims.User.ID, engine.UserTelegram); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete telegram for user", code)
+		return
+	}
+
+	if err = a.dataService.DeleteUserDetail(claims.Audience, claims.User.ID, engine.UserWebPush); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete web push subscriptions for user", code)
+		return
+	}
+
+	if a.inbox != nil {
+		if err = a.inbox.Delete(claims.Audience, claims.User.ID); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete notifications inbox for user", rest.ErrInternal)
+			return
+		}
+	}
+
 	if err = a.dataService.DeleteUser(claims.Audience, claims.User.ID, store.HardDelete); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete user", rest.ErrNoAccess)
 		return
@@ -148,18 +187,10 @@ func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := a.dataService.SetBlock(siteID, userID, blockStatus, ttl); err != nil {
+	if err := a.setBlock(siteID, userID, blockStatus, ttl); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set blocking status", rest.ErrActionRejected)
 		return
 	}
-
-	// delete comments for permanently blocked user.
-	if blockStatus && ttl == time.Duration(0) {
-		if err := a.dataService.DeleteUser(siteID, userID, store.SoftDelete); err != nil {
-			log.Printf("[WARN] can't delete comments for blocked user %s on site %s, %v", userID, siteID, err)
-		}
-	}
-	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
 	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "block": blockStatus})
 }
 
@@ -174,6 +205,81 @@ func (a *admin) blockedUsersCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, users)
 }
 
+// GET /suspicious?site=siteID - list comments flagged by spam classifier and waiting for review
+func (a *admin) suspiciousCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	comments, err := a.dataService.Suspicious(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get suspicious comments", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
+// GET /notify/failed?site=siteID - list of notifications failed to be delivered after all retries
+func (a *admin) failedNotificationsCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no notifier"), "can't get failed notifications", rest.ErrActionRejected)
+		return
+	}
+	jobs, err := a.notifyService.Failed(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get failed notifications", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, jobs)
+}
+
+// GET /notify/filter/{id}?site=siteID&url=post-url - checks which admin notification filter rule matches
+// the comment per destination
+func (a *admin) checkFilterCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no notifier"), "can't check notification filter", rest.ErrActionRejected)
+		return
+	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	comment, err := a.dataService.Get(locator, chi.URLParam(r, "id"), rest.MustGetUserInfo(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment", rest.ErrCommentNotFound)
+		return
+	}
+	render.JSON(w, r, a.notifyService.CheckFilter(comment))
+}
+
+// GET /email/preview?site=siteID&type=reply&locale=de - renders email of the type with sample data and
+// the template used for the site and locale, types are reply, mention, follow, admin, digest and verification
+func (a *admin) emailPreviewCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.emailPreview == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no email notifications"), "can't preview email", rest.ErrActionRejected)
+		return
+	}
+	locale := r.URL.Query().Get("locale")
+	if locale != "" && !notify.IsLocale(locale) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("bad locale"), "can't preview email", rest.ErrActionRejected)
+		return
+	}
+	preview, err := a.emailPreview.Preview(r.URL.Query().Get("type"), r.URL.Query().Get("site"), locale)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't preview email", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, preview)
+}
+
+// PUT /notify/replay/{id}?site=siteID - put failed notification back to the queue for delivery
+func (a *admin) replayNotificationCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no notifier"), "can't replay notification", rest.ErrActionRejected)
+		return
+	}
+	if err := a.notifyService.Replay(r.URL.Query().Get("site"), id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't replay notification", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "replayed": true})
+}
+
 // PUT /readonly?site=siteID&url=post-url&ro=1 - set or reset read-only status for the post
 func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -197,6 +303,7 @@ func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set readonly status", rest.ErrPostNotFound)
 		return
 	}
+	a.submitEvent(notify.Event{Type: notify.EventPostReadOnly, SiteID: locator.SiteID, URL: locator.URL, Status: roStatus})
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, locator.SiteID))
 	render.JSON(w, r, R.JSON{"locator": locator, "read-only": roStatus})
 }
@@ -228,10 +335,29 @@ func (a *admin) setVerifyCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set verify status", rest.ErrActionRejected)
 		return
 	}
+	a.submitEvent(notify.Event{Type: notify.EventUserVerified, SiteID: siteID, UserID: userID, Status: verifyStatus})
 	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID, userID))
 	render.JSON(w, r, R.JSON{"user": userID, "verified": verifyStatus})
 }
 
+// PUT /merge?site=siteID&from=userID&to=userID - merges user into another one,
+// comments, votes, details, flags, trust penalties and notifications of the user moved, later logins of the user act as the other one
+func (a *admin) mergeUsersCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	fromID, toID := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if err := a.dataService.MergeUsers(siteID, fromID, toID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't merge users", rest.ErrActionRejected)
+		return
+	}
+	if a.inbox != nil {
+		if err := a.inbox.Merge(siteID, fromID, toID); err != nil {
+			log.Printf("[WARN] can't merge notifications inbox of %s into %s, %v", fromID, toID, err)
+		}
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	render.JSON(w, r, R.JSON{"from": fromID, "to": toID, "merged": true})
+}
+
 // PUT /pin/{id}?site=siteID&url=post-url&pin=1
 // mark/unmark comment as a special
 func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
@@ -239,10 +365,117 @@ func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 	pinStatus := r.URL.Query().Get("pin") == "1"
 
-	if err := a.dataService.SetPin(locator, commentID, pinStatus); err != nil {
+	if err := a.setPin(locator, commentID, pinStatus); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set pin status", rest.ErrActionRejected)
 		return
 	}
-	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
 	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pin": pinStatus})
 }
+
+// PUT /approve/{id}?site=siteID&url=post-url - publish comment waiting for approval
+func (a *admin) approveCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	comment, err := a.dataService.Approve(locator, commentID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't approve comment", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, comment.User.ID))
+
+	if a.notifyService != nil {
+		a.notifyService.Submit(notify.Request{Comment: comment})
+	}
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pending": false})
+}
+
+// PUT /trust/{userid}?site=siteID&level=2 - set trust level for the user
+func (a *admin) setTrustLevelCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+
+	level, err := strconv.Atoi(r.URL.Query().Get("level"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse trust level", rest.ErrDecode)
+		return
+	}
+
+	if err = a.dataService.SetTrustLevel(siteID, userID, trust.Level(level)); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set trust level", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID, userID))
+	render.JSON(w, r, R.JSON{"user": userID, "trust_level": level})
+}
+
+// DELETE /trust/{userid}?site=siteID - reset trust level set by admin, level calculated from user's history again
+func (a *admin) resetTrustLevelCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+
+	if err := a.dataService.ResetTrustLevel(siteID, userID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't reset trust level", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID, userID))
+	render.JSON(w, r, R.JSON{"user": userID})
+}
+
+// deleteComment soft-deletes comment, notifies about the event and flushes cached comments of the post
+func (a *admin) deleteComment(locator store.Locator, id string) error {
+	comment, err := a.dataService.Get(locator, id, store.User{})
+	if err != nil {
+		comment = store.Comment{ID: id, Locator: locator} // author is not known, still can be deleted
+	}
+	if err = a.dataService.Delete(locator, id, store.SoftDelete); err != nil {
+		return err
+	}
+	a.submitEvent(notify.Event{Type: notify.EventCommentDeleted, SiteID: locator.SiteID, URL: locator.URL, Comment: &comment})
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	return nil
+}
+
+// setBlock blocks or unblocks user for ttl, zero ttl blocks permanently and deletes all user's comments
+func (a *admin) setBlock(siteID, userID string, status bool, ttl time.Duration) error {
+	if err := a.dataService.SetBlock(siteID, userID, status, ttl); err != nil {
+		return err
+	}
+
+	// existing sessions of the blocked user rejected right away, without waiting for token refresh
+	if status {
+		if err := revokeSessions(a.dataService, a.sessions, siteID, userID, time.Now()); err != nil {
+			log.Printf("[WARN] can't revoke sessions for blocked user %s on site %s, %v", userID, siteID, err)
+		}
+	}
+
+	// delete comments for permanently blocked user.
+	if status && ttl == time.Duration(0) {
+		if err := a.dataService.DeleteUser(siteID, userID, store.SoftDelete); err != nil {
+			log.Printf("[WARN] can't delete comments for blocked user %s on site %s, %v", userID, siteID, err)
+		}
+	}
+	a.submitEvent(notify.Event{Type: notify.EventUserBlocked, SiteID: siteID, UserID: userID, Status: status})
+	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
+	return nil
+}
+
+// setPin pins or unpins comment, notifies about the event and flushes cached comments of the post
+func (a *admin) setPin(locator store.Locator, id string, status bool) error {
+	if err := a.dataService.SetPin(locator, id, status); err != nil {
+		return err
+	}
+	if comment, err := a.dataService.Get(locator, id, store.User{}); err == nil {
+		a.submitEvent(notify.Event{Type: notify.EventCommentPinned, SiteID: locator.SiteID, URL: locator.URL,
+			Comment: &comment, Status: status})
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
+	return nil
+}
+
+// submitEvent sends event to notification destinations supporting it, like webhooks
+func (a *admin) submitEvent(e notify.Event) {
+	if a.notifyService != nil {
+		a.notifyService.SubmitEvent(e)
+	}
+}
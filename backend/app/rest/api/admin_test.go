@@ -3,13 +3,16 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,7 +22,9 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
+	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
@@ -619,6 +624,217 @@ func TestAdmin_Verify(t *testing.T) {
 	assert.False(t, comments.Comments[0].User.Verified)
 }
 
+func TestAdmin_MergeUsers(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	for _, c := range []store.Comment{
+		{Text: "test test #1", User: store.User{Name: "user1 name", ID: "user1"}},
+		{Text: "test test #2", User: store.User{Name: "user2 name", ID: "user2"}},
+	} {
+		c.Locator = store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+		_, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+	inboxFile := os.TempDir() + "/test-merge-inbox.db"
+	defer os.Remove(inboxFile)
+	inbox, err := notify.NewBoltInbox(inboxFile, 0, bolt.Options{})
+	require.NoError(t, err)
+	defer inbox.Close()
+	srv.adminRest.inbox = inbox
+	require.NoError(t, inbox.Add("remark42", "user1", notify.InboxItem{ID: "reply-c1", Type: notify.InboxReply}))
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/merge?site=remark42&from=user1&to=user2", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, `{"from":"user1","merged":true,"to":"user2"}`+"\n", string(body))
+
+	res, code := get(t, ts.URL+"/api/v1/comments?site=remark42&user=user2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, res, "test test #1")
+	assert.Contains(t, res, "test test #2")
+	assert.Equal(t, "user2", srv.DataService.CanonicalUserID("remark42", "user1"))
+	items, _, err := inbox.List("remark42", "user2", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items), "notifications of merged user moved")
+	assert.Equal(t, "reply-c1", items[0].ID)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/merge?site=remark42&from=user1&to=user2", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "already merged")
+}
+
+func TestAdmin_TrustLevel(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	_, trustTeardown := enableTrust(t, srv)
+	defer trustTeardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}}
+	_, err := srv.DataService.Create(c1)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/trust/user1?site=remark42&level=2", ts.URL), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments))
+	assert.Equal(t, 2, comments.Comments[0].User.TrustLevel)
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/trust/user1?site=remark42&level=bad", ts.URL), nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/trust/user1?site=remark42&level=5", ts.URL), nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "unknown level")
+
+	req, err = http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/trust/user1?site=remark42", ts.URL), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments))
+	assert.Equal(t, 0, comments.Comments[0].User.TrustLevel)
+}
+
+func TestAdmin_Approve(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	trustService, trustTeardown := enableTrust(t, srv)
+	defer trustTeardown()
+	trustService.Premoderation = true
+
+	id := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	assert.Equal(t, 0, len(comments.Comments), "pending comment hidden from anonymous")
+
+	res, code = getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments), "pending comment shown to the author")
+	assert.True(t, comments.Comments[0].Pending)
+
+	_, code = get(t, ts.URL+"/api/v1/id/"+id+"?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusBadRequest, code, "pending comment by id hidden from anonymous")
+	_, code = getWithDevAuth(t, ts.URL+"/api/v1/id/"+id+"?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusOK, code, "pending comment by id shown to the author")
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/approve/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments), "approved comment visible")
+	assert.False(t, comments.Comments[0].Pending)
+
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "not pending anymore")
+}
+
+func TestAdmin_Suspicious(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/suspicious?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, "classifier disabled")
+	assert.Contains(t, body, "can't get suspicious comments")
+
+	spamTeardown := enableSpam(t, srv)
+	defer spamTeardown()
+
+	postAsDev := func(text string) string {
+		body := fmt.Sprintf(`{"text": %q, "locator":{"url": "https://radio-t.com/blah", "site": "remark42"}}`, text)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		c := store.Comment{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		require.NoError(t, resp.Body.Close())
+		return c.ID
+	}
+	id := postAsDev("casino bonus")
+	postAsDev("nice episode")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/suspicious?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/suspicious?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	suspicious := []service.SuspiciousComment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &suspicious))
+	require.Equal(t, 1, len(suspicious))
+	assert.Equal(t, id, suspicious[0].ID)
+	assert.True(t, suspicious[0].Pending)
+	assert.True(t, suspicious[0].Probability > 0.9)
+
+	req, err = http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/suspicious?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body, "deleted comment not suspicious anymore")
+}
+
 func TestAdmin_ExportStream(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -695,6 +911,14 @@ func TestAdmin_DeleteMeRequest(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "test@example.org", email, "new email for user1 is readable")
 
+	inboxFile := os.TempDir() + "/test-deleteme-inbox.db"
+	defer os.Remove(inboxFile)
+	inbox, err := notify.NewBoltInbox(inboxFile, 0, bolt.Options{})
+	require.NoError(t, err)
+	defer inbox.Close()
+	srv.adminRest.inbox = inbox
+	require.NoError(t, inbox.Add("remark42", "user1", notify.InboxItem{ID: "reply-c1", Type: notify.InboxReply}))
+
 	claims := token.Claims{
 		SessionOnly: true,
 		StandardClaims: jwt.StandardClaims{
@@ -736,6 +960,9 @@ func TestAdmin_DeleteMeRequest(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, email, "user1 email was deleted")
 
+	items, _, err := inbox.List("remark42", "user1", 0)
+	require.NoError(t, err)
+	assert.Empty(t, items, "user1 inbox was deleted")
 }
 
 func TestAdmin_DeleteMeRequestFailed(t *testing.T) {
@@ -849,3 +1076,215 @@ func TestAdmin_GetUserInfo(t *testing.T) {
 	_, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/user/userX?site=remark42&url=https://radio-t.com/blah", ts.URL))
 	assert.Equal(t, 400, code, "no info about user")
 }
+
+func TestAdmin_FailedNotifications(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/failed?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, "persistent queue disabled")
+	assert.Contains(t, body, "can't get failed notifications")
+
+	queueDB, err := randomPath(os.TempDir(), "test-queue", ".db")
+	require.NoError(t, err)
+	defer os.Remove(queueDB)
+	q, err := notify.NewBoltQueue(queueDB, bolt.Options{})
+	require.NoError(t, err)
+	dest := &brokenDest{}
+	notifier := notify.NewQueuedService(nil, notify.QueueParams{Store: q, MaxAttempts: 1}, dest)
+	defer notifier.Close()
+	srv.adminRest.notifyService = notifier
+	notifier.Submit(notify.Request{Comment: store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark42"}}})
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/notify/failed?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	jobs := []notify.Job{}
+	assert.Eventually(t, func() bool {
+		body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/failed?site=remark42")
+		return code == http.StatusOK && json.Unmarshal([]byte(body), &jobs) == nil && len(jobs) == 1
+	}, 5*time.Second, 10*time.Millisecond, body)
+	assert.Equal(t, "c1", jobs[0].Request.Comment.ID)
+	assert.Equal(t, "smtp is down", jobs[0].Deliveries["brokendest"].LastError)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notify/replay/bad?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	dest.fix()
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notify/replay/"+jobs[0].ID+"?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Eventually(t, func() bool { return dest.sent() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/failed?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body)
+}
+
+func TestAdmin_CheckNotifyFilter(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	id := addComment(t, c, ts)
+	url := fmt.Sprintf("%s/api/v1/admin/notify/filter/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id)
+
+	body, code := getWithAdminAuth(t, url)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", body, "no destinations")
+
+	filter, err := notify.NewFilter(map[string][]notify.FilterRule{"mock id=0, closed=false": {{Name: "other", Sites: []string{"other"}}}}, nil, nil, nil)
+	require.NoError(t, err)
+	notifier := notify.NewService(nil, 1, &notify.MockDest{}).WithFilter(filter)
+	defer notifier.Close()
+	srv.adminRest.notifyService = notifier
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code = getWithAdminAuth(t, url)
+	require.Equal(t, http.StatusOK, code, body)
+	matches := []notify.FilterMatch{}
+	require.NoError(t, json.Unmarshal([]byte(body), &matches))
+	assert.Equal(t, []notify.FilterMatch{{Destination: "mock id=0, closed=false", Send: false}}, matches)
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/filter/bad?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAdmin_EmailPreview(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/email/preview?site=remark42&type=reply")
+	assert.Equal(t, http.StatusBadRequest, code, "email notifications disabled")
+	assert.Contains(t, body, "can't preview email")
+
+	email, err := notify.NewEmail(notify.EmailParams{
+		MsgTemplatePath:          "../../notify/testdata/msg.html.tmpl",
+		VerificationTemplatePath: "../../notify/testdata/verification.html.tmpl",
+	}, notify.SMTPParams{})
+	require.NoError(t, err)
+	srv.adminRest.emailPreview = email
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/email/preview?site=remark42&type=reply", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/email/preview?site=remark42&type=verification&locale=de")
+	require.Equal(t, http.StatusOK, code, body)
+	preview := notify.EmailPreview{}
+	require.NoError(t, json.Unmarshal([]byte(body), &preview))
+	assert.Equal(t, "Email verification", preview.Subject)
+	assert.Contains(t, preview.HTML, "sample-token")
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/email/preview?site=remark42&type=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/email/preview?site=remark42&type=reply&locale=../x")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// brokenDest fails all sends till fixed
+type brokenDest struct {
+	lock   sync.Mutex
+	fixed  bool
+	result int
+}
+
+func (b *brokenDest) Send(context.Context, notify.Request) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.fixed {
+		return errors.New("smtp is down")
+	}
+	b.result++
+	return nil
+}
+
+func (b *brokenDest) SendVerification(context.Context, notify.VerificationRequest) error { return nil }
+
+func (b *brokenDest) fix() {
+	b.lock.Lock()
+	b.fixed = true
+	b.lock.Unlock()
+}
+
+func (b *brokenDest) sent() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.result
+}
+
+func (b *brokenDest) String() string { return "broken" }
+
+func TestAdmin_WebhookEvents(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	var lock sync.Mutex
+	var events []notify.Event
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := notify.Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		lock.Lock()
+		events = append(events, e)
+		lock.Unlock()
+	}))
+	defer hookSrv.Close()
+	wh, err := notify.NewWebhook(notify.WebhookParams{Hooks: []notify.Hook{{SiteID: "remark42", URL: hookSrv.URL}}})
+	require.NoError(t, err)
+	notifier := notify.NewService(nil, 10, wh)
+	defer notifier.Close()
+	srv.adminRest.notifyService, srv.privRest.notifyService = notifier, notifier
+
+	received := func() []notify.Event {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]notify.Event{}, events...)
+	}
+	send := func(method, url, tkn, body string, n int) {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, url)
+		assert.Eventually(t, func() bool { return len(received()) == n }, 5*time.Second, 10*time.Millisecond, url)
+	}
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}}, ts)
+	assert.Eventually(t, func() bool { return len(received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	send(http.MethodPut, "/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah", devToken, `{"text":"updated text"}`, 2)
+	send(http.MethodPut, "/api/v1/vote/"+id+"?site=remark42&url=https://radio-t.com/blah&vote=1", adminUmputunToken, "", 3)
+	send(http.MethodPut, "/api/v1/admin/verify/dev?site=remark42&verified=1", adminUmputunToken, "", 4)
+	send(http.MethodPut, "/api/v1/admin/readonly?site=remark42&url=https://radio-t.com/blah&ro=1", adminUmputunToken, "", 5)
+	send(http.MethodDelete, "/api/v1/admin/comment/"+id+"?site=remark42&url=https://radio-t.com/blah", adminUmputunToken, "", 6)
+	send(http.MethodPut, "/api/v1/admin/user/dev?site=remark42&block=1&ttl=10s", adminUmputunToken, "", 7)
+
+	res := received()
+	assert.Equal(t, notify.EventCommentCreated, res[0].Type)
+	assert.Equal(t, id, res[0].Comment.ID)
+	assert.Equal(t, notify.EventCommentEdited, res[1].Type)
+	assert.Equal(t, "updated text", res[1].Comment.Orig)
+	assert.Equal(t, "dev", res[1].UserID)
+	assert.Equal(t, notify.EventCommentVoted, res[2].Type)
+	assert.Equal(t, 1, res[2].Comment.Score)
+	assert.True(t, res[2].Status)
+	assert.Equal(t, notify.Event{Type: notify.EventUserVerified, Time: res[3].Time, SiteID: "remark42", UserID: "dev", Status: true}, res[3])
+	assert.Equal(t, notify.Event{Type: notify.EventPostReadOnly, Time: res[4].Time, SiteID: "remark42",
+		URL: "https://radio-t.com/blah", Status: true}, res[4])
+	assert.Equal(t, notify.EventCommentDeleted, res[5].Type)
+	assert.Equal(t, id, res[5].Comment.ID)
+	assert.Equal(t, notify.Event{Type: notify.EventUserBlocked, Time: res[6].Time, SiteID: "remark42", UserID: "dev", Status: true}, res[6])
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/middleware"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store/apitoken"
+)
+
+// APITokenUser is the basic auth user name for requests with api tokens, the token's secret used as password
+const APITokenUser = "token"
+
+// APITokenAttr is the user attribute with api token the request made with, set by basic auth checker
+const APITokenAttr = "api_token"
+
+// checkedAPIToken is the token verified by basic auth checker, kept in user's attributes
+// for the rest of the request and marshaled as token id only
+type checkedAPIToken apitoken.Token
+
+// MarshalJSON hides everything but id of the token
+func (t checkedAPIToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.ID)
+}
+
+// apiTokenService defines interface for api tokens
+type apiTokenService interface {
+	Create(req apitoken.CreateRequest) (apitoken.Token, string, error)
+	List(siteID string) ([]apitoken.Token, error)
+	Revoke(siteID, id, actor string) error
+	Used(tkn apitoken.Token, details string)
+	Denied(tkn apitoken.Token, details string)
+	AuditLog(siteID string) ([]apitoken.AuditRecord, error)
+}
+
+// apiTokenChecker verifies secrets of api tokens
+type apiTokenChecker interface {
+	Check(secret string) (apitoken.Token, error)
+}
+
+// migratePaths are admin routes allowed with migrate permission
+var migratePaths = []string{"/export", "/import", "/import/form", "/remap", "/wait"}
+
+// NewBasicAuthChecker makes checker accepting basic auth of admin with adminPasswd, if set, and of api tokens.
+// Token user made admin of the token's site, apiTokenScope limits what it can do.
+func NewBasicAuthChecker(adminPasswd string, tokens apiTokenChecker) middleware.BasicAuthFunc {
+	return func(user, passwd string) (ok bool, userInfo token.User, err error) {
+		switch user {
+		case "admin":
+			if adminPasswd == "" || subtle.ConstantTimeCompare([]byte(passwd), []byte(adminPasswd)) != 1 {
+				log.Printf("[WARN] admin basic auth failed, password mismatch")
+				return false, token.User{}, nil
+			}
+			return true, token.User{ID: "admin", Name: "admin", Attributes: map[string]interface{}{"admin": true}}, nil
+		case APITokenUser:
+			tkn, e := tokens.Check(passwd)
+			if e != nil {
+				log.Printf("[WARN] api token auth failed, %v", e)
+				return false, token.User{}, nil
+			}
+			userInfo = token.User{ID: "token_" + tkn.ID, Name: tkn.Name, Audience: tkn.SiteID}
+			userInfo.SetAdmin(true)
+			userInfo.Attributes[APITokenAttr] = checkedAPIToken(tkn)
+			return true, userInfo, nil
+		}
+		return false, token.User{}, nil
+	}
+}
+
+// apiTokenScope is a middleware limiting requests made with api tokens to the token's site and permissions.
+// Admin requests allowed by the permission recorded to the audit trail as well as rejected ones.
+// Tokens can't manage tokens and can't make any non-admin changes. Other users passed as is.
+func (s *Rest) apiTokenScope(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, err := token.GetUserInfo(r)
+		if err != nil || user.Attributes[APITokenAttr] == nil || s.APITokens == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		checked, ok := user.Attributes[APITokenAttr].(checkedAPIToken)
+		if !ok { // attribute not set by basic auth checker
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		tkn := apitoken.Token(checked)
+
+		details := r.Method + " " + r.URL.Path
+		adminPath := strings.TrimPrefix(r.URL.Path, "/api/v1/admin")
+		if adminPath == r.URL.Path { // not admin request, only reading allowed
+			if r.Method != http.MethodGet || r.URL.Query().Get("site") != tkn.SiteID {
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.Query().Get("site") != tkn.SiteID || !tkn.Allowed(apiTokenPermission(r.Method, adminPath)) {
+			s.APITokens.Denied(tkn, details)
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		s.APITokens.Used(tkn, details)
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// apiTokenPermission returns permission needed for admin request, empty for requests not allowed with tokens
+func apiTokenPermission(method, adminPath string) apitoken.Permission {
+	if strings.HasPrefix(adminPath, "/tokens") {
+		return ""
+	}
+	for _, p := range migratePaths {
+		if adminPath == p {
+			return apitoken.PermMigrate
+		}
+	}
+	if method == http.MethodGet && adminPath != "/deleteme" {
+		return apitoken.PermRead
+	}
+	return apitoken.PermModerate
+}
+
+// POST /tokens?site=siteID - creates api token, body is {"name": "backup", "permissions": ["read", "migrate"], "ttl": "720h"}.
+// Returns the token along with its secret, the secret can't be retrieved later
+func (a *admin) createAPITokenCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	req := struct {
+		Name        string                `json:"name"`
+		Permissions []apitoken.Permission `json:"permissions"`
+		TTL         string                `json:"ttl"`
+	}{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, hardBodyLimit)).Decode(&req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse token request", rest.ErrDecode)
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse token ttl", rest.ErrDecode)
+		return
+	}
+
+	tkn, secret, err := a.apiTokens.Create(apitoken.CreateRequest{SiteID: siteID, Name: req.Name,
+		Permissions: req.Permissions, TTL: ttl, Actor: user.ID})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't create token", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] api token %s (%s) created for %s by %s", tkn.ID, tkn.Name, siteID, user.ID)
+	tkn.Hash = ""
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, R.JSON{"token": tkn, "secret": secret})
+}
+
+// GET /tokens?site=siteID - lists api tokens of the site
+func (a *admin) listAPITokensCtrl(w http.ResponseWriter, r *http.Request) {
+	tokens, err := a.apiTokens.List(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't list tokens", rest.ErrInternal)
+		return
+	}
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	render.JSON(w, r, tokens)
+}
+
+// DELETE /tokens/{id}?site=siteID - revokes api token
+func (a *admin) revokeAPITokenCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	id, siteID := chi.URLParam(r, "id"), r.URL.Query().Get("site")
+
+	if err := a.apiTokens.Revoke(siteID, id, user.ID); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, apitoken.ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		rest.SendErrorJSON(w, r, code, err, "can't revoke token", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] api token %s revoked for %s by %s", id, siteID, user.ID)
+	render.JSON(w, r, R.JSON{"id": id, "revoked": true})
+}
+
+// GET /tokens/audit?site=siteID - returns audit trail of api tokens, from the latest record
+func (a *admin) apiTokensAuditCtrl(w http.ResponseWriter, r *http.Request) {
+	recs, err := a.apiTokens.AuditLog(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get tokens audit", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, recs)
+}
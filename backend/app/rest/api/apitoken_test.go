@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/apitoken"
+)
+
+func TestRest_APITokens(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	tokensFile, err := randomPath(os.TempDir(), "test-tokens", ".db")
+	require.NoError(t, err)
+	tokensStore, err := apitoken.NewBoltStorage(tokensFile, bolt.Options{})
+	require.NoError(t, err)
+	defer os.Remove(tokensFile)
+	tokens := &apitoken.Service{Store: tokensStore}
+	defer tokens.Close()
+
+	srv.APITokens = tokens
+	srv.Authenticator = auth.NewService(auth.Opts{
+		SecretReader:     token.SecretFunc(func(aud string) (string, error) { return "secret", nil }),
+		BasicAuthChecker: NewBasicAuthChecker("password", tokens),
+	})
+	ts.Config.Handler = srv.routes()
+
+	c1, err := srv.DataService.Create(store.Comment{Text: "test test #1", User: store.User{ID: "user1", Name: "u1"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}})
+	require.NoError(t, err)
+
+	do := func(method, url, body, user, passwd string) (int, string) {
+		req, e := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, e)
+		req.SetBasicAuth(user, passwd)
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		b, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+
+	code, _ := do("POST", "/api/v1/admin/tokens?site=remark42", `{"name":"bad","permissions":["read"]}`, "admin", "password")
+	assert.Equal(t, http.StatusBadRequest, code, "no ttl")
+	code, _ = do("POST", "/api/v1/admin/tokens?site=remark42", `{"name":"bad","permissions":["read"]}`, "admin", "bad")
+	assert.Equal(t, http.StatusUnauthorized, code, "wrong admin password")
+
+	// create token for backups
+	code, body := do("POST", "/api/v1/admin/tokens?site=remark42",
+		`{"name":"reader","permissions":["read","migrate"],"ttl":"1h"}`, "admin", "password")
+	require.Equal(t, http.StatusCreated, code, body)
+	created := struct {
+		Token  apitoken.Token `json:"token"`
+		Secret string         `json:"secret"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	assert.Equal(t, "reader", created.Token.Name)
+	assert.Equal(t, "", created.Token.Hash, "hash not exposed")
+	assert.Equal(t, "admin", created.Token.CreatedBy)
+
+	code, body = do("GET", "/api/v1/admin/blocked?site=remark42", "", "token", created.Secret)
+	assert.Equal(t, http.StatusOK, code, body)
+	code, _ = do("GET", "/api/v1/admin/export?site=remark42&mode=stream", "", "token", created.Secret)
+	assert.Equal(t, http.StatusOK, code)
+	code, body = do("GET", "/api/v1/user?site=remark42", "", "token", created.Secret)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"id":"token_`+created.Token.ID+`"`)
+	code, _ = do("GET", "/api/v1/admin/blocked?site=other", "", "token", created.Secret)
+	assert.Equal(t, http.StatusForbidden, code, "another site")
+	code, _ = do("GET", "/api/v1/admin/blocked", "", "token", created.Secret)
+	assert.Equal(t, http.StatusForbidden, code, "site required")
+	code, _ = do("DELETE", fmt.Sprintf("/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", c1), "",
+		"token", created.Secret)
+	assert.Equal(t, http.StatusForbidden, code, "no moderate permission")
+	code, _ = do("GET", "/api/v1/admin/tokens?site=remark42", "", "token", created.Secret)
+	assert.Equal(t, http.StatusForbidden, code, "tokens can't manage tokens")
+	code, _ = do("POST", "/api/v1/comment?site=remark42",
+		`{"text": "test", "locator":{"url": "https://radio-t.com/blah", "site": "remark42"}}`, "token", created.Secret)
+	assert.Equal(t, http.StatusForbidden, code, "tokens can't comment")
+	code, _ = do("GET", "/api/v1/admin/blocked?site=remark42", "", "token", created.Secret+"x")
+	assert.Equal(t, http.StatusUnauthorized, code, "wrong secret")
+
+	// moderate token
+	code, body = do("POST", "/api/v1/admin/tokens?site=remark42",
+		`{"name":"cleanup","permissions":["moderate"],"ttl":"1h"}`, "admin", "password")
+	require.Equal(t, http.StatusCreated, code, body)
+	moderator := created
+	require.NoError(t, json.Unmarshal([]byte(body), &moderator))
+	code, body = do("DELETE", fmt.Sprintf("/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", c1), "",
+		"token", moderator.Secret)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	code, body = do("GET", "/api/v1/admin/tokens?site=remark42", "", "admin", "password")
+	require.Equal(t, http.StatusOK, code, body)
+	list := []apitoken.Token{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, "reader", list[0].Name)
+	assert.Equal(t, "", list[0].Hash)
+	assert.False(t, list[0].LastUsed.IsZero())
+
+	code, _ = do("DELETE", "/api/v1/admin/tokens/"+created.Token.ID+"?site=other", "", "admin", "password")
+	assert.Equal(t, http.StatusNotFound, code, "token of another site")
+	code, _ = do("DELETE", "/api/v1/admin/tokens/"+created.Token.ID+"?site=remark42", "", "admin", "password")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = do("GET", "/api/v1/admin/blocked?site=remark42", "", "token", created.Secret)
+	assert.Equal(t, http.StatusUnauthorized, code, "revoked")
+
+	code, body = do("GET", "/api/v1/admin/tokens/audit?site=remark42", "", "admin", "password")
+	require.Equal(t, http.StatusOK, code, body)
+	recs := []apitoken.AuditRecord{}
+	require.NoError(t, json.Unmarshal([]byte(body), &recs))
+	actions := make([]apitoken.Action, 0, len(recs))
+	for _, r := range recs {
+		actions = append(actions, r.Action)
+	}
+	assert.Equal(t, []apitoken.Action{apitoken.ActionRevoke, apitoken.ActionUse, apitoken.ActionCreate,
+		apitoken.ActionDeny, apitoken.ActionDeny, apitoken.ActionDeny, apitoken.ActionDeny, apitoken.ActionUse,
+		apitoken.ActionUse, apitoken.ActionCreate}, actions)
+}
+
+func TestRest_APITokenPermission(t *testing.T) {
+	tbl := []struct {
+		method, path string
+		perm         apitoken.Permission
+	}{
+		{"GET", "/blocked", apitoken.PermRead},
+		{"GET", "/export", apitoken.PermMigrate},
+		{"POST", "/import/form", apitoken.PermMigrate},
+		{"PUT", "/pin/123", apitoken.PermModerate},
+		{"GET", "/deleteme", apitoken.PermModerate},
+		{"GET", "/tokens", ""},
+		{"DELETE", "/tokens/123", ""},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.perm, apiTokenPermission(tt.method, tt.path), tt.method+" "+tt.path)
+	}
+}
+
+func TestCheckedAPIToken_MarshalJSON(t *testing.T) {
+	u := token.User{ID: "token_id1"}
+	u.SetAdmin(true)
+	u.Attributes[APITokenAttr] = checkedAPIToken(apitoken.Token{ID: "id1", Name: "backup", Hash: "secret-hash"})
+	data, err := json.Marshal(u)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"api_token":"id1"`)
+	assert.NotContains(t, string(data), "secret-hash")
+}
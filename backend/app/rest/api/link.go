@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// LinkedFromAttr is the user attribute with the id given by auth provider, set for users linked to another account
+const LinkedFromAttr = "linked_from"
+
+const (
+	linkCookieName = "REMARK42-LINK"
+	linkCookiePath = "/api/v1/link"
+	linkPrefix     = "link::"
+	linkTTL        = 10 * time.Minute
+)
+
+// ProviderUserID returns id of the user given by auth provider, it differs from user's id for linked users
+func ProviderUserID(u token.User) string {
+	if id := u.StrAttr(LinkedFromAttr); id != "" {
+		return id
+	}
+	return u.ID
+}
+
+// POST /link?site=siteID - starts linking of another auth provider's account to the current user.
+// Link request kept in the cookie of this browser, the user logs in with another provider
+// and confirms with POST /link/confirm. Request made by someone else can't be confirmed this way.
+func (s *private) startLinkCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: linkPrefix + user.ID},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  siteID,
+			ExpiresAt: time.Now().Add(linkTTL).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	tkn, err := s.authenticator.TokenService().Token(claims)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to make link token", rest.ErrInternal)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: linkCookieName, Value: tkn, Path: linkCookiePath, HttpOnly: true,
+		MaxAge: int(linkTTL.Seconds()), Secure: strings.HasPrefix(s.remarkURL, "https://")})
+	render.JSON(w, r, R.JSON{"user": user.ID, "expires": time.Unix(claims.ExpiresAt, 0)})
+}
+
+// POST /link/confirm?site=siteID - merges the current user, logged in with another provider,
+// into the user started the link and returns the linked user.
+func (s *private) confirmLinkCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	cookie, err := r.Cookie(linkCookieName)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "no link request", rest.ErrActionRejected)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: linkCookieName, Path: linkCookiePath, HttpOnly: true, MaxAge: -1})
+
+	linkClaims, err := s.authenticator.TokenService().Parse(cookie.Value)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to verify link request", rest.ErrNoAccess)
+		return
+	}
+	if s.authenticator.TokenService().IsExpired(linkClaims) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("expired"), "failed to verify link request", rest.ErrNoAccess)
+		return
+	}
+	if linkClaims.Handshake == nil || !strings.HasPrefix(linkClaims.Handshake.ID, linkPrefix) || linkClaims.Audience != siteID {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("bad link token"), "invalid link request", rest.ErrActionRejected)
+		return
+	}
+	toID := strings.TrimPrefix(linkClaims.Handshake.ID, linkPrefix)
+	if toID == user.ID {
+		rest.SendErrorJSON(w, r, http.StatusConflict, errors.New("same user"), "account already linked", rest.ErrActionRejected)
+		return
+	}
+
+	if err = s.dataService.MergeUsers(siteID, user.ID, toID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "can't link account", rest.ErrActionRejected)
+		return
+	}
+	if s.inbox != nil {
+		if err = s.inbox.Merge(siteID, user.ID, toID); err != nil {
+			log.Printf("[WARN] can't merge notifications inbox of %s into %s, %v", user.ID, toID, err)
+		}
+	}
+	log.Printf("[INFO] user %s linked to %s on %s", user.ID, toID, siteID)
+	s.cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+
+	// token set again, the user in it replaced by the linked one on update of claims
+	claims, _, err := s.authenticator.TokenService().Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to get token", rest.ErrNoAccess)
+		return
+	}
+	if claims, err = s.authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+		return
+	}
+
+	render.JSON(w, r, claims.User)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRest_Link(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.Authenticator = auth.NewService(auth.Opts{
+		SecretReader: token.SecretFunc(func(aud string) (string, error) { return "secret", nil }),
+		ClaimsUpd: token.ClaimsUpdFunc(func(c token.Claims) token.Claims { // the same way as by the server
+			if c.User != nil {
+				if id := srv.DataService.CanonicalUserID(c.Audience, c.User.ID); id != c.User.ID {
+					c.User.SetStrAttr(LinkedFromAttr, c.User.ID)
+					c.User.ID = id
+				}
+			}
+			return c
+		}),
+	})
+	inboxFile := os.TempDir() + "/test-link-inbox.db"
+	defer os.Remove(inboxFile)
+	inbox, err := notify.NewBoltInbox(inboxFile, 0, bolt.Options{})
+	require.NoError(t, err)
+	defer inbox.Close()
+	srv.Inbox = inbox
+	ts.Config.Handler = srv.routes()
+
+	userToken := func(id string) string {
+		tkn, err := srv.Authenticator.TokenService().Token(token.Claims{User: &token.User{ID: id, Name: id},
+			StandardClaims: jwt.StandardClaims{Audience: "remark42", Issuer: "remark42",
+				ExpiresAt: time.Now().Add(time.Hour).Unix()}})
+		require.NoError(t, err)
+		return tkn
+	}
+	require.NoError(t, inbox.Add("remark42", "google_1", notify.InboxItem{ID: "reply-c1", Type: notify.InboxReply}))
+	_, err = srv.DataService.Create(store.Comment{Text: "from google", User: store.User{ID: "google_1", Name: "g"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}})
+	require.NoError(t, err)
+
+	// start link as github user
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/link?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, userToken("github_1"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var linkCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == linkCookieName {
+			linkCookie = c
+		}
+	}
+	require.NotNil(t, linkCookie)
+	assert.True(t, linkCookie.HttpOnly)
+
+	confirm := func(tkn string, cookie *http.Cookie) *http.Response {
+		r, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/link/confirm?site=remark42", nil)
+		require.NoError(t, e)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		res, e := sendReq(t, r, tkn)
+		require.NoError(t, e)
+		return res
+	}
+
+	resp = confirm(userToken("google_1"), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no link cookie")
+	resp = confirm(userToken("google_1"), &http.Cookie{Name: linkCookieName, Value: "bad"})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "bad link cookie")
+	resp = confirm(userToken("github_1"), linkCookie)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "the same user")
+
+	// confirm as google user in the same browser
+	resp = confirm(userToken("google_1"), linkCookie)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	u := token.User{}
+	require.NoError(t, json.Unmarshal(body, &u))
+	assert.Equal(t, "github_1", u.ID, "session switched to the linked user")
+	assert.Equal(t, "google_1", ProviderUserID(u))
+	assert.Equal(t, "github_1", srv.DataService.CanonicalUserID("remark42", "google_1"))
+
+	comments, err := srv.DataService.User("remark42", "github_1", 10, 0, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, "github_1", comments[0].User.ID, "comment of google user moved")
+	items, _, err := inbox.List("remark42", "github_1", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items), "notifications of google user moved")
+	assert.Equal(t, "reply-c1", items[0].ID)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/link/confirm?site=remark42", nil)
+	require.NoError(t, err)
+	req.AddCookie(linkCookie)
+	resp, err = sendReq(t, req, userToken("google_2"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "confirmed by POST only")
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/link?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, anonToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "anonymous can't link")
+}
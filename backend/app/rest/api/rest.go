@@ -44,6 +44,13 @@ type Rest struct {
 	CommentFormatter *store.CommentFormatter
 	Migrator         *Migrator
 	NotifyService    *notify.Service
+	TelegramService  telegramService // links users' telegram accounts for reply notifications, optional
+	Inbox            inboxStore      // users' notifications about replies, mentions and admin actions, optional
+	EmailPreview     emailPreviewer  // renders email notifications for admins, optional
+	SSO              *SSO            // exchanges tokens issued by sites for sessions, optional
+	APITokens        apiTokenService // named tokens for admin automation, optional
+	Sessions         sessionsCache   // resets sessions revocation time cached by authenticator, optional
+	TelegramAudit    telegramAuditor // audit trail of telegram moderation buttons, optional
 	ImageService     *image.Service
 
 	AnonVote        bool
@@ -57,6 +64,8 @@ type Rest struct {
 	}
 	UpdateLimiter      float64
 	EmailNotifications bool
+	WebPushPublicKey   string   // VAPID public key for browsers to subscribe, web push notifications disabled if empty
+	WebPushHosts       []string // push services allowed as subscription endpoints, with subdomains
 	EmojiEnabled       bool
 	SimpleView         bool
 	ProxyCORS          bool
@@ -239,14 +248,25 @@ func (s *Rest) routes() chi.Router {
 			rava.Mount("/avatar", avatarHandler)
 		})
 
+		// sso routes, without logging of bodies with tokens
+		if s.SSO != nil {
+			rapi.Group(func(rsso chi.Router) {
+				rsso.Use(middleware.Timeout(10 * time.Second))
+				rsso.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)), middleware.NoCache)
+				rsso.Post("/sso/login", s.ssoLoginCtrl)
+				rsso.Post("/sso/logout", s.ssoLogoutCtrl)
+			})
+		}
+
 		// open routes
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
 			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			ropen.Use(authMiddleware.Trace, middleware.NoCache, logInfoWithBody)
+			ropen.Use(authMiddleware.Trace, s.apiTokenScope, middleware.NoCache, logInfoWithBody)
 			ropen.Get("/config", s.configCtrl)
 			ropen.Get("/find", s.pubRest.findCommentsCtrl)
 			ropen.Get("/id/{id}", s.pubRest.commentByIDCtrl)
+			ropen.Get("/thread/{id}", s.pubRest.threadCtrl)
 			ropen.Get("/comments", s.pubRest.findUserCommentsCtrl)
 			ropen.Get("/last/{limit}", s.pubRest.lastCommentsCtrl)
 			ropen.Get("/count", s.pubRest.countCtrl)
@@ -268,7 +288,7 @@ func (s *Rest) routes() chi.Router {
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
 			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			ropen.Use(authMiddleware.Trace, logInfoWithBody)
+			ropen.Use(authMiddleware.Trace, s.apiTokenScope, logInfoWithBody)
 			ropen.Get("/picture/{user}/{id}", s.pubRest.loadPictureCtrl)
 		})
 
@@ -276,7 +296,7 @@ func (s *Rest) routes() chi.Router {
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(30 * time.Second))
 			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			rauth.Use(authMiddleware.Auth, matchSiteID, middleware.NoCache, logInfoWithBody)
+			rauth.Use(authMiddleware.Auth, s.apiTokenScope, matchSiteID, middleware.NoCache, logInfoWithBody)
 			rauth.Get("/user", s.privRest.userInfoCtrl)
 			rauth.Get("/userdata", s.privRest.userAllDataCtrl)
 		})
@@ -285,7 +305,7 @@ func (s *Rest) routes() chi.Router {
 		rapi.Route("/admin", func(radmin chi.Router) {
 			radmin.Use(middleware.Timeout(30 * time.Second))
 			radmin.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			radmin.Use(authMiddleware.Auth, authMiddleware.AdminOnly, matchSiteID)
+			radmin.Use(authMiddleware.Auth, authMiddleware.AdminOnly, s.apiTokenScope, matchSiteID)
 			radmin.Use(middleware.NoCache, logInfoWithBody)
 
 			radmin.Delete("/comment/{id}", s.adminRest.deleteCommentCtrl)
@@ -294,10 +314,20 @@ func (s *Rest) routes() chi.Router {
 			radmin.Get("/user/{userid}", s.adminRest.getUserInfoCtrl)
 			radmin.Get("/deleteme", s.adminRest.deleteMeRequestCtrl)
 			radmin.Put("/verify/{userid}", s.adminRest.setVerifyCtrl)
+			radmin.Put("/merge", s.adminRest.mergeUsersCtrl)
+			radmin.Delete("/sessions/{userid}", s.adminRest.revokeSessionsCtrl)
 			radmin.Put("/pin/{id}", s.adminRest.setPinCtrl)
 			radmin.Get("/blocked", s.adminRest.blockedUsersCtrl)
+			radmin.Get("/suspicious", s.adminRest.suspiciousCtrl)
 			radmin.Put("/readonly", s.adminRest.setReadOnlyCtrl)
 			radmin.Put("/title/{id}", s.adminRest.setTitleCtrl)
+			radmin.Put("/approve/{id}", s.adminRest.approveCtrl)
+			radmin.Put("/trust/{userid}", s.adminRest.setTrustLevelCtrl)
+			radmin.Delete("/trust/{userid}", s.adminRest.resetTrustLevelCtrl)
+			radmin.Get("/notify/failed", s.adminRest.failedNotificationsCtrl)
+			radmin.Put("/notify/replay/{id}", s.adminRest.replayNotificationCtrl)
+			radmin.Get("/notify/filter/{id}", s.adminRest.checkFilterCtrl)
+			radmin.Get("/email/preview", s.adminRest.emailPreviewCtrl)
 
 			// migrator
 			radmin.Get("/export", s.adminRest.migrator.exportCtrl)
@@ -305,30 +335,61 @@ func (s *Rest) routes() chi.Router {
 			radmin.Post("/import/form", s.adminRest.migrator.importFormCtrl)
 			radmin.Post("/remap", s.adminRest.migrator.remapCtrl)
 			radmin.Get("/wait", s.adminRest.migrator.waitCtrl)
+
+			// api tokens
+			if s.APITokens != nil {
+				radmin.Post("/tokens", s.adminRest.createAPITokenCtrl)
+				radmin.Get("/tokens", s.adminRest.listAPITokensCtrl)
+				radmin.Get("/tokens/audit", s.adminRest.apiTokensAuditCtrl)
+				radmin.Delete("/tokens/{id}", s.adminRest.revokeAPITokenCtrl)
+			}
+
+			// audit trail of telegram moderation buttons
+			if s.TelegramAudit != nil {
+				radmin.Get("/telegram/audit", s.adminRest.telegramAuditCtrl)
+			}
 		})
 
 		// protected routes, throttled to 10/s by default, controlled by external UpdateLimiter param
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
 			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
-			rauth.Use(authMiddleware.Auth, matchSiteID)
+			rauth.Use(authMiddleware.Auth, s.apiTokenScope, matchSiteID)
 			rauth.Use(middleware.NoCache, logInfoWithBody)
 
 			rauth.Put("/comment/{id}", s.privRest.updateCommentCtrl)
 			rauth.Post("/comment", s.privRest.createCommentCtrl)
 			rauth.Put("/vote/{id}", s.privRest.voteCtrl)
+			rauth.With(rejectAnonUser).Get("/sessions", s.privRest.sessionsCtrl)
+			rauth.With(rejectAnonUser).Delete("/sessions", s.privRest.logoutOtherSessionsCtrl)
+			rauth.With(rejectAnonUser).Put("/reaction/{id}", s.privRest.reactionCtrl)
 			rauth.With(rejectAnonUser).Post("/deleteme", s.privRest.deleteMeCtrl)
 			rauth.With(rejectAnonUser).Get("/email", s.privRest.getEmailCtrl)
 			rauth.With(rejectAnonUser).Post("/email/subscribe", s.privRest.sendEmailConfirmationCtrl)
 			rauth.With(rejectAnonUser).Post("/email/confirm", s.privRest.setConfirmedEmailCtrl)
 			rauth.With(rejectAnonUser).Delete("/email", s.privRest.deleteEmailCtrl)
+			rauth.With(rejectAnonUser).Put("/email/mentions", s.privRest.setMentionsCtrl)
+			rauth.With(rejectAnonUser).Put("/email/digest", s.privRest.setDigestCtrl)
+			rauth.With(rejectAnonUser).Put("/email/locale", s.privRest.setLocaleCtrl)
+			rauth.With(rejectAnonUser).Post("/telegram/subscribe", s.privRest.sendTelegramConfirmationCtrl)
+			rauth.With(rejectAnonUser).Post("/telegram/confirm", s.privRest.setConfirmedTelegramCtrl)
+			rauth.With(rejectAnonUser).Delete("/telegram", s.privRest.deleteTelegramCtrl)
+			rauth.With(rejectAnonUser).Post("/webpush/subscribe", s.privRest.setWebPushCtrl)
+			rauth.With(rejectAnonUser).Delete("/webpush", s.privRest.deleteWebPushCtrl)
+			rauth.With(rejectAnonUser).Get("/notifications", s.privRest.notificationsCtrl)
+			rauth.With(rejectAnonUser).Post("/notifications/read", s.privRest.readNotificationsCtrl)
+			rauth.With(rejectAnonUser).Put("/read", s.privRest.markReadCtrl)
+			rauth.With(rejectAnonUser).Get("/follow", s.privRest.getFollowCtrl)
+			rauth.With(rejectAnonUser).Put("/follow", s.privRest.setFollowCtrl)
+			rauth.With(rejectAnonUser).Post("/link", s.privRest.startLinkCtrl)
+			rauth.With(rejectAnonUser).Post("/link/confirm", s.privRest.confirmLinkCtrl)
 		})
 
 		// protected routes, anonymous rejected
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
 			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
-			rauth.Use(authMiddleware.Auth, rejectAnonUser, matchSiteID)
+			rauth.Use(authMiddleware.Auth, rejectAnonUser, s.apiTokenScope, matchSiteID)
 			rauth.Use(logger.New(logger.Log(log.Default()), logger.Prefix("[DEBUG]"), logger.IPfn(ipFn)).Handler)
 			rauth.Post("/picture", s.privRest.savePictureCtrl)
 		})
@@ -369,6 +430,11 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		readOnlyAge:      s.ReadOnlyAge,
 		authenticator:    s.Authenticator,
 		notifyService:    s.NotifyService,
+		telegramService:  s.TelegramService,
+		webPushKey:       s.WebPushPublicKey,
+		webPushHosts:     s.WebPushHosts,
+		inbox:            s.Inbox,
+		sessions:         s.Sessions,
 		remarkURL:        s.RemarkURL,
 		anonVote:         s.AnonVote,
 		templates:        templates.NewFS(),
@@ -380,6 +446,12 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		cache:         s.Cache,
 		authenticator: s.Authenticator,
 		readOnlyAge:   s.ReadOnlyAge,
+		notifyService: s.NotifyService,
+		emailPreview:  s.EmailPreview,
+		apiTokens:     s.APITokens,
+		sessions:      s.Sessions,
+		telegramAudit: s.TelegramAudit,
+		inbox:         s.Inbox,
 	}
 
 	rssGrp := rss{
@@ -421,9 +493,14 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		ReadOnlyAge        int      `json:"readonly_age"`
 		MaxImageSize       int      `json:"max_image_size"`
 		EmailNotifications bool     `json:"email_notifications"`
+		TelegramBot        string   `json:"telegram_bot_username,omitempty"`
+		WebPushPublicKey   string   `json:"webpush_public_key,omitempty"`
+		Inbox              bool     `json:"notifications_inbox"`
+		SSO                bool     `json:"sso"`
 		EmojiEnabled       bool     `json:"emoji_enabled"`
 		SimpleView         bool     `json:"simple_view"`
 		SendJWTHeader      bool     `json:"send_jwt_header"`
+		Reactions          []string `json:"reactions"`
 	}{
 		Version:            s.Version,
 		EditDuration:       int(s.DataService.EditDuration.Seconds()),
@@ -437,10 +514,18 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		ReadOnlyAge:        s.ReadOnlyAge,
 		MaxImageSize:       s.ImageService.MaxSize,
 		EmailNotifications: s.EmailNotifications,
+		WebPushPublicKey:   s.WebPushPublicKey,
+		Inbox:              s.Inbox != nil,
+		SSO:                s.SSO != nil,
 		EmojiEnabled:       s.EmojiEnabled,
 		AnonVote:           s.AnonVote,
 		SimpleView:         s.SimpleView,
 		SendJWTHeader:      s.SendJWTHeader,
+		Reactions:          s.DataService.Reactions(siteID),
+	}
+
+	if s.TelegramService != nil {
+		cnf.TelegramBot = s.TelegramService.GetBotUsername()
 	}
 
 	cnf.Auth = []string{}
@@ -3,12 +3,16 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +32,7 @@ import (
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/store/trust"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -38,26 +43,65 @@ type private struct {
 	commentFormatter *store.CommentFormatter
 	imageService     *image.Service
 	notifyService    *notify.Service
+	telegramService  telegramService
+	webPushKey       string   // VAPID public key, web push notifications enabled if set
+	webPushHosts     []string // push services allowed as subscription endpoints, with subdomains
+	inbox            inboxStore
+	sessions         sessionsCache
 	authenticator    *auth.Service
 	remarkURL        string
 	anonVote         bool
 	templates        templates.FileReader
 }
 
+// telegramService links telegram accounts of users with the bot, for reply notifications
+type telegramService interface {
+	AddToken(token, userID, siteID string, expires time.Time)
+	CheckToken(token, userID string) (chatID, siteID string, err error)
+	GetBotUsername() string
+}
+
+// inboxStore keeps users' notifications about replies, mentions and admin actions with their comments
+type inboxStore interface {
+	List(siteID, userID string, limit int) (items []notify.InboxItem, unread int, err error)
+	MarkRead(siteID, userID string, ids []string) (unread int, err error)
+	Delete(siteID, userID string) error
+	Merge(siteID, fromID, toID string) error
+}
+
 type privStore interface {
 	Create(comment store.Comment) (commentID string, err error)
 	EditComment(locator store.Locator, commentID string, req service.EditRequest) (comment store.Comment, err error)
 	Vote(req service.VoteReq) (comment store.Comment, err error)
+	React(req service.ReactReq) (comment store.Comment, err error)
 	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
 	GetUserEmail(siteID string, userID string) (string, error)
 	SetUserEmail(siteID string, userID string, value string) (string, error)
+	GetUserTelegram(siteID string, userID string) (string, error)
+	SetUserTelegram(siteID string, userID string, value string) (string, error)
+	SetWebPush(siteID, userID string, sub store.PushSubscription, subscribe bool) error
+	WebPush(siteID, userID string) ([]store.PushSubscription, error)
 	DeleteUserDetail(siteID string, userID string, detail engine.UserDetail) error
+	SetMentionsOff(siteID, userID string, off bool) error
+	IsMentionsOff(siteID, userID string) bool
+	SetDigestPeriod(siteID, userID, period string) error
+	DigestPeriod(siteID, userID string) string
+	SetLocale(siteID, userID, locale string) error
+	Locale(siteID, userID string) string
+	SetLastSeen(locator store.Locator, userID string, ts time.Time) error
+	SetFollow(locator store.Locator, userID string, follow bool) error
+	Following(locator store.Locator, userID string) (post, site bool)
 	ValidateComment(c *store.Comment) error
 	IsVerified(siteID string, userID string) bool
 	IsReadOnly(locator store.Locator) bool
 	IsBlocked(siteID string, userID string) bool
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
+	TrustLevel(siteID string, user store.User) trust.Level
+	MergeUsers(siteID, fromID, toID string) error
+	Sessions(siteID, userID string) ([]store.Session, error)
+	RegisterSession(siteID, userID string, sess store.Session) error
+	RevokeSessions(siteID, userID string, ts time.Time) error
 }
 
 // POST /comment - adds comment, resets all immutable fields
@@ -113,6 +157,10 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentRestrictWords)
 		return
 	}
+	if err == service.ErrLinksNotAllowed || err == service.ErrImagesNotAllowed {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "invalid comment", rest.ErrCommentTrustLevel)
+		return
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save comment", rest.ErrInternal)
 		return
@@ -127,7 +175,8 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
 		Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
 
-	if s.notifyService != nil {
+	// pending comment notifications sent on approval
+	if s.notifyService != nil && !finalComment.Pending {
 		s.notifyService.Submit(notify.Request{Comment: finalComment})
 	}
 
@@ -170,12 +219,14 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	formatted := s.commentFormatter.Format(store.Comment{Text: edit.Text, Locator: locator}) // renders mentions as well
 	editReq := service.EditRequest{
-		Text:    s.commentFormatter.FormatText(edit.Text),
-		Orig:    edit.Text,
-		Summary: edit.Summary,
-		Delete:  edit.Delete,
-		Admin:   user.Admin,
+		Text:     formatted.Text,
+		Orig:     edit.Text,
+		Summary:  edit.Summary,
+		Delete:   edit.Delete,
+		Admin:    user.Admin,
+		Mentions: formatted.Mentions,
 	}
 
 	res, err := s.dataService.EditComment(locator, id, editReq)
@@ -183,6 +234,10 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
 		return
 	}
+	if err == service.ErrLinksNotAllowed || err == service.ErrImagesNotAllowed {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "invalid comment", rest.ErrCommentTrustLevel)
+		return
+	}
 
 	if err != nil {
 		code := parseError(err, rest.ErrCommentRejected)
@@ -190,15 +245,43 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.notifyService != nil {
+		event := notify.EventCommentEdited
+		if edit.Delete {
+			event = notify.EventCommentDeleted
+		}
+		s.notifyService.SubmitEvent(notify.Event{Type: event, SiteID: locator.SiteID, URL: locator.URL, Comment: &res, UserID: user.ID})
+		if added := addedMentions(currComment.Mentions, res.Mentions); len(added) > 0 && !edit.Delete && !res.Pending {
+			mentioned := res
+			mentioned.Mentions = added
+			s.notifyService.Submit(notify.Request{Comment: mentioned, Edited: true})
+		}
+	}
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, user.ID))
 	render.JSON(w, r, res)
 }
 
+// addedMentions returns ids of users mentioned in the edited comment and not mentioned before
+func addedMentions(before, after []string) []string {
+	mentioned := make(map[string]bool, len(before))
+	for _, id := range before {
+		mentioned[id] = true
+	}
+	var res []string
+	for _, id := range after {
+		if !mentioned[id] {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
 // GET /user?site=siteID - returns user info
 func (s *private) userInfoCtrl(w http.ResponseWriter, r *http.Request) {
 	user := rest.MustGetUserInfo(r)
 	if siteID := r.URL.Query().Get("site"); siteID != "" {
 		user.Verified = s.dataService.IsVerified(siteID, user.ID)
+		user.TrustLevel = int(s.dataService.TrustLevel(siteID, user))
 
 		email, err := s.dataService.GetUserEmail(siteID, user.ID)
 		if err != nil {
@@ -207,6 +290,12 @@ func (s *private) userInfoCtrl(w http.ResponseWriter, r *http.Request) {
 		if len(email) > 0 {
 			user.EmailSubscription = true
 		}
+
+		chatID, err := s.dataService.GetUserTelegram(siteID, user.ID)
+		if err != nil {
+			log.Printf("[WARN] can't read telegram for %s, %v", user.ID, err)
+		}
+		user.TelegramSubscription = chatID != ""
 	}
 
 	render.JSON(w, r, user)
@@ -249,10 +338,41 @@ func (s *private) voteCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't vote for comment", code)
 		return
 	}
+	if s.notifyService != nil {
+		s.notifyService.SubmitEvent(notify.Event{Type: notify.EventCommentVoted, SiteID: locator.SiteID, URL: locator.URL,
+			Comment: &comment, UserID: user.ID, Status: vote})
+	}
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
 	render.JSON(w, r, R.JSON{"id": comment.ID, "score": comment.Score})
 }
 
+// PUT /reaction/{id}?site=siteID&url=post-url&emoji=👍 - toggle reaction for the comment
+func (s *private) reactionCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	log.Printf("[DEBUG] reaction for comment %s", id)
+
+	if s.isReadOnly(locator) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "old post, read-only", rest.ErrReadOnly)
+		return
+	}
+
+	if s.dataService.IsBlocked(locator.SiteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked", rest.ErrUserBlocked)
+		return
+	}
+
+	req := service.ReactReq{Locator: locator, CommentID: id, UserID: user.ID, Emoji: r.URL.Query().Get("emoji")}
+	comment, err := s.dataService.React(req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't react to comment", rest.ErrReactionRejected)
+		return
+	}
+	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+	render.JSON(w, r, R.JSON{"id": comment.ID, "reactions": comment.Reactions, "my_reactions": comment.MyReactions})
+}
+
 // getEmailCtrl gets email address for authenticated user.
 // GET /email?site=siteID
 func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
@@ -263,20 +383,129 @@ func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
 	}
 
-	render.JSON(w, r, R.JSON{"user": user, "address": address})
+	digest := s.dataService.DigestPeriod(siteID, user.ID)
+	if digest == "" {
+		digest = "immediate"
+	}
+	render.JSON(w, r, R.JSON{"user": user, "address": address, "mentions": !s.dataService.IsMentionsOff(siteID, user.ID),
+		"digest": digest, "locale": s.dataService.Locale(siteID, user.ID)})
+}
+
+// PUT /email/mentions?site=siteID&notify=0 - disables (notify=0) or enables (notify=1) notifications about user's mentions
+func (s *private) setMentionsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	notify := r.URL.Query().Get("notify") != "0"
+	log.Printf("[DEBUG] set mentions notifications for user %s to %v", user.ID, notify)
+
+	if err := s.dataService.SetMentionsOff(siteID, user.ID, !notify); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set mentions notifications for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"mentions": notify})
+}
+
+// PUT /email/digest?site=siteID&period=daily - sets delivery of user's email notifications,
+// immediate or as hourly, daily or weekly digest
+func (s *private) setDigestCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	period := r.URL.Query().Get("period")
+	if period != "immediate" && !notify.IsDigestPeriod(period) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("unknown period %q", period),
+			"period should be immediate, hourly, daily or weekly", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[DEBUG] set digest for user %s to %s", user.ID, period)
+
+	value := period
+	if period == "immediate" {
+		value = ""
+	}
+	if err := s.dataService.SetDigestPeriod(siteID, user.ID, value); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set digest for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"digest": period})
+}
+
+// PUT /email/locale?site=siteID&locale=de - sets locale of user's email notifications, empty locale for the default one
+func (s *private) setLocaleCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	locale := r.URL.Query().Get("locale")
+	if locale != "" && !notify.IsLocale(locale) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest,
+			fmt.Errorf("bad locale %q", locale), "locale should be language code, like en or pt-BR", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[DEBUG] set locale for user %s to %q", user.ID, locale)
+
+	if err := s.dataService.SetLocale(siteID, user.ID, locale); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set locale for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"locale": locale})
+}
+
+// PUT /read?site=siteID&url=post-url - marks all comments of the post as read by the current user
+func (s *private) markReadCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[DEBUG] mark %+v as read by user %s", locator, user.ID)
+
+	if err := s.dataService.SetLastSeen(locator, user.ID, time.Now()); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't mark post as read", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"url": locator.URL, "read": true})
+}
+
+// GET /follow?site=siteID&url=post-url - returns whether the current user follows the post and the whole site
+func (s *private) getFollowCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	post, site := s.dataService.Following(locator, user.ID)
+	render.JSON(w, r, R.JSON{"post": post, "site": site})
+}
+
+// PUT /follow?site=siteID&url=post-url&follow=1 - follows (follow=1) or unfollows (follow=0) all new comments of the post,
+// or of the whole site if url is not set. Notifications are sent to the user's email.
+func (s *private) setFollowCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	follow := r.URL.Query().Get("follow") != "0"
+	log.Printf("[DEBUG] set follow %+v for user %s to %v", locator, user.ID, follow)
+
+	if err := s.dataService.SetFollow(locator, user.ID, follow); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set follow for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"url": locator.URL, "follow": follow})
 }
 
 // sendEmailConfirmationCtrl gets address and siteID from query, makes confirmation token and sends it to user.
-// GET /email/subscribe?site=siteID&address=someone@example.com
+// Optional locale sets the language of the confirmation and of the following notifications.
+// GET /email/subscribe?site=siteID&address=someone@example.com&locale=de
 func (s *private) sendEmailConfirmationCtrl(w http.ResponseWriter, r *http.Request) {
 	user := rest.MustGetUserInfo(r)
 	address := r.URL.Query().Get("address")
 	siteID := r.URL.Query().Get("site")
+	locale := r.URL.Query().Get("locale")
 	if address == "" {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest,
 			errors.New("missing parameter"), "address parameter is required", rest.ErrInternal)
 		return
 	}
+	if locale != "" && !notify.IsLocale(locale) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest,
+			fmt.Errorf("bad locale %q", locale), "locale should be language code, like en or pt-BR", rest.ErrInternal)
+		return
+	}
 	existingAddress, err := s.dataService.GetUserEmail(siteID, user.ID)
 	if err != nil {
 		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
@@ -302,12 +531,19 @@ func (s *private) sendEmailConfirmationCtrl(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if locale != "" {
+		if err = s.dataService.SetLocale(siteID, user.ID, locale); err != nil {
+			log.Printf("[WARN] can't set locale for %s, %v", user.ID, err)
+		}
+	}
+
 	s.notifyService.SubmitVerification(
 		notify.VerificationRequest{
 			SiteID: siteID,
 			User:   user.Name,
 			Email:  address,
 			Token:  tkn,
+			Locale: locale,
 		},
 	)
 
@@ -366,7 +602,8 @@ func (s *private) setConfirmedEmailCtrl(w http.ResponseWriter, r *http.Request)
 	render.JSON(w, r, R.JSON{"updated": true, "address": val})
 }
 
-// POST/GET /email/unsubscribe.html?site=siteID&tkn=jwt - unsubscribe the user in token from email notifications
+// POST/GET /email/unsubscribe.html?site=siteID&tkn=jwt - unsubscribe the user in token from email notifications.
+// With unfollow=post&url=post-url or unfollow=site only the followed post or site unsubscribed, email kept.
 func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 	tkn := r.URL.Query().Get("tkn")
 	if tkn == "" {
@@ -413,24 +650,38 @@ func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[DEBUG] unsubscribe user %s", userID)
+	switch r.URL.Query().Get("unfollow") {
+	case "post", "site":
+		locator := store.Locator{SiteID: siteID}
+		if r.URL.Query().Get("unfollow") == "post" {
+			locator.URL = r.URL.Query().Get("url")
+		}
+		log.Printf("[DEBUG] unfollow %+v for user %s", locator, userID)
+		if err = s.dataService.SetFollow(locator, userID, false); err != nil {
+			code := parseError(err, rest.ErrInternal)
+			rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't unfollow for user", code, s.templates)
+			return
+		}
+	default:
+		log.Printf("[DEBUG] unsubscribe user %s", userID)
 
-	if err = s.dataService.DeleteUserDetail(siteID, userID, engine.UserEmail); err != nil {
-		code := parseError(err, rest.ErrInternal)
-		rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't delete email for user", code, s.templates)
-		return
-	}
-	// clean User.Email from the token, if user has the token
-	claims, _, err := s.authenticator.TokenService().Get(r)
-	if err != nil {
-		log.Printf("[DEBUG] unsubscribed user doesn't have valid JWT token to update %s, %v", userID, err)
-	}
-	if claims.User != nil && claims.User.Email != "" {
-		claims.User.Email = ""
-		if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
-			rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal, s.templates)
+		if err = s.dataService.DeleteUserDetail(siteID, userID, engine.UserEmail); err != nil {
+			code := parseError(err, rest.ErrInternal)
+			rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't delete email for user", code, s.templates)
 			return
 		}
+		// clean User.Email from the token, if user has the token
+		claims, _, e := s.authenticator.TokenService().Get(r)
+		if e != nil {
+			log.Printf("[DEBUG] unsubscribed user doesn't have valid JWT token to update %s, %v", userID, e)
+		}
+		if claims.User != nil && claims.User.Email != "" {
+			claims.User.Email = ""
+			if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
+				rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal, s.templates)
+				return
+			}
+		}
 	}
 
 	// MustExecute behaves like template.Execute, but panics if an error occurs.
@@ -480,10 +731,210 @@ func (s *private) deleteEmailCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"deleted": true})
 }
 
+// POST /telegram/subscribe?site=siteID - makes token to link user's telegram account and returns the bot's deep link with it.
+// The account is linked by setConfirmedTelegramCtrl once the user starts the bot with the link.
+func (s *private) sendTelegramConfirmationCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.telegramService == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not enabled"), "telegram notifications are not enabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	rnd := make([]byte, 16)
+	if _, err := rand.Read(rnd); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to make verification token", rest.ErrInternal)
+		return
+	}
+	tkn := hex.EncodeToString(rnd)
+	s.telegramService.AddToken(tkn, user.ID, siteID, time.Now().Add(30*time.Minute))
+
+	bot := s.telegramService.GetBotUsername()
+	render.JSON(w, r, R.JSON{"bot": bot, "token": tkn, "link": fmt.Sprintf("https://t.me/%s?start=%s", bot, tkn)})
+}
+
+// POST /telegram/confirm?site=siteID&tkn=token - links user's telegram account if the user started the bot
+// with the token made by sendTelegramConfirmationCtrl, responds with 404 till then
+func (s *private) setConfirmedTelegramCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.telegramService == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not enabled"), "telegram notifications are not enabled", rest.ErrActionRejected)
+		return
+	}
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing parameter"), "token parameter is required", rest.ErrInternal)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	chatID, tknSite, err := s.telegramService.CheckToken(tkn, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "telegram account is not confirmed", rest.ErrInternal)
+		return
+	}
+	if tknSite != siteID {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("site mismatch"), "token made for another site", rest.ErrInternal)
+		return
+	}
+
+	log.Printf("[DEBUG] set telegram for user %s", user.ID)
+
+	if _, err = s.dataService.SetUserTelegram(siteID, user.ID, chatID); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set telegram for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"updated": true})
+}
+
+// DELETE /telegram?site=siteID - unlinks user's telegram account
+func (s *private) deleteTelegramCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	log.Printf("[DEBUG] remove telegram for user %s", user.ID)
+
+	if err := s.dataService.DeleteUserDetail(siteID, user.ID, engine.UserTelegram); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete telegram for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"deleted": true})
+}
+
+// POST /webpush/subscribe?site=siteID - adds browser's push subscription of the user, body is the subscription
+// as returned by PushManager.subscribe(), i.e. {"endpoint": "https://...", "keys": {"p256dh": "...", "auth": "..."}}
+func (s *private) setWebPushCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.webPushKey == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not enabled"), "web push notifications are not enabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	sub := store.PushSubscription{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &sub); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind push subscription", rest.ErrDecode)
+		return
+	}
+	if u, err := url.Parse(sub.Endpoint); err != nil || u.Scheme != "https" || !s.allowedPushHost(u.Hostname()) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("invalid endpoint"),
+			"push subscription endpoint should be https url of allowed push service", rest.ErrActionRejected)
+		return
+	}
+
+	log.Printf("[DEBUG] set web push subscription for user %s", user.ID)
+	if err := s.dataService.SetWebPush(siteID, user.ID, sub, true); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set web push subscription for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"updated": true})
+}
+
+// allowedPushHost checks the host is one of allowed push services or its subdomain. Endpoints are requested by
+// the server on each notification, so arbitrary ones would let users make the server call internal addresses.
+func (s *private) allowedPushHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, h := range s.webPushHosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// DELETE /webpush?site=siteID&endpoint=url - removes user's push subscription with the endpoint,
+// or all subscriptions of the user without endpoint
+func (s *private) deleteWebPushCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	endpoint := r.URL.Query().Get("endpoint")
+	log.Printf("[DEBUG] remove web push subscription for user %s", user.ID)
+
+	var err error
+	if endpoint == "" {
+		err = s.dataService.DeleteUserDetail(siteID, user.ID, engine.UserWebPush)
+	} else {
+		err = s.dataService.SetWebPush(siteID, user.ID, store.PushSubscription{Endpoint: endpoint}, false)
+	}
+	if err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete web push subscription for user", code)
+		return
+	}
+	render.JSON(w, r, R.JSON{"deleted": true})
+}
+
+// GET /notifications?site=siteID&limit=50 - returns user's notifications, newest first, and the number of unread ones
+func (s *private) notificationsCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.inbox == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not enabled"), "notifications inbox is not enabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+
+	items, unread, err := s.inbox.List(siteID, user.ID, limit)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get notifications", rest.ErrInternal)
+		return
+	}
+	if items == nil {
+		items = []notify.InboxItem{}
+	}
+	render.JSON(w, r, R.JSON{"notifications": items, "unread": unread})
+}
+
+// POST /notifications/read?site=siteID&id=id1,id2 - marks user's notifications with ids as read, all of them without ids.
+// Returns the number of unread notifications left.
+func (s *private) readNotificationsCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.inbox == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("not enabled"), "notifications inbox is not enabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	var ids []string
+	if v := r.URL.Query().Get("id"); v != "" {
+		ids = strings.Split(v, ",")
+	}
+
+	unread, err := s.inbox.MarkRead(siteID, user.ID, ids)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't mark notifications read", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"unread": unread})
+}
+
 // GET /userdata?site=siteID - exports all data about the user as a json with user info and list of all comments
 func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 	user := rest.MustGetUserInfo(r)
+	chatID, err := s.dataService.GetUserTelegram(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read telegram for %s, %v", user.ID, err)
+	}
+	user.TelegramSubscription = chatID != ""
+	pushes, err := s.dataService.WebPush(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read web push subscriptions for %s, %v", user.ID, err)
+	}
+	var inboxItems []notify.InboxItem
+	if s.inbox != nil {
+		if inboxItems, _, err = s.inbox.List(siteID, user.ID, 0); err != nil {
+			log.Printf("[WARN] can't read notifications inbox for %s, %v", user.ID, err)
+		}
+	}
 	userB, err := json.Marshal(&user)
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't marshal user info", rest.ErrInternal)
@@ -529,6 +980,17 @@ func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if chatID != "" {
+		merr = multierror.Append(merr, write([]byte(`, "telegram": `+strconv.Quote(chatID)))) // send linked telegram chat
+	}
+	if len(pushes) > 0 {
+		pushesB, e := json.Marshal(pushes)
+		merr = multierror.Append(merr, e, write([]byte(`, "webpush": `)), write(pushesB)) // send push subscriptions
+	}
+	if len(inboxItems) > 0 {
+		inboxB, e := json.Marshal(inboxItems)
+		merr = multierror.Append(merr, e, write([]byte(`, "inbox": `)), write(inboxB)) // send notifications inbox
+	}
 	merr = multierror.Append(merr, write([]byte(`}`)))
 	if merr.(*multierror.Error).ErrorOrNil() != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, merr, "can't write user info", rest.ErrInternal)
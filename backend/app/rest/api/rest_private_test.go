@@ -6,13 +6,16 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,10 +26,14 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/store/trust"
 )
 
 // gopher png for test, from https://golang.org/src/image/png/example_test.go
@@ -55,6 +62,74 @@ func TestRest_Create(t *testing.T) {
 	assert.True(t, len(c["id"].(string)) > 8)
 }
 
+func TestRest_CreateWithMentions(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.commentFormatter = store.NewCommentFormatter().WithMentions(srv.DataService)
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	_, err := srv.DataService.Create(store.Comment{Text: "first", Locator: locator, User: store.User{ID: "u1", Name: "user one"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", "u1", "u1@example.com")
+	require.NoError(t, err)
+
+	resp, err := post(t, ts.URL+"/api/v1/comment",
+		`{"text": "thanks @user_one", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	c := store.Comment{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, []string{"u1"}, c.Mentions)
+	assert.Contains(t, c.Text, `class="mention"`)
+
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 1, len(mockDestination.Get()))
+	assert.Equal(t, []notify.Mention{{UserID: "u1", Email: "u1@example.com"}}, mockDestination.Get()[0].Mentions)
+}
+
+func TestRest_UpdateWithMentions(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.commentFormatter = store.NewCommentFormatter().WithMentions(srv.DataService)
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	for _, u := range []store.User{{ID: "u1", Name: "user one"}, {ID: "u2", Name: "user two"}} {
+		_, err := srv.DataService.Create(store.Comment{Text: "first", Locator: locator, User: u})
+		require.NoError(t, err)
+		_, err = srv.DataService.SetUserEmail("remark42", u.ID, u.ID+"@example.com")
+		require.NoError(t, err)
+	}
+
+	id := addComment(t, store.Comment{Text: "thanks @user_one", Locator: locator}, ts)
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 1, len(mockDestination.Get()))
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"thanks @user_one and @user_two"}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	c := store.Comment{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, []string{"u1", "u2"}, c.Mentions)
+
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 2, len(mockDestination.Get()))
+	edited := mockDestination.Get()[1]
+	assert.True(t, edited.Edited)
+	assert.Equal(t, []notify.Mention{{UserID: "u2", Email: "u2@example.com"}}, edited.Mentions, "only new mention notified")
+	assert.Empty(t, edited.Followers)
+}
+
 func TestRest_CreateOldPost(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -142,6 +217,46 @@ func TestRest_CreateWithRestrictedWord(t *testing.T) {
 	assert.Equal(t, "invalid comment", c["details"])
 }
 
+func TestRest_CreateWithTrustLevel(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	_, trustTeardown := enableTrust(t, srv)
+	defer trustTeardown()
+
+	linkComment := `{"text": "see [this](https://example.com)", "locator":{"url": "https://radio-t.com/blah1",
+"site": "remark42"}}`
+
+	postAsDev := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", strings.NewReader(linkComment))
+		require.NoError(t, err)
+		return sendReq(t, req, devToken)
+	}
+
+	resp, err := postAsDev()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	c := R.JSON{}
+	err = json.Unmarshal(b, &c)
+	assert.NoError(t, err)
+	assert.Equal(t, "links not allowed for user's trust level", c["error"])
+	assert.Equal(t, float64(rest.ErrCommentTrustLevel), c["code"])
+
+	require.NoError(t, srv.DataService.SetTrustLevel("remark42", "dev", trust.LevelBasic))
+	resp, err = postAsDev()
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	user := store.User{}
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+	assert.Equal(t, int(trust.LevelBasic), user.TrustLevel)
+}
+
 func TestRest_CreateRejected(t *testing.T) {
 
 	ts, _, teardown := startupT(t)
@@ -425,6 +540,53 @@ func TestRest_UpdateWithRestrictedWords(t *testing.T) {
 	assert.Equal(t, "invalid comment", c["details"])
 }
 
+func TestRest_Reaction(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ReactionsLister = service.StaticReactionsLister{Default: []string{"👍", "🎉"}}
+
+	id1 := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+
+	react := func(emoji string) (int, R.JSON) {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/reaction/%s?site=remark42&url=https://radio-t.com/blah&emoji=%s", ts.URL, id1, url.QueryEscape(emoji)), nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		res := R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, res
+	}
+
+	code, res := react("👍")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"👍": 1.0}, res["reactions"])
+	assert.Equal(t, []interface{}{"👍"}, res["my_reactions"])
+
+	code, res = react("🤡")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, float64(rest.ErrReactionRejected), res["code"])
+
+	body, code := getWithDevAuth(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	require.Equal(t, http.StatusOK, code)
+	cr := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &cr))
+	assert.Equal(t, map[string]int{"👍": 1}, cr.Reactions)
+	assert.Equal(t, []string{"👍"}, cr.MyReactions)
+	assert.Nil(t, cr.Reactors, "hidden")
+	assert.Equal(t, 0, cr.Score)
+
+	code, res = react("👍")
+	require.Equal(t, http.StatusOK, code)
+	assert.Nil(t, res["reactions"], "toggled off")
+
+	body, code = get(t, ts.URL+"/api/v1/config?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"reactions":["👍","🎉"]`)
+}
+
 func TestRest_Vote(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -607,14 +769,25 @@ func TestRest_Email(t *testing.T) {
 		{description: "set user email, token not set", url: "/api/v1/email/confirm?site=remark42", method: http.MethodPost, responseCode: http.StatusBadRequest},
 		{description: "send confirmation without address", url: "/api/v1/email/subscribe?site=remark42", method: http.MethodPost, responseCode: http.StatusBadRequest},
 		{description: "send confirmation", url: "/api/v1/email/subscribe?site=remark42&address=good@example.com", method: http.MethodPost, responseCode: http.StatusOK},
+		{description: "send confirmation with bad locale", url: "/api/v1/email/subscribe?site=remark42&address=good@example.com&locale=../de", method: http.MethodPost, responseCode: http.StatusBadRequest},
+		{description: "send confirmation with locale", url: "/api/v1/email/subscribe?site=remark42&address=good@example.com&locale=pt-BR", method: http.MethodPost, responseCode: http.StatusOK},
 		{description: "set user email, token is good", url: fmt.Sprintf("/api/v1/email/confirm?site=remark42&tkn=%s", goodToken), method: http.MethodPost, responseCode: http.StatusOK, cookieEmail: "good@example.com"},
 		{description: "send confirmation with same address", url: "/api/v1/email/subscribe?site=remark42&address=good@example.com", method: http.MethodPost, responseCode: http.StatusConflict},
 		{description: "get user email", url: "/api/v1/email?site=remark42", method: http.MethodGet, responseCode: http.StatusOK},
+		{description: "disable mentions notifications", url: "/api/v1/email/mentions?site=remark42&notify=0", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "enable mentions notifications", url: "/api/v1/email/mentions?site=remark42&notify=1", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "set daily digest", url: "/api/v1/email/digest?site=remark42&period=daily", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "set immediate delivery", url: "/api/v1/email/digest?site=remark42&period=immediate", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "set unknown digest period", url: "/api/v1/email/digest?site=remark42&period=monthly", method: http.MethodPut, responseCode: http.StatusBadRequest},
+		{description: "disable mentions notifications without site_id", url: "/api/v1/email/mentions?notify=0", method: http.MethodPut, responseCode: http.StatusBadRequest},
 		{description: "delete user email", url: "/api/v1/email?site=remark42", method: http.MethodDelete, responseCode: http.StatusOK},
 		{description: "send another confirmation", url: "/api/v1/email/subscribe?site=remark42&address=good@example.com", method: http.MethodPost, responseCode: http.StatusOK},
 		{description: "set user email, token is good", url: fmt.Sprintf("/api/v1/email/confirm?site=remark42&tkn=%s", goodToken), method: http.MethodPost, responseCode: http.StatusOK, cookieEmail: "good@example.com"},
 		{description: "unsubscribe user, no token", url: "/email/unsubscribe.html?site=remark42", method: http.MethodPost, responseCode: http.StatusBadRequest},
 		{description: "unsubscribe user, wrong token", url: "/email/unsubscribe.html?site=remark42&tkn=jwt", method: http.MethodGet, responseCode: http.StatusForbidden},
+		{description: "follow the post", url: "/api/v1/follow?site=remark42&url=https://radio-t.com/blah1&follow=1", method: http.MethodPut, responseCode: http.StatusOK},
+		{description: "unfollow the post by email link", url: fmt.Sprintf("/email/unsubscribe.html?site=remark42&tkn=%s&unfollow=post&url=https://radio-t.com/blah1", goodToken), method: http.MethodGet, responseCode: http.StatusOK},
+		{description: "unfollow the site by email link", url: fmt.Sprintf("/email/unsubscribe.html?site=remark42&tkn=%s&unfollow=site", goodToken), method: http.MethodGet, responseCode: http.StatusOK},
 		{description: "unsubscribe user, good token", url: fmt.Sprintf("/email/unsubscribe.html?site=remark42&tkn=%s", goodToken), method: http.MethodPost, responseCode: http.StatusOK},
 		{description: "unsubscribe user second time, good token", url: fmt.Sprintf("/email/unsubscribe.html?site=remark42&tkn=%s", goodToken), method: http.MethodPost, responseCode: http.StatusConflict},
 	}
@@ -809,6 +982,14 @@ func TestRest_UserAllData(t *testing.T) {
 	_, err = srv.DataService.Create(c3)
 	require.NoError(t, err)
 
+	inboxFile := os.TempDir() + "/test-userdata-inbox.db"
+	defer os.Remove(inboxFile)
+	inbox, err := notify.NewBoltInbox(inboxFile, 0, bolt.Options{})
+	require.NoError(t, err)
+	defer inbox.Close()
+	srv.privRest.inbox = inbox
+	require.NoError(t, inbox.Add("remark42", "dev", notify.InboxItem{ID: "reply-c1", Type: notify.InboxReply}))
+
 	client := &http.Client{Timeout: 1 * time.Second}
 	req, err := http.NewRequest("GET", ts.URL+"/api/v1/userdata?site=remark42", nil)
 	require.NoError(t, err)
@@ -829,8 +1010,9 @@ func TestRest_UserAllData(t *testing.T) {
 	assert.Equal(t, 3, strings.Count(strUungzBody, `"text":`), "3 comments inside")
 
 	parsed := struct {
-		Info     store.User      `json:"info"`
-		Comments []store.Comment `json:"comments"`
+		Info     store.User         `json:"info"`
+		Comments []store.Comment    `json:"comments"`
+		Inbox    []notify.InboxItem `json:"inbox"`
 	}{}
 
 	err = json.Unmarshal(ungzBody, &parsed)
@@ -838,6 +1020,8 @@ func TestRest_UserAllData(t *testing.T) {
 	assert.Equal(t, store.User{Name: "developer one", ID: "dev",
 		Picture: "http://example.com/pic.png", IP: "127.0.0.1", SiteID: "remark42"}, parsed.Info)
 	assert.Equal(t, 3, len(parsed.Comments))
+	require.Equal(t, 1, len(parsed.Inbox))
+	assert.Equal(t, "reply-c1", parsed.Inbox[0].ID)
 
 	req, err = http.NewRequest("GET", ts.URL+"/api/v1/userdata?site=remark42", nil)
 	require.NoError(t, err)
@@ -1068,3 +1252,369 @@ func TestRest_CreateWithPictures(t *testing.T) {
 		assert.NoError(t, err, "picture %d moved from staging and available in permanent location", i)
 	}
 }
+
+func TestRest_MarkRead(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	_, err := srv.DataService.Create(store.Comment{Text: "old", Locator: locator, User: store.User{ID: "u1"},
+		Timestamp: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, `"new":true`, "never visited, nothing marked")
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/read?site=remark42&url=https://radio-t.com/blah1", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	seenTS, seen := srv.DataService.LastSeen(locator, "dev")
+	require.True(t, seen)
+	_, err = srv.DataService.Create(store.Comment{Text: "boundary", Locator: locator, User: store.User{ID: "u3"},
+		Timestamp: seenTS})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{Text: "fresh", Locator: locator, User: store.User{ID: "u2"},
+		Timestamp: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{Text: "mine", Locator: locator, User: store.User{ID: "dev"},
+		Timestamp: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&sort=time")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 4, len(comments.Comments))
+	assert.False(t, comments.Comments[0].New)
+	assert.False(t, comments.Comments[1].New, "posted at the time of the visit, not new")
+	assert.True(t, comments.Comments[2].New)
+	assert.False(t, comments.Comments[3].New, "own comment is not new")
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/info?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	info := store.PostInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &info))
+	assert.Equal(t, 4, info.Count)
+	assert.Equal(t, 1, info.Unread, "same comments counted as marked new")
+
+	body, code = get(t, ts.URL+"/api/v1/info?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "unread", "no unread for anonymous request")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/read?site=remark42&url=https://radio-t.com/blah1", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, "")
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRest_Follow(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	_, err := srv.DataService.Create(store.Comment{Text: "first", Locator: locator, User: store.User{ID: "u1"}})
+	require.NoError(t, err)
+
+	follow := func(query string) {
+		req, e := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/follow?site=remark42&"+query, http.NoBody)
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, devToken)
+		require.NoError(t, e)
+		assert.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/follow?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"post":false,"site":false}`+"\n", body)
+
+	follow("url=https://radio-t.com/blah1&follow=1")
+	follow("follow=1")
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/follow?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"post":true,"site":true}`+"\n", body)
+
+	body, code = get(t, ts.URL+"/api/v1/info?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	info := store.PostInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &info))
+	assert.Equal(t, 1, info.Followers)
+
+	follow("url=https://radio-t.com/blah1&follow=0")
+	post, site := srv.DataService.Following(locator, "dev")
+	assert.False(t, post)
+	assert.True(t, site)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/follow?site=remark42&follow=1", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, "")
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRest_EmailDigest(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/email?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"digest":"immediate"`)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/email/digest?site=remark42&period=weekly", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/email?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"digest":"weekly"`)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/email/digest?site=remark42&period=monthly", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(b), `"code":17`, "rejected, not internal error")
+}
+
+func TestRest_EmailLocale(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	setLocale := func(locale string) int {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/email/locale?site=remark42&locale="+locale, http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		assert.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, setLocale("pt-BR"))
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/email?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"locale":"pt-BR"`)
+
+	assert.Equal(t, http.StatusBadRequest, setLocale("not/a/locale"))
+	assert.Equal(t, "pt-BR", srv.DataService.Locale("remark42", "dev"), "bad locale not set")
+
+	require.Equal(t, http.StatusOK, setLocale(""))
+	assert.Equal(t, "", srv.DataService.Locale("remark42", "dev"), "reset to the default")
+}
+
+func TestRest_Telegram(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/telegram/subscribe?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "telegram not enabled")
+
+	tg := &mockTelegram{requests: map[string]string{}}
+	srv.privRest.telegramService = tg
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/telegram/subscribe?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	subscription := struct {
+		Bot   string `json:"bot"`
+		Token string `json:"token"`
+		Link  string `json:"link"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&subscription))
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, "remark42_bot", subscription.Bot)
+	assert.Equal(t, 32, len(subscription.Token))
+	assert.Equal(t, "https://t.me/remark42_bot?start="+subscription.Token, subscription.Link)
+
+	confirm := func(tkn string) int {
+		r, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/telegram/confirm?site=remark42&tkn="+tkn, http.NoBody)
+		require.NoError(t, e)
+		resp, e := sendReq(t, r, devToken)
+		require.NoError(t, e)
+		assert.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusBadRequest, confirm(""), "no token")
+	assert.Equal(t, http.StatusNotFound, confirm(subscription.Token), "bot not started yet")
+
+	tg.start(subscription.Token, "12345")
+	assert.Equal(t, http.StatusOK, confirm(subscription.Token))
+	chatID, err := srv.DataService.GetUserTelegram("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "12345", chatID)
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"telegram_subscription":true`)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/telegram?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	chatID, err = srv.DataService.GetUserTelegram("remark42", "dev")
+	require.NoError(t, err)
+	assert.Empty(t, chatID, "telegram unlinked")
+}
+
+func TestRest_WebPush(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	subscribe := func(body string) int {
+		r, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/webpush/subscribe?site=remark42", strings.NewReader(body))
+		require.NoError(t, e)
+		resp, e := sendReq(t, r, devToken)
+		require.NoError(t, e)
+		assert.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	unsubscribe := func(endpoint string) int {
+		r, e := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/webpush?site=remark42&endpoint="+url.QueryEscape(endpoint), http.NoBody)
+		require.NoError(t, e)
+		resp, e := sendReq(t, r, devToken)
+		require.NoError(t, e)
+		assert.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	sub1 := `{"endpoint":"https://push.example.com/1","keys":{"p256dh":"key1","auth":"auth1"}}`
+	sub2 := `{"endpoint":"https://push.example.com/2","keys":{"p256dh":"key2","auth":"auth2"}}`
+
+	assert.Equal(t, http.StatusBadRequest, subscribe(sub1), "web push not enabled")
+
+	srv.privRest.webPushKey = "vapid-public-key"
+	srv.privRest.webPushHosts = []string{"example.com"}
+	assert.Equal(t, http.StatusBadRequest, subscribe(`{"endpoint":"https://127.0.0.1/1","keys":{"p256dh":"k","auth":"a"}}`), "loopback")
+	assert.Equal(t, http.StatusBadRequest, subscribe(`{"endpoint":"https://evilexample.com/1","keys":{"p256dh":"k","auth":"a"}}`), "not allowed host")
+	assert.Equal(t, http.StatusBadRequest, subscribe(`{"endpoint":"https://example.com.evil.io/1","keys":{"p256dh":"k","auth":"a"}}`), "not allowed host")
+	assert.Equal(t, http.StatusBadRequest, subscribe(`{"endpoint":"http://push.example.com/1","keys":{"p256dh":"k","auth":"a"}}`), "not https")
+	assert.Equal(t, http.StatusBadRequest, subscribe(`{"endpoint":"https://push.example.com/1"}`), "no keys")
+	assert.Equal(t, http.StatusBadRequest, subscribe(`bad json`))
+	assert.Equal(t, http.StatusOK, subscribe(sub1))
+	assert.Equal(t, http.StatusOK, subscribe(sub2))
+
+	subs, err := srv.DataService.WebPush("remark42", "dev")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(subs))
+	assert.Equal(t, "https://push.example.com/1", subs[0].Endpoint)
+	assert.Equal(t, "key1", subs[0].Keys.P256dh)
+
+	assert.Equal(t, http.StatusOK, unsubscribe("https://push.example.com/1"))
+	subs, err = srv.DataService.WebPush("remark42", "dev")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(subs))
+	assert.Equal(t, "https://push.example.com/2", subs[0].Endpoint)
+
+	assert.Equal(t, http.StatusOK, unsubscribe(""), "remove all subscriptions")
+	subs, err = srv.DataService.WebPush("remark42", "dev")
+	require.NoError(t, err)
+	assert.Empty(t, subs)
+}
+
+func TestRest_Notifications(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/notifications?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, "inbox not enabled")
+	assert.Contains(t, body, "notifications inbox is not enabled")
+
+	inboxFile := os.TempDir() + "/test-rest-inbox.db"
+	defer os.Remove(inboxFile)
+	inbox, err := notify.NewBoltInbox(inboxFile, 0, bolt.Options{})
+	require.NoError(t, err)
+	defer inbox.Close()
+	notifier := notify.NewService(nil, 10, notify.NewInbox(inbox))
+	defer notifier.Close()
+	srv.adminRest.notifyService, srv.privRest.notifyService, srv.privRest.inbox = notifier, notifier, inbox
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}}, ts)
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/pin/"+id+"?site=remark42&url=https://radio-t.com/blah&pin=1", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	list := func() (res struct {
+		Notifications []notify.InboxItem `json:"notifications"`
+		Unread        int                `json:"unread"`
+	}) {
+		body, code := getWithDevAuth(t, ts.URL+"/api/v1/notifications?site=remark42")
+		require.Equal(t, http.StatusOK, code)
+		require.NoError(t, json.Unmarshal([]byte(body), &res))
+		return res
+	}
+	assert.Eventually(t, func() bool { return list().Unread == 1 }, 5*time.Second, 10*time.Millisecond, "pin notified")
+	res := list()
+	require.Equal(t, 1, len(res.Notifications))
+	assert.Equal(t, notify.InboxPinned, res.Notifications[0].Type)
+	assert.Equal(t, id, res.Notifications[0].CommentID)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/notifications/read?site=remark42&id=pinned-"+id, http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, `{"unread":0}`+"\n", string(b))
+	res = list()
+	require.Equal(t, 1, len(res.Notifications))
+	assert.True(t, res.Notifications[0].Read)
+
+	srv.Inbox = inbox
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/config?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"notifications_inbox":true`)
+}
+
+type mockTelegram struct {
+	lock     sync.Mutex
+	requests map[string]string // chat id by token, empty till the bot started
+}
+
+func (m *mockTelegram) AddToken(token, _, _ string, _ time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[token] = ""
+}
+
+func (m *mockTelegram) CheckToken(token, _ string) (chatID, siteID string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.requests[token] == "" {
+		return "", "", errors.New("request is not verified yet")
+	}
+	return m.requests[token], "remark42", nil
+}
+
+func (m *mockTelegram) GetBotUsername() string { return "remark42_bot" }
+
+func (m *mockTelegram) start(token, chatID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[token] = chatID
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
@@ -48,16 +49,16 @@ type pubStore interface {
 	ValidateComment(c *store.Comment) error
 	IsReadOnly(locator store.Locator) bool
 	Counts(siteID string, postIDs []string) ([]store.PostInfo, error)
+	LastSeen(locator store.Locator, userID string) (time.Time, bool)
 }
 
-// GET /find?site=siteID&url=post-url&format=[tree|plain]&sort=[+/-time|+/-score|+/-controversy]&view=[user|all]&since=unix_ts_msec
-// find comments for given post. Returns in tree or plain formats, sorted
+// GET /find?site=siteID&url=post-url&format=[tree|plain]&sort=[+/-time|+/-score|+/-controversy|+/-reactions|+/-hot|+/-best]&view=[user|all]&since=unix_ts_msec
+// find comments for given post. Returns in tree or plain formats, sorted.
+// Tree format can be paginated with offset&limit=threads&depth=levels&replies=per-comment, see treePage.
+// For authenticated user comments of others posted after the last visit (see PUT /read) marked as new.
 func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
-	sort := r.URL.Query().Get("sort")
-	if strings.HasPrefix(sort, " ") { // restore + replaced by " "
-		sort = "+" + sort[1:]
-	}
+	sort := s.parseSort(r)
 
 	view := r.URL.Query().Get("view")
 	since, err := s.parseSince(r)
@@ -72,13 +73,20 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[DEBUG] get comments for %+v, sort %s, format %s, since %v", locator, sort, format, since)
 
-	key := cache.NewKey(locator.SiteID).ID(URLKeyWithUser(r)).Scopes(locator.SiteID, locator.URL)
+	seenTS, seen := s.lastSeen(r, locator)
+	key := cache.NewKey(locator.SiteID).ID(URLKeyWithUser(r)+seenKey(seenTS, seen)).Scopes(locator.SiteID, locator.URL)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.FindSince(locator, sort, rest.GetUserOrEmpty(r), since)
+		user := rest.GetUserOrEmpty(r)
+		comments, e := s.dataService.FindSince(locator, sort, user, since)
 		if e != nil {
 			comments = []store.Comment{} // error should clear comments and continue for post info
 		}
 		comments = s.applyView(comments, view)
+		if seen {
+			for i := range comments {
+				comments[i].New = comments[i].User.ID != user.ID && comments[i].Timestamp.After(seenTS)
+			}
+		}
 		var b []byte
 		switch format {
 		case "tree":
@@ -89,6 +97,9 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 			if s.dataService.IsReadOnly(locator) {
 				tree.Info.ReadOnly = true
 			}
+			if page := s.treePage(r); page != (service.TreePage{}) {
+				tree.Paginate(page)
+			}
 			b, e = encodeJSONWithHTML(tree)
 		default:
 			withInfo := commentsWithInfo{Comments: comments}
@@ -110,6 +121,39 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /thread/{id}?site=siteID&url=post-url&sort=[+/-time|...]&offset=0&limit=10&depth=3&replies=5
+// returns subtree of the comment, offset and limit applied to the direct replies of the comment.
+// Used to load replies hidden in paginated tree.
+func (s *public) threadCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	sort := s.parseSort(r)
+
+	log.Printf("[DEBUG] get thread %s for %+v", id, locator)
+
+	key := cache.NewKey(locator.SiteID).ID(URLKeyWithUser(r)).Scopes(locator.SiteID, locator.URL)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		comments, e := s.dataService.FindSince(locator, sort, rest.GetUserOrEmpty(r), time.Time{})
+		if e != nil {
+			return nil, e
+		}
+		node, ok := service.MakeTree(comments, sort, s.readOnlyAge).Subtree(id, s.treePage(r))
+		if !ok {
+			return nil, errors.Errorf("no comment %s in %s", id, locator.URL)
+		}
+		return encodeJSONWithHTML(node)
+	})
+
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get thread", rest.ErrCommentNotFound)
+		return
+	}
+
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render thread %s for post %+v", id, locator)
+	}
+}
+
 // POST /preview, body is a comment, returns rendered html
 func (s *public) previewCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	comment := store.Comment{}
@@ -145,16 +189,35 @@ func (s *public) previewCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	render.HTML(w, r, comment.Text)
 }
 
-// GET /info?site=siteID&url=post-url - get info about the post
+// GET /info?site=siteID&url=post-url - get info about the post.
+// For authenticated user who visited the post before, number of comments since the last visit returned as unread
 func (s *public) infoCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 
-	key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, locator.URL)
+	seenTS, seen := s.lastSeen(r, locator)
+	keyID := URLKey(r)
+	if seen {
+		keyID = URLKeyWithUser(r) + seenKey(seenTS, seen)
+	}
+	key := cache.NewKey(locator.SiteID).ID(keyID).Scopes(locator.SiteID, locator.URL)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
 		info, e := s.dataService.Info(locator, s.readOnlyAge)
 		if e != nil {
 			return nil, e
 		}
+		if seen {
+			user := rest.GetUserOrEmpty(r)
+			comments, ee := s.dataService.FindSince(locator, "time", user, seenTS)
+			if ee != nil {
+				return nil, ee
+			}
+			for _, c := range comments {
+				// FindSince includes the comments posted at seenTS, count only ones marked as new in find
+				if c.User.ID != user.ID && !c.Deleted && c.Timestamp.After(seenTS) {
+					info.Unread++
+				}
+			}
+		}
 		return encodeJSONWithHTML(info)
 	})
 
@@ -168,8 +231,8 @@ func (s *public) infoCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GET /last/{limit}?site=siteID&since=unix_ts_msec - last comments for the siteID, across all posts, sorted by time, optionally
-// limited with "since" param
+// GET /last/{limit}?site=siteID&since=unix_ts_msec&sort=[+/-hot|+/-best|...] - last comments for the siteID, across all posts,
+// sorted by time, optionally limited with "since" param. With sort param set, selected last comments re-sorted accordingly
 func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 	log.Printf("[DEBUG] get last comments for %s", siteID)
@@ -193,6 +256,9 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 		// filter deleted from last comments view. Blocked marked as deleted and will sneak in without
 		filterDeleted := filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
+		if sort := s.parseSort(r); sort != "" {
+			filterDeleted = engine.SortComments(filterDeleted, sort)
+		}
 		return encodeJSONWithHTML(filterDeleted)
 	})
 
@@ -215,7 +281,11 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[DEBUG] get comments by id %s, %s %s", id, siteID, url)
 
-	comment, err := s.dataService.Get(store.Locator{SiteID: siteID, URL: url}, id, rest.GetUserOrEmpty(r))
+	user := rest.GetUserOrEmpty(r)
+	comment, err := s.dataService.Get(store.Locator{SiteID: siteID, URL: url}, id, user)
+	if err == nil && comment.Pending && !user.Admin && comment.User.ID != user.ID {
+		err = errors.Errorf("comment %s is waiting for approval", id) // shown only to admins and the author
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment by id", rest.ErrCommentNotFound)
 		return
@@ -227,7 +297,8 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GET /comments?site=siteID&user=id - returns comments for given userID
+// GET /comments?site=siteID&user=id&sort=[+/-hot|+/-best|...] - returns comments for given userID.
+// Comments selected by time, with sort param set they re-sorted accordingly
 func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.URL.Query().Get("user")
@@ -252,6 +323,9 @@ func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 			return nil, e
 		}
 		comments = filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
+		if sort := s.parseSort(r); sort != "" {
+			comments = engine.SortComments(comments, sort)
+		}
 		count, e := s.dataService.UserCount(siteID, userID)
 		if e != nil {
 			return nil, e
@@ -413,6 +487,46 @@ func (s *public) applyView(comments []store.Comment, view string) []store.Commen
 	return comments
 }
 
+// lastSeen returns the time of the last visit of the post by the current user,
+// false for anonymous and not authenticated users or if the post wasn't visited yet
+func (s *public) lastSeen(r *http.Request, locator store.Locator) (time.Time, bool) {
+	user, err := rest.GetUserInfo(r)
+	if err != nil || strings.HasPrefix(user.ID, "anonymous_") {
+		return time.Time{}, false
+	}
+	return s.dataService.LastSeen(locator, user.ID)
+}
+
+// seenKey makes cache key suffix for the last visit time, to invalidate user's cached responses on visit
+func seenKey(ts time.Time, seen bool) string {
+	if !seen {
+		return ""
+	}
+	return "!!seen-" + strconv.FormatInt(ts.Unix(), 10)
+}
+
+// treePage makes tree pagination from offset, limit, depth and replies query params. Invalid values treated as 0,
+// i.e. not limited
+func (s *public) treePage(r *http.Request) service.TreePage {
+	param := func(name string) int {
+		v, err := strconv.Atoi(r.URL.Query().Get(name))
+		if err != nil || v < 0 {
+			return 0
+		}
+		return v
+	}
+	return service.TreePage{Offset: param("offset"), Limit: param("limit"), Depth: param("depth"), Replies: param("replies")}
+}
+
+// parseSort returns sort query param, with "+" restored as it replaced by " " in query
+func (s *public) parseSort(r *http.Request) string {
+	sort := r.URL.Query().Get("sort")
+	if strings.HasPrefix(sort, " ") {
+		sort = "+" + sort[1:]
+	}
+	return sort
+}
+
 func (s *public) parseSince(r *http.Request) (time.Time, error) {
 	sinceTS := time.Time{}
 	if since := r.URL.Query().Get("since"); since != "" {
@@ -198,6 +198,50 @@ func TestRest_Find(t *testing.T) {
 	assert.False(t, tree.Info.ReadOnly, "post is fresh")
 }
 
+func TestRest_FindTreePaginated(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1 := addComment(t, store.Comment{Text: "top #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "top #2", Locator: locator}, ts)
+	id11 := addComment(t, store.Comment{Text: "reply #11", ParentID: id1, Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "reply #12", ParentID: id1, Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "reply #111", ParentID: id11, Locator: locator}, ts)
+
+	tree := service.Tree{}
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&sort=+time&limit=1&depth=1&replies=1")
+	require.Equal(t, 200, code, res)
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Equal(t, 1, len(tree.Nodes))
+	assert.Equal(t, id1, tree.Nodes[0].Comment.ID)
+	require.Equal(t, 1, len(tree.Nodes[0].Replies))
+	assert.Equal(t, 1, tree.Nodes[0].MoreReplies)
+	assert.Equal(t, 1, tree.Nodes[0].Replies[0].MoreReplies)
+	assert.Equal(t, &service.PageInfo{Offset: 0, Limit: 1, Total: 2}, tree.Page)
+	assert.Equal(t, 5, tree.Info.Count)
+
+	// next page cached separately
+	tree = service.Tree{}
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&sort=+time&offset=1&limit=1")
+	require.Equal(t, 200, code, res)
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Equal(t, 1, len(tree.Nodes))
+	assert.Equal(t, id2, tree.Nodes[0].Comment.ID)
+
+	node := service.Node{}
+	res, code = get(t, ts.URL+"/api/v1/thread/"+id1+"?site=remark42&url=https://radio-t.com/blah1&offset=1")
+	require.Equal(t, 200, code, res)
+	require.NoError(t, json.Unmarshal([]byte(res), &node))
+	assert.Equal(t, id1, node.Comment.ID)
+	require.Equal(t, 1, len(node.Replies))
+	assert.Equal(t, "<p>reply #12</p>\n", node.Replies[0].Comment.Text)
+	assert.Equal(t, 2, node.MoreReplies)
+
+	_, code = get(t, ts.URL+"/api/v1/thread/bad-id?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
 func TestRest_FindAge(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -349,6 +393,16 @@ func TestRest_Last(t *testing.T) {
 	assert.Equal(t, id1, comments[1].ID)
 	assert.Equal(t, id2, comments[0].ID)
 
+	_, err = srv.DataService.Vote(service.VoteReq{Locator: c1.Locator, CommentID: id1, UserID: "u2", Val: true})
+	require.NoError(t, err)
+	res, code = get(t, ts.URL+"/api/v1/last/2?site=remark42&sort=-best")
+	assert.Equal(t, 200, code)
+	comments = []store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 2, len(comments), "should have 2 comments")
+	assert.Equal(t, id1, comments[0].ID, "upvoted comment is the best")
+	assert.Equal(t, id2, comments[1].ID)
+
 	res, code = get(t, fmt.Sprintf("%s/api/v1/last/2?site=remark42&since=%d", ts.URL, ts1))
 	assert.Equal(t, 200, code)
 	comments = []store.Comment{}
@@ -591,6 +645,7 @@ func TestRest_Config(t *testing.T) {
 	assert.Equal(t, 10000.0, j["max_image_size"])
 	assert.Equal(t, true, j["emoji_enabled"].(bool))
 	assert.Equal(t, false, j["admin_edit"].(bool))
+	assert.Equal(t, []interface{}{}, j["reactions"])
 }
 
 func TestRest_Info(t *testing.T) {
@@ -43,6 +43,8 @@ func (s *rss) postCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			return nil, e
 		}
+		// feed cached for all users, author's own pending comments can't be shown
+		comments = filterComments(comments, func(c store.Comment) bool { return !c.Pending })
 		feed, e := s.toRssFeed(locator.URL, comments, "post comments for "+r.URL.Query().Get("url"))
 		if e != nil {
 			return nil, e
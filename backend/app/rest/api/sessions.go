@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// sessionsCache keeps revocation time of users' sessions checked by authenticator on each request
+type sessionsCache interface {
+	ResetSessions(siteID, userID string) // drops cached revocation time of the user on all instances
+}
+
+// sessionsRevoker invalidates all sessions of the user issued before the given time
+type sessionsRevoker interface {
+	RevokeSessions(siteID, userID string, ts time.Time) error
+}
+
+// sessionInfo is a session of the user marked as current for the one the request made with
+type sessionInfo struct {
+	store.Session
+	Current bool `json:"current"`
+}
+
+// revokeSessions revokes sessions of the user and resets cached revocation time, so the change applied
+// on the next request instead of the cache expiration
+func revokeSessions(ds sessionsRevoker, sc sessionsCache, siteID, userID string, ts time.Time) error {
+	if err := ds.RevokeSessions(siteID, userID, ts); err != nil {
+		return err
+	}
+	if sc != nil {
+		sc.ResetSessions(siteID, userID)
+	}
+	return nil
+}
+
+// GET /sessions?site=siteID - lists active sessions of the user, the one the request made with marked as current
+func (s *private) sessionsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	sessions, err := s.dataService.Sessions(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get sessions", rest.ErrInternal)
+		return
+	}
+	var currentID string
+	if claims, _, e := s.authenticator.TokenService().Get(r); e == nil {
+		currentID = claims.Id
+	}
+
+	res := make([]sessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		res = append(res, sessionInfo{Session: sess, Current: currentID != "" && sess.ID == currentID})
+	}
+	render.JSON(w, r, res)
+}
+
+// DELETE /sessions?site=siteID - logs out all other sessions of the user, the current one re-issued to stay valid
+func (s *private) logoutOtherSessionsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	claims, _, err := s.authenticator.TokenService().Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to get token", rest.ErrInternal)
+		return
+	}
+
+	now := time.Now()
+	current := store.Session{ID: claims.Id, Created: now, Refreshed: now, Expires: now.Add(s.authenticator.TokenService().CookieDuration)}
+	if sessions, e := s.dataService.Sessions(siteID, user.ID); e == nil {
+		for _, sess := range sessions {
+			if sess.ID == claims.Id {
+				current.Created = sess.Created
+			}
+		}
+	}
+
+	if err = revokeSessions(s.dataService, s.sessions, siteID, user.ID, now); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't revoke sessions", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] other sessions of %s on %s revoked", user.ID, siteID)
+
+	// current session registered again, so the token re-issued in the same second as the revocation stays valid
+	if claims.Id != "" {
+		if err = s.dataService.RegisterSession(siteID, user.ID, current); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't register current session", rest.ErrInternal)
+			return
+		}
+	}
+	claims.ExpiresAt = 0 // token issued after the revocation
+	if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"user_id": user.ID, "site_id": siteID, "revoked": true})
+}
+
+// DELETE /sessions/{userid}?site=siteID - revokes all sessions of the user
+func (a *admin) revokeSessionsCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+
+	if err := revokeSessions(a.dataService, a.sessions, siteID, userID, time.Now()); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't revoke sessions", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] sessions of %s on %s revoked by %s", userID, siteID, rest.MustGetUserInfo(r).ID)
+	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "revoked": true})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRest_Sessions(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	sc := &mockSessionsCache{}
+	srv.Sessions = sc
+	ts.Config.Handler = srv.routes()
+
+	now := time.Now().Truncate(time.Second)
+	for _, id := range []string{"random id", "other id"} { // devToken made with "random id" jti
+		require.NoError(t, srv.DataService.RegisterSession("remark42", "dev",
+			store.Session{ID: id, Created: now, Refreshed: now, Expires: now.Add(time.Hour)}))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/sessions?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	sessions := []sessionInfo{}
+	require.NoError(t, json.Unmarshal(body, &sessions))
+	require.Equal(t, 2, len(sessions))
+	assert.Equal(t, "random id", sessions[0].ID)
+	assert.True(t, sessions[0].Current)
+	assert.Equal(t, "other id", sessions[1].ID)
+	assert.False(t, sessions[1].Current)
+
+	// log out other sessions
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/sessions?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Set-Cookie"), "current token re-issued")
+	assert.False(t, srv.DataService.SessionsRevoked("remark42", "dev").IsZero())
+	assert.Equal(t, []string{"remark42:dev"}, sc.reset)
+	sess, err := srv.DataService.Sessions("remark42", "dev")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(sess), "only the current session left")
+	assert.Equal(t, "random id", sess[0].ID)
+	assert.True(t, now.Equal(sess[0].Created), "creation time of the current session kept")
+	assert.True(t, srv.DataService.IsSessionActive("remark42", "dev", "random id"))
+	assert.False(t, srv.DataService.IsSessionActive("remark42", "dev", "other id"))
+
+	// admin revokes all sessions of the user
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/sessions/user1?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, srv.DataService.SessionsRevoked("remark42", "user1").IsZero())
+	assert.Equal(t, []string{"remark42:dev", "remark42:user1"}, sc.reset)
+
+	// sessions of blocked user revoked
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/user/user2?site=remark42&block=1&ttl=1h", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, srv.DataService.SessionsRevoked("remark42", "user2").IsZero())
+	assert.Equal(t, []string{"remark42:dev", "remark42:user1", "remark42:user2"}, sc.reset)
+
+	// anonymous users have no sessions to manage
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req, err = http.NewRequest(method, ts.URL+"/api/v1/sessions?site=remark42", http.NoBody)
+		require.NoError(t, err)
+		resp, err = sendReq(t, req, anonToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, method)
+	}
+	assert.Equal(t, 3, len(sc.reset), "no sessions reset for anonymous")
+}
+
+type mockSessionsCache struct {
+	reset []string
+}
+
+func (m *mockSessionsCache) ResetSessions(siteID, userID string) {
+	m.reset = append(m.reset, siteID+":"+userID)
+}
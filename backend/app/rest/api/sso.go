@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/sha1" // nolint
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// SSOAdminAttr is the user attribute set for users logged in with the admin flag in the site's token
+const SSOAdminAttr = "sso_admin"
+
+// SSO lets sites with own user accounts log their users in without OAuth. The site issues short-lived
+// token signed with the site's secret, and SSO exchanges it for remark42 session of the user namespaced
+// by the site. The site logs user out of remark42 with the same kind of token, which revokes
+// all sessions of the user started before. Each token accepted once, token ids kept until the token expires.
+type SSO struct {
+	keys interface {
+		Key(siteID string) (key string, err error)
+	}
+	maxAge time.Duration // max lifetime of site's token
+
+	lock sync.Mutex
+	used map[string]time.Time // expiration of used tokens by site and token id
+}
+
+// ssoClaims are the claims of the token issued by the site. Subject is the user id on the site,
+// audience is the site id, expiration is required.
+type ssoClaims struct {
+	jwt.StandardClaims
+	Name    string `json:"name"`
+	Picture string `json:"picture,omitempty"`
+	Admin   bool   `json:"admin,omitempty"`
+}
+
+// NewSSO makes SSO with the site secrets from keys, tokens with lifetime longer than maxAge rejected
+func NewSSO(keys interface {
+	Key(siteID string) (key string, err error)
+}, maxAge time.Duration) *SSO {
+	return &SSO{keys: keys, maxAge: maxAge, used: map[string]time.Time{}}
+}
+
+// user checks the site's token and returns the user from it
+func (s *SSO) user(siteID, tkn string) (token.User, error) {
+	claims, err := s.parse(siteID, tkn)
+	if err != nil {
+		return token.User{}, err
+	}
+	if claims.Name == "" {
+		return token.User{}, errors.New("no user name in token")
+	}
+	u := token.User{ID: ssoUserID(siteID, claims.Subject), Name: claims.Name, Picture: claims.Picture, Audience: siteID}
+	if claims.Admin {
+		u.SetBoolAttr(SSOAdminAttr, true)
+	}
+	return u, nil
+}
+
+// logoutUser checks the site's token and returns id of the user to log out
+func (s *SSO) logoutUser(siteID, tkn string) (string, error) {
+	claims, err := s.parse(siteID, tkn)
+	if err != nil {
+		return "", err
+	}
+	return ssoUserID(siteID, claims.Subject), nil
+}
+
+// parse checks signature of the site's token with the site's secret, the site in audience, short lifetime
+// and the token not used before
+func (s *SSO) parse(siteID, tkn string) (ssoClaims, error) {
+	if siteID == "" || tkn == "" {
+		return ssoClaims{}, errors.New("site and token required")
+	}
+	claims := ssoClaims{}
+	_, err := jwt.ParseWithClaims(tkn, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		key, err := s.keys.Key(siteID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get secret of %s", siteID)
+		}
+		return []byte(key), nil
+	})
+	if err != nil {
+		return ssoClaims{}, errors.Wrap(err, "can't parse token")
+	}
+	if !claims.VerifyAudience(siteID, true) {
+		return ssoClaims{}, errors.Errorf("token issued for another site %q", claims.Audience)
+	}
+	if claims.ExpiresAt == 0 || time.Until(time.Unix(claims.ExpiresAt, 0)) > s.maxAge {
+		return ssoClaims{}, errors.Errorf("token should expire in %v", s.maxAge)
+	}
+	if claims.Subject == "" {
+		return ssoClaims{}, errors.New("no user id in token")
+	}
+	if claims.Id == "" {
+		return ssoClaims{}, errors.New("no token id in token")
+	}
+	if err = s.use(siteID+"::"+claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return ssoClaims{}, err
+	}
+	return claims, nil
+}
+
+// use marks the token as used till its expiration, error if it's already used
+func (s *SSO) use(id string, exp time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for k, ts := range s.used { // drop expired tokens, can't be accepted anyway
+		if now.After(ts) {
+			delete(s.used, k)
+		}
+	}
+	if _, ok := s.used[id]; ok {
+		return errors.New("token already used")
+	}
+	s.used[id] = exp
+	return nil
+}
+
+// ssoUserID makes remark42 user id of the site's user, the same user id on different sites makes different users
+func ssoUserID(siteID, userID string) string {
+	return "sso_" + token.HashID(sha1.New(), siteID+"::"+userID) // nolint
+}
+
+// POST /sso/login?site=siteID&session=1 - exchanges token issued by the site, passed as token form value,
+// for remark42 session, session=1 makes session cookie. Returns user info.
+func (s *Rest) ssoLoginCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	u, err := s.SSO.user(siteID, r.PostFormValue("token"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, err, "invalid sso token", rest.ErrNoAccess)
+		return
+	}
+
+	if avatarProxy := s.Authenticator.AvatarProxy(); avatarProxy != nil { // picture saved the same way as by providers
+		avatarURL, e := avatarProxy.Put(u, &http.Client{Timeout: 5 * time.Second})
+		if e != nil {
+			log.Printf("[WARN] can't save avatar of sso user %s, %v", u.ID, e)
+		}
+		u.Picture = avatarURL
+	}
+
+	claims := token.Claims{
+		User:           &u,
+		StandardClaims: jwt.StandardClaims{Id: uuid.New().String(), Audience: siteID},
+		SessionOnly:    r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
+	}
+	if claims, err = s.Authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set token", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] sso login of %s (%s) to %s", claims.User.ID, claims.User.Name, siteID)
+	render.JSON(w, r, claims.User)
+}
+
+// POST /sso/logout?site=siteID - logs the site's user out from all remark42 sessions, the site's token
+// passed as token form value
+func (s *Rest) ssoLogoutCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	userID, err := s.SSO.logoutUser(siteID, r.PostFormValue("token"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, err, "invalid sso token", rest.ErrNoAccess)
+		return
+	}
+	if err = revokeSessions(s.DataService, s.Sessions, siteID, userID, time.Now()); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't revoke sessions", rest.ErrInternal)
+		return
+	}
+	if claims, _, e := s.Authenticator.TokenService().Get(r); e == nil && claims.User != nil && claims.User.ID == userID {
+		s.Authenticator.TokenService().Reset(w) // logout called by the user's browser
+	}
+	log.Printf("[INFO] sso logout of %s from %s", userID, siteID)
+	render.JSON(w, r, R.JSON{"id": userID, "logout": true})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRest_SSOLogin(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.SSO = NewSSO(srv.DataService.AdminStore, 5*time.Minute)
+	ts.Config.Handler = srv.routes()
+
+	siteToken := func(claims ssoClaims, secret string) string {
+		tkn, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return tkn
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+	valid := ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t1", Subject: "u1", Audience: "remark42", ExpiresAt: exp},
+		Name: "Site User", Admin: true}
+
+	resp, err := http.Get(ts.URL + "/api/v1/sso/login?site=remark42&token=" + siteToken(valid, "123456"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "token not accepted in url")
+
+	resp, err = http.PostForm(ts.URL+"/api/v1/sso/login?site=remark42", url.Values{"token": {siteToken(valid, "123456")}})
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	u := token.User{}
+	require.NoError(t, json.Unmarshal(body, &u))
+	assert.Equal(t, ssoUserID("remark42", "u1"), u.ID)
+	assert.Equal(t, "Site User", u.Name)
+	assert.True(t, u.BoolAttr(SSOAdminAttr))
+	assert.True(t, strings.HasPrefix(u.Picture, "/avatar/"), u.Picture) // identicon made by avatar proxy
+	var jwtCookie string
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c.Value
+		}
+	}
+	assert.NotEmpty(t, jwtCookie)
+	assert.NotEqual(t, ssoUserID("remark42", "u1"), ssoUserID("other", "u1"), "users namespaced by site")
+
+	bad := []struct {
+		name, token string
+	}{
+		{"wrong secret", siteToken(valid, "bad")},
+		{"replayed", siteToken(valid, "123456")},
+		{"another site", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t2", Subject: "u1", Audience: "other",
+			ExpiresAt: exp}, Name: "n"}, "123456")},
+		{"long-lived", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t3", Subject: "u1", Audience: "remark42",
+			ExpiresAt: time.Now().Add(time.Hour).Unix()}, Name: "n"}, "123456")},
+		{"no expiration", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t4", Subject: "u1", Audience: "remark42"},
+			Name: "n"}, "123456")},
+		{"expired", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t5", Subject: "u1", Audience: "remark42",
+			ExpiresAt: time.Now().Add(-time.Minute).Unix()}, Name: "n"}, "123456")},
+		{"no user id", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t6", Audience: "remark42", ExpiresAt: exp},
+			Name: "n"}, "123456")},
+		{"no token id", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Subject: "u1", Audience: "remark42",
+			ExpiresAt: exp}, Name: "n"}, "123456")},
+		{"no name", siteToken(ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t7", Subject: "u1", Audience: "remark42",
+			ExpiresAt: exp}}, "123456")},
+		{"empty", ""},
+	}
+	for _, tt := range bad {
+		time.Sleep(100 * time.Millisecond) // sso routes limited to 10 requests per second
+		resp, err = http.PostForm(ts.URL+"/api/v1/sso/login?site=remark42", url.Values{"token": {tt.token}})
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, tt.name)
+	}
+
+	config, code := get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, config, `"sso":true`)
+}
+
+func TestRest_SSOLogout(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.SSO = NewSSO(srv.DataService.AdminStore, 5*time.Minute)
+	ts.Config.Handler = srv.routes()
+
+	userID := ssoUserID("remark42", "u1")
+	assert.True(t, srv.DataService.SessionsRevoked("remark42", userID).IsZero())
+
+	tkn, err := jwt.NewWithClaims(jwt.SigningMethodHS256, ssoClaims{StandardClaims: jwt.StandardClaims{Id: "t1", Subject: "u1",
+		Audience: "remark42", ExpiresAt: time.Now().Add(time.Minute).Unix()}}).SignedString([]byte("123456"))
+	require.NoError(t, err)
+	resp, err := http.PostForm(ts.URL+"/api/v1/sso/logout?site=remark42", url.Values{"token": {tkn}})
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, `{"id":"`+userID+`","logout":true}`+"\n", string(body))
+
+	revoked := srv.DataService.SessionsRevoked("remark42", userID)
+	assert.WithinDuration(t, time.Now(), revoked, 2*time.Second, "sessions started before logout revoked")
+	assert.True(t, srv.DataService.SessionsRevoked("remark42", ssoUserID("remark42", "u2")).IsZero(), "other user")
+
+	resp, err = http.PostForm(ts.URL+"/api/v1/sso/logout?site=remark42", url.Values{"token": {tkn}})
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "replayed")
+
+	resp, err = http.PostForm(ts.URL+"/api/v1/sso/logout?site=remark42", url.Values{"token": {"bad"}})
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
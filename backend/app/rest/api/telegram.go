@@ -0,0 +1,98 @@
+package api
+
+import (
+	"crypto/sha1" // nolint
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+// telegramAuditor returns audit trail of telegram moderation buttons
+type telegramAuditor interface {
+	AuditLog(siteID string) ([]notify.TelegramAuditRecord, error)
+}
+
+// TelegramModerator performs moderation actions pressed by admins in telegram notifications, with the same
+// operations as admin API. Telegram user allowed to moderate if the user is the site admin logged in with telegram
+// or mapped to the site admin by Users.
+type TelegramModerator struct {
+	adm    admin
+	admins interface {
+		Admins(siteID string) (ids []string, err error)
+	}
+	users map[int64]string // site admin's user id by telegram id
+}
+
+// NewTelegramModerator makes moderator for buttons of telegram admin notifications
+func NewTelegramModerator(dataService *service.DataStore, cache LoadingCache, notifyService *notify.Service,
+	users map[int64]string) *TelegramModerator {
+	return &TelegramModerator{
+		adm:    admin{dataService: dataService, cache: cache, notifyService: notifyService},
+		admins: dataService.AdminStore,
+		users:  users,
+	}
+}
+
+// Moderate checks the telegram user is the site admin and performs the action
+func (m *TelegramModerator) Moderate(act notify.TelegramAction) error {
+	adminID, err := m.adminID(act.Locator.SiteID, act.AdminID)
+	if err != nil {
+		log.Printf("[WARN] telegram user %d rejected to %s comment %s, %v", act.AdminID, act.Type, act.CommentID, err)
+		return err
+	}
+	log.Printf("[INFO] admin %s (telegram %d) requested %s of comment %s by %s, site %s",
+		adminID, act.AdminID, act.Type, act.CommentID, act.UserID, act.Locator.SiteID)
+
+	switch act.Type {
+	case notify.TelegramActionDelete:
+		err = m.adm.deleteComment(act.Locator, act.CommentID)
+	case notify.TelegramActionPin:
+		err = m.adm.setPin(act.Locator, act.CommentID, true)
+	case notify.TelegramActionBlockDay:
+		err = m.adm.setBlock(act.Locator.SiteID, act.UserID, true, 24*time.Hour)
+	case notify.TelegramActionBlock:
+		err = m.adm.setBlock(act.Locator.SiteID, act.UserID, true, 0)
+	default:
+		return errors.Errorf("unknown action %s", act.Type)
+	}
+	return errors.Wrapf(err, "can't %s comment %s", act.Type, act.CommentID)
+}
+
+// adminID returns id of the site admin for telegram user, error if telegram user is not an admin of the site
+func (m *TelegramModerator) adminID(siteID string, telegramID int64) (string, error) {
+	admins, err := m.admins.Admins(siteID)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get admins of %s", siteID)
+	}
+	candidates := []string{"telegram_" + token.HashID(sha1.New(), strconv.FormatInt(telegramID, 10))} // nolint
+	if id, ok := m.users[telegramID]; ok {
+		candidates = append(candidates, id)
+	}
+	for _, a := range admins {
+		for _, c := range candidates {
+			if a == c {
+				return a, nil
+			}
+		}
+	}
+	return "", errors.Errorf("telegram user %d is not an admin of %s", telegramID, siteID)
+}
+
+// GET /telegram/audit?site=siteID - audit trail of telegram moderation buttons, from the latest record
+func (a *admin) telegramAuditCtrl(w http.ResponseWriter, r *http.Request) {
+	recs, err := a.telegramAudit.AuditLog(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get telegram audit", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, recs)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"crypto/sha1" // nolint
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestTelegramModerator_Moderate(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+
+	tgAdminID := "telegram_" + token.HashID(sha1.New(), "333") // nolint
+	srv.DataService.AdminStore = adminstore.NewStaticStore("123456", []string{"remark42"}, []string{"a1", tgAdminID}, "")
+	m := NewTelegramModerator(srv.DataService, srv.Cache, notify.NopService, map[int64]string{111: "a1", 222: "a3"})
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: locator, User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "test test #2", Locator: locator, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+
+	act := notify.TelegramAction{Type: notify.TelegramActionPin, Locator: locator, CommentID: id1, UserID: "user1", AdminID: 111}
+	require.NoError(t, m.Moderate(act), "mapped admin")
+	c, err := srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Pin)
+
+	act = notify.TelegramAction{Type: notify.TelegramActionDelete, Locator: locator, CommentID: id2, UserID: "user2", AdminID: 222}
+	assert.EqualError(t, m.Moderate(act), "telegram user 222 is not an admin of remark42", "mapped to non-admin")
+	act.AdminID = 444
+	assert.Error(t, m.Moderate(act), "unknown telegram user")
+
+	act.AdminID = 333
+	require.NoError(t, m.Moderate(act), "admin logged in with telegram")
+	c, err = srv.DataService.Get(locator, id2, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+
+	act = notify.TelegramAction{Type: notify.TelegramActionBlockDay, Locator: locator, CommentID: id1, UserID: "user1", AdminID: 111}
+	require.NoError(t, m.Moderate(act))
+	assert.True(t, srv.DataService.IsBlocked("remark42", "user1"))
+	blocked, err := srv.DataService.BlockedUsers("remark42")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(blocked))
+	c, err = srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted, "comments kept for temporary block")
+
+	act = notify.TelegramAction{Type: notify.TelegramActionBlock, Locator: locator, CommentID: id1, UserID: "user1", AdminID: 111}
+	require.NoError(t, m.Moderate(act))
+	c, err = srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted, "comments deleted for permanent block")
+
+	act.Type = "bad"
+	assert.EqualError(t, m.Moderate(act), "unknown action bad")
+}
+
+func TestRest_TelegramAudit(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	auditFile, err := randomPath(os.TempDir(), "test-telegram", ".db")
+	require.NoError(t, err)
+	defer os.Remove(auditFile)
+	tgStore, err := notify.NewBoltTelegram(auditFile, bolt.Options{})
+	require.NoError(t, err)
+	defer tgStore.Close()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	require.NoError(t, tgStore.Audit(notify.TelegramAuditRecord{Time: time.Now(), Action: notify.TelegramActionPin,
+		Locator: locator, CommentID: "c1", UserID: "user1", AdminID: 111, Result: "ok"}))
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/telegram/audit?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "not enabled")
+
+	srv.TelegramAudit = tgStore
+	ts.Config.Handler = srv.routes()
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/admin/telegram/audit?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/telegram/audit?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	recs := []notify.TelegramAuditRecord{}
+	require.NoError(t, json.Unmarshal([]byte(body), &recs))
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, "c1", recs[0].CommentID)
+	assert.Equal(t, int64(111), recs[0].AdminID)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/telegram/audit?site=other")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body)
+}
@@ -40,6 +40,8 @@ const (
 	ErrAssetNotFound        = 18 // requested file not found
 	ErrCommentRestrictWords = 19 // restricted words in a comment
 	ErrImgNotFound          = 20 // posted image not found in the storage
+	ErrCommentTrustLevel    = 21 // comment content not allowed for user's trust level
+	ErrReactionRejected     = 22 // general error on reaction rejected
 )
 
 // errTmplData store data for error message
@@ -0,0 +1,83 @@
+package mastodon
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltApps implements AppStore with bolt DB, apps kept in a single bucket keyed by instance.
+// New apps rejected when MaxApps reached, as logins with arbitrary instances add them.
+type BoltApps struct {
+	MaxApps  int // max number of stored apps, unlimited if not positive
+	fileName string
+	db       *bolt.DB
+}
+
+const (
+	appsBucketName = "apps"
+	defaultMaxApps = 10000
+)
+
+// NewBoltApps makes bolt store for apps registered on instances
+func NewBoltApps(fileName string, options bolt.Options) (*BoltApps, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(appsBucketName))
+		return errors.Wrapf(e, "failed to create bucket %s", appsBucketName)
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltApps{db: db, fileName: fileName, MaxApps: defaultMaxApps}, nil
+}
+
+// App returns the app registered on the instance, found is false if there is no one
+func (b *BoltApps) App(instance string) (a App, found bool, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(appsBucketName)).Get([]byte(instance))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return errors.Wrapf(json.Unmarshal(data, &a), "can't unmarshal app of %s", instance)
+	})
+	return a, found, err
+}
+
+// AddApp adds the app unless the instance has one already, returns the stored app
+func (b *BoltApps) AddApp(instance string, a App) (res App, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(appsBucketName))
+		if data := bkt.Get([]byte(instance)); data != nil {
+			return errors.Wrapf(json.Unmarshal(data, &res), "can't unmarshal app of %s", instance)
+		}
+		if b.MaxApps > 0 {
+			count := 0
+			c := bkt.Cursor()
+			for k, _ := c.First(); k != nil && count < b.MaxApps; k, _ = c.Next() {
+				count++
+			}
+			if count >= b.MaxApps {
+				return errors.Errorf("can't add app of %s, limit of %d apps reached", instance, b.MaxApps)
+			}
+		}
+		data, e := json.Marshal(a)
+		if e != nil {
+			return errors.Wrapf(e, "can't marshal app of %s", instance)
+		}
+		res = a
+		return errors.Wrapf(bkt.Put([]byte(instance), data), "can't put app of %s", instance)
+	})
+	return res, err
+}
+
+// Close bolt store
+func (b *BoltApps) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
@@ -0,0 +1,37 @@
+package mastodon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltApps(t *testing.T) {
+	st := prepApps(t)
+	st.MaxApps = 2
+
+	_, found, err := st.App("mastodon.social")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	a, err := st.AddApp("mastodon.social", App{ClientID: "cid1", ClientSecret: "sec1"})
+	require.NoError(t, err)
+	assert.Equal(t, App{ClientID: "cid1", ClientSecret: "sec1"}, a)
+	a, err = st.AddApp("mastodon.social", App{ClientID: "cid2", ClientSecret: "sec2"})
+	require.NoError(t, err)
+	assert.Equal(t, App{ClientID: "cid1", ClientSecret: "sec1"}, a, "the first app kept")
+
+	a, found, err = st.App("mastodon.social")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "cid1", a.ClientID)
+
+	_, err = st.AddApp("fosstodon.org", App{ClientID: "cid3", ClientSecret: "sec3"})
+	require.NoError(t, err)
+	_, err = st.AddApp("other.example", App{ClientID: "cid4", ClientSecret: "sec4"})
+	assert.EqualError(t, err, "can't add app of other.example, limit of 2 apps reached")
+	a, err = st.AddApp("fosstodon.org", App{ClientID: "cid5", ClientSecret: "sec5"})
+	require.NoError(t, err, "existing app returned over the limit")
+	assert.Equal(t, "cid3", a.ClientID)
+}
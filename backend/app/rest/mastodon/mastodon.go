@@ -0,0 +1,398 @@
+// Package mastodon implements go-pkgz/auth provider logging users in with accounts of Mastodon and compatible
+// Fediverse instances. The instance is set by the user on login, and the provider registers OAuth app on it
+// the first time, with app credentials kept in the store and cached per instance. Instances resolved
+// to loopback, private or link-local addresses are rejected, so login can't make requests to internal services.
+package mastodon
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // used for user id hashing the same way as other providers, not for security
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// Name is the name of the provider, used in auth routes and as the prefix of user ids
+const Name = "mastodon"
+
+const (
+	scope         = "read:accounts"
+	maxCachedApps = 1000
+)
+
+// Params of the provider
+type Params struct {
+	URL         string                // root url of remark42, like https://remark42.example.com
+	Issuer      string                // iss claim of tokens
+	JwtService  provider.TokenService // auth tokens service of go-pkgz/auth
+	AvatarSaver provider.AvatarSaver  // saves users' pictures, optional
+	AppName     string                // name of the app registered on instances
+	Instances   []string              // instances allowed for login, any if empty
+	Store       AppStore              // keeps apps registered on instances, apps kept in memory only if not set
+}
+
+// AppStore keeps OAuth apps registered on instances
+type AppStore interface {
+	App(instance string) (a App, found bool, err error)
+	AddApp(instance string, a App) (App, error) // adds app unless the instance has one, returns the stored app
+}
+
+// Provider logs users in with accounts of Mastodon instances
+type Provider struct {
+	Params
+	client    *http.Client                                 // refuses connections to non-public addresses
+	baseURL   func(instance string) string                 // url of the instance's api, https://<instance> by default
+	checkHost func(ctx context.Context, host string) error // rejects instances resolved to non-public addresses
+	apps      lcw.LoadingCache                             // registered apps by instance, limited to maxCachedApps
+}
+
+// App is the OAuth app registered on the instance
+type App struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// account is the part of Mastodon account used for the user
+type account struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+}
+
+var reInstance = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z][a-z0-9-]{0,62}$`)
+
+// nonPublicNets are address ranges of loopback, private, shared and link-local networks
+var nonPublicNets = func() (res []*net.IPNet) {
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		res = append(res, n)
+	}
+	return res
+}()
+
+// New makes the provider
+func New(params Params) *Provider {
+	if params.AppName == "" {
+		params.AppName = "remark42"
+	}
+	apps, _ := lcw.NewLruCache(lcw.MaxKeys(maxCachedApps))
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) { // checked on connect, as the name may resolve differently
+			return errors.Errorf("connection to non-public address %s refused", host)
+		}
+		return nil
+	}}
+	return &Provider{
+		Params:    params,
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{DialContext: dialer.DialContext}},
+		baseURL:   func(instance string) string { return "https://" + instance },
+		checkHost: publicHost,
+		apps:      apps,
+	}
+}
+
+// Name returns the name of the provider
+func (p *Provider) Name() string { return Name }
+
+// LoginHandler registers the app on the user's instance if needed and redirects to the instance's authorization
+// GET /login?instance=mastodon.social&site=site&from=url&session=1
+func (p *Provider) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	instance, err := p.instance(r.URL.Query().Get("instance"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid instance", rest.ErrActionRejected)
+		return
+	}
+	if err = p.checkHost(r.Context(), instance); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid instance", rest.ErrActionRejected)
+		return
+	}
+	conf, err := p.config(r.Context(), instance)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadGateway, err, "can't register app on the instance", rest.ErrInternal)
+		return
+	}
+
+	state, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't make oauth2 state", rest.ErrInternal)
+		return
+	}
+	cid, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't make claim's id", rest.ErrInternal)
+		return
+	}
+	claims := token.Claims{
+		Handshake:   &token.Handshake{State: state, From: r.URL.Query().Get("from"), ID: instance},
+		SessionOnly: r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
+		StandardClaims: jwt.StandardClaims{
+			Id:        cid,
+			Audience:  r.URL.Query().Get("site"),
+			ExpiresAt: time.Now().Add(30 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+		},
+	}
+	if _, err = p.JwtService.Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set token", rest.ErrInternal)
+		return
+	}
+	http.Redirect(w, r, conf.AuthCodeURL(state), http.StatusFound)
+}
+
+// AuthHandler exchanges the code for the instance's token, gets user's account and sets auth token.
+// Redirects to "from" url of login if set. GET /callback
+func (p *Provider) AuthHandler(w http.ResponseWriter, r *http.Request) {
+	oauthClaims, _, err := p.JwtService.Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "can't get token", rest.ErrNoAccess)
+		return
+	}
+	if oauthClaims.Handshake == nil || oauthClaims.Handshake.State == "" ||
+		oauthClaims.Handshake.State != r.URL.Query().Get("state") {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("unexpected state"), "invalid handshake", rest.ErrNoAccess)
+		return
+	}
+	instance := oauthClaims.Handshake.ID
+	conf, err := p.config(r.Context(), instance)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadGateway, err, "can't get app of the instance", rest.ErrInternal)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, p.client)
+	tok, err := conf.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadGateway, err, "exchange failed", rest.ErrInternal)
+		return
+	}
+	acc, err := p.account(ctx, conf.Client(ctx, tok), instance)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadGateway, err, "can't get account", rest.ErrInternal)
+		return
+	}
+
+	u := p.user(acc, instance)
+	if p.AvatarSaver != nil {
+		avatarURL, e := p.AvatarSaver.Put(u, p.client)
+		if e != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, e, "can't save avatar", rest.ErrInternal)
+			return
+		}
+		u.Picture = avatarURL
+	}
+
+	cid, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't make claim's id", rest.ErrInternal)
+		return
+	}
+	claims := token.Claims{
+		User:           &u,
+		StandardClaims: jwt.StandardClaims{Issuer: p.Issuer, Id: cid, Audience: oauthClaims.Audience},
+		SessionOnly:    oauthClaims.SessionOnly,
+	}
+	if _, err = p.JwtService.Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set token", rest.ErrInternal)
+		return
+	}
+	log.Printf("[DEBUG] mastodon login of %s from %s", u.ID, instance)
+
+	if oauthClaims.Handshake.From != "" {
+		http.Redirect(w, r, oauthClaims.Handshake.From, http.StatusTemporaryRedirect)
+		return
+	}
+	render.JSON(w, r, &u)
+}
+
+// LogoutHandler removes auth token. GET /logout
+func (p *Provider) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := p.JwtService.Get(r); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "logout not allowed", rest.ErrNoAccess)
+		return
+	}
+	p.JwtService.Reset(w)
+}
+
+// instance normalizes the instance set by user, like https://mastodon.social/ or user@mastodon.social,
+// and checks it's the domain name allowed for login
+func (p *Provider) instance(value string) (string, error) {
+	res := strings.ToLower(strings.TrimSpace(value))
+	res = strings.TrimPrefix(res, "https://")
+	res = strings.TrimSuffix(res, "/")
+	if i := strings.LastIndex(res, "@"); i >= 0 {
+		res = res[i+1:]
+	}
+	if !reInstance.MatchString(res) {
+		return "", errors.Errorf("%q is not a domain name", value)
+	}
+	if len(p.Instances) == 0 {
+		return res, nil
+	}
+	for _, allowed := range p.Instances {
+		if strings.EqualFold(allowed, res) {
+			return res, nil
+		}
+	}
+	return "", errors.Errorf("instance %s not allowed", res)
+}
+
+// config returns oauth2 config for the instance, registers the app on the instance if not registered yet
+func (p *Provider) config(ctx context.Context, instance string) (oauth2.Config, error) {
+	v, err := p.apps.Get(instance, func() (interface{}, error) { return p.app(ctx, instance) })
+	if err != nil {
+		return oauth2.Config{}, err
+	}
+	a := v.(App)
+	base := p.baseURL(instance)
+	return oauth2.Config{
+		ClientID:     a.ClientID,
+		ClientSecret: a.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: base + "/oauth/authorize", TokenURL: base + "/oauth/token"},
+		RedirectURL:  p.redirectURL(),
+		Scopes:       []string{scope},
+	}, nil
+}
+
+// app returns the app of the instance from the store, registers the app and adds it to the store if not found
+func (p *Provider) app(ctx context.Context, instance string) (App, error) {
+	if p.Store != nil {
+		a, found, err := p.Store.App(instance)
+		if err != nil {
+			return App{}, errors.Wrapf(err, "can't get app of %s", instance)
+		}
+		if found {
+			return a, nil
+		}
+	}
+	a, err := p.register(ctx, instance)
+	if err != nil || p.Store == nil {
+		return a, err
+	}
+	return p.Store.AddApp(instance, a) // app registered concurrently kept, if any
+}
+
+// register makes the OAuth app on the instance
+func (p *Provider) register(ctx context.Context, instance string) (App, error) {
+	form := url.Values{"client_name": {p.AppName}, "redirect_uris": {p.redirectURL()}, "scopes": {scope},
+		"website": {p.URL}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL(instance)+"/api/v1/apps",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return App{}, errors.Wrapf(err, "can't make app request for %s", instance)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := App{}
+	if err = doWith(p.client, req, &res); err != nil {
+		return App{}, errors.Wrapf(err, "can't register app on %s", instance)
+	}
+	if res.ClientID == "" || res.ClientSecret == "" {
+		return App{}, errors.Errorf("no credentials of app registered on %s", instance)
+	}
+	log.Printf("[INFO] registered mastodon app on %s", instance)
+	return res, nil
+}
+
+// account gets account of the user logged in with the client
+func (p *Provider) account(ctx context.Context, client *http.Client, instance string) (account, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL(instance)+"/api/v1/accounts/verify_credentials", nil)
+	if err != nil {
+		return account{}, errors.Wrapf(err, "can't make account request for %s", instance)
+	}
+	res := account{}
+	if err = doWith(client, req, &res); err != nil {
+		return account{}, errors.Wrapf(err, "can't get account from %s", instance)
+	}
+	if res.ID == "" {
+		return account{}, errors.Errorf("no account id from %s", instance)
+	}
+	return res, nil
+}
+
+// user makes user of the account, id namespaced by the instance as accounts ids are per instance
+func (p *Provider) user(acc account, instance string) token.User {
+	u := token.User{
+		ID:      Name + "_" + token.HashID(sha1.New(), acc.ID+"@"+instance), //nolint:gosec // not for security
+		Name:    acc.DisplayName,
+		Picture: acc.Avatar,
+	}
+	if u.Name == "" {
+		u.Name = acc.Username
+	}
+	return u
+}
+
+func (p *Provider) redirectURL() string {
+	return strings.TrimSuffix(p.URL, "/") + "/auth/" + Name + "/callback"
+}
+
+// doWith makes the request and decodes JSON response
+func doWith(client *http.Client, req *http.Request, res interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// publicHost resolves the host and checks all its addresses are public
+func publicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.Wrapf(err, "can't resolve %s", host)
+	}
+	for _, a := range addrs {
+		if !isPublicIP(a.IP) {
+			return errors.Errorf("%s resolves to non-public address %s", host, a.IP)
+		}
+	}
+	return nil
+}
+
+// isPublicIP checks the address is not loopback, private, link-local, multicast or unspecified one
+func isPublicIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func randToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't get random")
+	}
+	return hex.EncodeToString(b), nil
+}
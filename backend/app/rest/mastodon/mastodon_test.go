@@ -0,0 +1,188 @@
+package mastodon
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // user ids made the same way as by provider
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/token"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestProvider_Login(t *testing.T) {
+	var registered int32
+	remark := httptest.NewUnstartedServer(nil)
+	remarkURL := "http://" + remark.Listener.Addr().String()
+
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/apps":
+			atomic.AddInt32(&registered, 1)
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "remark42 test", r.Form.Get("client_name"))
+			assert.Equal(t, remarkURL+"/auth/mastodon/callback", r.Form.Get("redirect_uris"))
+			assert.Equal(t, "read:accounts", r.Form.Get("scopes"))
+			_, _ = w.Write([]byte(`{"id":"1","client_id":"cid","client_secret":"csecret"}`))
+		case "/oauth/authorize": // logs user in right away and redirects back with the code
+			assert.Equal(t, "cid", r.URL.Query().Get("client_id"))
+			redir, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+			require.NoError(t, err)
+			redir.RawQuery = url.Values{"code": {"code1"}, "state": {r.URL.Query().Get("state")}}.Encode()
+			http.Redirect(w, r, redir.String(), http.StatusFound)
+		case "/oauth/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "code1", r.Form.Get("code"))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"access1","token_type":"Bearer","scope":"read:accounts"}`))
+		case "/api/v1/accounts/verify_credentials":
+			assert.Equal(t, "Bearer access1", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"id":"109","username":"reader","acct":"reader","display_name":"Fedi Reader"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer instance.Close()
+
+	authService := auth.NewService(auth.Opts{
+		SecretReader:   token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		TokenDuration:  time.Minute,
+		CookieDuration: time.Hour,
+		Issuer:         "remark42",
+		URL:            remarkURL,
+		AvatarStore:    avatar.NewNoOp(),
+	})
+	appsStore := prepApps(t)
+	newProvider := func() *Provider {
+		p := New(Params{URL: remarkURL, Issuer: "remark42", JwtService: authService.TokenService(), AppName: "remark42 test",
+			Store: appsStore})
+		p.baseURL = func(string) string { return instance.URL }
+		p.checkHost = func(context.Context, string) error { return nil }
+		p.client = &http.Client{Timeout: 5 * time.Second} // test instance listens on loopback
+		return p
+	}
+	p := newProvider()
+	authService.AddCustomHandler(p)
+	authHandler, _ := authService.Handlers()
+	remark.Config.Handler = authHandler
+	remark.Start()
+	defer remark.Close()
+
+	for i := 0; i < 2; i++ {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		client := &http.Client{Jar: jar, Timeout: 5 * time.Second}
+		resp, err := client.Get(remark.URL + "/auth/mastodon/login?site=remark&instance=reader@Mastodon.Example")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		u := token.User{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&u))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "mastodon_"+token.HashID(sha1.New(), "109@mastodon.example"), u.ID)
+		assert.Equal(t, "Fedi Reader", u.Name)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&registered), "app registered once per instance")
+
+	_, err := newProvider().config(context.Background(), "mastodon.example")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&registered), "app loaded from the store after restart")
+
+	guarded := New(Params{URL: remarkURL})
+	guarded.baseURL = func(string) string { return instance.URL }
+	_, err = guarded.config(context.Background(), "other.example")
+	require.Error(t, err, "connection to loopback refused")
+	assert.Contains(t, err.Error(), "connection to non-public address 127.0.0.1 refused")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&registered))
+
+	resp, err := http.Get(remark.URL + "/auth/mastodon/login?site=remark&instance=127.0.0.1")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	p.checkHost = func(context.Context, string) error { return errors.New("resolves to non-public address") }
+	resp, err = http.Get(remark.URL + "/auth/mastodon/login?site=remark&instance=internal.example")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestProvider_instance(t *testing.T) {
+	p := New(Params{})
+	tbl := []struct {
+		value, instance string
+		err             bool
+	}{
+		{"mastodon.social", "mastodon.social", false},
+		{" https://Mastodon.Social/ ", "mastodon.social", false},
+		{"@user@fosstodon.org", "fosstodon.org", false},
+		{"localhost", "", true},
+		{"10.0.0.1", "", true},
+		{"mastodon.social:8080", "", true},
+		{"mastodon.social/path", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tbl {
+		res, err := p.instance(tt.value)
+		assert.Equal(t, tt.err, err != nil, tt.value)
+		assert.Equal(t, tt.instance, res, tt.value)
+	}
+
+	p = New(Params{Instances: []string{"mastodon.social"}})
+	_, err := p.instance("fosstodon.org")
+	assert.EqualError(t, err, "instance fosstodon.org not allowed")
+	res, err := p.instance("mastodon.social")
+	assert.NoError(t, err)
+	assert.Equal(t, "mastodon.social", res)
+}
+
+func TestProvider_user(t *testing.T) {
+	p := New(Params{})
+	u := p.user(account{ID: "1", Username: "reader", Avatar: "https://m.example/a.png"}, "m.example")
+	assert.Equal(t, token.User{ID: "mastodon_" + token.HashID(sha1.New(), "1@m.example"), Name: "reader",
+		Picture: "https://m.example/a.png"}, u)
+	assert.NotEqual(t, u.ID, p.user(account{ID: "1"}, "other.example").ID, "ids namespaced by instance")
+}
+
+func TestPublicHost(t *testing.T) {
+	err := publicHost(context.Background(), "localhost")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resolves to non-public address")
+
+	tbl := []struct {
+		ip     string
+		public bool
+	}{
+		{"1.1.1.1", true}, {"2a00:1450:4001:82a::200e", true},
+		{"127.0.0.1", false}, {"10.1.2.3", false}, {"172.20.0.1", false}, {"192.168.1.1", false},
+		{"169.254.169.254", false}, {"100.64.0.1", false}, {"0.0.0.0", false}, {"::1", false},
+		{"fd00::1", false}, {"fe80::1", false}, {"224.0.0.1", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.public, isPublicIP(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
+
+func prepApps(t *testing.T) *BoltApps {
+	fileName := os.TempDir() + "/test-mastodon-apps.db"
+	_ = os.Remove(fileName)
+	st, err := NewBoltApps(fileName, bolt.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, st.Close())
+		_ = os.Remove(fileName)
+	})
+	return st
+}
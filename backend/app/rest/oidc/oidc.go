@@ -0,0 +1,218 @@
+// Package oidc makes generic OpenID Connect auth providers, like Keycloak, from the issuer's discovery document.
+// Providers work as custom oauth2 providers of go-pkgz/auth, with user info claims mapped to the user.
+package oidc
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for user id hashing the same way as other providers, not for security
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// AdminAttr is the user attribute with comma-separated sites of the provider, set for users granted admin
+// by the provider's admin claim
+const AdminAttr = "oidc_admin"
+
+// Provider defines OpenID Connect provider
+type Provider struct {
+	Name   string   `json:"name"`   // provider name used in auth routes and user ids, like keycloak
+	Issuer string   `json:"issuer"` // issuer url, discovery document loaded from <issuer>/.well-known/openid-configuration
+	CID    string   `json:"cid"`
+	CSEC   string   `json:"csec"`
+	Scopes []string `json:"scopes,omitempty"` // openid, profile and email if empty
+	Claims Claims   `json:"claims,omitempty"`
+}
+
+// Claims defines user info claims mapped to the user, nested claims set with dots, like realm_access.roles
+type Claims struct {
+	ID         string   `json:"id,omitempty"`          // sub if empty
+	Name       string   `json:"name,omitempty"`        // name if empty, with fallback to preferred_username and email
+	Picture    string   `json:"picture,omitempty"`     // picture if empty
+	Admin      string   `json:"admin,omitempty"`       // claim granting admin, like groups, admin not mapped if empty
+	AdminValue string   `json:"admin_value,omitempty"` // value of the admin claim or its element for lists, true if empty
+	AdminSites []string `json:"admin_sites,omitempty"` // sites the admin claim grants admin on, required with admin claim
+}
+
+// discovery is the part of OpenID provider metadata used for login
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+var (
+	reName        = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+	reservedNames = []string{"google", "github", "facebook", "microsoft", "yandex", "twitter", "dev", "email",
+		"anonymous", "telegram", "mastodon", "sso"}
+)
+
+// Load reads providers from JSON file with the list of them and checks they are valid
+func Load(fileName string) ([]Provider, error) {
+	data, err := ioutil.ReadFile(fileName) //nolint:gosec // file name set by server's admin
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read oidc providers %s", fileName)
+	}
+	var res []Provider
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrapf(err, "can't parse oidc providers %s", fileName)
+	}
+	names := map[string]bool{}
+	for _, p := range res {
+		if err = p.validate(); err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, errors.Errorf("duplicate oidc provider %q", p.Name)
+		}
+		names[p.Name] = true
+	}
+	return res, nil
+}
+
+func (p Provider) validate() error {
+	if !reName.MatchString(p.Name) {
+		return errors.Errorf("invalid oidc provider name %q, should be lowercase letters, digits, _ and -", p.Name)
+	}
+	for _, n := range reservedNames {
+		if p.Name == n {
+			return errors.Errorf("oidc provider name %q is reserved", p.Name)
+		}
+	}
+	if p.Issuer == "" || p.CID == "" || p.CSEC == "" {
+		return errors.Errorf("oidc provider %s should have issuer, cid and csec", p.Name)
+	}
+	if p.Claims.Admin != "" && len(p.Claims.AdminSites) == 0 {
+		return errors.Errorf("oidc provider %s should have admin sites for admin claim", p.Name)
+	}
+	return nil
+}
+
+// HandlerOpt loads the discovery document of the issuer and makes options of go-pkgz/auth custom provider
+func (p Provider) HandlerOpt(ctx context.Context, client *http.Client) (provider.CustomHandlerOpt, error) {
+	d, err := p.discover(ctx, client)
+	if err != nil {
+		return provider.CustomHandlerOpt{}, err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	return provider.CustomHandlerOpt{
+		Endpoint:  oauth2.Endpoint{AuthURL: d.AuthorizationEndpoint, TokenURL: d.TokenEndpoint},
+		InfoURL:   d.UserinfoEndpoint,
+		MapUserFn: p.mapUser,
+		Scopes:    scopes,
+	}, nil
+}
+
+func (p Provider) discover(ctx context.Context, client *http.Client) (discovery, error) {
+	u := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return discovery{}, errors.Wrapf(err, "can't make discovery request for %s", p.Name)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return discovery{}, errors.Wrapf(err, "can't get discovery document of %s", p.Name)
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+	if resp.StatusCode != http.StatusOK {
+		return discovery{}, errors.Errorf("unexpected discovery status %d for %s", resp.StatusCode, p.Name)
+	}
+	d := discovery{}
+	if err = json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return discovery{}, errors.Wrapf(err, "can't decode discovery document of %s", p.Name)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.Issuer, "/") {
+		return discovery{}, errors.Errorf("issuer %q of %s discovery document doesn't match %q", d.Issuer, p.Name, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.UserinfoEndpoint == "" {
+		return discovery{}, errors.Errorf("discovery document of %s misses endpoints", p.Name)
+	}
+	return d, nil
+}
+
+// mapUser makes user from user info claims, id prefixed by the provider name like ids of other providers
+func (p Provider) mapUser(data provider.UserData, _ []byte) token.User {
+	claim := func(name, def string, fallbacks ...string) string {
+		if name == "" {
+			name = def
+		}
+		for _, n := range append([]string{name}, fallbacks...) {
+			if v := value(data, n); v != nil {
+				if s := fmt.Sprintf("%v", v); s != "" {
+					return s
+				}
+			}
+		}
+		return ""
+	}
+	u := token.User{
+		ID:      p.Name + "_" + token.HashID(sha1.New(), claim(p.Claims.ID, "sub")), //nolint:gosec // not for security
+		Name:    claim(p.Claims.Name, "name", "preferred_username", "email"),
+		Picture: claim(p.Claims.Picture, "picture"),
+	}
+	if u.Name == "" {
+		u.Name = "noname_" + u.ID[len(u.ID)-4:]
+	}
+	if p.Claims.Admin != "" && len(p.Claims.AdminSites) > 0 && p.isAdmin(value(data, p.Claims.Admin)) {
+		u.SetStrAttr(AdminAttr, strings.Join(p.Claims.AdminSites, ","))
+	}
+	return u
+}
+
+// IsAdmin checks the user is granted admin on the site by the admin claim of the provider
+func IsAdmin(u *token.User, siteID string) bool {
+	for _, site := range strings.Split(u.StrAttr(AdminAttr), ",") {
+		if site != "" && site == siteID {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin checks the value of the admin claim, bool, string or the list of strings
+func (p Provider) isAdmin(v interface{}) bool {
+	expected := p.Claims.AdminValue
+	if expected == "" {
+		expected = "true"
+	}
+	switch val := v.(type) {
+	case []interface{}:
+		for _, e := range val {
+			if fmt.Sprintf("%v", e) == expected {
+				return true
+			}
+		}
+		return false
+	case nil:
+		return false
+	default:
+		return fmt.Sprintf("%v", val) == expected
+	}
+}
+
+// value returns the claim by dotted path, nil if not found
+func value(data map[string]interface{}, path string) interface{} {
+	var cur interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[key]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // user ids made the same way as by provider
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tbl := []struct {
+		data string
+		err  string
+	}{
+		{`[{"name":"keycloak","issuer":"https://kc/realms/staff","cid":"c","csec":"s"},
+			{"name":"partners","issuer":"https://kc/realms/partners","cid":"c","csec":"s","scopes":["openid"]}]`, ""},
+		{`[{"name":"Keycloak","issuer":"https://kc","cid":"c","csec":"s"}]`,
+			`invalid oidc provider name "Keycloak", should be lowercase letters, digits, _ and -`},
+		{`[{"name":"github","issuer":"https://kc","cid":"c","csec":"s"}]`, `oidc provider name "github" is reserved`},
+		{`[{"name":"kc","cid":"c","csec":"s"}]`, "oidc provider kc should have issuer, cid and csec"},
+		{`[{"name":"sso","issuer":"https://kc","cid":"c","csec":"s"}]`, `oidc provider name "sso" is reserved`},
+		{`[{"name":"kc","issuer":"https://kc","cid":"c","csec":"s","claims":{"admin":"groups"}}]`,
+			"oidc provider kc should have admin sites for admin claim"},
+		{`[{"name":"kc","issuer":"https://kc","cid":"c","csec":"s"},{"name":"kc","issuer":"https://kc2","cid":"c","csec":"s"}]`,
+			`duplicate oidc provider "kc"`},
+	}
+	for i, tt := range tbl {
+		f, err := ioutil.TempFile("", "oidc")
+		require.NoError(t, err)
+		_, err = f.WriteString(tt.data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		res, err := Load(f.Name())
+		_ = os.Remove(f.Name())
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, i)
+			continue
+		}
+		require.NoError(t, err, i)
+		assert.Equal(t, 2, len(res))
+		assert.Equal(t, []string{"openid"}, res[1].Scopes)
+	}
+
+	_, err := Load("/tmp/no-such-oidc.json")
+	assert.Error(t, err)
+}
+
+func TestProvider_mapUser(t *testing.T) {
+	tbl := []struct {
+		claims Claims
+		data   string
+		user   token.User
+		admin  bool
+	}{
+		{Claims{}, `{"sub":"u1","name":"User 1","picture":"https://kc/u1.png"}`,
+			token.User{ID: "kc_" + token.HashID(sha1.New(), "u1"), Name: "User 1", Picture: "https://kc/u1.png"}, false},
+		{Claims{}, `{"sub":"u1","preferred_username":"user1"}`,
+			token.User{ID: "kc_" + token.HashID(sha1.New(), "u1"), Name: "user1"}, false},
+		{Claims{ID: "email", Name: "nick", Picture: "avatar.url", Admin: "realm_access.roles", AdminValue: "remark42-admin",
+			AdminSites: []string{"remark", "other"}},
+			`{"sub":"u1","email":"u1@example.com","nick":"nick1","avatar":{"url":"https://kc/a.png"},
+				"realm_access":{"roles":["user","remark42-admin"]}}`,
+			token.User{ID: "kc_" + token.HashID(sha1.New(), "u1@example.com"), Name: "nick1", Picture: "https://kc/a.png"}, true},
+		{Claims{Admin: "groups", AdminValue: "admins", AdminSites: []string{"remark"}}, `{"sub":"u1","name":"u","groups":["users"]}`,
+			token.User{ID: "kc_" + token.HashID(sha1.New(), "u1"), Name: "u"}, false},
+		{Claims{Admin: "is_admin", AdminSites: []string{"remark"}}, `{"sub":"u1","name":"u","is_admin":true}`,
+			token.User{ID: "kc_" + token.HashID(sha1.New(), "u1"), Name: "u"}, true},
+		{Claims{Admin: "role", AdminValue: "admin", AdminSites: []string{"remark"}}, `{"sub":"u1","name":"u","role":"admin"}`,
+			token.User{ID: "kc_" + token.HashID(sha1.New(), "u1"), Name: "u"}, true},
+	}
+	for i, tt := range tbl {
+		data := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(tt.data), &data))
+		u := Provider{Name: "kc", Claims: tt.claims}.mapUser(data, []byte(tt.data))
+		assert.Equal(t, tt.admin, IsAdmin(&u, "remark"), i)
+		assert.False(t, IsAdmin(&u, "third"), i)
+		u.Attributes = nil
+		assert.Equal(t, tt.user, u, i)
+	}
+}
+
+func TestProvider_Login(t *testing.T) {
+	var issuer *httptest.Server
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/staff/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 issuer.URL + "/realms/staff",
+				"authorization_endpoint": issuer.URL + "/realms/staff/auth",
+				"token_endpoint":         issuer.URL + "/realms/staff/token",
+				"userinfo_endpoint":      issuer.URL + "/realms/staff/userinfo",
+			})
+		case "/realms/staff/auth": // logs user in right away and redirects back with the code
+			assert.Equal(t, "openid profile groups", r.URL.Query().Get("scope"))
+			redir, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+			require.NoError(t, err)
+			redir.RawQuery = url.Values{"code": {"code1"}, "state": {r.URL.Query().Get("state")}}.Encode()
+			http.Redirect(w, r, redir.String(), http.StatusFound)
+		case "/realms/staff/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "code1", r.Form.Get("code"))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"access1","token_type":"Bearer","expires_in":300}`))
+		case "/realms/staff/userinfo":
+			assert.Equal(t, "Bearer access1", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"sub":"u1","name":"Staff User","groups":["staff","remark42-admins"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer issuer.Close()
+
+	remark := httptest.NewUnstartedServer(nil)
+	authService := auth.NewService(auth.Opts{
+		SecretReader:   token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		TokenDuration:  time.Minute,
+		CookieDuration: time.Hour,
+		Issuer:         "remark42",
+		URL:            "http://" + remark.Listener.Addr().String(),
+		AvatarStore:    avatar.NewNoOp(),
+	})
+	p := Provider{Name: "keycloak", Issuer: issuer.URL + "/realms/staff/", CID: "cid", CSEC: "csec",
+		Scopes: []string{"openid", "profile", "groups"}, Claims: Claims{Admin: "groups", AdminValue: "remark42-admins", AdminSites: []string{"remark"}}}
+	opts, err := p.HandlerOpt(context.Background(), http.DefaultClient)
+	require.NoError(t, err)
+	authService.AddCustomProvider(p.Name, auth.Client{Cid: p.CID, Csecret: p.CSEC}, opts)
+	authHandler, _ := authService.Handlers()
+	remark.Config.Handler = authHandler
+	remark.Start()
+	defer remark.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar, Timeout: 5 * time.Second}
+	resp, err := client.Get(remark.URL + "/auth/keycloak/login?site=remark")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	u := token.User{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&u))
+	assert.Equal(t, "keycloak_"+token.HashID(sha1.New(), "u1"), u.ID)
+	assert.Equal(t, "Staff User", u.Name)
+	assert.True(t, IsAdmin(&u, "remark"))
+	assert.False(t, IsAdmin(&u, "other"), "admin on the provider's sites only")
+
+	_, err = Provider{Name: "other", Issuer: issuer.URL + "/realms/other", CID: "c", CSEC: "s"}.
+		HandlerOpt(context.Background(), http.DefaultClient)
+	assert.EqualError(t, err, "unexpected discovery status 404 for other")
+}
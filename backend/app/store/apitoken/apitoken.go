@@ -0,0 +1,302 @@
+// Package apitoken implements named API tokens for programmatic admin access.
+// Token is scoped to a site and a set of permissions and expires. Store keeps tokens with hashed secrets
+// and the audit trail of their creation, use and revocation. Service is the one consumer should use.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Permission defines a group of admin actions allowed for the token
+type Permission string
+
+// enum of all permissions
+const (
+	PermRead     Permission = "read"     // read-only admin requests
+	PermModerate Permission = "moderate" // admin actions with comments and users
+	PermMigrate  Permission = "migrate"  // export, import and remap
+)
+
+// Action is a kind of audit record
+type Action string
+
+// enum of all audit actions
+const (
+	ActionCreate Action = "create"
+	ActionRevoke Action = "revoke"
+	ActionUse    Action = "use"
+	ActionDeny   Action = "deny"
+)
+
+// Token is a named credential for admin api. Secret itself never stored, only its hash
+type Token struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	SiteID      string       `json:"site"`
+	Permissions []Permission `json:"permissions"`
+	Hash        string       `json:"hash,omitempty"`
+	CreatedBy   string       `json:"created_by"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	LastUsed    time.Time    `json:"last_used,omitempty"`
+}
+
+// Allowed checks if the token has the permission
+func (t Token) Allowed(perm Permission) bool {
+	for _, p := range t.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired checks if the token expired at the given time
+func (t Token) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// AuditRecord is an entry of tokens audit trail
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	SiteID    string    `json:"site"`
+	TokenID   string    `json:"token_id"`
+	TokenName string    `json:"token_name"`
+	Action    Action    `json:"action"`
+	Actor     string    `json:"actor"`             // user made the change, the token itself for use and deny
+	Details   string    `json:"details,omitempty"` // request for use and deny
+}
+
+// Store defines interface to keep tokens and audit trail
+type Store interface {
+	Save(tkn Token) error
+	Get(id string) (Token, error)
+	Delete(id string) error
+	List(siteID string) ([]Token, error)                           // all tokens of the site, sorted by creation time
+	Audit(rec AuditRecord) error                                   // add record to audit trail of the site
+	AuditLog(siteID string) ([]AuditRecord, error)                 // audit trail of the site, from the latest record
+	Usage(lastUsed map[string]time.Time, recs []AuditRecord) error // update last use of tokens and add audit records at once
+	Close() error
+}
+
+// ErrNotFound returned by Store for unknown token
+var ErrNotFound = errors.New("token not found")
+
+const secretSep = "."
+
+const (
+	defaultFlushInterval = 10 * time.Second
+	maxPendingRecords    = 100
+)
+
+// Service wraps Store with tokens generation and verification.
+// Use and deny of tokens collected in memory and written to Store in batches,
+// on FlushInterval, on too many pending records and before any read of tokens or audit trail.
+type Service struct {
+	Store         Store
+	MaxTTL        time.Duration // max lifetime of the token, unlimited if not set
+	FlushInterval time.Duration // max time usage kept in memory, 10s if not set
+
+	lock     sync.Mutex
+	lastUsed map[string]time.Time // pending last use time by token id
+	records  []AuditRecord        // pending use and deny records
+	flushed  time.Time
+}
+
+// CreateRequest defines parameters of the new token
+type CreateRequest struct {
+	SiteID      string
+	Name        string
+	Permissions []Permission
+	TTL         time.Duration
+	Actor       string // user creating the token
+}
+
+// Create makes new token and returns it along with the secret. The secret can't be restored later
+func (s *Service) Create(req CreateRequest) (tkn Token, secret string, err error) {
+	if req.SiteID == "" || req.Name == "" {
+		return Token{}, "", errors.New("site and name required")
+	}
+	if len(req.Permissions) == 0 {
+		return Token{}, "", errors.New("at least one permission required")
+	}
+	for _, p := range req.Permissions {
+		if p != PermRead && p != PermModerate && p != PermMigrate {
+			return Token{}, "", errors.Errorf("unknown permission %q", p)
+		}
+	}
+	if req.TTL <= 0 {
+		return Token{}, "", errors.New("ttl required")
+	}
+	if s.MaxTTL > 0 && req.TTL > s.MaxTTL {
+		return Token{}, "", errors.Errorf("ttl %v exceeds max %v", req.TTL, s.MaxTTL)
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return Token{}, "", err
+	}
+	key, err := randomHex(24)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret = id + secretSep + key
+
+	now := time.Now()
+	tkn = Token{
+		ID:          id,
+		Name:        req.Name,
+		SiteID:      req.SiteID,
+		Permissions: req.Permissions,
+		Hash:        hashSecret(secret),
+		CreatedBy:   req.Actor,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(req.TTL),
+	}
+	if err = s.Store.Save(tkn); err != nil {
+		return Token{}, "", errors.Wrapf(err, "can't save token %s", req.Name)
+	}
+	s.flush()
+	s.audit(tkn, ActionCreate, req.Actor, "")
+	return tkn, secret, nil
+}
+
+// Check verifies the secret and returns the token it belongs to
+func (s *Service) Check(secret string) (Token, error) {
+	elems := strings.SplitN(secret, secretSep, 2)
+	if len(elems) != 2 || elems[0] == "" {
+		return Token{}, errors.New("malformed token")
+	}
+	tkn, err := s.Store.Get(elems[0])
+	if err != nil {
+		return Token{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(tkn.Hash)) != 1 {
+		return Token{}, errors.New("token mismatch")
+	}
+	if tkn.Expired(time.Now()) {
+		return Token{}, errors.Errorf("token %s expired", tkn.ID)
+	}
+	return tkn, nil
+}
+
+// Get returns the token by id
+func (s *Service) Get(id string) (Token, error) {
+	s.flush()
+	return s.Store.Get(id)
+}
+
+// List returns all tokens of the site
+func (s *Service) List(siteID string) ([]Token, error) {
+	s.flush()
+	return s.Store.List(siteID)
+}
+
+// Revoke deletes the token of the site
+func (s *Service) Revoke(siteID, id, actor string) error {
+	tkn, err := s.Store.Get(id)
+	if err != nil {
+		return err
+	}
+	if tkn.SiteID != siteID {
+		return ErrNotFound
+	}
+	s.flush()
+	if err = s.Store.Delete(id); err != nil {
+		return errors.Wrapf(err, "can't delete token %s", id)
+	}
+	s.audit(tkn, ActionRevoke, actor, "")
+	return nil
+}
+
+// Used records the request made with the token and updates its last use time
+func (s *Service) Used(tkn Token, details string) {
+	s.pending(tkn, ActionUse, details)
+}
+
+// Denied records the request rejected for lack of permissions
+func (s *Service) Denied(tkn Token, details string) {
+	s.pending(tkn, ActionDeny, details)
+}
+
+// AuditLog returns audit trail of the site, from the latest record
+func (s *Service) AuditLog(siteID string) ([]AuditRecord, error) {
+	s.flush()
+	return s.Store.AuditLog(siteID)
+}
+
+// Close writes pending usage and closes store
+func (s *Service) Close() error {
+	s.flush()
+	return s.Store.Close()
+}
+
+// pending keeps use or deny of the token in memory, written to the store if the batch is due
+func (s *Service) pending(tkn Token, action Action, details string) {
+	now := time.Now()
+	s.lock.Lock()
+	if s.lastUsed == nil {
+		s.lastUsed = map[string]time.Time{}
+	}
+	if s.flushed.IsZero() {
+		s.flushed = now
+	}
+	if action == ActionUse {
+		s.lastUsed[tkn.ID] = now
+	}
+	s.records = append(s.records, AuditRecord{Time: now, SiteID: tkn.SiteID, TokenID: tkn.ID, TokenName: tkn.Name,
+		Action: action, Actor: tkn.ID, Details: details})
+	interval := s.FlushInterval
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	due := len(s.records) >= maxPendingRecords || now.Sub(s.flushed) >= interval
+	s.lock.Unlock()
+	if due {
+		s.flush()
+	}
+}
+
+// flush writes pending usage to the store, usage dropped on error
+func (s *Service) flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flushed = time.Now()
+	if len(s.records) == 0 {
+		return
+	}
+	if err := s.Store.Usage(s.lastUsed, s.records); err != nil {
+		log.Printf("[WARN] can't record usage of %d tokens, %v", len(s.lastUsed), err)
+	}
+	s.lastUsed, s.records = map[string]time.Time{}, nil
+}
+
+func (s *Service) audit(tkn Token, action Action, actor, details string) {
+	rec := AuditRecord{Time: time.Now(), SiteID: tkn.SiteID, TokenID: tkn.ID, TokenName: tkn.Name,
+		Action: action, Actor: actor, Details: details}
+	if err := s.Store.Audit(rec); err != nil {
+		log.Printf("[WARN] can't record %s of token %s, %v", action, tkn.ID, err)
+	}
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't generate random token")
+	}
+	return hex.EncodeToString(b), nil
+}
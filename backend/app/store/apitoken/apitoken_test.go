@@ -0,0 +1,137 @@
+package apitoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestService_CreateCheck(t *testing.T) {
+	b, teardown := prepareBoltTokensStorageTest(t)
+	defer teardown()
+	svc := Service{Store: b, MaxTTL: 24 * time.Hour}
+
+	tkn, secret, err := svc.Create(CreateRequest{SiteID: "site1", Name: "backup", TTL: time.Hour,
+		Permissions: []Permission{PermRead, PermMigrate}, Actor: "admin"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, tkn.ID+"."))
+	assert.NotContains(t, tkn.Hash, secret, "secret not stored")
+	assert.True(t, tkn.Allowed(PermMigrate))
+	assert.False(t, tkn.Allowed(PermModerate))
+
+	checked, err := svc.Check(secret)
+	require.NoError(t, err)
+	assert.Equal(t, tkn.ID, checked.ID)
+	assert.Equal(t, "site1", checked.SiteID)
+
+	_, err = svc.Check(secret + "x")
+	assert.EqualError(t, err, "token mismatch")
+	_, err = svc.Check("blah")
+	assert.EqualError(t, err, "malformed token")
+	_, err = svc.Check("unknown.blah")
+	assert.Equal(t, ErrNotFound, err)
+
+	expired := tkn
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, b.Save(expired))
+	_, err = svc.Check(secret)
+	assert.EqualError(t, err, "token "+tkn.ID+" expired")
+
+	for _, req := range []CreateRequest{
+		{Name: "no site", TTL: time.Hour, Permissions: []Permission{PermRead}},
+		{SiteID: "site1", Name: "no perms", TTL: time.Hour},
+		{SiteID: "site1", Name: "bad perm", TTL: time.Hour, Permissions: []Permission{"all"}},
+		{SiteID: "site1", Name: "no ttl", Permissions: []Permission{PermRead}},
+		{SiteID: "site1", Name: "long ttl", TTL: 48 * time.Hour, Permissions: []Permission{PermRead}},
+	} {
+		_, _, err = svc.Create(req)
+		assert.Error(t, err, req.Name)
+	}
+}
+
+func TestService_RevokeAudit(t *testing.T) {
+	b, teardown := prepareBoltTokensStorageTest(t)
+	defer teardown()
+	svc := Service{Store: b}
+
+	tkn, secret, err := svc.Create(CreateRequest{SiteID: "site1", Name: "cleanup", TTL: time.Hour,
+		Permissions: []Permission{PermModerate}, Actor: "admin"})
+	require.NoError(t, err)
+
+	svc.Used(tkn, "DELETE /api/v1/admin/comment/123")
+	svc.Denied(tkn, "GET /api/v1/admin/export")
+	stored, err := svc.Get(tkn.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.LastUsed.IsZero())
+
+	assert.Equal(t, ErrNotFound, svc.Revoke("site2", tkn.ID, "admin2"), "token of another site")
+	require.NoError(t, svc.Revoke("site1", tkn.ID, "admin2"))
+	_, err = svc.Check(secret)
+	assert.Equal(t, ErrNotFound, err, "revoked")
+	tokens, err := svc.List("site1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(tokens))
+
+	recs, err := svc.AuditLog("site1")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(recs))
+	assert.Equal(t, ActionRevoke, recs[0].Action)
+	assert.Equal(t, "admin2", recs[0].Actor)
+	assert.Equal(t, ActionDeny, recs[1].Action)
+	assert.Equal(t, "GET /api/v1/admin/export", recs[1].Details)
+	assert.Equal(t, ActionUse, recs[2].Action)
+	assert.Equal(t, ActionCreate, recs[3].Action)
+	assert.Equal(t, "cleanup", recs[3].TokenName)
+	assert.Equal(t, "admin", recs[3].Actor)
+}
+
+func TestService_UsageBatch(t *testing.T) {
+	b, teardown := prepareBoltTokensStorageTest(t)
+	defer teardown()
+	svc := Service{Store: b, FlushInterval: time.Hour}
+
+	tkn, _, err := svc.Create(CreateRequest{SiteID: "site1", Name: "reader", TTL: time.Hour,
+		Permissions: []Permission{PermRead}, Actor: "admin"})
+	require.NoError(t, err)
+
+	for i := 0; i < maxPendingRecords-1; i++ {
+		svc.Used(tkn, "GET /api/v1/admin/blocked")
+	}
+	stored, err := b.Get(tkn.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.LastUsed.IsZero(), "usage kept in memory")
+	recs, err := b.AuditLog("site1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(recs), "create only")
+
+	svc.Denied(tkn, "GET /api/v1/admin/tokens")
+	stored, err = b.Get(tkn.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.LastUsed.IsZero(), "written on too many pending records")
+	recs, err = b.AuditLog("site1")
+	require.NoError(t, err)
+	require.Equal(t, maxPendingRecords+1, len(recs))
+	assert.Equal(t, ActionDeny, recs[0].Action)
+
+	svc.FlushInterval = time.Millisecond
+	svc.Used(tkn, "GET /api/v1/admin/export")
+	time.Sleep(5 * time.Millisecond)
+	svc.Used(tkn, "GET /api/v1/admin/export")
+	recs, err = b.AuditLog("site1")
+	require.NoError(t, err)
+	assert.Equal(t, maxPendingRecords+3, len(recs), "written on interval")
+
+	svc.FlushInterval = time.Hour
+	svc.Used(tkn, "GET /api/v1/admin/export")
+	require.NoError(t, svc.Close())
+	b, err = NewBoltStorage(b.fileName, bolt.Options{})
+	require.NoError(t, err)
+	defer b.Close()
+	recs, err = b.AuditLog("site1")
+	require.NoError(t, err)
+	assert.Equal(t, maxPendingRecords+4, len(recs), "written on close")
+}
@@ -0,0 +1,203 @@
+package apitoken
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	tokensBucketName = "tokens"
+	auditBucketName  = "audit"
+)
+
+// DefaultAuditSize used if Bolt.AuditSize not set
+const DefaultAuditSize = 10000
+
+// Bolt implements Store with bolt DB. Tokens kept in a single bucket keyed by id,
+// audit trail of each site in the nested bucket keyed by sequence number.
+type Bolt struct {
+	AuditSize int // max number of audit records kept for each site
+	fileName  string
+	db        *bolt.DB
+}
+
+// NewBoltStorage makes bolt tokens store
+func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bkt := range []string{tokensBucketName, auditBucketName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bkt)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bkt)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db, fileName: fileName, AuditSize: DefaultAuditSize}, nil
+}
+
+// Save adds or replaces the token
+func (b *Bolt) Save(tkn Token) error {
+	data, err := json.Marshal(tkn)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal token %s", tkn.ID)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return errors.Wrapf(tx.Bucket([]byte(tokensBucketName)).Put([]byte(tkn.ID), data), "can't put token %s", tkn.ID)
+	})
+}
+
+// Get returns the token by id, ErrNotFound if no such token
+func (b *Bolt) Get(id string) (tkn Token, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(tokensBucketName)).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return errors.Wrapf(json.Unmarshal(data, &tkn), "can't unmarshal token %s", id)
+	})
+	return tkn, err
+}
+
+// Delete removes the token, ErrNotFound if no such token
+func (b *Bolt) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(tokensBucketName))
+		if bkt.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return errors.Wrapf(bkt.Delete([]byte(id)), "can't delete token %s", id)
+	})
+}
+
+// List returns all tokens of the site sorted by creation time
+func (b *Bolt) List(siteID string) (res []Token, err error) {
+	res = []Token{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tokensBucketName)).ForEach(func(k, v []byte) error {
+			tkn := Token{}
+			if e := json.Unmarshal(v, &tkn); e != nil {
+				return errors.Wrapf(e, "can't unmarshal token %s", string(k))
+			}
+			if tkn.SiteID == siteID {
+				res = append(res, tkn)
+			}
+			return nil
+		})
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, err
+}
+
+// Audit adds the record to audit trail of the site, the oldest record dropped if AuditSize reached
+func (b *Bolt) Audit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal audit record")
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return b.audit(tx, rec.SiteID, data)
+	})
+}
+
+// Usage updates last use time of tokens and adds records to audit trail in a single transaction.
+// Tokens deleted in the meantime skipped.
+func (b *Bolt) Usage(lastUsed map[string]time.Time, recs []AuditRecord) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		tokensBkt := tx.Bucket([]byte(tokensBucketName))
+		for id, ts := range lastUsed {
+			data := tokensBkt.Get([]byte(id))
+			if data == nil {
+				continue
+			}
+			tkn := Token{}
+			if err := json.Unmarshal(data, &tkn); err != nil {
+				return errors.Wrapf(err, "can't unmarshal token %s", id)
+			}
+			if !ts.After(tkn.LastUsed) {
+				continue
+			}
+			tkn.LastUsed = ts
+			data, err := json.Marshal(tkn)
+			if err != nil {
+				return errors.Wrapf(err, "can't marshal token %s", id)
+			}
+			if err = tokensBkt.Put([]byte(id), data); err != nil {
+				return errors.Wrapf(err, "can't put token %s", id)
+			}
+		}
+		for _, rec := range recs {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return errors.Wrap(err, "can't marshal audit record")
+			}
+			if err = b.audit(tx, rec.SiteID, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AuditLog returns audit trail of the site from the latest record
+func (b *Bolt) AuditLog(siteID string) (res []AuditRecord, err error) {
+	res = []AuditRecord{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(auditBucketName)).Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			rec := AuditRecord{}
+			if e := json.Unmarshal(v, &rec); e != nil {
+				return errors.Wrapf(e, "can't unmarshal audit record for %s", siteID)
+			}
+			res = append(res, rec)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Close bolt store
+func (b *Bolt) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
+
+// audit puts the record to audit trail of the site, the oldest record dropped if AuditSize reached.
+// Should run in update tx
+func (b *Bolt) audit(tx *bolt.Tx, siteID string, data []byte) error {
+	bkt, err := tx.Bucket([]byte(auditBucketName)).CreateBucketIfNotExists([]byte(siteID))
+	if err != nil {
+		return errors.Wrapf(err, "can't create audit bucket for %s", siteID)
+	}
+	seq, err := bkt.NextSequence()
+	if err != nil {
+		return errors.Wrapf(err, "can't get sequence for %s", siteID)
+	}
+	if err = bkt.Put(seqKey(seq), data); err != nil {
+		return errors.Wrapf(err, "can't put audit record for %s", siteID)
+	}
+	if b.AuditSize > 0 && seq > uint64(b.AuditSize) {
+		return errors.Wrapf(bkt.Delete(seqKey(seq-uint64(b.AuditSize))), "can't delete old audit record for %s", siteID)
+	}
+	return nil
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
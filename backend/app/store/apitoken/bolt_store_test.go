@@ -0,0 +1,116 @@
+package apitoken
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore_Tokens(t *testing.T) {
+	b, teardown := prepareBoltTokensStorageTest(t)
+	defer teardown()
+
+	_, err := b.Get("id1")
+	assert.Equal(t, ErrNotFound, err)
+
+	ts := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, b.Save(Token{ID: "id2", Name: "second", SiteID: "site1", CreatedAt: ts.Add(time.Hour)}))
+	require.NoError(t, b.Save(Token{ID: "id1", Name: "first", SiteID: "site1", CreatedAt: ts,
+		Permissions: []Permission{PermRead}}))
+	require.NoError(t, b.Save(Token{ID: "id3", Name: "other", SiteID: "site2", CreatedAt: ts}))
+
+	tkn, err := b.Get("id1")
+	require.NoError(t, err)
+	assert.Equal(t, "first", tkn.Name)
+	assert.Equal(t, []Permission{PermRead}, tkn.Permissions)
+
+	tokens, err := b.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(tokens))
+	assert.Equal(t, "id1", tokens[0].ID, "sorted by creation time")
+	assert.Equal(t, "id2", tokens[1].ID)
+
+	require.NoError(t, b.Delete("id1"))
+	assert.Equal(t, ErrNotFound, b.Delete("id1"))
+	tokens, err = b.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(tokens))
+
+	tokens, err = b.List("site3")
+	require.NoError(t, err)
+	assert.Equal(t, []Token{}, tokens)
+}
+
+func TestBoltStore_Audit(t *testing.T) {
+	b, teardown := prepareBoltTokensStorageTest(t)
+	defer teardown()
+	b.AuditSize = 3
+
+	recs, err := b.AuditLog("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []AuditRecord{}, recs)
+
+	for _, id := range []string{"id1", "id2", "id3", "id4", "id5"} {
+		require.NoError(t, b.Audit(AuditRecord{SiteID: "site1", TokenID: id, Action: ActionUse}))
+	}
+	require.NoError(t, b.Audit(AuditRecord{SiteID: "site2", TokenID: "id6", Action: ActionCreate}))
+
+	recs, err = b.AuditLog("site1")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(recs), "oldest records dropped")
+	assert.Equal(t, "id5", recs[0].TokenID, "the latest record first")
+	assert.Equal(t, "id3", recs[2].TokenID)
+
+	recs, err = b.AuditLog("site2")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, ActionCreate, recs[0].Action)
+}
+
+func TestBoltStore_Usage(t *testing.T) {
+	b, teardown := prepareBoltTokensStorageTest(t)
+	defer teardown()
+
+	ts := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, b.Save(Token{ID: "id1", SiteID: "site1", LastUsed: ts}))
+	require.NoError(t, b.Save(Token{ID: "id2", SiteID: "site1", LastUsed: ts}))
+
+	err := b.Usage(map[string]time.Time{"id1": ts.Add(time.Minute), "id2": ts.Add(-time.Minute), "unknown": ts},
+		[]AuditRecord{{SiteID: "site1", TokenID: "id1", Action: ActionUse}, {SiteID: "site1", TokenID: "id2", Action: ActionDeny}})
+	require.NoError(t, err)
+
+	tkn, err := b.Get("id1")
+	require.NoError(t, err)
+	assert.Equal(t, ts.Add(time.Minute), tkn.LastUsed.UTC())
+	tkn, err = b.Get("id2")
+	require.NoError(t, err)
+	assert.Equal(t, ts, tkn.LastUsed.UTC(), "earlier use ignored")
+	_, err = b.Get("unknown")
+	assert.Equal(t, ErrNotFound, err, "unknown token not created")
+
+	recs, err := b.AuditLog("site1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, ActionDeny, recs[0].Action)
+	assert.Equal(t, ActionUse, recs[1].Action)
+}
+
+func prepareBoltTokensStorageTest(t *testing.T) (b *Bolt, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_tokens_r42")
+	require.NoError(t, err, "failed to make temp dir")
+
+	b, err = NewBoltStorage(path.Join(loc, "tokens.db"), bolt.Options{})
+	require.NoError(t, err, "new bolt storage")
+
+	teardown = func() {
+		_ = b.Close()
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+	return b, teardown
+}
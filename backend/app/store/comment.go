@@ -3,6 +3,7 @@ package store
 import (
 	"fmt"
 	"html/template"
+	"math"
 	"regexp"
 	"strings"
 	"time"
@@ -23,12 +24,19 @@ type Comment struct {
 	VotedIPs    map[string]VotedIPInfo `json:"voted_ips,omitempty"` // voted ips (hashes) with TS
 	Vote        int                    `json:"vote"`                // vote for the current user, -1/1/0.
 	Controversy float64                `json:"controversy,omitempty"`
+	Best        float64                `json:"best,omitempty"` // lower bound of Wilson score interval for upvotes ratio
 	Timestamp   time.Time              `json:"time" bson:"time"`
 	Edit        *Edit                  `json:"edit,omitempty" bson:"edit,omitempty"` // pointer to have empty default in json response
 	Pin         bool                   `json:"pin,omitempty" bson:"pin,omitempty"`
 	Deleted     bool                   `json:"delete,omitempty" bson:"delete"`
 	Imported    bool                   `json:"imported,omitempty" bson:"imported"`
 	PostTitle   string                 `json:"title,omitempty" bson:"title"`
+	Pending     bool                   `json:"pending,omitempty" bson:"pending"`               // waiting for admin's approval
+	Reactions   map[string]int         `json:"reactions,omitempty" bson:"reactions,omitempty"` // number of reactions per emoji
+	Reactors    map[string][]string    `json:"reactors,omitempty" bson:"reactors,omitempty"`   // users reacted per emoji, admins only
+	MyReactions []string               `json:"my_reactions,omitempty" bson:"-"`                // reactions of the current user
+	Mentions    []string               `json:"mentions,omitempty"`                             // ids of mentioned users
+	New         bool                   `json:"new,omitempty"`                                  // posted after the current user's last visit
 }
 
 // Locator keeps site and url of the post
@@ -45,11 +53,14 @@ type Edit struct {
 
 // PostInfo holds summary for given post url
 type PostInfo struct {
-	URL      string    `json:"url"`
-	Count    int       `json:"count"`
-	ReadOnly bool      `json:"read_only,omitempty" bson:"read_only,omitempty"`
-	FirstTS  time.Time `json:"first_time,omitempty" bson:"first_time,omitempty"`
-	LastTS   time.Time `json:"last_time,omitempty" bson:"last_time,omitempty"`
+	URL       string    `json:"url"`
+	Count     int       `json:"count"`                                      // published comments, pending ones not included
+	Pending   int       `json:"pending,omitempty" bson:"pending,omitempty"` // comments waiting for admin's approval
+	ReadOnly  bool      `json:"read_only,omitempty" bson:"read_only,omitempty"`
+	FirstTS   time.Time `json:"first_time,omitempty" bson:"first_time,omitempty"`
+	LastTS    time.Time `json:"last_time,omitempty" bson:"last_time,omitempty"`
+	Unread    int       `json:"unread,omitempty"`    // comments since the current user's last visit
+	Followers int       `json:"followers,omitempty"` // users subscribed to new comments of the post
 }
 
 // BlockedUser holds id and ts for blocked user
@@ -86,9 +97,40 @@ func (c *Comment) PrepareUntrusted() {
 	c.Votes = make(map[string]bool)
 	c.VotedIPs = make(map[string]VotedIPInfo)
 	c.Score = 0
+	c.Best = 0
 	c.Edit = nil
 	c.Pin = false
 	c.Deleted = false
+	c.Pending = false
+	c.Reactions = nil
+	c.Reactors = nil
+	c.MyReactions = nil
+	c.Mentions = nil
+	c.New = false
+}
+
+// TotalReactions returns number of reactions of all kinds
+func (c *Comment) TotalReactions() (res int) {
+	for _, n := range c.Reactions {
+		res += n
+	}
+	return res
+}
+
+// Hot returns score with time decay, i.e. the new comment ranked higher than the old one with a bit better score.
+// Every 12.5 hours of age worth the same as 10x score.
+// source - https://github.com/reddit-archive/reddit/blob/master/r2/r2/lib/db/_sorts.pyx#L47
+func (c *Comment) Hot() float64 {
+	order := math.Log10(math.Max(math.Abs(float64(c.Score)), 1))
+	sign := 0.0
+	switch {
+	case c.Score > 0:
+		sign = 1
+	case c.Score < 0:
+		sign = -1
+	}
+	seconds := float64(c.Timestamp.Unix() - 1134028003)
+	return sign*order + seconds/45000
 }
 
 // SetDeleted clears comment info, reset to deleted state. hard flag will clear all user info as well
@@ -99,6 +141,8 @@ func (c *Comment) SetDeleted(mode DeleteMode) {
 	c.Votes = map[string]bool{}
 	c.VotedIPs = make(map[string]VotedIPInfo)
 	c.Edit = nil
+	c.Reactions = nil
+	c.Reactors = nil
 	c.Deleted = true
 	c.Pin = false
 
@@ -121,6 +165,7 @@ func (c *Comment) Sanitize() {
 		"|vi|vm|l|ld|s|sa|sb|sc|dl|sd|s2|se|sh|si|sx|sr|s1|ss|m|mb|mf|mh|mi|il" +
 		"|mo|o|ow|p|c|ch|cm|cp|cpf|c1|cs|g|gd|ge|gr|gh|gi|go|gp|gs|gu|gt|gl)$"
 	p.AllowAttrs("class").Matching(regexp.MustCompile(codeSpanClassRegex)).OnElements("span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile("^mention$")).OnElements("a")
 	p.AllowAttrs("loading").Matching(regexp.MustCompile("^(lazy|eager)$")).OnElements("img")
 	c.Text = p.Sanitize(c.Text)
 	c.Orig = p.Sanitize(c.Orig)
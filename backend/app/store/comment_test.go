@@ -89,10 +89,15 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 		ID:        "123",
 		Locator:   Locator{SiteID: "site", URL: "url"},
 		Score:     10,
+		Best:      0.5,
 		Pin:       true,
 		Deleted:   true,
+		Pending:   true,
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
+		Reactions: map[string]int{"👍": 1},
+		Reactors:  map[string][]string{"👍": {"uu"}},
+		New:       true,
 	}
 
 	comment.PrepareUntrusted()
@@ -100,15 +105,33 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 	assert.Equal(t, "p123", comment.ParentID)
 	assert.Equal(t, "blah", comment.Text)
 	assert.Equal(t, 0, comment.Score)
+	assert.Equal(t, 0.0, comment.Best)
 	assert.Equal(t, false, comment.Pin)
 	assert.Equal(t, time.Time{}, comment.Timestamp)
 	assert.Equal(t, false, comment.Deleted)
+	assert.Equal(t, false, comment.Pending)
+	assert.Nil(t, comment.Reactions)
+	assert.Nil(t, comment.Reactors)
+	assert.False(t, comment.New)
 	assert.Equal(t, make(map[string]bool), comment.Votes)
 	assert.Equal(t, make(map[string]VotedIPInfo), comment.VotedIPs)
 	assert.Equal(t, User{ID: "username"}, comment.User)
 
 }
 
+func TestComment_Hot(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := Comment{Score: 10, Timestamp: ts}
+	fresh := Comment{Score: 2, Timestamp: ts.Add(24 * time.Hour)}
+	negative := Comment{Score: -10, Timestamp: ts.Add(24 * time.Hour)}
+	zero := Comment{Timestamp: ts}
+
+	assert.True(t, fresh.Hot() > old.Hot(), "day-old comment ranked lower than the fresh one with lower score")
+	assert.True(t, old.Hot() > zero.Hot())
+	assert.True(t, negative.Hot() < fresh.Hot())
+	assert.InDelta(t, zero.Hot()+1, old.Hot(), 0.0001, "10x score adds 1")
+}
+
 func TestComment_SetDeleted(t *testing.T) {
 	comment := Comment{
 		Text:      `blah`,
@@ -121,7 +144,9 @@ func TestComment_SetDeleted(t *testing.T) {
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
 		Pin:       true,
+		Reactions: map[string]int{"👍": 2},
 	}
+	assert.Equal(t, 2, comment.TotalReactions())
 
 	comment.SetDeleted(SoftDelete)
 
@@ -133,6 +158,7 @@ func TestComment_SetDeleted(t *testing.T) {
 	assert.True(t, comment.Deleted)
 	assert.Nil(t, comment.Edit)
 	assert.False(t, comment.Pin)
+	assert.Equal(t, 0, comment.TotalReactions())
 	assert.Equal(t, User{Name: "username", ID: "userid", Picture: "pic", Admin: false, Blocked: false, IP: "123"}, comment.User)
 }
 
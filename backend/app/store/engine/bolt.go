@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,6 +41,8 @@ const (
 	infoBucketName        = "info"
 	readonlyBucketName    = "readonly"
 	verifiedBucketName    = "verified"
+	followersBucketName   = "followers" // index of users following the post, bucket per post url
+	activityBucketName    = "activity"  // index of comments voted, reacted or mentioned by the user, bucket per user id
 
 	tsNano = "2006-01-02T15:04:05.000000000Z07:00"
 )
@@ -76,6 +79,14 @@ func NewBoltDB(options bolt.Options, sites ...BoltSite) (*BoltDB, error) {
 			return nil, errors.Wrap(err, "failed to create top level bucket)")
 		}
 
+		if err = makeFollowersIndex(db); err != nil {
+			return nil, errors.Wrap(err, "failed to make followers index")
+		}
+
+		if err = makeActivityIndex(db); err != nil {
+			return nil, errors.Wrap(err, "failed to make activity index")
+		}
+
 		result.dbs[site.SiteID] = db
 		log.Printf("[DEBUG] bolt store created for %s", site.SiteID)
 	}
@@ -127,6 +138,10 @@ func (b *BoltDB) Create(comment store.Comment) (commentID string, err error) {
 			return errors.Wrapf(err, "failed to put user comment %s for %s", comment.ID, comment.User.ID)
 		}
 
+		if err = indexActivity(tx, ref, store.Comment{}, comment); err != nil {
+			return err
+		}
+
 		// set info with the count for post url
 		if _, err = b.setInfo(tx, comment); err != nil {
 			return errors.Wrapf(err, "failed to set info for %s", comment.Locator)
@@ -211,7 +226,8 @@ func (b *BoltDB) Flag(req FlagRequest) (val bool, err error) {
 // and all site's details listing under the same function (and not to extend interface by two separate functions).
 func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 	switch req.Detail {
-	case UserEmail:
+	case UserEmail, UserMentionsOff, UserLastSeen, UserFollows, UserDigest, UserTelegram, UserWebPush, UserLocale, UserLinked,
+		UserSessions, UserRevoked:
 		if req.UserID == "" {
 			return nil, errors.New("userid cannot be empty in request for single detail")
 		}
@@ -228,6 +244,11 @@ func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 			return b.listDetails(req.Locator)
 		}
 		return nil, errors.New("unsupported request with userdetail all")
+	case PostFollowers:
+		if req.Update == "" && req.UserID == "" && req.Locator.URL != "" {
+			return b.listFollowers(req.Locator)
+		}
+		return nil, errors.New("unsupported request with userdetail followers")
 	default:
 		return nil, errors.Errorf("unsupported detail %q", req.Detail)
 	}
@@ -255,6 +276,27 @@ func (b *BoltDB) Update(comment store.Comment) error {
 		if e != nil {
 			return e
 		}
+		cur := store.Comment{}
+		if e = b.load(bucket, comment.ID, &cur); e == nil && !cur.Deleted && cur.Pending != comment.Pending {
+			// comment approved or sent back to approval, moved between post's counts
+			e = b.updateInfo(tx, comment.Locator.URL, func(info *store.PostInfo) {
+				if !comment.Pending {
+					if info.Pending > 0 { // pending comments made before the pending count kept are counted already
+						info.Pending--
+						info.Count++
+					}
+					return
+				}
+				info.Count--
+				info.Pending++
+			})
+			if e != nil {
+				return errors.Wrapf(e, "failed to update counts for %s", comment.Locator.URL)
+			}
+		}
+		if e = indexActivity(tx, b.makeRef(comment), cur, comment); e != nil {
+			return e
+		}
 		return b.save(bucket, comment.ID, comment)
 	})
 }
@@ -420,6 +462,156 @@ func (b *BoltDB) Delete(req DeleteRequest) error {
 	return errors.Errorf("invalid delete request %+v", req)
 }
 
+// Merge moves comments, votes, reactions, details and flags of FromUserID to ToUserID in one transaction.
+// Details of the merged user replaced by UserLinked pointing to ToUserID.
+func (b *BoltDB) Merge(req MergeRequest) error {
+	if req.FromUserID == "" || req.ToUserID == "" || req.FromUserID == req.ToUserID {
+		return errors.Errorf("invalid merge request %+v", req)
+	}
+	bdb, e := b.db(req.Locator.SiteID)
+	if e != nil {
+		return e
+	}
+
+	return bdb.Update(func(tx *bolt.Tx) error {
+		if err := b.mergeComments(tx, req.FromUserID, req.ToUserID); err != nil {
+			return err
+		}
+
+		// move references to user's comments
+		usersBkt := tx.Bucket([]byte(userBucketName))
+		if fromBkt := usersBkt.Bucket([]byte(req.FromUserID)); fromBkt != nil {
+			toBkt, err := b.getUserBucket(tx, req.ToUserID)
+			if err != nil {
+				return err
+			}
+			err = fromBkt.ForEach(func(k, v []byte) error {
+				return toBkt.Put(k, v)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "can't move comment references of %s", req.FromUserID)
+			}
+			if err = usersBkt.DeleteBucket([]byte(req.FromUserID)); err != nil {
+				return errors.Wrapf(err, "can't delete user bucket %s", req.FromUserID)
+			}
+		}
+
+		// combine details, the merged user keeps the link only
+		detailsBkt := tx.Bucket([]byte(userDetailsBucketName))
+		from, to := UserDetailEntry{}, UserDetailEntry{}
+		if v := detailsBkt.Get([]byte(req.FromUserID)); v != nil {
+			if err := json.Unmarshal(v, &from); err != nil {
+				return errors.Wrapf(err, "failed to unmarshal details of %s", req.FromUserID)
+			}
+		}
+		if v := detailsBkt.Get([]byte(req.ToUserID)); v != nil {
+			if err := json.Unmarshal(v, &to); err != nil {
+				return errors.Wrapf(err, "failed to unmarshal details of %s", req.ToUserID)
+			}
+		}
+		merged := MergeUserDetails(to, from)
+		merged.UserID, merged.Linked = req.ToUserID, to.Linked
+		if err := b.save(detailsBkt, req.ToUserID, merged); err != nil {
+			return errors.Wrapf(err, "failed to save details of %s", req.ToUserID)
+		}
+		link := UserDetailEntry{UserID: req.FromUserID, Linked: req.ToUserID}
+		if err := b.save(detailsBkt, req.FromUserID, link); err != nil {
+			return errors.Wrapf(err, "failed to save details of %s", req.FromUserID)
+		}
+		if err := indexFollowers(tx, req.FromUserID, from.Follows, nil); err != nil {
+			return err
+		}
+		if err := indexFollowers(tx, req.ToUserID, to.Follows, merged.Follows); err != nil {
+			return err
+		}
+
+		return b.mergeFlags(tx, req.FromUserID, req.ToUserID)
+	})
+}
+
+// mergeComments replaces fromID with toID in comments made, voted, reacted or mentioned by fromID,
+// found by user's comments references and activity index, and moves the index to toID. Should run in update tx
+func (b *BoltDB) mergeComments(tx *bolt.Tx, fromID, toID string) error {
+	refs := map[string]bool{}
+	if userBkt := tx.Bucket([]byte(userBucketName)).Bucket([]byte(fromID)); userBkt != nil {
+		_ = userBkt.ForEach(func(_, ref []byte) error {
+			refs[string(ref)] = true
+			return nil
+		})
+	}
+	activityBkt := tx.Bucket([]byte(activityBucketName))
+	fromBkt := activityBkt.Bucket([]byte(fromID))
+	if fromBkt != nil {
+		_ = fromBkt.ForEach(func(ref, _ []byte) error {
+			refs[string(ref)] = true
+			return nil
+		})
+	}
+
+	for ref := range refs {
+		url, commentID, err := b.parseRef([]byte(ref))
+		if err != nil {
+			return errors.Wrapf(err, "can't parse reference %s", ref)
+		}
+		postBkt, err := b.getPostBucket(tx, url)
+		if err != nil {
+			continue // post removed, nothing to merge
+		}
+		comment := store.Comment{}
+		if err = b.load(postBkt, commentID, &comment); err != nil {
+			continue // comment removed, nothing to merge
+		}
+		if !MergeComment(&comment, fromID, toID) {
+			continue
+		}
+		if err = b.save(postBkt, comment.ID, comment); err != nil {
+			return errors.Wrapf(err, "can't save merged comment %s", comment.ID)
+		}
+	}
+
+	if fromBkt == nil {
+		return nil
+	}
+	toBkt, err := activityBkt.CreateBucketIfNotExists([]byte(toID))
+	if err != nil {
+		return errors.Wrapf(err, "can't get activity bucket %s", toID)
+	}
+	err = fromBkt.ForEach(func(ref, _ []byte) error {
+		return toBkt.Put(ref, []byte{})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can't move activity of %s", fromID)
+	}
+	return errors.Wrapf(activityBkt.DeleteBucket([]byte(fromID)), "can't delete activity bucket %s", fromID)
+}
+
+// mergeFlags moves blocked and verified flags of fromID to toID, the later block wins. Should run in update tx
+func (b *BoltDB) mergeFlags(tx *bolt.Tx, fromID, toID string) error {
+	for _, name := range []string{blocksBucketName, verifiedBucketName} {
+		bkt := tx.Bucket([]byte(name))
+		from := bkt.Get([]byte(fromID))
+		if from == nil {
+			continue
+		}
+		to := bkt.Get([]byte(toID))
+		later := false
+		if to != nil && name == blocksBucketName {
+			fromTS, e1 := time.Parse(tsNano, string(from))
+			toTS, e2 := time.Parse(tsNano, string(to))
+			later = e1 == nil && (e2 != nil || fromTS.After(toTS))
+		}
+		if to == nil || later {
+			if err := bkt.Put([]byte(toID), append([]byte{}, from...)); err != nil {
+				return errors.Wrapf(err, "failed to merge %s flag of %s", name, fromID)
+			}
+		}
+		if err := bkt.Delete([]byte(fromID)); err != nil {
+			return errors.Wrapf(err, "failed to delete %s flag of %s", name, fromID)
+		}
+	}
+	return nil
+}
+
 // Close boltdb store
 func (b *BoltDB) Close() error {
 	errs := new(multierror.Error)
@@ -668,6 +860,29 @@ func (b *BoltDB) getUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 			switch req.Detail {
 			case UserEmail:
 				result = []UserDetailEntry{{UserID: req.UserID, Email: entry.Email}}
+			case UserMentionsOff:
+				result = []UserDetailEntry{{UserID: req.UserID, MentionsOff: entry.MentionsOff}}
+			case UserLastSeen:
+				result = []UserDetailEntry{{UserID: req.UserID, LastSeen: entry.LastSeen}}
+				if ts, ok := entry.LastSeen[req.Locator.URL]; ok && req.Locator.URL != "" {
+					result[0].LastSeen = map[string]int64{req.Locator.URL: ts}
+				}
+			case UserFollows:
+				result = []UserDetailEntry{{UserID: req.UserID, Follows: entry.Follows}}
+			case UserDigest:
+				result = []UserDetailEntry{{UserID: req.UserID, Digest: entry.Digest}}
+			case UserTelegram:
+				result = []UserDetailEntry{{UserID: req.UserID, Telegram: entry.Telegram}}
+			case UserWebPush:
+				result = []UserDetailEntry{{UserID: req.UserID, WebPush: entry.WebPush}}
+			case UserLocale:
+				result = []UserDetailEntry{{UserID: req.UserID, Locale: entry.Locale}}
+			case UserLinked:
+				result = []UserDetailEntry{{UserID: req.UserID, Linked: entry.Linked}}
+			case UserSessions:
+				result = []UserDetailEntry{{UserID: req.UserID, Sessions: entry.Sessions}}
+			case UserRevoked:
+				result = []UserDetailEntry{{UserID: req.UserID, Revoked: entry.Revoked}}
 			}
 		}
 		return nil
@@ -684,6 +899,43 @@ func (b *BoltDB) setUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 		return result, e
 	}
 
+	var lastSeen int64
+	if req.Detail == UserLastSeen {
+		if req.Locator.URL == "" {
+			return nil, errors.New("url cannot be empty in request for last seen")
+		}
+		if lastSeen, err = strconv.ParseInt(req.Update, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "can't parse last seen time %q", req.Update)
+		}
+	}
+	if req.Detail == UserFollows && req.Locator.URL == "" {
+		return nil, errors.New("url cannot be empty in request for follows")
+	}
+	var revoked int64
+	if req.Detail == UserRevoked {
+		if revoked, err = strconv.ParseInt(req.Update, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "can't parse revocation time %q", req.Update)
+		}
+	}
+	var sess store.Session
+	if req.Detail == UserSessions {
+		if err = json.Unmarshal([]byte(req.Update), &sess); err != nil {
+			return nil, errors.Wrap(err, "can't parse session")
+		}
+		if sess.ID == "" {
+			return nil, errors.New("id cannot be empty in request for session")
+		}
+	}
+	var push store.PushSubscription
+	if req.Detail == UserWebPush {
+		if err = json.Unmarshal([]byte(req.Update), &push); err != nil {
+			return nil, errors.Wrap(err, "can't parse push subscription")
+		}
+		if push.Endpoint == "" {
+			return nil, errors.New("endpoint cannot be empty in request for push subscription")
+		}
+	}
+
 	var entry UserDetailEntry
 	err = bdb.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(userDetailsBucketName))
@@ -704,15 +956,73 @@ func (b *BoltDB) setUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 		// new entry to be created, need to set UserID for it
 		entry.UserID = req.UserID
 	}
+	follows := entry.Follows
 
 	switch req.Detail {
 	case UserEmail:
 		entry.Email = req.Update
+	case UserMentionsOff:
+		entry.MentionsOff = req.Update == "true"
+	case UserDigest:
+		entry.Digest = req.Update
+	case UserTelegram:
+		entry.Telegram = req.Update
+	case UserLocale:
+		entry.Locale = req.Update
+	case UserLinked:
+		entry.Linked = req.Update
+	case UserRevoked:
+		entry.Revoked = revoked
+	case UserSessions:
+		entry.Sessions = addSession(entry.Sessions, sess)
+	case UserLastSeen:
+		if entry.LastSeen == nil {
+			entry.LastSeen = map[string]int64{}
+		}
+		entry.LastSeen[req.Locator.URL] = lastSeen
+		if req.TTL > 0 { // drop expired posts to keep the entry compact
+			expired := time.Now().Add(-req.TTL).Unix()
+			for url, ts := range entry.LastSeen {
+				if ts < expired {
+					delete(entry.LastSeen, url)
+				}
+			}
+		}
+	case UserFollows:
+		updated := make([]string, 0, len(entry.Follows)+1)
+		for _, url := range entry.Follows {
+			if url != req.Locator.URL {
+				updated = append(updated, url)
+			}
+		}
+		if req.Update == "true" {
+			updated = append(updated, req.Locator.URL)
+		}
+		entry.Follows = updated
+	case UserWebPush:
+		subs := make([]store.PushSubscription, 0, len(entry.WebPush)+1)
+		for _, sub := range entry.WebPush {
+			if sub.Endpoint != push.Endpoint {
+				subs = append(subs, sub)
+			}
+		}
+		if push.Keys.P256dh != "" {
+			subs = append(subs, push)
+		}
+		if len(subs) > maxPushSubscriptions { // drop the oldest subscriptions
+			subs = subs[len(subs)-maxPushSubscriptions:]
+		}
+		entry.WebPush = subs
 	}
 
 	err = bdb.Update(func(tx *bolt.Tx) error {
-		err = b.save(tx.Bucket([]byte(userDetailsBucketName)), req.UserID, entry)
-		return errors.Wrapf(err, "failed to update detail %s for %s in %s", req.Detail, req.UserID, req.Locator.SiteID)
+		if err = b.save(tx.Bucket([]byte(userDetailsBucketName)), req.UserID, entry); err != nil {
+			return errors.Wrapf(err, "failed to update detail %s for %s in %s", req.Detail, req.UserID, req.Locator.SiteID)
+		}
+		if req.Detail != UserFollows {
+			return nil
+		}
+		return indexFollowers(tx, req.UserID, follows, entry.Follows)
 	})
 
 	return []UserDetailEntry{entry}, err
@@ -726,9 +1036,9 @@ func (b *BoltDB) listDetails(loc store.Locator) (result []UserDetailEntry, err e
 	}
 
 	err = bdb.View(func(tx *bolt.Tx) error {
-		var entry UserDetailEntry
 		bucket := tx.Bucket([]byte(userDetailsBucketName))
 		return bucket.ForEach(func(userID, value []byte) error {
+			var entry UserDetailEntry // new entry for each user, unmarshal into the same one would share maps
 			if err = json.Unmarshal(value, &entry); err != nil {
 				return errors.Wrap(e, "failed to unmarshal entry")
 			}
@@ -739,6 +1049,149 @@ func (b *BoltDB) listDetails(loc store.Locator) (result []UserDetailEntry, err e
 	return result, err
 }
 
+// listFollowers lists details of users following the post, with email and follows only
+func (b *BoltDB) listFollowers(loc store.Locator) (result []UserDetailEntry, err error) {
+	bdb, e := b.db(loc.SiteID)
+	if e != nil {
+		return result, e
+	}
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		postBkt := tx.Bucket([]byte(followersBucketName)).Bucket([]byte(loc.URL))
+		if postBkt == nil {
+			return nil
+		}
+		detailsBkt := tx.Bucket([]byte(userDetailsBucketName))
+		return postBkt.ForEach(func(userID, _ []byte) error {
+			var entry UserDetailEntry
+			if err = b.load(detailsBkt, string(userID), &entry); err != nil {
+				return errors.Wrapf(err, "failed to load details of follower %s", userID)
+			}
+			result = append(result, UserDetailEntry{UserID: entry.UserID, Email: entry.Email, Follows: entry.Follows})
+			return nil
+		})
+	})
+	return result, err
+}
+
+// indexFollowers updates followers index for the user's follows changed from before to after.
+// Should be called in the same transaction with the update of user details.
+func indexFollowers(tx *bolt.Tx, userID string, before, after []string) error {
+	followersBkt := tx.Bucket([]byte(followersBucketName))
+	keep := map[string]bool{}
+	for _, url := range after {
+		keep[url] = true
+		postBkt, err := followersBkt.CreateBucketIfNotExists([]byte(url))
+		if err != nil {
+			return errors.Wrapf(err, "failed to make followers bucket for %s", url)
+		}
+		if err = postBkt.Put([]byte(userID), []byte{}); err != nil {
+			return errors.Wrapf(err, "failed to add follower %s of %s", userID, url)
+		}
+	}
+	for _, url := range before {
+		if keep[url] {
+			continue
+		}
+		postBkt := followersBkt.Bucket([]byte(url))
+		if postBkt == nil {
+			continue
+		}
+		if err := postBkt.Delete([]byte(userID)); err != nil {
+			return errors.Wrapf(err, "failed to remove follower %s of %s", userID, url)
+		}
+		if k, _ := postBkt.Cursor().First(); k == nil { // no followers left
+			if err := followersBkt.DeleteBucket([]byte(url)); err != nil {
+				return errors.Wrapf(err, "failed to delete followers bucket for %s", url)
+			}
+		}
+	}
+	return nil
+}
+
+// makeFollowersIndex creates followers index from users details, for stores made before the index was added
+func makeFollowersIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(followersBucketName)) != nil {
+			return nil
+		}
+		if _, err := tx.CreateBucket([]byte(followersBucketName)); err != nil {
+			return errors.Wrapf(err, "failed to create top level bucket %s", followersBucketName)
+		}
+		return tx.Bucket([]byte(userDetailsBucketName)).ForEach(func(userID, value []byte) error {
+			var entry UserDetailEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return errors.Wrapf(err, "failed to unmarshal details of %s", userID)
+			}
+			return indexFollowers(tx, string(userID), nil, entry.Follows)
+		})
+	})
+}
+
+// indexActivity adds reference to the comment to activity index of users voted, reacted or mentioned in the comment
+// after the change, and missing before it. The index never shrinks, stale references skipped on use. Should run in update tx
+func indexActivity(tx *bolt.Tx, ref []byte, before, after store.Comment) error {
+	had := activityUsers(before)
+	activityBkt := tx.Bucket([]byte(activityBucketName))
+	for userID := range activityUsers(after) {
+		if had[userID] {
+			continue
+		}
+		userBkt, err := activityBkt.CreateBucketIfNotExists([]byte(userID))
+		if err != nil {
+			return errors.Wrapf(err, "can't get activity bucket %s", userID)
+		}
+		if err = userBkt.Put(ref, []byte{}); err != nil {
+			return errors.Wrapf(err, "can't put activity reference %s for %s", ref, userID)
+		}
+	}
+	return nil
+}
+
+// activityUsers returns users voted, reacted or mentioned in the comment
+func activityUsers(c store.Comment) map[string]bool {
+	res := map[string]bool{}
+	for userID := range c.Votes {
+		res[userID] = true
+	}
+	for _, users := range c.Reactors {
+		for _, userID := range users {
+			res[userID] = true
+		}
+	}
+	for _, userID := range c.Mentions {
+		res[userID] = true
+	}
+	return res
+}
+
+// makeActivityIndex creates activity index from all comments, for stores made before the index was added
+func makeActivityIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(activityBucketName)) != nil {
+			return nil
+		}
+		if _, err := tx.CreateBucket([]byte(activityBucketName)); err != nil {
+			return errors.Wrapf(err, "failed to create top level bucket %s", activityBucketName)
+		}
+		postsBkt := tx.Bucket([]byte(postsBucketName))
+		return postsBkt.ForEach(func(postURL, _ []byte) error {
+			postBkt := postsBkt.Bucket(postURL)
+			if postBkt == nil {
+				return nil
+			}
+			return postBkt.ForEach(func(k, v []byte) error {
+				comment := store.Comment{}
+				if err := json.Unmarshal(v, &comment); err != nil {
+					return errors.Wrapf(err, "failed to unmarshal comment %s", k)
+				}
+				ref := []byte(fmt.Sprintf("%s!!%s", postURL, k)) // the same as makeRef
+				return indexActivity(tx, ref, store.Comment{}, comment)
+			})
+		})
+	})
+}
+
 // deleteUserDetail deletes requested UserDetail or whole UserDetailEntry
 func (b *BoltDB) deleteUserDetail(bdb *bolt.DB, userID string, userDetail UserDetail) error {
 	var entry UserDetailEntry
@@ -757,30 +1210,57 @@ func (b *BoltDB) deleteUserDetail(bdb *bolt.DB, userID string, userDetail UserDe
 		return err
 	}
 
-	if entry == (UserDetailEntry{}) {
+	if entry.UserID == "" {
 		// absent entry means that we should not do anything
 		return nil
 	}
+	follows := entry.Follows
 
 	switch userDetail {
 	case UserEmail:
 		entry.Email = ""
+	case UserMentionsOff:
+		entry.MentionsOff = false
+	case UserLastSeen:
+		entry.LastSeen = nil
+	case UserFollows:
+		entry.Follows = nil
+	case UserDigest:
+		entry.Digest = ""
+	case UserTelegram:
+		entry.Telegram = ""
+	case UserWebPush:
+		entry.WebPush = nil
+	case UserLocale:
+		entry.Locale = ""
+	case UserLinked:
+		entry.Linked = ""
+	case UserSessions:
+		entry.Sessions = nil
+	case UserRevoked:
+		entry.Revoked = 0
 	case AllUserDetails:
 		entry = UserDetailEntry{UserID: userID}
 	}
 
-	if entry == (UserDetailEntry{UserID: userID}) {
+	if entry.Email == "" && !entry.MentionsOff && entry.Digest == "" && entry.Telegram == "" && entry.Locale == "" &&
+		entry.Linked == "" && entry.Revoked == 0 && len(entry.LastSeen) == 0 && len(entry.Follows) == 0 &&
+		len(entry.WebPush) == 0 && len(entry.Sessions) == 0 {
 		// if entry doesn't have non-empty details, we should delete it
 		return bdb.Update(func(tx *bolt.Tx) error {
-			err := tx.Bucket([]byte(userDetailsBucketName)).Delete([]byte(userID))
-			return errors.Wrapf(err, "failed to delete user detail %s for %s", userDetail, userID)
+			if err := tx.Bucket([]byte(userDetailsBucketName)).Delete([]byte(userID)); err != nil {
+				return errors.Wrapf(err, "failed to delete user detail %s for %s", userDetail, userID)
+			}
+			return indexFollowers(tx, userID, follows, nil)
 		})
 	}
 
 	return bdb.Update(func(tx *bolt.Tx) error {
 		// updated entry is not empty and we need to store it's updated copy
-		err := b.save(tx.Bucket([]byte(userDetailsBucketName)), userID, entry)
-		return errors.Wrapf(err, "failed to update detail %s for %s", userDetail, userID)
+		if err := b.save(tx.Bucket([]byte(userDetailsBucketName)), userID, entry); err != nil {
+			return errors.Wrapf(err, "failed to update detail %s for %s", userDetail, userID)
+		}
+		return indexFollowers(tx, userID, follows, entry.Follows)
 	})
 }
 
@@ -799,8 +1279,15 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 		}
 
 		if !comment.Deleted {
-			// decrement comments count for post url
-			if _, e = b.count(tx, comment.Locator.URL, -1); e != nil {
+			// decrement comments count for post url, pending comments counted separately
+			e = b.updateInfo(tx, comment.Locator.URL, func(info *store.PostInfo) {
+				if comment.Pending && info.Pending > 0 {
+					info.Pending--
+					return
+				}
+				info.Count--
+			})
+			if e != nil {
 				return errors.Wrapf(e, "failed to decrement count for %s", comment.Locator)
 			}
 		}
@@ -826,7 +1313,8 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 func (b *BoltDB) deleteAll(bdb *bolt.DB, siteID string) error {
 
 	// delete all buckets except blocked users
-	toDelete := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName, infoBucketName}
+	toDelete := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName, infoBucketName,
+		followersBucketName, activityBucketName}
 
 	// delete top-level buckets
 	err := bdb.Update(func(tx *bolt.Tx) error {
@@ -998,6 +1486,17 @@ func (b *BoltDB) count(tx *bolt.Tx, postURL string, val int) (int, error) {
 	return info.Count, b.save(infoBkt, postURL, &info)
 }
 
+// updateInfo loads info of postURL, empty if not stored yet, modifies it with fn and saves
+func (b *BoltDB) updateInfo(tx *bolt.Tx, postURL string, fn func(info *store.PostInfo)) error {
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+	info := store.PostInfo{}
+	if err := b.load(infoBkt, postURL, &info); err != nil {
+		info = store.PostInfo{}
+	}
+	fn(&info)
+	return b.save(infoBkt, postURL, &info)
+}
+
 func (b *BoltDB) setInfo(tx *bolt.Tx, comment store.Comment) (store.PostInfo, error) {
 	infoBkt := tx.Bucket([]byte(infoBucketName))
 	info := store.PostInfo{}
@@ -1009,7 +1508,11 @@ func (b *BoltDB) setInfo(tx *bolt.Tx, comment store.Comment) (store.PostInfo, er
 			LastTS:  comment.Timestamp,
 		}
 	}
-	info.Count++
+	if comment.Pending {
+		info.Pending++
+	} else {
+		info.Count++
+	}
 	info.LastTS = comment.Timestamp
 	err := b.save(infoBkt, comment.Locator.URL, &info)
 	return info, err
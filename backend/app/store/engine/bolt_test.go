@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -320,6 +322,52 @@ func TestBoltDB_CountUser(t *testing.T) {
 	assert.EqualError(t, err, `no comments for user userZ in store for radio-t site`)
 }
 
+func TestBoltDB_CountPending(t *testing.T) {
+	var b, teardown = prep(t) // two comments for https://radio-t.com
+	defer teardown()
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	for _, id := range []string{"id-3", "id-4"} {
+		_, err := b.Create(store.Comment{ID: id, Text: "pending", Pending: true, Timestamp: time.Now(),
+			Locator: locator, User: store.User{ID: "user2", Name: "user name 2"}})
+		require.NoError(t, err)
+	}
+
+	c, err := b.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 2, c, "pending comments not counted")
+	info, err := b.Info(InfoRequest{Locator: locator})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(info))
+	assert.Equal(t, 2, info[0].Count)
+	assert.Equal(t, 2, info[0].Pending)
+
+	// approve one
+	comment, err := b.Get(getReq(locator, "id-3"))
+	require.NoError(t, err)
+	comment.Pending = false
+	require.NoError(t, b.Update(comment))
+	info, err = b.Info(InfoRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 3, info[0].Count)
+	assert.Equal(t, 1, info[0].Pending)
+
+	// update of approved comment doesn't change counts
+	comment.Text = "edited"
+	require.NoError(t, b.Update(comment))
+
+	// delete pending and approved
+	require.NoError(t, b.Delete(DeleteRequest{Locator: locator, CommentID: "id-4", DeleteMode: store.HardDelete}))
+	require.NoError(t, b.Delete(DeleteRequest{Locator: locator, CommentID: "id-3", DeleteMode: store.SoftDelete}))
+	info, err = b.Info(InfoRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 2, info[0].Count)
+	assert.Equal(t, 0, info[0].Pending)
+	c, err = b.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 2, c)
+}
+
 func TestBoltDB_InfoPost(t *testing.T) {
 	b, teardown := prep(t) // two comments for https://radio-t.com
 	defer teardown()
@@ -665,6 +713,186 @@ func TestBoltDB_UserDetail(t *testing.T) {
 		}
 		assert.ElementsMatch(t, x.expected, result, "Result should match expected for case %d", i)
 	}
+
+	// mentions opt-out kept along with email
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserMentionsOff, Update: "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com", MentionsOff: true}}, result)
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserMentionsOff})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", MentionsOff: true}}, result)
+	assert.NoError(t, b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", UserDetail: UserMentionsOff}))
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserEmail})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com"}}, result, "email kept after opt-out removal")
+
+	// digest period
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserDigest, Update: "daily"})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com", Digest: "daily"}}, result)
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserDigest})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Digest: "daily"}}, result)
+	assert.NoError(t, b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", UserDetail: UserDigest}))
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserDigest})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1"}}, result)
+
+	// telegram chat
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserTelegram, Update: "12345"})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "test@example.com", Telegram: "12345"}}, result)
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserTelegram})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Telegram: "12345"}}, result)
+	assert.NoError(t, b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", UserDetail: UserTelegram}))
+	result, err = b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserTelegram})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1"}}, result)
+}
+
+func TestBoltDB_UserWebPush(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	req := func(update string) UserDetailRequest {
+		return UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", Detail: UserWebPush, Update: update}
+	}
+	sub := func(endpoint string) store.PushSubscription {
+		res := store.PushSubscription{Endpoint: endpoint}
+		res.Keys.P256dh, res.Keys.Auth = "key-"+endpoint, "auth-"+endpoint
+		return res
+	}
+
+	_, err := b.UserDetail(req(`{"endpoint":"https://push.example.com/1","keys":{"p256dh":"key-https://push.example.com/1","auth":"auth-https://push.example.com/1"}}`))
+	require.NoError(t, err)
+	_, err = b.UserDetail(req(`{"endpoint":"https://push.example.com/2","keys":{"p256dh":"key-https://push.example.com/2","auth":"auth-https://push.example.com/2"}}`))
+	require.NoError(t, err)
+	_, err = b.UserDetail(req(`{"endpoint":"https://push.example.com/1","keys":{"p256dh":"key-https://push.example.com/1","auth":"auth-https://push.example.com/1"}}`))
+	require.NoError(t, err, "same subscription again")
+
+	result, err := b.UserDetail(req(""))
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", WebPush: []store.PushSubscription{sub("https://push.example.com/2"),
+		sub("https://push.example.com/1")}}}, result, "re-subscribed endpoint moved to the end")
+
+	_, err = b.UserDetail(req(`{"endpoint":"https://push.example.com/2"}`))
+	require.NoError(t, err)
+	result, err = b.UserDetail(req(""))
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", WebPush: []store.PushSubscription{sub("https://push.example.com/1")}}}, result)
+
+	_, err = b.UserDetail(req(`{"keys":{}}`))
+	assert.EqualError(t, err, "endpoint cannot be empty in request for push subscription")
+	_, err = b.UserDetail(req(`bad json`))
+	assert.Error(t, err)
+
+	for i := 0; i < 12; i++ {
+		_, err = b.UserDetail(req(fmt.Sprintf(`{"endpoint":"https://push.example.com/x%d","keys":{"p256dh":"k","auth":"a"}}`, i)))
+		require.NoError(t, err)
+	}
+	result, err = b.UserDetail(req(""))
+	require.NoError(t, err)
+	require.Equal(t, 10, len(result[0].WebPush), "limited number of subscriptions")
+	assert.Equal(t, "https://push.example.com/x2", result[0].WebPush[0].Endpoint, "oldest dropped")
+
+	assert.NoError(t, b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1", UserDetail: UserWebPush}))
+	result, err = b.UserDetail(req(""))
+	require.NoError(t, err)
+	assert.Empty(t, result, "entry without details removed")
+}
+
+func TestBoltDB_UserSessions(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	req := func(sess store.Session) UserDetailRequest {
+		data, err := json.Marshal(sess)
+		require.NoError(t, err)
+		return UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserSessions, Update: string(data)}
+	}
+	created := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	_, err := b.UserDetail(req(store.Session{ID: "s1", Created: created, Refreshed: created, Expires: expires}))
+	require.NoError(t, err)
+	_, err = b.UserDetail(req(store.Session{ID: "s2", Created: created, Refreshed: created,
+		Expires: time.Now().Add(-time.Minute)}))
+	require.NoError(t, err)
+	_, err = b.UserDetail(req(store.Session{ID: "s3", Created: created, Refreshed: created, Expires: expires}))
+	require.NoError(t, err)
+	refreshed := created.Add(time.Hour)
+	_, err = b.UserDetail(req(store.Session{ID: "s1", Created: refreshed, Refreshed: refreshed, Expires: expires}))
+	require.NoError(t, err, "refresh of the same session")
+
+	result, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserSessions})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Sessions: []store.Session{
+		{ID: "s3", Created: created, Refreshed: created, Expires: expires},
+		{ID: "s1", Created: created, Refreshed: refreshed, Expires: expires},
+	}}}, result, "expired session dropped, refreshed one keeps creation time")
+
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserSessions, Update: `{"created":"2021-05-01T10:00:00Z"}`})
+	assert.EqualError(t, err, "id cannot be empty in request for session")
+
+	for i := 0; i < 25; i++ {
+		_, err = b.UserDetail(req(store.Session{ID: fmt.Sprintf("x%d", i), Expires: expires}))
+		require.NoError(t, err)
+	}
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserSessions})
+	require.NoError(t, err)
+	require.Equal(t, 20, len(result[0].Sessions), "limited number of sessions")
+	assert.Equal(t, "x5", result[0].Sessions[0].ID, "oldest dropped")
+
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserRevoked, Update: "1620000000"})
+	require.NoError(t, err)
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserRevoked})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Revoked: 1620000000}}, result)
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserRevoked, Update: "bad"})
+	assert.Error(t, err)
+
+	assert.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserSessions}))
+	assert.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserRevoked}))
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Empty(t, result, "entry without details removed")
+}
+
+func TestBoltDB_UserLastSeen(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := func(url string) store.Locator { return store.Locator{SiteID: "radio-t", URL: url} }
+	now := time.Now()
+	old := strconv.FormatInt(now.Add(-48*time.Hour).Unix(), 10)
+	fresh := strconv.FormatInt(now.Unix(), 10)
+
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc("https://radio-t.com/1"), UserID: "u1", Detail: UserLastSeen, Update: old})
+	require.NoError(t, err)
+	res, err := b.UserDetail(UserDetailRequest{Locator: loc("https://radio-t.com/2"), UserID: "u1", Detail: UserLastSeen, Update: fresh})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res[0].LastSeen))
+
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc("https://radio-t.com/1"), UserID: "u1", Detail: UserLastSeen})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", LastSeen: map[string]int64{"https://radio-t.com/1": now.Add(-48 * time.Hour).Unix()}}}, res)
+
+	// set with ttl drops expired
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc("https://radio-t.com/3"), UserID: "u1", Detail: UserLastSeen, Update: fresh, TTL: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"https://radio-t.com/2": now.Unix(), "https://radio-t.com/3": now.Unix()}, res[0].LastSeen)
+
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc(""), UserID: "u1", Detail: UserLastSeen, Update: fresh})
+	assert.EqualError(t, err, "url cannot be empty in request for last seen")
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc("https://radio-t.com/3"), UserID: "u1", Detail: UserLastSeen, Update: "bad"})
+	assert.Error(t, err)
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc(""), UserID: "u1", UserDetail: UserLastSeen}))
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc(""), Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Empty(t, res, "entry removed with last detail")
 }
 
 func TestBolt_DeleteComment(t *testing.T) {
@@ -847,6 +1075,115 @@ func TestBoltAdmin_DeleteUserHard(t *testing.T) {
 	assert.EqualError(t, err, `site "radio-t-bad" not found`)
 }
 
+func TestBoltDB_Merge(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	site := store.Locator{SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "text3", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2", Name: "user2"},
+		Votes: map[string]bool{"user1": true, "user3": false}, Score: 0, Controversy: 1,
+		Reactions: map[string]int{"+1": 2}, Reactors: map[string][]string{"+1": {"user1", "user3"}},
+		Mentions: []string{"user1"}})
+	require.NoError(t, err)
+
+	detail := func(userID string, detail UserDetail, url, update string) {
+		_, e := b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t", URL: url}, UserID: userID,
+			Detail: detail, Update: update})
+		require.NoError(t, e)
+	}
+	detail("user1", UserEmail, "", "user1@example.com")
+	detail("user1", UserFollows, "https://radio-t.com", "true")
+	detail("user2", UserLocale, "", "de")
+	detail("user2", UserFollows, "https://radio-t.com/2", "true")
+	_, err = b.Flag(FlagRequest{Flag: Blocked, Locator: site, UserID: "user1", Update: FlagTrue, TTL: time.Hour})
+	require.NoError(t, err)
+	_, err = b.Flag(FlagRequest{Flag: Verified, Locator: site, UserID: "user1", Update: FlagTrue})
+	require.NoError(t, err)
+
+	err = b.Merge(MergeRequest{Locator: site, FromUserID: "user1", ToUserID: "user2"})
+	require.NoError(t, err)
+
+	comments, err := b.Find(FindRequest{Locator: site, UserID: "user2", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments), "comments of user1 moved")
+	_, err = b.Find(FindRequest{Locator: site, UserID: "user1", Limit: 10})
+	assert.EqualError(t, err, "no comments for user user1 in store")
+
+	c, err := b.Get(GetRequest{Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, CommentID: "id-3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user3": false}, c.Votes, "vote for own comment dropped")
+	assert.Equal(t, -1, c.Score)
+	assert.Equal(t, 0., c.Controversy)
+	assert.Equal(t, map[string]int{"+1": 2}, c.Reactions)
+	assert.Equal(t, map[string][]string{"+1": {"user3", "user2"}}, c.Reactors)
+	assert.Equal(t, []string{"user2"}, c.Mentions)
+
+	res, err := b.UserDetail(UserDetailRequest{Locator: site, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{
+		{UserID: "user1", Linked: "user2"},
+		{UserID: "user2", Email: "user1@example.com", Locale: "de",
+			Follows: []string{"https://radio-t.com/2", "https://radio-t.com"}},
+	}, res)
+
+	val, err := b.Flag(FlagRequest{Flag: Blocked, Locator: site, UserID: "user2"})
+	require.NoError(t, err)
+	assert.True(t, val, "block moved")
+	val, err = b.Flag(FlagRequest{Flag: Verified, Locator: site, UserID: "user2"})
+	require.NoError(t, err)
+	assert.True(t, val, "verified moved")
+	val, err = b.Flag(FlagRequest{Flag: Blocked, Locator: site, UserID: "user1"})
+	require.NoError(t, err)
+	assert.False(t, val)
+
+	assert.Error(t, b.Merge(MergeRequest{Locator: site, FromUserID: "user2", ToUserID: "user2"}))
+	assert.Error(t, b.Merge(MergeRequest{Locator: store.Locator{SiteID: "bad"}, FromUserID: "user1", ToUserID: "user2"}))
+}
+
+func TestBoltDB_MergeActivity(t *testing.T) {
+	b, teardown := prep(t) // two comments of user1 for https://radio-t.com
+	defer teardown()
+
+	site := store.Locator{SiteID: "radio-t"}
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	c, err := b.Get(getReq(loc, "id-1"))
+	require.NoError(t, err)
+	c.Votes, c.Score = map[string]bool{"user3": true}, 1
+	require.NoError(t, b.Update(c), "vote of user3 added by update")
+
+	c, err = b.Get(getReq(loc, "id-2"))
+	require.NoError(t, err)
+	c.Reactions, c.Reactors = map[string]int{"+1": 1}, map[string][]string{"+1": {"user4"}}
+	require.NoError(t, b.Update(c))
+
+	// drop the index to get the store made before it was added
+	err = b.dbs["radio-t"].Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte(activityBucketName)) })
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+	b, err = NewBoltDB(bolt.Options{}, BoltSite{FileName: testDB, SiteID: "radio-t"})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Merge(MergeRequest{Locator: site, FromUserID: "user3", ToUserID: "user5"}))
+	require.NoError(t, b.Merge(MergeRequest{Locator: site, FromUserID: "user4", ToUserID: "user5"}))
+	c, err = b.Get(getReq(loc, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user5": true}, c.Votes, "vote moved")
+	c, err = b.Get(getReq(loc, "id-2"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"+1": {"user5"}}, c.Reactors, "reaction from the migrated index moved")
+
+	// activity of the merged user moved with it
+	require.NoError(t, b.Merge(MergeRequest{Locator: site, FromUserID: "user5", ToUserID: "user6"}))
+	c, err = b.Get(getReq(loc, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user6": true}, c.Votes)
+	c, err = b.Get(getReq(loc, "id-2"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"+1": {"user6"}}, c.Reactors)
+	require.NoError(t, b.Close())
+}
+
 func TestBoltAdmin_DeleteUserSoft(t *testing.T) {
 
 	b, teardown := prep(t)
@@ -960,3 +1297,108 @@ func getReq(locator store.Locator, commentID string) GetRequest {
 		CommentID: commentID,
 	}
 }
+
+func TestBoltDB_UserFollows(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := func(url string) store.Locator { return store.Locator{SiteID: "radio-t", URL: url} }
+	follow := func(userID, url, update string) []UserDetailEntry {
+		res, err := b.UserDetail(UserDetailRequest{Locator: loc(url), UserID: userID, Detail: UserFollows, Update: update})
+		require.NoError(t, err)
+		return res
+	}
+
+	follow("u1", "https://radio-t.com/1", "true")
+	follow("u1", "https://radio-t.com/1", "true")
+	res := follow("u1", "https://radio-t.com/2", "true")
+	assert.Equal(t, []string{"https://radio-t.com/1", "https://radio-t.com/2"}, res[0].Follows, "no duplicates")
+	follow("u2", "https://radio-t.com/2", "true")
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc("https://radio-t.com/2"), UserID: "u2", Detail: UserLastSeen, Update: "1600000000"})
+	require.NoError(t, err)
+
+	res = follow("u1", "https://radio-t.com/1", "false")
+	assert.Equal(t, []string{"https://radio-t.com/2"}, res[0].Follows)
+	res = follow("u1", "", "")
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Follows: []string{"https://radio-t.com/2"}}}, res)
+
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc(""), UserID: "u1", Detail: UserFollows, Update: "true"})
+	assert.EqualError(t, err, "url cannot be empty in request for follows")
+
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc(""), Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{
+		{UserID: "u1", Follows: []string{"https://radio-t.com/2"}},
+		{UserID: "u2", Follows: []string{"https://radio-t.com/2"}, LastSeen: map[string]int64{"https://radio-t.com/2": 1600000000}},
+	}, res)
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc(""), UserID: "u1", UserDetail: UserFollows}))
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc(""), Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(res), "u1 entry removed with the last detail")
+}
+
+func TestBoltDB_PostFollowers(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := func(url string) store.Locator { return store.Locator{SiteID: "radio-t", URL: url} }
+	set := func(userID string, detail UserDetail, url, update string) {
+		_, err := b.UserDetail(UserDetailRequest{Locator: loc(url), UserID: userID, Detail: detail, Update: update})
+		require.NoError(t, err)
+	}
+	followers := func(url string) []UserDetailEntry {
+		res, err := b.UserDetail(UserDetailRequest{Locator: loc(url), Detail: PostFollowers})
+		require.NoError(t, err)
+		return res
+	}
+
+	set("u1", UserFollows, "https://radio-t.com/1", "true")
+	set("u1", UserFollows, "https://radio-t.com/2", "true")
+	set("u1", UserEmail, "", "u1@example.com")
+	set("u2", UserFollows, "https://radio-t.com/2", "true")
+	set("u3", UserEmail, "", "u3@example.com")
+
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "u1@example.com",
+		Follows: []string{"https://radio-t.com/1", "https://radio-t.com/2"}}}, followers("https://radio-t.com/1"))
+	assert.Equal(t, 2, len(followers("https://radio-t.com/2")))
+	assert.Empty(t, followers("https://radio-t.com/3"))
+
+	set("u1", UserFollows, "https://radio-t.com/1", "false")
+	assert.Empty(t, followers("https://radio-t.com/1"), "unfollowed")
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc(""), UserID: "u2", UserDetail: UserFollows}))
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "u1@example.com", Follows: []string{"https://radio-t.com/2"}}},
+		followers("https://radio-t.com/2"), "u2 follows deleted")
+
+	require.NoError(t, b.Merge(MergeRequest{Locator: loc(""), FromUserID: "u1", ToUserID: "u3"}))
+	assert.Equal(t, []UserDetailEntry{{UserID: "u3", Email: "u3@example.com", Follows: []string{"https://radio-t.com/2"}}},
+		followers("https://radio-t.com/2"), "follows moved to the merged user")
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc(""), UserID: "u3", UserDetail: AllUserDetails}))
+	assert.Empty(t, followers("https://radio-t.com/2"), "all details deleted")
+
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc(""), Detail: PostFollowers})
+	assert.EqualError(t, err, "unsupported request with userdetail followers")
+}
+
+func TestBoltDB_PostFollowersIndexMigration(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/1"}
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserFollows, Update: "true"})
+	require.NoError(t, err)
+
+	// drop the index to get the store made before it was added
+	err = b.dbs["radio-t"].Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte(followersBucketName)) })
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	b, err = NewBoltDB(bolt.Options{}, BoltSite{FileName: testDB, SiteID: "radio-t"})
+	require.NoError(t, err)
+	res, err := b.UserDetail(UserDetailRequest{Locator: loc, Detail: PostFollowers})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Follows: []string{"https://radio-t.com/1"}}}, res)
+	require.NoError(t, b.Close())
+}
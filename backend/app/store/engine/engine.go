@@ -25,6 +25,7 @@ type Interface interface {
 	Delete(req DeleteRequest) error                             // Delete post(s), user, comment, user details, or everything
 	Flag(req FlagRequest) (bool, error)                         // set and get flags
 	ListFlags(req FlagRequest) ([]interface{}, error)           // get list of flagged keys, like blocked & verified user
+	Merge(req MergeRequest) error                               // move comments, votes, details and flags of user to another one
 
 	// UserDetail sets or gets single detail value, or gets all details for requested site
 	// Returns list even for single entry request is a compromise in order to have both single detail getting and setting
@@ -67,6 +68,13 @@ type DeleteRequest struct {
 	DeleteMode store.DeleteMode `json:"del_mode"`
 }
 
+// MergeRequest is the input for merge of two users of the site, FromUserID merged into ToUserID
+type MergeRequest struct {
+	Locator    store.Locator `json:"locator"` // site of users
+	FromUserID string        `json:"from_user_id"`
+	ToUserID   string        `json:"to_user_id"`
+}
+
 // Flag defines type of binary attribute
 type Flag string
 
@@ -91,6 +99,28 @@ const (
 const (
 	// UserEmail is a user email
 	UserEmail = UserDetail("email")
+	// UserMentionsOff disables notifications about user's mentions
+	UserMentionsOff = UserDetail("mentions_off")
+	// UserLastSeen is a time user has seen the post (Locator.URL) last time
+	UserLastSeen = UserDetail("last_seen")
+	// UserFollows is a list of posts (Locator.URL) user follows to get notified about all new comments
+	UserFollows = UserDetail("follows")
+	// UserDigest is a period of digest delivery for user's email notifications, empty for immediate delivery
+	UserDigest = UserDetail("digest")
+	// UserTelegram is a telegram chat id of the user, linked for notifications
+	UserTelegram = UserDetail("telegram")
+	// UserWebPush is a list of user's browser push subscriptions
+	UserWebPush = UserDetail("webpush")
+	// UserLocale is a locale of user's email notifications, like "de" or "pt-br"
+	UserLocale = UserDetail("locale")
+	// UserLinked is the id of the user the user merged into, set for merged users only
+	UserLinked = UserDetail("linked")
+	// UserSessions is a list of user's login sessions
+	UserSessions = UserDetail("sessions")
+	// UserRevoked is a unix time all user's sessions issued before are revoked
+	UserRevoked = UserDetail("revoked")
+	// PostFollowers used for listing users following the post (Locator.URL), read only
+	PostFollowers = UserDetail("followers")
 	// AllUserDetails used for listing and deletion requests
 	AllUserDetails = UserDetail("all")
 )
@@ -109,22 +139,39 @@ type UserDetail string
 
 // UserDetailEntry contains single user details entry
 type UserDetailEntry struct {
-	UserID string `json:"user_id"`         // duplicate user's id to use this structure not only embedded but separately
-	Email  string `json:"email,omitempty"` // UserEmail
+	UserID      string `json:"user_id"`                // duplicate user's id to use this structure not only embedded but separately
+	Email       string `json:"email,omitempty"`        // UserEmail
+	MentionsOff bool   `json:"mentions_off,omitempty"` // UserMentionsOff
+	Digest      string `json:"digest,omitempty"`       // UserDigest
+	Telegram    string `json:"telegram,omitempty"`     // UserTelegram
+	Locale      string `json:"locale,omitempty"`       // UserLocale
+	Linked      string `json:"linked,omitempty"`       // UserLinked
+	Revoked     int64  `json:"revoked,omitempty"`      // UserRevoked, unix time
+
+	LastSeen map[string]int64 `json:"last_seen,omitempty"` // UserLastSeen, unix time per post url
+	Follows  []string         `json:"follows,omitempty"`   // UserFollows, followed post urls
+
+	WebPush  []store.PushSubscription `json:"webpush,omitempty"`  // UserWebPush, browser subscriptions
+	Sessions []store.Session          `json:"sessions,omitempty"` // UserSessions, login sessions
 }
 
-// UserDetailRequest is the input for both get/set for details, like email
+// UserDetailRequest is the input for both get/set for details, like email.
+// Update is "true" or "false" to follow or unfollow for UserFollows, json of subscription to add for UserWebPush
+// (subscription without keys removes one with the same endpoint) and json of session to add or refresh for UserSessions.
 type UserDetailRequest struct {
 	Detail  UserDetail    `json:"detail"`           // detail name
 	Locator store.Locator `json:"locator"`          // post locator
 	UserID  string        `json:"user_id"`          // user id for get\set
 	Update  string        `json:"update,omitempty"` // update value
+	TTL     time.Duration `json:"ttl,omitempty"`    // ttl for time-sensitive details only, like last seen
 }
 
 const (
 	// limits
-	lastLimit = 1000
-	userLimit = 500
+	lastLimit            = 1000
+	userLimit            = 500
+	maxPushSubscriptions = 10 // per user, one for each browser
+	maxSessions          = 20 // per user, the oldest dropped
 )
 
 // SortComments is for engines can't sort data internally
@@ -161,9 +208,203 @@ func SortComments(comments []store.Comment, sortFld string) []store.Comment {
 			}
 			return comments[i].Controversy < comments[j].Controversy
 
+		case "+reactions", "-reactions", "reactions":
+			if strings.HasPrefix(sortFld, "-") {
+				if comments[i].TotalReactions() == comments[j].TotalReactions() {
+					return comments[i].Timestamp.Before(comments[j].Timestamp)
+				}
+				return comments[i].TotalReactions() > comments[j].TotalReactions()
+			}
+			if comments[i].TotalReactions() == comments[j].TotalReactions() {
+				return comments[i].Timestamp.Before(comments[j].Timestamp)
+			}
+			return comments[i].TotalReactions() < comments[j].TotalReactions()
+
+		case "+hot", "-hot", "hot":
+			if strings.HasPrefix(sortFld, "-") {
+				if comments[i].Hot() == comments[j].Hot() {
+					return comments[i].Timestamp.Before(comments[j].Timestamp)
+				}
+				return comments[i].Hot() > comments[j].Hot()
+			}
+			if comments[i].Hot() == comments[j].Hot() {
+				return comments[i].Timestamp.Before(comments[j].Timestamp)
+			}
+			return comments[i].Hot() < comments[j].Hot()
+
+		case "+best", "-best", "best":
+			if strings.HasPrefix(sortFld, "-") {
+				if comments[i].Best == comments[j].Best {
+					return comments[i].Timestamp.Before(comments[j].Timestamp)
+				}
+				return comments[i].Best > comments[j].Best
+			}
+			if comments[i].Best == comments[j].Best {
+				return comments[i].Timestamp.Before(comments[j].Timestamp)
+			}
+			return comments[i].Best < comments[j].Best
+
 		default:
 			return comments[i].Timestamp.Before(comments[j].Timestamp)
 		}
 	})
 	return comments
 }
+
+// MergeComment replaces fromID with toID as the author, voter, reactor and mentioned user of the comment,
+// returns true if the comment changed. Vote and reaction of fromID dropped if toID has the same one,
+// or if toID is the author, as users can't vote for own comments.
+func MergeComment(c *store.Comment, fromID, toID string) (changed bool) {
+	if c.User.ID == fromID {
+		c.User.ID = toID
+		changed = true
+	}
+
+	if vote, ok := c.Votes[fromID]; ok {
+		delete(c.Votes, fromID)
+		if _, voted := c.Votes[toID]; voted || c.User.ID == toID {
+			if vote {
+				c.Score--
+			} else {
+				c.Score++
+			}
+			c.Controversy, c.Best = 0, 0 // recalculated on read
+		} else {
+			c.Votes[toID] = vote
+		}
+		changed = true
+	}
+
+	for emoji, users := range c.Reactors {
+		res := make([]string, 0, len(users))
+		found, dup := false, false
+		for _, u := range users {
+			switch u {
+			case fromID:
+				found = true
+			case toID:
+				dup = true
+				res = append(res, u)
+			default:
+				res = append(res, u)
+			}
+		}
+		if !found {
+			continue
+		}
+		if dup {
+			c.Reactions[emoji]--
+		} else {
+			res = append(res, toID)
+		}
+		c.Reactors[emoji] = res
+		changed = true
+	}
+
+	for i, m := range c.Mentions {
+		if m == fromID {
+			c.Mentions[i] = toID
+			changed = true
+		}
+	}
+	return changed
+}
+
+// MergeUserDetails combines details of two users, values of to take precedence over values of from
+func MergeUserDetails(to, from UserDetailEntry) UserDetailEntry {
+	res := to
+	pick := func(a, b string) string {
+		if a != "" {
+			return a
+		}
+		return b
+	}
+	res.Email = pick(to.Email, from.Email)
+	res.Digest = pick(to.Digest, from.Digest)
+	res.Telegram = pick(to.Telegram, from.Telegram)
+	res.Locale = pick(to.Locale, from.Locale)
+	res.MentionsOff = to.MentionsOff || from.MentionsOff
+
+	if len(from.LastSeen) > 0 {
+		res.LastSeen = map[string]int64{}
+		for url, ts := range to.LastSeen {
+			res.LastSeen[url] = ts
+		}
+		for url, ts := range from.LastSeen {
+			if ts > res.LastSeen[url] {
+				res.LastSeen[url] = ts
+			}
+		}
+	}
+
+	follows := append([]string{}, to.Follows...)
+	for _, url := range from.Follows {
+		if !contains(url, follows) {
+			follows = append(follows, url)
+		}
+	}
+	if len(follows) > 0 {
+		res.Follows = follows
+	}
+
+	subs := append([]store.PushSubscription{}, to.WebPush...)
+	for _, sub := range from.WebPush {
+		found := false
+		for _, s := range subs {
+			found = found || s.Endpoint == sub.Endpoint
+		}
+		if !found {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) > maxPushSubscriptions {
+		subs = subs[len(subs)-maxPushSubscriptions:]
+	}
+	if len(subs) > 0 {
+		res.WebPush = subs
+	}
+
+	if from.Revoked > res.Revoked {
+		res.Revoked = from.Revoked
+	}
+	sessions := append([]store.Session{}, to.Sessions...)
+	for _, sess := range from.Sessions {
+		sessions = addSession(sessions, sess)
+	}
+	if len(sessions) > 0 {
+		res.Sessions = sessions
+	}
+	return res
+}
+
+// addSession adds new session or refreshes existing one, keeping its creation time.
+// Expired sessions dropped, as well as the oldest ones above the limit
+func addSession(sessions []store.Session, sess store.Session) []store.Session {
+	res := make([]store.Session, 0, len(sessions)+1)
+	now := time.Now()
+	for _, s := range sessions {
+		if s.ID == sess.ID {
+			if !s.Created.IsZero() && s.Created.Before(sess.Created) {
+				sess.Created = s.Created
+			}
+			continue
+		}
+		if s.Expires.After(now) {
+			res = append(res, s)
+		}
+	}
+	res = append(res, sess)
+	if len(res) > maxSessions {
+		res = res[len(res)-maxSessions:]
+	}
+	return res
+}
+
+func contains(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -193,6 +193,20 @@ func (_m *MockInterface) ListFlags(req FlagRequest) ([]interface{}, error) {
 	return r0, r1
 }
 
+// Merge provides a mock function with given fields: req
+func (_m *MockInterface) Merge(req MergeRequest) error {
+	ret := _m.Called(req)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(MergeRequest) error); ok {
+		r0 = rf(req)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: comment
 func (_m *MockInterface) Update(comment store.Comment) error {
 	ret := _m.Called(comment)
@@ -11,10 +11,10 @@ import (
 
 func TestEngine_sortComments(t *testing.T) {
 	cc := []store.Comment{
-		{ID: "1", Score: 5, Controversy: 1, Timestamp: time.Date(2018, 2, 5, 10, 1, 0, 0, time.Local)},
-		{ID: "2", Score: 4, Controversy: 2, Timestamp: time.Date(2018, 2, 5, 10, 2, 0, 0, time.Local)},
-		{ID: "3", Score: 6, Controversy: 3, Timestamp: time.Date(2018, 2, 5, 10, 3, 0, 0, time.Local)},
-		{ID: "4", Score: 6, Controversy: 1, Timestamp: time.Date(2018, 2, 5, 10, 4, 0, 0, time.Local)},
+		{ID: "1", Score: 5, Controversy: 1, Best: 0.5, Reactions: map[string]int{"a": 2}, Timestamp: time.Date(2018, 2, 5, 10, 1, 0, 0, time.Local)},
+		{ID: "2", Score: 4, Controversy: 2, Best: 0.9, Reactions: map[string]int{"a": 1, "b": 2}, Timestamp: time.Date(2018, 2, 5, 10, 2, 0, 0, time.Local)},
+		{ID: "3", Score: 6, Controversy: 3, Best: 0.1, Timestamp: time.Date(2018, 2, 5, 10, 3, 0, 0, time.Local)},
+		{ID: "4", Score: 6, Controversy: 1, Best: 0.5, Reactions: map[string]int{"a": 1}, Timestamp: time.Date(2018, 2, 5, 10, 4, 0, 0, time.Local)},
 	}
 
 	SortComments(cc, "+time")
@@ -52,4 +52,74 @@ func TestEngine_sortComments(t *testing.T) {
 	assert.Equal(t, "2", cc[1].ID)
 	assert.Equal(t, "1", cc[2].ID)
 	assert.Equal(t, "4", cc[3].ID)
+	SortComments(cc, "reactions")
+	assert.Equal(t, "3", cc[0].ID)
+	assert.Equal(t, "4", cc[1].ID)
+	assert.Equal(t, "1", cc[2].ID)
+	assert.Equal(t, "2", cc[3].ID)
+
+	SortComments(cc, "-reactions")
+	assert.Equal(t, "2", cc[0].ID)
+	assert.Equal(t, "1", cc[1].ID)
+	assert.Equal(t, "4", cc[2].ID)
+	assert.Equal(t, "3", cc[3].ID)
+
+	SortComments(cc, "-hot")
+	assert.Equal(t, "4", cc[0].ID)
+	assert.Equal(t, "3", cc[1].ID)
+	assert.Equal(t, "1", cc[2].ID)
+	assert.Equal(t, "2", cc[3].ID)
+
+	SortComments(cc, "-best")
+	assert.Equal(t, "2", cc[0].ID)
+	assert.Equal(t, "1", cc[1].ID)
+	assert.Equal(t, "4", cc[2].ID)
+	assert.Equal(t, "3", cc[3].ID)
+
+	SortComments(cc, "best")
+	assert.Equal(t, "3", cc[0].ID)
+	assert.Equal(t, "2", cc[3].ID)
+}
+
+func TestMergeComment(t *testing.T) {
+	c := store.Comment{User: store.User{ID: "u1"}, Score: 1, Controversy: 0.5, Best: 0.3,
+		Votes:     map[string]bool{"u1": false, "u2": true, "u3": false},
+		Reactions: map[string]int{"a": 2, "b": 1}, Reactors: map[string][]string{"a": {"u1", "u2"}, "b": {"u1"}},
+		Mentions: []string{"u1", "u3"}}
+	assert.True(t, MergeComment(&c, "u1", "u2"))
+	assert.Equal(t, "u2", c.User.ID)
+	assert.Equal(t, map[string]bool{"u2": true, "u3": false}, c.Votes, "duplicate vote dropped")
+	assert.Equal(t, 2, c.Score)
+	assert.Equal(t, 0., c.Controversy)
+	assert.Equal(t, 0., c.Best)
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, c.Reactions)
+	assert.Equal(t, map[string][]string{"a": {"u2"}, "b": {"u2"}}, c.Reactors)
+	assert.Equal(t, []string{"u2", "u3"}, c.Mentions)
+
+	c = store.Comment{User: store.User{ID: "u3"}, Votes: map[string]bool{"u1": true}, Score: 1}
+	assert.True(t, MergeComment(&c, "u1", "u2"))
+	assert.Equal(t, map[string]bool{"u2": true}, c.Votes, "vote moved")
+	assert.Equal(t, 1, c.Score)
+
+	assert.False(t, MergeComment(&c, "u4", "u2"), "nothing to merge")
+}
+
+func TestMergeUserDetails(t *testing.T) {
+	to := UserDetailEntry{UserID: "u2", Email: "u2@example.com", LastSeen: map[string]int64{"p1": 10},
+		Follows: []string{"p1"}, WebPush: []store.PushSubscription{{Endpoint: "e1"}}}
+	from := UserDetailEntry{UserID: "u1", Email: "u1@example.com", Telegram: "123", MentionsOff: true,
+		LastSeen: map[string]int64{"p1": 20, "p2": 5}, Follows: []string{"p1", "p2"},
+		WebPush: []store.PushSubscription{{Endpoint: "e1"}, {Endpoint: "e2"}}}
+	assert.Equal(t, UserDetailEntry{UserID: "u2", Email: "u2@example.com", Telegram: "123", MentionsOff: true,
+		LastSeen: map[string]int64{"p1": 20, "p2": 5}, Follows: []string{"p1", "p2"},
+		WebPush: []store.PushSubscription{{Endpoint: "e1"}, {Endpoint: "e2"}}}, MergeUserDetails(to, from))
+	assert.Equal(t, map[string]int64{"p1": 10}, to.LastSeen, "target entry not modified")
+
+	assert.Equal(t, UserDetailEntry{UserID: "u2"}, MergeUserDetails(UserDetailEntry{UserID: "u2"}, UserDetailEntry{}))
+
+	expires := time.Now().Add(time.Hour)
+	to = UserDetailEntry{UserID: "u2", Revoked: 100, Sessions: []store.Session{{ID: "s1", Expires: expires}}}
+	from = UserDetailEntry{UserID: "u1", Revoked: 200, Sessions: []store.Session{{ID: "s2", Expires: expires}}}
+	assert.Equal(t, UserDetailEntry{UserID: "u2", Revoked: 200,
+		Sessions: []store.Session{{ID: "s1", Expires: expires}, {ID: "s2", Expires: expires}}}, MergeUserDetails(to, from))
 }
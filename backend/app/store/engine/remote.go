@@ -110,6 +110,12 @@ func (r *RPC) Delete(req DeleteRequest) error {
 	return err
 }
 
+// Merge moves comments, votes, details and flags of user to another one
+func (r *RPC) Merge(req MergeRequest) error {
+	_, err := r.Call("store.merge", req)
+	return err
+}
+
 // Close storage engine
 func (r *RPC) Close() error {
 	_, err := r.Call("store.close")
@@ -180,6 +180,16 @@ func TestRemote_Delete(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRemote_Merge(t *testing.T) {
+	ts := testServer(t, `{"method":"store.merge","params":{"locator":{"site":"site1","url":""},"from_user_id":"u1","to_user_id":"u2"},"id":1}`,
+		`{}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	err := c.Merge(MergeRequest{Locator: store.Locator{SiteID: "site1"}, FromUserID: "u1", ToUserID: "u2"})
+	assert.NoError(t, err)
+}
+
 func TestRemote_Close(t *testing.T) {
 	ts := testServer(t, `{"method":"store.close","id":1}`, `{}`)
 	defer ts.Close()
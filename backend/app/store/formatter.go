@@ -2,19 +2,37 @@ package store
 
 import (
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/Depado/bfchroma"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/alecthomas/chroma/formatters/html"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
 	bf "github.com/russross/blackfriday/v2"
+	"golang.org/x/net/html"
 )
 
 // CommentFormatter implements all generic formatting ops on comment
 type CommentFormatter struct {
 	converters []CommentConverter
+	mentions   MentionsLister
 }
 
+// MentionsLister provides users who can be mentioned with @name in comments of the post
+type MentionsLister interface {
+	Mentionable(locator Locator) ([]Mention, error)
+}
+
+// Mention is a user who can be mentioned, CommentID refers to the latest user's comment on the post
+type Mention struct {
+	UserID    string
+	Name      string
+	CommentID string
+}
+
+// mention matches @name not preceded by a word character, so emails are not treated as mentions
+var reMention = regexp.MustCompile(`(^|[^\p{L}\p{N}_.@/])@([\p{L}\p{N}_.\-]+)`)
+
 // CommentConverter defines interface to convert some parts of commentHTML
 // Passed at creation time and does client-defined conversions, like image proxy link change
 type CommentConverter interface {
@@ -34,9 +52,16 @@ func NewCommentFormatter(converters ...CommentConverter) *CommentFormatter {
 	return &CommentFormatter{converters: converters}
 }
 
+// WithMentions enables resolving of @name mentions against users listed by lister
+func (f *CommentFormatter) WithMentions(lister MentionsLister) *CommentFormatter {
+	f.mentions = lister
+	return f
+}
+
 // Format comment fields
 func (f *CommentFormatter) Format(c Comment) Comment {
 	c.Text = f.FormatText(c.Text)
+	c.Text, c.Mentions = f.resolveMentions(c.Text, c.Locator)
 	return c
 }
 
@@ -50,7 +75,7 @@ func (f *CommentFormatter) FormatText(txt string) (res string) {
 		Flags: bf.Smartypants | bf.SmartypantsFractions | bf.SmartypantsDashes | bf.SmartypantsAngledQuotes,
 	})
 
-	extRend := bfchroma.NewRenderer(bfchroma.Extend(rend), bfchroma.ChromaOptions(html.WithClasses(true)))
+	extRend := bfchroma.NewRenderer(bfchroma.Extend(rend), bfchroma.ChromaOptions(chromahtml.WithClasses(true)))
 
 	res = string(bf.Run([]byte(txt), bf.WithExtensions(mdExt), bf.WithRenderer(extRend)))
 	res = f.unEscape(res)
@@ -125,3 +150,96 @@ func (f *CommentFormatter) lazyImage(commentHTML string) (resHTML string) {
 	}
 	return resHTML
 }
+
+// resolveMentions replaces @name or @user_id with the link to the latest comment of the mentioned user on the same post
+// and returns ids of all mentioned users. Names shared by several users are not resolved, such users can be mentioned
+// by id only. Mentions inside links and code are ignored.
+func (f *CommentFormatter) resolveMentions(commentHTML string, locator Locator) (resHTML string, userIDs []string) {
+	if f.mentions == nil || !strings.Contains(commentHTML, "@") {
+		return commentHTML, nil
+	}
+	mentionable, err := f.mentions.Mentionable(locator)
+	if err != nil || len(mentionable) == 0 {
+		return commentHTML, nil
+	}
+	users := make(map[string]Mention, 2*len(mentionable))
+	ambiguous := map[string]bool{}
+	for _, m := range mentionable {
+		name := MentionName(m.Name)
+		if _, ok := users[name]; ok {
+			ambiguous[name] = true // several users with the same name, can be mentioned by id only
+		}
+		users[name] = m
+	}
+	for name := range ambiguous {
+		delete(users, name)
+	}
+	for _, m := range mentionable { // id mention takes precedence over the name
+		users[strings.ToLower(m.UserID)] = m
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
+	if err != nil {
+		return commentHTML, nil
+	}
+
+	seen := map[string]bool{}
+	var textNodes []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "a" || n.Data == "code" || n.Data == "pre") {
+			return
+		}
+		if n.Type == html.TextNode {
+			textNodes = append(textNodes, n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range doc.Find("body").Nodes {
+		walk(n)
+	}
+
+	for _, n := range textNodes {
+		text, pos := n.Data, 0
+		for _, loc := range reMention.FindAllStringSubmatchIndex(text, -1) {
+			name := strings.TrimRight(text[loc[4]:loc[5]], ".-")
+			m, ok := users[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+			start, end := loc[4]-1, loc[4]+len(name) // include @ in the link text
+			if start > pos {
+				n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[pos:start]}, n)
+			}
+			link := &html.Node{Type: html.ElementNode, Data: "a", Attr: []html.Attribute{
+				{Key: "href", Val: locator.URL + "#remark42__comment-" + m.CommentID},
+				{Key: "class", Val: "mention"},
+			}}
+			link.AppendChild(&html.Node{Type: html.TextNode, Data: text[start:end]})
+			n.Parent.InsertBefore(link, n)
+			pos = end
+			if !seen[m.UserID] {
+				seen[m.UserID] = true
+				userIDs = append(userIDs, m.UserID)
+			}
+		}
+		n.Data = text[pos:]
+	}
+
+	if len(userIDs) == 0 {
+		return commentHTML, nil
+	}
+	resHTML, err = doc.Find("body").Html()
+	if err != nil {
+		return commentHTML, nil
+	}
+	return resHTML, userIDs
+}
+
+// MentionName makes name used to mention the user, i.e. lowercase with spaces replaced by underscores
+func MentionName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), "_"))
+}
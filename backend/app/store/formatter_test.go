@@ -141,3 +141,40 @@ func TestCommentFormatter_lazyImage(t *testing.T) {
 	}
 
 }
+
+type mockMentionsLister []Mention
+
+func (m mockMentionsLister) Mentionable(Locator) ([]Mention, error) { return m, nil }
+
+func TestFormatter_FormatMentions(t *testing.T) {
+	f := NewCommentFormatter().WithMentions(mockMentionsLister{
+		{UserID: "u1", Name: "Bob", CommentID: "c1"},
+		{UserID: "u2", Name: "Alice Smith", CommentID: "c2"},
+		{UserID: "github_123", Name: "John", CommentID: "c3"},
+		{UserID: "google_456", Name: "john", CommentID: "c4"},
+	})
+	locator := Locator{SiteID: "site", URL: "https://example.com/post"}
+
+	tbl := []struct {
+		in, out  string
+		mentions []string
+	}{
+		{"no mentions here", "<p>no mentions here</p>\n", nil},
+		{"hi @bob and @alice_smith.", `<p>hi <a href="https://example.com/post#remark42__comment-c1" class="mention">@bob</a>` +
+			` and <a href="https://example.com/post#remark42__comment-c2" class="mention">@alice_smith</a>.</p>` + "\n", []string{"u1", "u2"}},
+		{"@Bob @bob", `<p><a href="https://example.com/post#remark42__comment-c1" class="mention">@Bob</a> ` +
+			`<a href="https://example.com/post#remark42__comment-c1" class="mention">@bob</a></p>` + "\n", []string{"u1"}},
+		{"@unknown and bob@example.com", "<p>@unknown and bob@example.com</p>\n", nil},
+		{"`@bob` in code", "<p><code>@bob</code> in code</p>\n", nil},
+		{"@john is ambiguous", "<p>@john is ambiguous</p>\n", nil},
+		{"@google_456 and @u1", `<p><a href="https://example.com/post#remark42__comment-c4" class="mention">@google_456</a> and ` +
+			`<a href="https://example.com/post#remark42__comment-c1" class="mention">@u1</a></p>` + "\n", []string{"google_456", "u1"}},
+	}
+	for i, tt := range tbl {
+		c := f.Format(Comment{Text: tt.in, Locator: locator})
+		assert.Equal(t, tt.out, c.Text, "case %d", i)
+		assert.Equal(t, tt.mentions, c.Mentions, "case %d", i)
+	}
+
+	assert.Equal(t, "alice_smith", MentionName(" Alice  Smith "))
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SetDigestPeriod sets period of digest delivery for user's email notifications, empty period for immediate delivery
+func (s *DataStore) SetDigestPeriod(siteID, userID, period string) error {
+	if period == "" {
+		return s.DeleteUserDetail(siteID, userID, engine.UserDigest)
+	}
+	_, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserDigest,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  period,
+	})
+	return err
+}
+
+// DigestPeriod returns period of digest delivery for user's email notifications, empty for immediate delivery
+func (s *DataStore) DigestPeriod(siteID, userID string) string {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserDigest,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil || len(res) != 1 {
+		return ""
+	}
+	return res[0].Digest
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_DigestPeriod(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	assert.Equal(t, "", b.DigestPeriod("radio-t", "user1"))
+	require.NoError(t, b.SetDigestPeriod("radio-t", "user1", "weekly"))
+	assert.Equal(t, "weekly", b.DigestPeriod("radio-t", "user1"))
+	require.NoError(t, b.SetDigestPeriod("radio-t", "user1", ""))
+	assert.Equal(t, "", b.DigestPeriod("radio-t", "user1"))
+}
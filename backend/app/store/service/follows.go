@@ -0,0 +1,65 @@
+package service
+
+import (
+	"strconv"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// followSiteURL stored in user's follows instead of post url for the whole site subscription
+const followSiteURL = "*"
+
+// SetFollow subscribes or unsubscribes user to all new comments of the post.
+// Empty locator.URL means the whole site.
+func (s *DataStore) SetFollow(locator store.Locator, userID string, follow bool) error {
+	if locator.URL == "" {
+		locator.URL = followSiteURL
+	}
+	_, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserFollows,
+		Locator: locator,
+		UserID:  userID,
+		Update:  strconv.FormatBool(follow),
+	})
+	return err
+}
+
+// Following checks if user follows the post and the whole site
+func (s *DataStore) Following(locator store.Locator, userID string) (post, site bool) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserFollows,
+		Locator: store.Locator{SiteID: locator.SiteID},
+		UserID:  userID,
+	})
+	if err != nil || len(res) != 1 {
+		return false, false
+	}
+	for _, url := range res[0].Follows {
+		post = post || (url == locator.URL && url != "")
+		site = site || url == followSiteURL
+	}
+	return post, site
+}
+
+// Followers returns users following the post, either directly or with the whole site.
+// FollowSite is true for users subscribed to the whole site only.
+func (s *DataStore) Followers(locator store.Locator) ([]store.Follower, error) {
+	res := []store.Follower{}
+	found := map[string]bool{}
+	for _, url := range []string{locator.URL, followSiteURL} { // post followers first, to skip them in site followers
+		details, err := s.Engine.UserDetail(engine.UserDetailRequest{Locator: store.Locator{SiteID: locator.SiteID, URL: url},
+			Detail: engine.PostFollowers})
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range details {
+			if found[entry.UserID] {
+				continue
+			}
+			found[entry.UserID] = true
+			res = append(res, store.Follower{UserID: entry.UserID, Email: entry.Email, FollowSite: url == followSiteURL})
+		}
+	}
+	return res, nil
+}
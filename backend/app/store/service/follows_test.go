@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Follow(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	post, site := b.Following(locator, "user1")
+	assert.False(t, post)
+	assert.False(t, site)
+
+	require.NoError(t, b.SetFollow(locator, "user1", true))
+	require.NoError(t, b.SetFollow(store.Locator{SiteID: "radio-t"}, "user2", true))
+	_, err := b.SetUserEmail("radio-t", "user2", "user2@example.com")
+	require.NoError(t, err)
+
+	post, site = b.Following(locator, "user1")
+	assert.True(t, post)
+	assert.False(t, site)
+	post, site = b.Following(locator, "user2")
+	assert.False(t, post)
+	assert.True(t, site)
+
+	res, err := b.Followers(locator)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []store.Follower{{UserID: "user1"}, {UserID: "user2", Email: "user2@example.com", FollowSite: true}}, res)
+
+	res, err = b.Followers(store.Locator{URL: "https://radio-t.com/other", SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, []store.Follower{{UserID: "user2", Email: "user2@example.com", FollowSite: true}}, res, "site follower only")
+
+	info, err := b.Info(locator, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Followers)
+
+	require.NoError(t, b.SetFollow(locator, "user1", false))
+	post, _ = b.Following(locator, "user1")
+	assert.False(t, post)
+}
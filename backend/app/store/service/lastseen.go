@@ -0,0 +1,42 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SetLastSeen records the time user has seen comments of the post last time.
+// Visits to other posts older than LastSeenTTL are dropped on update.
+func (s *DataStore) SetLastSeen(locator store.Locator, userID string, ts time.Time) error {
+	_, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserLastSeen,
+		Locator: locator,
+		UserID:  userID,
+		Update:  strconv.FormatInt(ts.Unix(), 10),
+		TTL:     s.LastSeenTTL,
+	})
+	return err
+}
+
+// LastSeen returns the time user has seen comments of the post last time, false if never visited
+func (s *DataStore) LastSeen(locator store.Locator, userID string) (time.Time, bool) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserLastSeen,
+		Locator: locator,
+		UserID:  userID,
+	})
+	if err != nil || len(res) != 1 {
+		return time.Time{}, false
+	}
+	ts, ok := res[0].LastSeen[locator.URL]
+	if !ok {
+		return time.Time{}, false
+	}
+	if s.LastSeenTTL > 0 && time.Since(time.Unix(ts, 0)) > s.LastSeenTTL {
+		return time.Time{}, false
+	}
+	return time.Unix(ts, 0), true
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_LastSeen(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), LastSeenTTL: time.Hour}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, ok := b.LastSeen(locator, "user1")
+	assert.False(t, ok, "never visited")
+
+	ts := time.Now().Add(-time.Minute).Truncate(time.Second)
+	require.NoError(t, b.SetLastSeen(locator, "user1", ts))
+	res, ok := b.LastSeen(locator, "user1")
+	assert.True(t, ok)
+	assert.Equal(t, ts.Unix(), res.Unix())
+
+	_, ok = b.LastSeen(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, "user1")
+	assert.False(t, ok, "other post not visited")
+
+	require.NoError(t, b.SetLastSeen(locator, "user1", time.Now().Add(-2*time.Hour)))
+	_, ok = b.LastSeen(locator, "user1")
+	assert.False(t, ok, "expired visit ignored")
+
+	assert.Error(t, b.SetLastSeen(store.Locator{SiteID: "radio-t"}, "user1", ts), "url required")
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// maxLinkDepth limits the chain of merged users followed to the canonical one
+const maxLinkDepth = 5
+
+// MergeUsers moves comments, votes, details, flags and trust penalties of fromID to toID and links fromID to toID,
+// so later logins with fromID act as toID. Merge into the user already merged to another one rejected.
+func (s *DataStore) MergeUsers(siteID, fromID, toID string) error {
+	if fromID == "" || toID == "" {
+		return errors.New("both users required for merge")
+	}
+	if s.CanonicalUserID(siteID, toID) != toID {
+		return errors.Errorf("user %s already merged into another user", toID)
+	}
+	if linked := s.CanonicalUserID(siteID, fromID); linked != fromID {
+		return errors.Errorf("user %s already merged into %s", fromID, linked)
+	}
+	if s.IsAdmin(siteID, fromID) && !s.IsAdmin(siteID, toID) {
+		return errors.Errorf("admin %s can't be merged into non-admin user", fromID)
+	}
+
+	req := engine.MergeRequest{Locator: store.Locator{SiteID: siteID}, FromUserID: fromID, ToUserID: toID}
+	if err := s.Engine.Merge(req); err != nil {
+		return errors.Wrapf(err, "can't merge %s into %s", fromID, toID)
+	}
+	if s.Trust != nil {
+		if err := s.Trust.Merge(siteID, fromID, toID); err != nil {
+			log.Printf("[WARN] can't merge trust stats of %s into %s, %v", fromID, toID, err)
+		}
+	}
+	log.Printf("[INFO] user %s merged into %s on %s", fromID, toID, siteID)
+	return nil
+}
+
+// CanonicalUserID returns id of the user the given one merged into, or the given id for not merged users
+func (s *DataStore) CanonicalUserID(siteID, userID string) string {
+	res := userID
+	for i := 0; i < maxLinkDepth; i++ {
+		details, err := s.Engine.UserDetail(engine.UserDetailRequest{
+			Detail:  engine.UserLinked,
+			Locator: store.Locator{SiteID: siteID},
+			UserID:  res,
+		})
+		if err != nil || len(details) != 1 || details[0].Linked == "" || details[0].Linked == userID {
+			return res
+		}
+		res = details[0].Linked
+	}
+	return res
+}
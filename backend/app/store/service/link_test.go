@@ -0,0 +1,72 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/trust"
+)
+
+func TestService_MergeUsers(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", []string{"radio-t"}, []string{"admin"}, "")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{Text: "from user2", User: store.User{ID: "user2", Name: "user2"}, Locator: locator})
+	require.NoError(t, err)
+	_, err = b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, "user1", b.CanonicalUserID("radio-t", "user1"))
+	require.NoError(t, b.MergeUsers("radio-t", "user1", "user2"))
+	assert.Equal(t, "user2", b.CanonicalUserID("radio-t", "user1"))
+	assert.Equal(t, "user2", b.CanonicalUserID("radio-t", "user2"))
+
+	count, err := b.UserCount("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count, "comments found by id of the merged user")
+	comments, err := b.User("radio-t", "user2", 10, 0, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(comments))
+	email, err := b.GetUserEmail("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, "user1@example.com", email)
+
+	// chain of merges followed to the last user
+	require.NoError(t, b.MergeUsers("radio-t", "user2", "user3"))
+	assert.Equal(t, "user3", b.CanonicalUserID("radio-t", "user1"))
+
+	assert.EqualError(t, b.MergeUsers("radio-t", "user1", "user4"), "user user1 already merged into user3")
+	assert.EqualError(t, b.MergeUsers("radio-t", "user4", "user2"), "user user2 already merged into another user")
+	assert.EqualError(t, b.MergeUsers("radio-t", "admin", "user4"), "admin admin can't be merged into non-admin user")
+	assert.Error(t, b.MergeUsers("radio-t", "", "user4"))
+}
+
+func TestService_MergeUsersTrust(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	trustSvc, trustTeardown := prepTrustService(t)
+	defer trustTeardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Trust: trustSvc}
+
+	require.NoError(t, b.SetTrustLevel("radio-t", "user1", trust.LevelNew))
+	require.NoError(t, trustSvc.OnDelete("radio-t", "user1", 0, true))
+	require.NoError(t, b.SetTrustLevel("radio-t", "user2", trust.LevelTrusted))
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	for _, userID := range []string{"user1", "user2"} {
+		_, err := b.Create(store.Comment{Text: "text", User: store.User{ID: userID, Name: userID}, Locator: locator})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, b.MergeUsers("radio-t", "user1", "user2"))
+	assert.Equal(t, trust.LevelNew, b.TrustLevel("radio-t", store.User{ID: "user2"}), "override of merged user moved")
+	stats, err := trustSvc.Store.Get("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Deleted, "penalty of merged user moved")
+	assert.Equal(t, 2, stats.Comments, "comments of merged user counted without rebuild")
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SetLocale sets locale of user's email notifications, empty locale for the default one
+func (s *DataStore) SetLocale(siteID, userID, locale string) error {
+	if locale == "" {
+		return s.DeleteUserDetail(siteID, userID, engine.UserLocale)
+	}
+	_, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserLocale,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  locale,
+	})
+	return err
+}
+
+// Locale returns locale of user's email notifications, empty for the default one
+func (s *DataStore) Locale(siteID, userID string) string {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserLocale,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil || len(res) != 1 {
+		return ""
+	}
+	return res[0].Locale
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Locale(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	assert.Equal(t, "", b.Locale("radio-t", "user1"))
+	require.NoError(t, b.SetLocale("radio-t", "user1", "de"))
+	require.NoError(t, b.SetDigestPeriod("radio-t", "user1", "weekly"))
+	assert.Equal(t, "de", b.Locale("radio-t", "user1"))
+	assert.Equal(t, "", b.Locale("radio-t", "user2"))
+	require.NoError(t, b.SetLocale("radio-t", "user1", ""))
+	assert.Equal(t, "", b.Locale("radio-t", "user1"))
+	assert.Equal(t, "weekly", b.DigestPeriod("radio-t", "user1"), "other details kept")
+}
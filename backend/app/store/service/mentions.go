@@ -0,0 +1,49 @@
+package service
+
+import (
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// Mentionable returns users who commented on the post, with the latest visible comment of each user.
+// Implements store.MentionsLister and used by the formatter to resolve @name mentions.
+func (s *DataStore) Mentionable(locator store.Locator) ([]store.Mention, error) {
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "-time"})
+	if err != nil {
+		return nil, err
+	}
+	res := []store.Mention{}
+	seen := map[string]bool{}
+	for _, c := range comments {
+		if c.Deleted || c.Pending || seen[c.User.ID] {
+			continue
+		}
+		seen[c.User.ID] = true
+		res = append(res, store.Mention{UserID: c.User.ID, Name: c.User.Name, CommentID: c.ID})
+	}
+	return res, nil
+}
+
+// SetMentionsOff disables or enables notifications about user's mentions
+func (s *DataStore) SetMentionsOff(siteID, userID string, off bool) error {
+	if !off {
+		return s.DeleteUserDetail(siteID, userID, engine.UserMentionsOff)
+	}
+	_, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserMentionsOff,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  "true",
+	})
+	return err
+}
+
+// IsMentionsOff checks if user disabled notifications about mentions
+func (s *DataStore) IsMentionsOff(siteID, userID string) bool {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserMentionsOff,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	return err == nil && len(res) == 1 && res[0].MentionsOff
+}
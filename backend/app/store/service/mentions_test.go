@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Mentionable(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{Text: "pending", User: store.User{ID: "user2", Name: "user2"}, Locator: locator, Pending: true})
+	require.NoError(t, err)
+
+	res, err := b.Mentionable(locator)
+	require.NoError(t, err)
+	assert.Equal(t, []store.Mention{{UserID: "user1", Name: "user name", CommentID: "id-2"}}, res, "latest comment, pending skipped")
+
+	_, err = b.Mentionable(store.Locator{URL: "https://radio-t.com/none", SiteID: "radio-t"})
+	assert.Error(t, err, "no post yet")
+
+	f := store.NewCommentFormatter().WithMentions(&b)
+	c := f.Format(store.Comment{Text: "thanks @user_name", Locator: locator})
+	assert.Equal(t, []string{"user1"}, c.Mentions)
+}
+
+func TestService_MentionsOff(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	assert.False(t, b.IsMentionsOff("radio-t", "user1"))
+	require.NoError(t, b.SetMentionsOff("radio-t", "user1", true))
+	assert.True(t, b.IsMentionsOff("radio-t", "user1"))
+	assert.False(t, b.IsMentionsOff("radio-t", "user2"))
+	require.NoError(t, b.SetMentionsOff("radio-t", "user1", false))
+	assert.False(t, b.IsMentionsOff("radio-t", "user1"))
+	assert.Error(t, b.SetMentionsOff("bad", "user1", true))
+}
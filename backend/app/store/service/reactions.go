@@ -0,0 +1,141 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ReactionsLister provides allowed reactions per site
+type ReactionsLister interface {
+	List(siteID string) (reactions []string, err error)
+}
+
+// StaticReactionsLister provides reactions from the fixed configuration, Default used for sites not in Sites
+type StaticReactionsLister struct {
+	Default []string
+	Sites   map[string][]string
+}
+
+// List provides allowed reactions for the site
+func (l StaticReactionsLister) List(siteID string) (reactions []string, err error) {
+	if r, ok := l.Sites[siteID]; ok {
+		return r, nil
+	}
+	return l.Default, nil
+}
+
+// ReactReq is the request to toggle user's reaction
+type ReactReq struct {
+	Locator   store.Locator
+	CommentID string
+	UserID    string
+	Emoji     string
+}
+
+// Reactions returns allowed reactions for the site, empty if reactions disabled
+func (s *DataStore) Reactions(siteID string) []string {
+	if s.ReactionsLister == nil {
+		return []string{}
+	}
+	reactions, err := s.ReactionsLister.List(siteID)
+	if err != nil || reactions == nil {
+		return []string{}
+	}
+	return reactions
+}
+
+// React toggles user's reaction for comment, i.e. adds it if not set by the user yet and removes otherwise.
+// Reactions don't affect comment's score.
+func (s *DataStore) React(req ReactReq) (comment store.Comment, err error) {
+	if indexOf(req.Emoji, s.Reactions(req.Locator.SiteID)) < 0 {
+		return comment, errors.Errorf("reaction %q not allowed for site %s", req.Emoji, req.Locator.SiteID)
+	}
+
+	cLock := s.getScopedLocks(req.Locator.URL) // get lock for URL scope
+	cLock.Lock()                               // prevents race on reactions update
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: req.Locator, CommentID: req.CommentID})
+	if err != nil {
+		return comment, err
+	}
+	if comment.Deleted {
+		return comment, errors.Errorf("can't react to deleted comment %s", req.CommentID)
+	}
+
+	if comment.Reactors == nil {
+		comment.Reactors = map[string][]string{}
+	}
+
+	users := comment.Reactors[req.Emoji]
+	if idx := indexOf(req.UserID, users); idx >= 0 {
+		users = append(users[:idx], users[idx+1:]...)
+	} else {
+		if s.MaxUserReactions > 0 && len(userReactions(comment, req.UserID)) >= s.MaxUserReactions {
+			return comment, errors.Errorf("maximum number of reactions exceeded for comment %s", req.CommentID)
+		}
+		users = append(users, req.UserID)
+	}
+
+	if len(users) == 0 {
+		delete(comment.Reactors, req.Emoji)
+	} else {
+		comment.Reactors[req.Emoji] = users
+	}
+	comment.Reactions = countReactions(comment.Reactors)
+
+	comment.Locator = req.Locator
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	comment.MyReactions = userReactions(comment, req.UserID)
+	return comment, nil
+}
+
+// prepReactions sets reactions of the current user and hides reactors from non-admins
+func (s *DataStore) prepReactions(c store.Comment, user store.User) store.Comment {
+	c.MyReactions = userReactions(c, user.ID)
+	if !user.Admin {
+		c.Reactors = nil
+	}
+	return c
+}
+
+// userReactions returns sorted list of user's reactions for the comment
+func userReactions(c store.Comment, userID string) []string {
+	if userID == "" {
+		return nil
+	}
+	var res []string
+	for emoji, users := range c.Reactors {
+		if indexOf(userID, users) >= 0 {
+			res = append(res, emoji)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func countReactions(reactors map[string][]string) map[string]int {
+	if len(reactors) == 0 {
+		return nil
+	}
+	res := make(map[string]int, len(reactors))
+	for emoji, users := range reactors {
+		res[emoji] = len(users)
+	}
+	return res
+}
+
+func indexOf(s string, ss []string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
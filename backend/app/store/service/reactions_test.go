@@ -0,0 +1,78 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_React(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxUserReactions: 2,
+		ReactionsLister: StaticReactionsLister{Default: []string{"👍", "🎉", "👀"}, Sites: map[string][]string{"other": {"👍"}}}}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 1}, c.Reactions)
+	assert.Equal(t, []string{"👍"}, c.MyReactions)
+	assert.Equal(t, 0, c.Score, "reactions don't affect score")
+
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user1", Emoji: "👍"})
+	require.NoError(t, err, "own comment reaction allowed")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "🎉"})
+	require.NoError(t, err)
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👀"})
+	assert.EqualError(t, err, "maximum number of reactions exceeded for comment id-1")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "🤡"})
+	assert.EqualError(t, err, `reaction "🤡" not allowed for site radio-t`)
+
+	res, err := b.Find(locator, "time", store.User{ID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 2, "🎉": 1}, res[0].Reactions)
+	assert.Equal(t, []string{"🎉", "👍"}, res[0].MyReactions)
+	assert.Nil(t, res[0].Reactors, "reactors hidden from users")
+	assert.Nil(t, res[1].MyReactions)
+
+	res, err = b.Find(locator, "time", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"👍": {"user2", "user1"}, "🎉": {"user2"}}, res[0].Reactors, "reactors shown to admin")
+
+	// toggle off
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "🎉"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 2}, c.Reactions)
+	assert.Equal(t, []string{"👍"}, c.MyReactions)
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍"})
+	require.NoError(t, err)
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user1", Emoji: "👍"})
+	require.NoError(t, err)
+	assert.Nil(t, c.Reactions)
+	assert.Nil(t, c.MyReactions)
+
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-2", UserID: "user2", Emoji: "👀"})
+	require.NoError(t, err)
+	res, err = b.Find(locator, "-reactions", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "id-2", res[0].ID)
+
+	// reactions of the current user not stored
+	res, err = b.Find(locator, "time", store.User{ID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"👀"}, res[1].MyReactions)
+	require.NoError(t, b.Put(locator, res[1]))
+	stored, err := b.Engine.Get(getReq(locator, "id-2"))
+	require.NoError(t, err)
+	assert.Nil(t, stored.MyReactions)
+
+	assert.Equal(t, []string{"👍"}, b.Reactions("other"))
+	b.ReactionsLister = nil
+	assert.Equal(t, []string{}, b.Reactions("radio-t"))
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍"})
+	assert.Error(t, err, "reactions disabled")
+}
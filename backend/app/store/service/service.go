@@ -19,6 +19,8 @@ import (
 	"github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/spam"
+	"github.com/umputun/remark42/backend/app/store/trust"
 )
 
 // DataStore wraps store.Interface with additional methods
@@ -36,7 +38,12 @@ type DataStore struct {
 	TitleExtractor         *TitleExtractor
 	RestrictedWordsMatcher *RestrictedWordsMatcher
 	ImageService           *image.Service
-	AdminEdits             bool // allow admin unlimited edits
+	AdminEdits             bool            // allow admin unlimited edits
+	Trust                  *trust.Service  // optional, trust levels restrict new users
+	Spam                   *spam.Service   // optional, classifier holds suspicious comments for review
+	ReactionsLister        ReactionsLister // allowed reactions per site, reactions disabled if nil
+	MaxUserReactions       int             // max number of reactions from a single user per comment, 0 for unlimited
+	LastSeenTTL            time.Duration   // how long to keep user's last visit time per post, 0 to keep forever
 
 	// granular locks
 	scopedLocks struct {
@@ -90,6 +97,19 @@ func (s *DataStore) Create(comment store.Comment) (commentID string, err error)
 		return "", ErrRestrictedWordsFound
 	}
 
+	// imported comments are not restricted, trust stats for them made by RebuildTrust
+	if caps, ok := s.trustCapabilities(comment.Locator.SiteID, comment.User); ok && !comment.Imported {
+		if err = s.checkTrustedText(caps, comment.Text); err != nil {
+			return "", err
+		}
+		comment.Pending = caps.Premoderated
+	}
+
+	probability, suspicious := s.checkSpam(comment)
+	if suspicious {
+		comment.Pending = true
+	}
+
 	func() { // keep input title and set to extracted if missing
 		if s.TitleExtractor == nil || comment.PostTitle != "" {
 			return
@@ -102,9 +122,23 @@ func (s *DataStore) Create(comment store.Comment) (commentID string, err error)
 		comment.PostTitle = title
 	}()
 
+	comment.MyReactions = nil // made for the current user's view only, not stored
 	commentID, err = s.Engine.Create(comment)
 	s.submitImages(comment)
 
+	if err == nil && !comment.Imported {
+		s.onTrustEvent(func(t *trust.Service) error {
+			return t.OnComment(comment.Locator.SiteID, comment.User.ID, comment.Timestamp)
+		})
+	}
+
+	if err == nil && suspicious {
+		suspect := spam.Suspect{ID: commentID, URL: comment.Locator.URL, Probability: probability, Timestamp: comment.Timestamp}
+		if e := s.Spam.Flag(comment.Locator.SiteID, suspect); e != nil {
+			log.Printf("[WARN] failed to flag suspicious comment %s, %v", commentID, e)
+		}
+	}
+
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvCreate); e != nil {
 		log.Printf("[WARN] failed to send create event, %s", e)
 	}
@@ -119,13 +153,23 @@ func (s *DataStore) Find(locator store.Locator, sortMethod string, user store.Us
 
 // FindSince wraps engine's Find call and alter results if needed. Returns comments after since tx
 func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user store.User, since time.Time) ([]store.Comment, error) {
+	return s.findSince(locator, sortMethod, user, since, false)
+}
+
+// findSince finds comments like FindSince, with withPending comments waiting for approval are kept for everyone
+func (s *DataStore) findSince(locator store.Locator, sortMethod string, user store.User, since time.Time,
+	withPending bool) ([]store.Comment, error) {
 	req := engine.FindRequest{Locator: locator, Sort: sortMethod, Since: since}
+	// ranking sorts made locally, not all engines can sort by them natively
+	changedSort := isRankingSort(sortMethod)
+	if changedSort {
+		req.Sort = "time"
+	}
 	comments, err := s.Engine.Find(req)
 	if err != nil {
 		return comments, err
 	}
 
-	changedSort := false
 	// sets votes controversy for comments added prior to #274
 	// also sanitizes locator.URL for comments added prior to #927
 	for i, c := range comments {
@@ -137,6 +181,9 @@ func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user sto
 		}
 		comments[i] = s.alterComment(c, user)
 	}
+	if !withPending {
+		comments = s.filterPending(comments, user, true)
+	}
 
 	// resort commits if altered
 	if changedSort {
@@ -158,6 +205,7 @@ func (s *DataStore) Get(locator store.Locator, commentID string, user store.User
 // Put updates comment, mutable parts only
 func (s *DataStore) Put(locator store.Locator, comment store.Comment) error {
 	comment.Locator = locator
+	comment.MyReactions = nil // made for the current user's view only, not stored
 	return s.Engine.Update(comment)
 }
 
@@ -194,6 +242,39 @@ func (s *DataStore) SetUserEmail(siteID, userID, value string) (string, error) {
 	return "", nil
 }
 
+// GetUserTelegram gets user telegram chat id
+func (s *DataStore) GetUserTelegram(siteID, userID string) (string, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserTelegram,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res) == 1 {
+		return res[0].Telegram, nil
+	}
+	return "", nil
+}
+
+// SetUserTelegram sets user telegram chat id
+func (s *DataStore) SetUserTelegram(siteID, userID, value string) (string, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserTelegram,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  value,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res) == 1 {
+		return res[0].Telegram, nil
+	}
+	return "", nil
+}
+
 // DeleteUserDetail deletes user detail
 func (s *DataStore) DeleteUserDetail(siteID, userID string, detail engine.UserDetail) error {
 	return s.Engine.Delete(engine.DeleteRequest{
@@ -217,7 +298,8 @@ func (s *DataStore) ResubmitStagingImages(sites []string) error {
 	result := new(multierror.Error)
 	for _, site := range sites {
 		locator := store.Locator{SiteID: site}
-		comments, err := s.FindSince(locator, "time", store.User{}, ts)
+		// pending comments included, their images are kept till approval
+		comments, err := s.findSince(locator, "time", store.User{}, ts, true)
 		result = multierror.Append(result, errors.Wrapf(err, "problem finding comments for site %s", site))
 		for _, c := range comments {
 			s.submitImages(c)
@@ -277,10 +359,14 @@ func (s *DataStore) prepareNewComment(comment store.Comment) (store.Comment, err
 	return comment, nil
 }
 
-// DeleteAll removes all data from site
+// DeleteAll removes all data from site. Trust stats of the site marked outdated and rebuilt on the next start.
 func (s *DataStore) DeleteAll(siteID string) error {
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.onTrustEvent(func(t *trust.Service) error { return t.Invalidate(siteID) })
+	return nil
 }
 
 // SetPin pin/un-pin comment as special
@@ -294,6 +380,24 @@ func (s *DataStore) SetPin(locator store.Locator, commentID string, status bool)
 	return s.Engine.Update(comment)
 }
 
+// Approve publishes comment waiting for admin's approval
+func (s *DataStore) Approve(locator store.Locator, commentID string) (store.Comment, error) {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return comment, err
+	}
+	if !comment.Pending {
+		return comment, errors.Errorf("comment %s is not pending", commentID)
+	}
+	comment.Pending = false
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	s.learnSpam(comment, false)
+	return comment, nil
+}
+
 // VoteReq is the request ot make a vote
 type VoteReq struct {
 	Locator   store.Locator
@@ -368,11 +472,11 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 	}
 
 	// update score
-	if req.Val {
-		comment.Score++
-	} else {
-		comment.Score--
+	scoreDelta := 1
+	if !req.Val {
+		scoreDelta = -1
 	}
+	comment.Score += scoreDelta
 
 	comment.Vote = 0
 	if vv, ok := comment.Votes[req.UserID]; ok {
@@ -388,8 +492,13 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 	}
 
 	comment.Controversy = s.controversy(s.upsAndDowns(comment))
+	comment.Best = s.best(s.upsAndDowns(comment))
 	comment.Locator = req.Locator
-	return comment, s.Engine.Update(comment)
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	s.onTrustEvent(func(t *trust.Service) error { return t.OnVote(comment.Locator.SiteID, comment.User.ID, scoreDelta) })
+	return comment, nil
 }
 
 func (s *DataStore) isSameIPVote(req VoteReq, userIPHash string, comment store.Comment) bool {
@@ -424,13 +533,29 @@ func (s *DataStore) controversy(ups, downs int) float64 {
 	return math.Pow(float64(magnitude), balance)
 }
 
+// best calculates lower bound of Wilson score confidence interval for the ratio of upvotes
+// source - https://github.com/reddit-archive/reddit/blob/master/r2/r2/lib/db/_sorts.pyx#L70
+func (s *DataStore) best(ups, downs int) float64 {
+	n := float64(ups + downs)
+	if n == 0 {
+		return 0
+	}
+	const z = 1.281551565545 // 80% confidence
+	p := float64(ups) / n
+	left := p + 1/(2*n)*z*z
+	right := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	under := 1 + 1/n*z*z
+	return (left - right) / under
+}
+
 // EditRequest contains fields needed for comment update
 type EditRequest struct {
-	Text    string
-	Orig    string
-	Summary string
-	Delete  bool
-	Admin   bool
+	Text     string
+	Orig     string
+	Summary  string
+	Delete   bool
+	Admin    bool
+	Mentions []string // ids of users mentioned in the new text
 }
 
 // EditComment to edit text and update Edit info
@@ -441,8 +566,12 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 			return nil
 		}
 
-		// edit allowed in editDuration window only
-		if s.EditDuration > 0 && time.Now().After(comment.Timestamp.Add(s.EditDuration)) {
+		// edit allowed in editDuration window only, trusted users may have a longer one
+		editDuration := s.EditDuration
+		if caps, ok := s.trustCapabilities(comment.Locator.SiteID, comment.User); ok && caps.EditDuration > editDuration {
+			editDuration = caps.EditDuration
+		}
+		if editDuration > 0 && time.Now().After(comment.Timestamp.Add(editDuration)) {
 			return errors.Errorf("too late to edit %s", commentID)
 		}
 
@@ -467,15 +596,28 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 		}
 		comment.Deleted = true
 		delReq := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: store.SoftDelete}
-		return comment, s.Engine.Delete(delReq)
+		if err = s.Engine.Delete(delReq); err != nil {
+			return comment, err
+		}
+		s.onTrustEvent(func(t *trust.Service) error {
+			return t.OnDelete(comment.Locator.SiteID, comment.User.ID, comment.Score, false)
+		})
+		return comment, nil
 	}
 
 	if s.RestrictedWordsMatcher != nil && s.RestrictedWordsMatcher.Match(comment.Locator.SiteID, req.Text) {
 		return comment, ErrRestrictedWordsFound
 	}
 
+	if caps, ok := s.trustCapabilities(comment.Locator.SiteID, comment.User); ok {
+		if err = s.checkTrustedText(caps, req.Text); err != nil {
+			return comment, err
+		}
+	}
+
 	comment.Text = req.Text
 	comment.Orig = req.Orig
+	comment.Mentions = req.Mentions
 	comment.Edit = &store.Edit{Timestamp: time.Now(), Summary: req.Summary}
 	comment.Locator = locator
 	comment.Sanitize()
@@ -678,8 +820,14 @@ func (s *DataStore) SetBlock(siteID, userID string, status bool, ttl time.Durati
 	}
 	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
 		Flag: engine.Blocked, Update: roStatus, TTL: ttl}
-	_, err := s.Engine.Flag(req)
-	return err
+	if _, err := s.Engine.Flag(req); err != nil {
+		return err
+	}
+	if status {
+		s.onTrustEvent(func(t *trust.Service) error { return t.OnBlock(siteID, userID) })
+		s.learnBlockedSpam(siteID, userID)
+	}
+	return nil
 }
 
 // BlockedUsers returns list with all blocked users for given siteID
@@ -704,7 +852,11 @@ func (s *DataStore) Info(locator store.Locator, readonlyAge int) (store.PostInfo
 	if len(res) == 0 {
 		return store.PostInfo{}, errors.Errorf("post %+v not found", locator)
 	}
-	return res[0], nil
+	info := res[0]
+	if followers, e := s.Followers(locator); e == nil {
+		info.Followers = len(followers)
+	}
+	return info, nil
 }
 
 // Delete comment by id
@@ -712,8 +864,29 @@ func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.D
 	if e := s.AdminStore.OnEvent(locator.SiteID, admin.EvDelete); e != nil {
 		log.Printf("[WARN] failed to send delete event, %s", e)
 	}
+
+	// comment loaded before deletion as deleted one doesn't have score and text anymore
+	var comment store.Comment
+	if s.Trust != nil || s.Spam != nil {
+		c, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+		if err != nil {
+			return err
+		}
+		comment = c
+	}
+
 	req := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: mode}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	if !comment.Deleted {
+		s.onTrustEvent(func(t *trust.Service) error {
+			return t.OnDelete(locator.SiteID, comment.User.ID, comment.Score, true)
+		})
+		comment.Locator = locator
+		s.learnSpam(comment, true)
+	}
+	return nil
 }
 
 // DeleteUser removes all comments from user
@@ -831,6 +1004,11 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 			_, err := s.Engine.UserDetail(req)
 			errs = multierror.Append(errs, err)
 		}
+		if um.Details.Linked != "" {
+			req := engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, UserID: um.ID, Detail: engine.UserLinked, Update: um.Details.Linked}
+			_, err := s.Engine.UserDetail(req)
+			errs = multierror.Append(errs, err)
+		}
 	}
 
 	return errs.ErrorOrNil()
@@ -838,18 +1016,18 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 
 // User gets comment for given userID on siteID
 func (s *DataStore) User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error) {
-	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
+	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: s.CanonicalUserID(siteID, userID),
 		Limit: limit, Skip: skip, Sort: "-time"}
 	comments, err := s.Engine.Find(req)
 	if err != nil {
 		return comments, err
 	}
-	return s.alterComments(comments, user), nil
+	return s.filterPending(s.alterComments(comments, user), user, true), nil
 }
 
 // UserCount is comments count by user
 func (s *DataStore) UserCount(siteID, userID string) (int, error) {
-	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID}
+	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: s.CanonicalUserID(siteID, userID)}
 	return s.Engine.Count(req)
 }
 
@@ -860,7 +1038,8 @@ func (s *DataStore) Last(siteID string, limit int, since time.Time, user store.U
 	if err != nil {
 		return comments, err
 	}
-	return s.alterComments(comments, user), nil
+	// last comments cached for all non-admin users together, pending ones can't be shown even to the author
+	return s.filterPending(s.alterComments(comments, user), user, false), nil
 }
 
 // Close store service
@@ -872,10 +1051,25 @@ func (s *DataStore) Close() error {
 	if s.TitleExtractor != nil {
 		errs = multierror.Append(errs, s.TitleExtractor.Close())
 	}
+	if s.Trust != nil {
+		errs = multierror.Append(errs, s.Trust.Close())
+	}
+	if s.Spam != nil {
+		errs = multierror.Append(errs, s.Spam.Close())
+	}
 	errs = multierror.Append(errs, s.Engine.Close())
 	return errs.ErrorOrNil()
 }
 
+// isRankingSort checks if sort is hot or best, with optional +/- prefix
+func isRankingSort(sortMethod string) bool {
+	switch strings.TrimLeft(sortMethod, "+-") {
+	case "hot", "best":
+		return true
+	}
+	return false
+}
+
 func (s *DataStore) upsAndDowns(c store.Comment) (ups, downs int) {
 	for _, v := range c.Votes {
 		if v {
@@ -926,16 +1120,41 @@ func (s *DataStore) alterComment(c store.Comment, user store.User) (res store.Co
 		c.User.Verified, _ = s.Engine.Flag(verifReq)
 	}
 
+	if s.Trust != nil && !c.User.Blocked {
+		c.User.TrustLevel = int(s.TrustLevel(c.Locator.SiteID, c.User))
+	}
+
 	// hide info from non-admins
 	if !user.Admin {
 		c.User.IP = ""
 	}
 
+	// sets best for comments voted prior to best sort support
+	if c.Best == 0 && len(c.Votes) > 0 {
+		c.Best = s.best(s.upsAndDowns(c))
+	}
+
 	c = s.prepVotes(c, user)
+	c = s.prepReactions(c, user)
 	c.Locator.URL = c.SanitizeAsURL(c.Locator.URL) // urls prior to #927
 	return c
 }
 
+// filterPending removes comments waiting for approval. Admins see all of them, authors (if allowed) see their own.
+func (s *DataStore) filterPending(cc []store.Comment, user store.User, showOwn bool) []store.Comment {
+	if user.Admin {
+		return cc
+	}
+	res := make([]store.Comment, 0, len(cc))
+	for _, c := range cc {
+		if c.Pending && !(showOwn && user.ID != "" && c.User.ID == user.ID) {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
 // prepare vote info for client view
 func (s *DataStore) prepVotes(c store.Comment, user store.User) store.Comment {
 
@@ -615,6 +615,60 @@ func TestService_Controversy(t *testing.T) {
 	}
 }
 
+func TestService_Best(t *testing.T) {
+	tbl := []struct {
+		ups, downs int
+		res        float64
+	}{
+		{0, 0, 0},
+		{1, 0, 0.378},
+		{10, 0, 0.859},
+		{5, 5, 0.312},
+		{0, 5, 0},
+	}
+
+	b := DataStore{}
+	for i, tt := range tbl {
+		tt := tt
+		t.Run(fmt.Sprintf("check-%d-%d:%d", i, tt.ups, tt.downs), func(t *testing.T) {
+			assert.InDelta(t, tt.res, b.best(tt.ups, tt.downs), 0.001)
+		})
+	}
+}
+
+func TestService_FindRankingSort(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: false})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, c.Best)
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-2", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.378, c.Best, 0.001)
+
+	res, err := b.Find(locator, "-best", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-2", res[0].ID)
+	assert.Nil(t, res[0].Votes, "votes hidden")
+
+	res, err = b.Find(locator, "+hot", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", res[0].ID)
+
+	// best calculated for comments voted before best was stored
+	c, err = eng.Get(getReq(locator, "id-2"))
+	require.NoError(t, err)
+	c.Best = 0
+	require.NoError(t, eng.Update(c))
+	c, err = b.Get(locator, "id-2", store.User{})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.378, c.Best, 0.001)
+}
+
 func TestService_Pin(t *testing.T) {
 
 	eng, teardown := prepStoreEngine(t)
@@ -932,6 +986,23 @@ func TestService_UserDetailsOperations(t *testing.T) {
 	result, err = b.GetUserEmail("bad-site", "u3")
 	assert.Error(t, err, "Site not found")
 	assert.Empty(t, result)
+
+	// telegram chat kept separately from email
+	result, err = b.SetUserTelegram("radio-t", "u1", "12345")
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", result)
+	result, err = b.GetUserTelegram("radio-t", "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", result)
+	result, err = b.GetUserEmail("radio-t", "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+	assert.NoError(t, b.DeleteUserDetail("radio-t", "u1", engine.UserTelegram))
+	result, err = b.GetUserTelegram("radio-t", "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+	_, err = b.GetUserTelegram("bad-site", "u3")
+	assert.Error(t, err)
 }
 
 func TestService_IsAdmin(t *testing.T) {
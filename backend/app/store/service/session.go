@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// RegisterSession adds user's session or updates one with the same id
+func (s *DataStore) RegisterSession(siteID, userID string, sess store.Session) error {
+	update, err := json.Marshal(sess)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal session")
+	}
+	_, err = s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserSessions,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  string(update),
+	})
+	return err
+}
+
+// Sessions returns user's active sessions
+func (s *DataStore) Sessions(siteID, userID string) ([]store.Session, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserSessions,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := []store.Session{}
+	if len(res) == 1 {
+		for _, sess := range res[0].Sessions {
+			if sess.Expires.After(time.Now()) {
+				result = append(result, sess)
+			}
+		}
+	}
+	return result, nil
+}
+
+// IsSessionActive checks if user's session with given id registered and not expired or revoked
+func (s *DataStore) IsSessionActive(siteID, userID, sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	sessions, err := s.Sessions(siteID, userID)
+	if err != nil {
+		return false
+	}
+	for _, sess := range sessions {
+		if sess.ID == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionsRevoked returns the time user's sessions issued before are revoked, zero if never revoked
+func (s *DataStore) SessionsRevoked(siteID, userID string) time.Time {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserRevoked,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil || len(res) != 1 || res[0].Revoked == 0 {
+		return time.Time{}
+	}
+	return time.Unix(res[0].Revoked, 0)
+}
+
+// RevokeSessions invalidates all user's sessions issued before the given time and drops them from the list
+func (s *DataStore) RevokeSessions(siteID, userID string, ts time.Time) error {
+	_, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserRevoked,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  strconv.FormatInt(ts.Unix(), 10),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can't revoke sessions of %s", userID)
+	}
+	return s.Engine.Delete(engine.DeleteRequest{
+		Locator:    store.Locator{SiteID: siteID},
+		UserID:     userID,
+		UserDetail: engine.UserSessions,
+	})
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Sessions(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	sessions, err := b.Sessions("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, []store.Session{}, sessions)
+	assert.True(t, b.SessionsRevoked("radio-t", "user1").IsZero(), "never revoked")
+
+	now := time.Now().Truncate(time.Second)
+	require.NoError(t, b.RegisterSession("radio-t", "user1",
+		store.Session{ID: "s1", Created: now, Refreshed: now, Expires: now.Add(time.Hour)}))
+	require.NoError(t, b.RegisterSession("radio-t", "user1",
+		store.Session{ID: "s2", Created: now, Refreshed: now, Expires: now.Add(time.Hour)}))
+	require.NoError(t, b.RegisterSession("radio-t", "user2",
+		store.Session{ID: "s3", Created: now, Refreshed: now, Expires: now.Add(time.Hour)}))
+	assert.Error(t, b.RegisterSession("radio-t", "user1", store.Session{Created: now}), "id required")
+
+	sessions, err = b.Sessions("radio-t", "user1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(sessions))
+	assert.Equal(t, "s1", sessions[0].ID)
+	assert.Equal(t, "s2", sessions[1].ID)
+
+	assert.True(t, b.IsSessionActive("radio-t", "user1", "s1"))
+	assert.False(t, b.IsSessionActive("radio-t", "user1", "s3"), "session of another user")
+	assert.False(t, b.IsSessionActive("radio-t", "user1", ""))
+
+	require.NoError(t, b.RevokeSessions("radio-t", "user1", now))
+	assert.False(t, b.IsSessionActive("radio-t", "user1", "s1"), "revoked session")
+	assert.Equal(t, now.Unix(), b.SessionsRevoked("radio-t", "user1").Unix())
+	sessions, err = b.Sessions("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, []store.Session{}, sessions, "revoked sessions dropped")
+
+	sessions, err = b.Sessions("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(sessions), "other user not affected")
+	assert.True(t, b.SessionsRevoked("radio-t", "user2").IsZero())
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// maxBlockedSpamComments limits number of blocked user's comments used to train spam classifier
+const maxBlockedSpamComments = 100
+
+// trainSpamProgress is how often, in posts, training progress logged
+const trainSpamProgress = 1000
+
+// SuspiciousComment is a comment flagged by spam classifier and held for admin's review
+type SuspiciousComment struct {
+	store.Comment
+	Probability float64 `json:"spam_probability"`
+}
+
+// Suspicious returns comments flagged by spam classifier, the most recent first
+func (s *DataStore) Suspicious(siteID string) ([]SuspiciousComment, error) {
+	if s.Spam == nil {
+		return nil, errors.New("spam classifier disabled")
+	}
+	suspects, err := s.Spam.Suspects(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get suspicious comments for %s", siteID)
+	}
+	res := []SuspiciousComment{}
+	for _, sp := range suspects {
+		locator := store.Locator{SiteID: siteID, URL: sp.URL}
+		c, e := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: sp.ID})
+		if e != nil {
+			log.Printf("[WARN] can't get suspicious comment %s, %v", sp.ID, e)
+			continue
+		}
+		res = append(res, SuspiciousComment{Comment: c, Probability: sp.Probability})
+	}
+	return res, nil
+}
+
+// TrainSpam trains spam classifier with comments survived for given age, already trained comments skipped.
+// Scans all comments of the sites, made to run in background and stops on context cancellation.
+func (s *DataStore) TrainSpam(ctx context.Context, sites []string, age time.Duration) error {
+	if s.Spam == nil {
+		return nil
+	}
+	for _, siteID := range sites {
+		posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+		if err != nil {
+			return errors.Wrapf(err, "can't get list of posts for %s", siteID)
+		}
+
+		learned := 0
+		for i, p := range posts {
+			if ctx.Err() != nil {
+				return errors.Wrapf(ctx.Err(), "spam classifier training of %s interrupted", siteID)
+			}
+			if i > 0 && i%trainSpamProgress == 0 {
+				log.Printf("[DEBUG] spam classifier training of %s, %d of %d posts done", siteID, i, len(posts))
+			}
+			comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}})
+			if e != nil {
+				return errors.Wrapf(e, "can't get comments for %s", p.URL)
+			}
+			for _, c := range comments {
+				if c.Deleted || c.Pending || time.Since(c.Timestamp) < age {
+					continue
+				}
+				if e = s.Spam.LearnNew(siteID, c.ID, c.Text, false); e != nil {
+					return errors.Wrapf(e, "can't train spam classifier with %s", c.ID)
+				}
+				learned++
+			}
+		}
+		log.Printf("[INFO] spam classifier trained with %d comments of %s", learned, siteID)
+	}
+	return nil
+}
+
+// checkSpam returns spam probability of the new comment and flag if it should be held for review.
+// Admins and verified users are never suspicious.
+func (s *DataStore) checkSpam(comment store.Comment) (probability float64, suspicious bool) {
+	if s.Spam == nil || comment.Imported || comment.User.Admin || comment.User.Verified ||
+		s.IsAdmin(comment.Locator.SiteID, comment.User.ID) || s.IsVerified(comment.Locator.SiteID, comment.User.ID) {
+		return 0, false
+	}
+	probability, err := s.Spam.Probability(comment.Locator.SiteID, comment.Text)
+	if err != nil {
+		log.Printf("[WARN] can't get spam probability for comment from %s, %v", comment.User.ID, err)
+		return 0, false
+	}
+	return probability, s.Spam.Suspicious(probability)
+}
+
+// learnSpam trains classifier with moderated comment and removes it from suspicious
+func (s *DataStore) learnSpam(comment store.Comment, isSpam bool) {
+	if s.Spam == nil {
+		return
+	}
+	if err := s.Spam.Learn(comment.Locator.SiteID, comment.ID, comment.Text, isSpam); err != nil {
+		log.Printf("[WARN] failed to train spam classifier with %s, %v", comment.ID, err)
+	}
+	if err := s.Spam.Unflag(comment.Locator.SiteID, comment.ID); err != nil {
+		log.Printf("[WARN] failed to unflag comment %s, %v", comment.ID, err)
+	}
+}
+
+// learnBlockedSpam trains classifier with recent comments of blocked user
+func (s *DataStore) learnBlockedSpam(siteID, userID string) {
+	if s.Spam == nil {
+		return
+	}
+	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Limit: maxBlockedSpamComments}
+	comments, err := s.Engine.Find(req)
+	if err != nil {
+		log.Printf("[WARN] can't get comments of blocked user %s, %v", userID, err)
+		return
+	}
+	for _, c := range comments {
+		if !c.Deleted {
+			s.learnSpam(c, true)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/spam"
+)
+
+func TestService_SpamCreate(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	spamSvc, spamTeardown := prepSpamService(t)
+	defer spamTeardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Spam: spamSvc}
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, spamSvc.Learn("radio-t", fmt.Sprintf("s%d", i), "cheap pills casino bonus", true))
+		require.NoError(t, spamSvc.Learn("radio-t", fmt.Sprintf("h%d", i), "nice podcast episode about go", false))
+	}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	user := store.User{ID: "user3", Name: "user name 3"}
+
+	id, err := b.Create(store.Comment{Text: "casino bonus here", User: user, Locator: locator})
+	require.NoError(t, err)
+	c, err := b.Engine.Get(getReq(locator, id))
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "suspicious comment held for review")
+
+	id2, err := b.Create(store.Comment{Text: "nice episode", User: user, Locator: locator})
+	require.NoError(t, err)
+	c, err = b.Engine.Get(getReq(locator, id2))
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+
+	_, err = b.Create(store.Comment{Text: "casino bonus", User: store.User{ID: "admin", Name: "admin", Admin: true}, Locator: locator})
+	require.NoError(t, err)
+
+	res, err := b.Suspicious("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "only one suspicious comment")
+	assert.Equal(t, id, res[0].ID)
+	assert.True(t, res[0].Probability >= spam.DefaultThreshold)
+
+	_, err = b.Approve(locator, id)
+	require.NoError(t, err)
+	res, err = b.Suspicious("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "approved comment not suspicious anymore")
+	counts, err := spamSvc.Store.Counts("radio-t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, counts.Ham, "approved comment learned as ham")
+
+	require.NoError(t, b.Delete(locator, id2, store.SoftDelete))
+	counts, err = spamSvc.Store.Counts("radio-t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, counts.Spam, "deleted comment learned as spam")
+
+	b.Spam = nil
+	_, err = b.Suspicious("radio-t")
+	assert.Error(t, err)
+}
+
+func TestService_SpamBlockAndTrain(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	spamSvc, spamTeardown := prepSpamService(t)
+	defer spamTeardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	assert.NoError(t, b.TrainSpam(context.Background(), []string{"radio-t"}, time.Hour), "nothing to do without classifier")
+
+	b.Spam = spamSvc
+	require.NoError(t, b.SetBlock("radio-t", "user1", true, time.Hour))
+	counts, err := spamSvc.Store.Counts("radio-t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, counts.Spam, "blocked user's comments learned as spam")
+
+	require.NoError(t, b.TrainSpam(context.Background(), []string{"radio-t"}, time.Hour))
+	counts, err = spamSvc.Store.Counts("radio-t", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, counts.Spam, "already trained comments not relabeled")
+	assert.Equal(t, 0, counts.Ham)
+
+	assert.Error(t, b.TrainSpam(context.Background(), []string{"bad-site"}, time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, b.TrainSpam(ctx, []string{"radio-t"}, time.Hour), "interrupted by canceled context")
+}
+
+func prepSpamService(t *testing.T) (svc *spam.Service, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_spam_r42")
+	require.NoError(t, err)
+	spamStore, err := spam.NewBoltStorage(path.Join(loc, "spam.db"), bolt.Options{})
+	require.NoError(t, err)
+	return &spam.Service{Store: spamStore, MinDocs: 3}, func() {
+		_ = spamStore.Close()
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+}
@@ -12,14 +12,31 @@ import (
 type Tree struct {
 	Nodes []*Node        `json:"comments"`
 	Info  store.PostInfo `json:"info,omitempty"`
+	Page  *PageInfo      `json:"page,omitempty"` // set for paginated tree only
 }
 
 // Node is a comment with optional replies
 type Node struct {
-	Comment    store.Comment `json:"comment"`
-	Replies    []*Node       `json:"replies,omitempty"`
-	tsModified time.Time
-	tsCreated  time.Time
+	Comment     store.Comment `json:"comment"`
+	Replies     []*Node       `json:"replies,omitempty"`
+	MoreReplies int           `json:"more_replies,omitempty"` // number of replies hidden by pagination
+	tsModified  time.Time
+	tsCreated   time.Time
+}
+
+// TreePage defines part of the tree to return. Zero values mean no limits.
+type TreePage struct {
+	Offset  int // number of threads (top-level comments) to skip
+	Limit   int // max number of threads
+	Depth   int // max depth of replies
+	Replies int // max number of direct replies per comment
+}
+
+// PageInfo describes the page of paginated tree
+type PageInfo struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"` // total number of threads
 }
 
 // recurData wraps all fields used in recursive processing as intermediate results
@@ -78,6 +95,83 @@ func MakeTree(comments []store.Comment, sortType string, readOnlyAge int) *Tree
 	return &res
 }
 
+// Paginate keeps threads of the requested page only and truncates replies deeper or longer than allowed.
+// Truncated comment's MoreReplies has the number of all hidden replies, including nested ones.
+func (t *Tree) Paginate(page TreePage) *Tree {
+	t.Page = &PageInfo{Offset: page.Offset, Limit: page.Limit, Total: len(t.Nodes)}
+	t.Nodes = pageNodes(t.Nodes, page.Offset, page.Limit)
+	for _, n := range t.Nodes {
+		n.truncate(page.Depth, page.Replies, 0)
+	}
+	return t
+}
+
+// Subtree returns the node with given comment id, with replies paginated by page.
+// Offset and Limit applied to direct replies of the node.
+func (t *Tree) Subtree(commentID string, page TreePage) (*Node, bool) {
+	var find func(nodes []*Node) *Node
+	find = func(nodes []*Node) *Node {
+		for _, n := range nodes {
+			if n.Comment.ID == commentID {
+				return n
+			}
+			if res := find(n.Replies); res != nil {
+				return res
+			}
+		}
+		return nil
+	}
+	node := find(t.Nodes)
+	if node == nil {
+		return nil, false
+	}
+	hidden := node.countReplies()
+	node.Replies = pageNodes(node.Replies, page.Offset, page.Limit)
+	for _, r := range node.Replies {
+		hidden -= 1 + r.countReplies()
+	}
+	node.truncate(page.Depth, page.Replies, 0)
+	node.MoreReplies += hidden
+	return node, true
+}
+
+// truncate removes replies deeper than depth or above replies limit, level is the depth of the node itself
+func (n *Node) truncate(depth, replies, level int) {
+	if depth > 0 && level >= depth {
+		n.MoreReplies = n.countReplies()
+		n.Replies = nil
+		return
+	}
+	if replies > 0 && len(n.Replies) > replies {
+		for _, r := range n.Replies[replies:] {
+			n.MoreReplies += 1 + r.countReplies()
+		}
+		n.Replies = n.Replies[:replies]
+	}
+	for _, r := range n.Replies {
+		r.truncate(depth, replies, level+1)
+	}
+}
+
+// countReplies returns number of all replies, including nested ones
+func (n *Node) countReplies() (res int) {
+	for _, r := range n.Replies {
+		res += 1 + r.countReplies()
+	}
+	return res
+}
+
+func pageNodes(nodes []*Node, offset, limit int) []*Node {
+	if offset >= len(nodes) {
+		return []*Node{}
+	}
+	nodes = nodes[offset:]
+	if limit > 0 && limit < len(nodes) {
+		nodes = nodes[:limit]
+	}
+	return nodes
+}
+
 // proc makes tree for one top-level comment recursively
 func (t *Tree) proc(comments []store.Comment, node *Node, rd *recurData, parentID string) (result *Node, modified, created time.Time) {
 
@@ -163,6 +257,42 @@ func (t *Tree) sortNodes(sortType string) {
 			}
 			return t.Nodes[i].Comment.Controversy < t.Nodes[j].Comment.Controversy
 
+		case "+reactions", "-reactions", "reactions":
+			if strings.HasPrefix(sortType, "-") {
+				if t.Nodes[i].Comment.TotalReactions() == t.Nodes[j].Comment.TotalReactions() {
+					return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+				}
+				return t.Nodes[i].Comment.TotalReactions() > t.Nodes[j].Comment.TotalReactions()
+			}
+			if t.Nodes[i].Comment.TotalReactions() == t.Nodes[j].Comment.TotalReactions() {
+				return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+			}
+			return t.Nodes[i].Comment.TotalReactions() < t.Nodes[j].Comment.TotalReactions()
+
+		case "+hot", "-hot", "hot":
+			if strings.HasPrefix(sortType, "-") {
+				if t.Nodes[i].Comment.Hot() == t.Nodes[j].Comment.Hot() {
+					return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+				}
+				return t.Nodes[i].Comment.Hot() > t.Nodes[j].Comment.Hot()
+			}
+			if t.Nodes[i].Comment.Hot() == t.Nodes[j].Comment.Hot() {
+				return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+			}
+			return t.Nodes[i].Comment.Hot() < t.Nodes[j].Comment.Hot()
+
+		case "+best", "-best", "best":
+			if strings.HasPrefix(sortType, "-") {
+				if t.Nodes[i].Comment.Best == t.Nodes[j].Comment.Best {
+					return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+				}
+				return t.Nodes[i].Comment.Best > t.Nodes[j].Comment.Best
+			}
+			if t.Nodes[i].Comment.Best == t.Nodes[j].Comment.Best {
+				return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+			}
+			return t.Nodes[i].Comment.Best < t.Nodes[j].Comment.Best
+
 		default:
 			return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
 		}
@@ -53,6 +53,60 @@ func TestMakeTree(t *testing.T) {
 	assert.Equal(t, store.PostInfo{URL: "url", Count: 12, FirstTS: ts(46, 1), LastTS: ts(47, 22), ReadOnly: true}, res.Info)
 }
 
+func TestTreePaginate(t *testing.T) {
+	loc := store.Locator{URL: "url", SiteID: "site"}
+	ts := func(min int, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
+	comments := []store.Comment{
+		{Locator: loc, ID: "1", Timestamp: ts(46, 1)},
+		{Locator: loc, ID: "11", ParentID: "1", Timestamp: ts(46, 11)},
+		{Locator: loc, ID: "12", ParentID: "1", Timestamp: ts(46, 12)},
+		{Locator: loc, ID: "13", ParentID: "1", Timestamp: ts(46, 13)},
+		{Locator: loc, ID: "131", ParentID: "13", Timestamp: ts(46, 31)},
+		{Locator: loc, ID: "132", ParentID: "13", Timestamp: ts(46, 32)},
+		{Locator: loc, ID: "14", ParentID: "1", Timestamp: ts(46, 14)},
+		{Locator: loc, ID: "2", Timestamp: ts(47, 2)},
+		{Locator: loc, ID: "21", ParentID: "2", Timestamp: ts(47, 21)},
+		{Locator: loc, ID: "3", Timestamp: ts(47, 30)},
+		{Locator: loc, ID: "4", Timestamp: ts(47, 40)},
+	}
+
+	res := MakeTree(comments, "time", 0).Paginate(TreePage{Limit: 2, Depth: 1, Replies: 2})
+	assert.Equal(t, &PageInfo{Offset: 0, Limit: 2, Total: 4}, res.Page)
+	assert.Equal(t, 11, res.Info.Count, "info not affected by pagination")
+	require.Equal(t, 2, len(res.Nodes))
+	assert.Equal(t, "1", res.Nodes[0].Comment.ID)
+	require.Equal(t, 2, len(res.Nodes[0].Replies))
+	assert.Equal(t, "12", res.Nodes[0].Replies[1].Comment.ID)
+	assert.Equal(t, 4, res.Nodes[0].MoreReplies, "13 with two replies and 14 hidden")
+	assert.Equal(t, 1, len(res.Nodes[1].Replies))
+	assert.Equal(t, 0, res.Nodes[1].MoreReplies)
+
+	res = MakeTree(comments, "time", 0).Paginate(TreePage{Offset: 2, Limit: 2})
+	require.Equal(t, 2, len(res.Nodes))
+	assert.Equal(t, "3", res.Nodes[0].Comment.ID)
+	assert.Equal(t, "4", res.Nodes[1].Comment.ID)
+
+	res = MakeTree(comments, "time", 0).Paginate(TreePage{Offset: 10, Limit: 2})
+	assert.Equal(t, []*Node{}, res.Nodes)
+	assert.Equal(t, 4, res.Page.Total)
+
+	node, ok := MakeTree(comments, "time", 0).Subtree("1", TreePage{Offset: 2, Limit: 1, Depth: 1})
+	require.True(t, ok)
+	require.Equal(t, 1, len(node.Replies))
+	assert.Equal(t, "13", node.Replies[0].Comment.ID)
+	assert.Equal(t, 3, node.MoreReplies, "11, 12 and 14 hidden")
+	assert.Nil(t, node.Replies[0].Replies)
+	assert.Equal(t, 2, node.Replies[0].MoreReplies)
+
+	node, ok = MakeTree(comments, "time", 0).Subtree("13", TreePage{})
+	require.True(t, ok)
+	assert.Equal(t, 2, len(node.Replies))
+	assert.Equal(t, 0, node.MoreReplies)
+
+	_, ok = MakeTree(comments, "time", 0).Subtree("nope", TreePage{})
+	assert.False(t, ok)
+}
+
 func TestMakeEmptySubtree(t *testing.T) {
 	loc := store.Locator{URL: "url", SiteID: "site"}
 	ts := func(min int, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
@@ -153,6 +207,16 @@ func TestTreeSortNodes(t *testing.T) {
 	assert.Equal(t, "2", res.Nodes[2].Comment.ID)
 	assert.Equal(t, "3", res.Nodes[3].Comment.ID)
 
+	comments[2].Best, comments[3].Best = 0.3, 0.7
+	res = MakeTree(comments, "-best", 0)
+	assert.Equal(t, "2", res.Nodes[0].Comment.ID)
+	assert.Equal(t, "1", res.Nodes[1].Comment.ID)
+
+	res = MakeTree(comments, "-hot", 0)
+	assert.Equal(t, "2", res.Nodes[0].Comment.ID)
+	assert.Equal(t, "1", res.Nodes[1].Comment.ID)
+	assert.Equal(t, "4", res.Nodes[4].Comment.ID, "negative score is the last")
+
 	res = MakeTree(comments, "undefined", 0)
 	t.Log(res.Nodes[0].Comment.ID, res.Nodes[0].tsModified)
 	assert.Equal(t, "1", res.Nodes[0].Comment.ID)
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/trust"
+)
+
+// ErrLinksNotAllowed returned in case comment contains links and user's trust level doesn't allow them
+var ErrLinksNotAllowed = errors.New("links not allowed for user's trust level")
+
+// ErrImagesNotAllowed returned in case comment contains images and user's trust level doesn't allow them
+var ErrImagesNotAllowed = errors.New("images not allowed for user's trust level")
+
+// TrustLevel returns trust level of the user on the site, LevelNew if trust levels disabled
+func (s *DataStore) TrustLevel(siteID string, user store.User) trust.Level {
+	if s.Trust == nil {
+		return trust.LevelNew
+	}
+	if user.Admin || user.Verified || s.IsAdmin(siteID, user.ID) || s.IsVerified(siteID, user.ID) {
+		return trust.LevelTrusted
+	}
+	level, err := s.Trust.Level(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't get trust level for %s on %s, %v", user.ID, siteID, err)
+	}
+	return level
+}
+
+// SetTrustLevel sets trust level for the user regardless of user's history
+func (s *DataStore) SetTrustLevel(siteID, userID string, level trust.Level) error {
+	if s.Trust == nil {
+		return errors.New("trust levels disabled")
+	}
+	return s.Trust.SetOverride(siteID, userID, level)
+}
+
+// ResetTrustLevel removes trust level set by admin
+func (s *DataStore) ResetTrustLevel(siteID, userID string) error {
+	if s.Trust == nil {
+		return errors.New("trust levels disabled")
+	}
+	return s.Trust.ResetOverride(siteID, userID)
+}
+
+// RebuildTrust recalculates users stats for trust levels from all comments of given sites.
+// Sites with stats rebuilt by the current version of calculation skipped, stats kept updated by events after that.
+func (s *DataStore) RebuildTrust(sites []string) error {
+	if s.Trust == nil {
+		return nil
+	}
+	for _, siteID := range sites {
+		outdated, err := s.Trust.Outdated(siteID)
+		if err != nil {
+			return errors.Wrapf(err, "can't check trust stats of %s", siteID)
+		}
+		if !outdated {
+			continue
+		}
+		if err = s.rebuildSiteTrust(siteID); err != nil {
+			return err
+		}
+		if err = s.Trust.MarkRebuilt(siteID); err != nil {
+			return errors.Wrapf(err, "can't mark trust stats of %s rebuilt", siteID)
+		}
+	}
+	return nil
+}
+
+// rebuildSiteTrust recalculates stats of all users of the site from all comments
+func (s *DataStore) rebuildSiteTrust(siteID string) error {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return errors.Wrapf(err, "can't get list of posts for %s", siteID)
+	}
+
+	history := map[string]trust.Stats{}
+	for _, p := range posts {
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}})
+		if e != nil {
+			return errors.Wrapf(e, "can't get comments for %s", p.URL)
+		}
+		for _, c := range comments {
+			if c.Deleted {
+				continue
+			}
+			stats := history[c.User.ID]
+			stats.Comments++
+			stats.Score += c.Score
+			if stats.FirstSeen.IsZero() || c.Timestamp.Before(stats.FirstSeen) {
+				stats.FirstSeen = c.Timestamp
+			}
+			history[c.User.ID] = stats
+		}
+	}
+
+	blocked, err := s.BlockedUsers(siteID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get list of blocked users for %s", siteID)
+	}
+	for _, b := range blocked {
+		stats := history[b.ID]
+		stats.Blocks = 1
+		history[b.ID] = stats
+	}
+
+	for userID, stats := range history {
+		if err = s.Trust.Rebuild(siteID, userID, stats); err != nil {
+			return errors.Wrapf(err, "can't rebuild trust stats for %s", userID)
+		}
+	}
+	log.Printf("[INFO] trust stats rebuilt for %d users of %s", len(history), siteID)
+	return nil
+}
+
+// trustCapabilities returns capabilities for the user, false if trust levels disabled
+func (s *DataStore) trustCapabilities(siteID string, user store.User) (trust.Capabilities, bool) {
+	if s.Trust == nil {
+		return trust.Capabilities{}, false
+	}
+	return s.Trust.Capabilities(s.TrustLevel(siteID, user)), true
+}
+
+// checkTrustedText rejects text with links or images not allowed by capabilities
+func (s *DataStore) checkTrustedText(caps trust.Capabilities, text string) error {
+	if caps.Links && caps.Images {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		return errors.Wrap(err, "can't parse comment text")
+	}
+	if !caps.Links && doc.Find("a[href]").Length() > 0 {
+		return ErrLinksNotAllowed
+	}
+	if !caps.Images && doc.Find("img").Length() > 0 {
+		return ErrImagesNotAllowed
+	}
+	return nil
+}
+
+// onTrustEvent passes event to trust service if enabled, errors only logged
+func (s *DataStore) onTrustEvent(fn func(t *trust.Service) error) {
+	if s.Trust == nil {
+		return
+	}
+	if err := fn(s.Trust); err != nil {
+		log.Printf("[WARN] failed to update trust stats, %v", err)
+	}
+}
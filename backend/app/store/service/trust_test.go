@@ -0,0 +1,241 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/trust"
+)
+
+func TestService_TrustCreate(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	trustSvc, trustTeardown := prepTrustService(t)
+	defer trustTeardown()
+	trustSvc.Premoderation = true
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Trust: trustSvc}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	user := store.User{ID: "user3", Name: "user name 3"}
+
+	_, err := b.Create(store.Comment{Text: `see <a href="http://example.com">this</a>`, User: user, Locator: locator})
+	assert.Equal(t, ErrLinksNotAllowed, err)
+	_, err = b.Create(store.Comment{Text: `look <img src="http://example.com/1.png">`, User: user, Locator: locator})
+	assert.Equal(t, ErrImagesNotAllowed, err)
+
+	id, err := b.Create(store.Comment{Text: "plain text", User: user, Locator: locator})
+	require.NoError(t, err)
+	c, err := b.Engine.Get(getReq(locator, id))
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "new user premoderated")
+
+	// imported comments not restricted
+	_, err = b.Create(store.Comment{Text: `<a href="http://example.com">link</a>`, User: user, Locator: locator, Imported: true})
+	assert.NoError(t, err)
+
+	res, err := b.Find(locator, "time", store.User{ID: "someone"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "pending comment hidden from others")
+	res, err = b.Find(locator, "time", user)
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res), "pending comment shown to the author")
+	assert.True(t, res[2].Pending)
+	res, err = b.Find(locator, "time", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res), "pending comment shown to admin")
+	res, err = b.Last("radio-t", 0, time.Time{}, user)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "pending comment hidden from last even for the author")
+	res, err = b.findSince(locator, "time", store.User{}, time.Time{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res), "pending comment kept for staging images")
+	info, err := b.Info(locator, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, info.Count, "pending comment not counted")
+	assert.Equal(t, 1, info.Pending)
+	counts, err := b.Counts("radio-t", []string{locator.URL})
+	require.NoError(t, err)
+	assert.Equal(t, 3, counts[0].Count, "pending comment not counted")
+	posts, err := b.List("radio-t", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(posts))
+	assert.Equal(t, 3, posts[0].Count, "pending comment not counted")
+
+	c, err = b.Approve(locator, id)
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+	res, err = b.Find(locator, "time", store.User{ID: "someone"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(res), "approved comment visible")
+	info, err = b.Info(locator, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 4, info.Count, "approved comment counted")
+	_, err = b.Approve(locator, id)
+	assert.Error(t, err, "not pending anymore")
+
+	stats, err := trustSvc.Store.Get("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Comments, "imported and rejected comments not counted")
+
+	// admins are not restricted
+	id, err = b.Create(store.Comment{Text: `<a href="http://example.com">link</a>`, User: store.User{ID: "admin", Name: "admin", Admin: true}, Locator: locator})
+	require.NoError(t, err)
+	c, err = b.Engine.Get(getReq(locator, id))
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+}
+
+func TestService_TrustEvents(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	trustSvc, trustTeardown := prepTrustService(t)
+	defer trustTeardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Trust: trustSvc, MaxVotes: -1}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	for i := 0; i < 3; i++ {
+		_, err := b.Create(store.Comment{Text: "text", User: store.User{ID: "user3", Name: "name"}, Locator: locator})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, trust.LevelBasic, b.TrustLevel("radio-t", store.User{ID: "user3"}))
+
+	res, err := b.User("radio-t", "user3", 0, 0, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, int(trust.LevelBasic), res[0].User.TrustLevel)
+
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: res[0].ID, UserID: "user1", Val: true})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: res[1].ID, UserID: "user1", Val: true})
+	require.NoError(t, err)
+	stats, err := trustSvc.Store.Get("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Score)
+
+	require.NoError(t, b.Delete(locator, res[0].ID, store.SoftDelete))
+	stats, err = trustSvc.Store.Get("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Score)
+	assert.Equal(t, 2, stats.Comments)
+	assert.Equal(t, 1, stats.Deleted)
+	assert.Equal(t, trust.LevelNew, b.TrustLevel("radio-t", store.User{ID: "user3"}), "2 comments left")
+
+	_, err = b.EditComment(locator, res[1].ID, EditRequest{Delete: true})
+	require.NoError(t, err)
+	stats, err = trustSvc.Store.Get("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Score)
+	assert.Equal(t, 1, stats.Comments)
+	assert.Equal(t, 1, stats.Deleted, "self-deletion is not a penalty")
+
+	require.NoError(t, b.SetBlock("radio-t", "user3", true, time.Hour))
+	require.NoError(t, b.SetBlock("radio-t", "user3", false, 0))
+	stats, err = trustSvc.Store.Get("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Blocks)
+
+	require.NoError(t, b.SetTrustLevel("radio-t", "user3", trust.LevelMember))
+	assert.Equal(t, trust.LevelMember, b.TrustLevel("radio-t", store.User{ID: "user3"}))
+	require.NoError(t, b.ResetTrustLevel("radio-t", "user3"))
+	assert.Equal(t, trust.LevelNew, b.TrustLevel("radio-t", store.User{ID: "user3"}))
+
+	require.NoError(t, b.SetVerified("radio-t", "user3", true))
+	assert.Equal(t, trust.LevelTrusted, b.TrustLevel("radio-t", store.User{ID: "user3"}), "verified user trusted")
+
+	b.Trust = nil
+	assert.Equal(t, trust.LevelNew, b.TrustLevel("radio-t", store.User{ID: "user3"}))
+	assert.Error(t, b.SetTrustLevel("radio-t", "user3", trust.LevelMember))
+	assert.Error(t, b.ResetTrustLevel("radio-t", "user3"))
+}
+
+func TestService_TrustEditDuration(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	trustSvc, trustTeardown := prepTrustService(t)
+	defer trustTeardown()
+	trustSvc.EditDuration = time.Hour
+	b := DataStore{Engine: eng, EditDuration: 100 * time.Millisecond, AdminStore: admin.NewStaticKeyStore("secret 123"), Trust: trustSvc}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	id, err := b.Create(store.Comment{Text: "text", User: store.User{ID: "user3", Name: "name"}, Locator: locator,
+		Timestamp: time.Now()})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 200)
+
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "yyy", Text: "xxx"})
+	assert.EqualError(t, err, "too late to edit "+id)
+
+	require.NoError(t, b.SetTrustLevel("radio-t", "user3", trust.LevelTrusted))
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "yyy", Text: `<a href="http://example.com">xxx</a>`})
+	assert.NoError(t, err, "trusted user has extended edit window")
+
+	require.NoError(t, b.SetTrustLevel("radio-t", "user3", trust.LevelNew))
+	b.EditDuration = 0
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "yyy", Text: `<a href="http://example.com">xxx</a>`})
+	assert.Equal(t, ErrLinksNotAllowed, err)
+}
+
+func TestService_RebuildTrust(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	trustSvc, trustTeardown := prepTrustService(t)
+	defer trustTeardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	require.NoError(t, b.SetBlock("radio-t", "user2", true, time.Hour))
+	assert.NoError(t, b.RebuildTrust([]string{"radio-t"}), "nothing to do without trust service")
+
+	b.Trust = trustSvc
+	require.NoError(t, b.RebuildTrust([]string{"radio-t"}))
+
+	stats, err := trustSvc.Store.Get("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Comments)
+	assert.True(t, stats.FirstSeen.Equal(time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)))
+
+	stats, err = trustSvc.Store.Get("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Blocks)
+
+	// stats of the current version not rebuilt again
+	require.NoError(t, trustSvc.Store.Update("radio-t", "user1", func(stats *trust.Stats) { stats.Comments = 5 }))
+	require.NoError(t, b.RebuildTrust([]string{"radio-t"}))
+	stats, err = trustSvc.Store.Get("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, stats.Comments, "up to date stats skipped")
+
+	// import replaces all comments of the site
+	require.NoError(t, b.DeleteAll("radio-t"))
+	outdated, err := trustSvc.Outdated("radio-t")
+	require.NoError(t, err)
+	assert.True(t, outdated, "stats outdated after removal of all comments")
+	_, err = b.Create(store.Comment{Text: "imported", User: store.User{ID: "user1", Name: "user name"},
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Imported: true})
+	require.NoError(t, err)
+	require.NoError(t, b.RebuildTrust([]string{"radio-t"}))
+	stats, err = trustSvc.Store.Get("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Comments, "stats rebuilt from imported comments")
+
+	assert.Error(t, b.RebuildTrust([]string{"bad-site"}))
+}
+
+func prepTrustService(t *testing.T) (svc *trust.Service, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_trust_r42")
+	require.NoError(t, err)
+	trustStore, err := trust.NewBoltStorage(path.Join(loc, "trust.db"), bolt.Options{})
+	require.NoError(t, err)
+	return &trust.Service{Store: trustStore}, func() {
+		_ = trustStore.Close()
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SetWebPush adds user's browser push subscription or removes one with the same endpoint
+func (s *DataStore) SetWebPush(siteID, userID string, sub store.PushSubscription, subscribe bool) error {
+	if !subscribe {
+		sub.Keys.P256dh, sub.Keys.Auth = "", ""
+	} else if sub.Keys.P256dh == "" || sub.Keys.Auth == "" {
+		return errors.New("push subscription keys should be set")
+	}
+	update, err := json.Marshal(sub)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal push subscription")
+	}
+	_, err = s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserWebPush,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  string(update),
+	})
+	return err
+}
+
+// WebPush returns user's browser push subscriptions
+func (s *DataStore) WebPush(siteID, userID string) ([]store.PushSubscription, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserWebPush,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 1 {
+		return res[0].WebPush, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_WebPush(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	subs, err := b.WebPush("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Empty(t, subs)
+
+	sub := store.PushSubscription{Endpoint: "https://push.example.com/1"}
+	assert.EqualError(t, b.SetWebPush("radio-t", "user1", sub, true), "push subscription keys should be set")
+
+	sub.Keys.P256dh, sub.Keys.Auth = "key", "auth"
+	require.NoError(t, b.SetWebPush("radio-t", "user1", sub, true))
+	other := sub
+	other.Endpoint = "https://push.example.com/2"
+	require.NoError(t, b.SetWebPush("radio-t", "user1", other, true))
+
+	subs, err = b.WebPush("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, []store.PushSubscription{sub, other}, subs)
+
+	require.NoError(t, b.SetWebPush("radio-t", "user1", store.PushSubscription{Endpoint: sub.Endpoint}, false))
+	subs, err = b.WebPush("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, []store.PushSubscription{other}, subs)
+}
@@ -0,0 +1,207 @@
+package spam
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt implements Store with bolt DB. Each site has its own top-level bucket with nested buckets
+// for token counters, trained documents and suspects, and totals key with number of documents per class.
+type Bolt struct {
+	fileName string
+	db       *bolt.DB
+}
+
+const (
+	wordsBucketName    = "words"
+	docsBucketName     = "docs"
+	suspectsBucketName = "suspects"
+	totalsKey          = "totals"
+)
+
+// NewBoltStorage makes bolt spam store
+func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	return &Bolt{db: db, fileName: fileName}, nil
+}
+
+// Learn adds document to the model. Known document skipped unless replace set, in this case
+// it's unlearned with the old label first.
+func (b *Bolt) Learn(siteID string, doc Document, replace bool) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		siteBkt, err := b.siteBucket(tx, siteID)
+		if err != nil {
+			return err
+		}
+		docsBkt, wordsBkt := siteBkt.Bucket([]byte(docsBucketName)), siteBkt.Bucket([]byte(wordsBucketName))
+
+		totals := WordCount{}
+		if err = b.load(siteBkt, totalsKey, &totals); err != nil {
+			return err
+		}
+
+		if data := docsBkt.Get([]byte(doc.ID)); data != nil {
+			if !replace {
+				return nil
+			}
+			old := Document{}
+			if err = json.Unmarshal(data, &old); err != nil {
+				return errors.Wrapf(err, "can't unmarshal document %s", doc.ID)
+			}
+			if err = b.count(wordsBkt, &totals, old, -1); err != nil {
+				return err
+			}
+		}
+
+		if err = b.count(wordsBkt, &totals, doc, 1); err != nil {
+			return err
+		}
+		if err = b.save(siteBkt, totalsKey, totals); err != nil {
+			return err
+		}
+		return b.save(docsBkt, doc.ID, doc)
+	})
+}
+
+// Counts returns number of documents per class and counters for given tokens
+func (b *Bolt) Counts(siteID string, tokens []string) (res Counts, err error) {
+	res.Words = map[string]WordCount{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		siteBkt := tx.Bucket([]byte(siteID))
+		if siteBkt == nil {
+			return nil
+		}
+		totals := WordCount{}
+		if err = b.load(siteBkt, totalsKey, &totals); err != nil {
+			return err
+		}
+		res.Spam, res.Ham = totals.Spam, totals.Ham
+
+		wordsBkt := siteBkt.Bucket([]byte(wordsBucketName))
+		for _, t := range tokens {
+			wc := WordCount{}
+			if err = b.load(wordsBkt, t, &wc); err != nil {
+				return err
+			}
+			if wc.Spam > 0 || wc.Ham > 0 {
+				res.Words[t] = wc
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Flag saves suspect, replaces existing one with the same id
+func (b *Bolt) Flag(siteID string, suspect Suspect) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		siteBkt, err := b.siteBucket(tx, siteID)
+		if err != nil {
+			return err
+		}
+		return b.save(siteBkt.Bucket([]byte(suspectsBucketName)), suspect.ID, suspect)
+	})
+}
+
+// Unflag removes suspect, does nothing for unknown id
+func (b *Bolt) Unflag(siteID, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		siteBkt := tx.Bucket([]byte(siteID))
+		if siteBkt == nil {
+			return nil
+		}
+		return errors.Wrapf(siteBkt.Bucket([]byte(suspectsBucketName)).Delete([]byte(id)), "can't delete suspect %s", id)
+	})
+}
+
+// Suspects returns all suspects of the site, the most recent first
+func (b *Bolt) Suspects(siteID string) (res []Suspect, err error) {
+	res = []Suspect{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		siteBkt := tx.Bucket([]byte(siteID))
+		if siteBkt == nil {
+			return nil
+		}
+		return siteBkt.Bucket([]byte(suspectsBucketName)).ForEach(func(k, v []byte) error {
+			suspect := Suspect{}
+			if e := json.Unmarshal(v, &suspect); e != nil {
+				return errors.Wrapf(e, "can't unmarshal suspect %s", string(k))
+			}
+			res = append(res, suspect)
+			return nil
+		})
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Timestamp.After(res[j].Timestamp) })
+	return res, err
+}
+
+// Close bolt store
+func (b *Bolt) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
+
+// siteBucket returns site's bucket, creates it with all nested buckets if missing
+func (b *Bolt) siteBucket(tx *bolt.Tx, siteID string) (*bolt.Bucket, error) {
+	siteBkt, err := tx.CreateBucketIfNotExists([]byte(siteID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create bucket %s", siteID)
+	}
+	for _, name := range []string{wordsBucketName, docsBucketName, suspectsBucketName} {
+		if _, err = siteBkt.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return nil, errors.Wrapf(err, "failed to create bucket %s in %s", name, siteID)
+		}
+	}
+	return siteBkt, nil
+}
+
+// count adds delta to counters of all document's tokens and to totals
+func (b *Bolt) count(wordsBkt *bolt.Bucket, totals *WordCount, doc Document, delta int) error {
+	inc := func(wc *WordCount) {
+		if doc.Spam {
+			wc.Spam += delta
+			return
+		}
+		wc.Ham += delta
+	}
+
+	inc(totals)
+	for _, t := range doc.Tokens {
+		wc := WordCount{}
+		if err := b.load(wordsBkt, t, &wc); err != nil {
+			return err
+		}
+		inc(&wc)
+		if wc.Spam <= 0 && wc.Ham <= 0 {
+			if err := wordsBkt.Delete([]byte(t)); err != nil {
+				return errors.Wrapf(err, "can't delete token %s", t)
+			}
+			continue
+		}
+		if err := b.save(wordsBkt, t, wc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) load(bkt *bolt.Bucket, key string, res interface{}) error {
+	data := bkt.Get([]byte(key))
+	if data == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(data, res), "can't unmarshal %s", key)
+}
+
+func (b *Bolt) save(bkt *bolt.Bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s", key)
+	}
+	return errors.Wrapf(bkt.Put([]byte(key), data), "can't put %s", key)
+}
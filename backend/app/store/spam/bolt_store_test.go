@@ -0,0 +1,84 @@
+package spam
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore_LearnCounts(t *testing.T) {
+	b, teardown := prepareBoltSpamStorageTest(t)
+	defer teardown()
+
+	counts, err := b.Counts("site1", []string{"buy"})
+	require.NoError(t, err)
+	assert.Equal(t, Counts{Words: map[string]WordCount{}}, counts, "empty for unknown site")
+
+	require.NoError(t, b.Learn("site1", Document{ID: "c1", Spam: true, Tokens: []string{"buy", "cheap"}}, false))
+	require.NoError(t, b.Learn("site1", Document{ID: "c2", Spam: false, Tokens: []string{"buy", "book"}}, false))
+	require.NoError(t, b.Learn("site1", Document{ID: "c2", Spam: true, Tokens: []string{"buy", "book"}}, false))
+
+	counts, err = b.Counts("site1", []string{"buy", "cheap", "book", "other"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, counts.Spam)
+	assert.Equal(t, 1, counts.Ham, "known document not relabeled without replace")
+	assert.Equal(t, map[string]WordCount{"buy": {Spam: 1, Ham: 1}, "cheap": {Spam: 1}, "book": {Ham: 1}}, counts.Words)
+
+	require.NoError(t, b.Learn("site1", Document{ID: "c2", Spam: true, Tokens: []string{"buy", "book"}}, true))
+	counts, err = b.Counts("site1", []string{"buy", "cheap", "book"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, counts.Spam)
+	assert.Equal(t, 0, counts.Ham)
+	assert.Equal(t, map[string]WordCount{"buy": {Spam: 2}, "cheap": {Spam: 1}, "book": {Spam: 1}}, counts.Words)
+
+	counts, err = b.Counts("site2", []string{"buy"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, counts.Spam, "model separated by site")
+}
+
+func TestBoltStore_Suspects(t *testing.T) {
+	b, teardown := prepareBoltSpamStorageTest(t)
+	defer teardown()
+
+	res, err := b.Suspects("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []Suspect{}, res)
+	require.NoError(t, b.Unflag("site1", "c1"), "unflag unknown")
+
+	ts := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, b.Flag("site1", Suspect{ID: "c1", URL: "https://example.com/1", Probability: 0.95, Timestamp: ts}))
+	require.NoError(t, b.Flag("site1", Suspect{ID: "c2", URL: "https://example.com/2", Probability: 0.99, Timestamp: ts.Add(time.Minute)}))
+
+	res, err = b.Suspects("site1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "c2", res[0].ID, "the most recent first")
+	assert.Equal(t, "https://example.com/1", res[1].URL)
+	assert.Equal(t, 0.95, res[1].Probability)
+
+	require.NoError(t, b.Unflag("site1", "c2"))
+	res, err = b.Suspects("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "c1", res[0].ID)
+}
+
+func prepareBoltSpamStorageTest(t *testing.T) (b *Bolt, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_spam_r42")
+	require.NoError(t, err, "failed to make temp dir")
+
+	b, err = NewBoltStorage(path.Join(loc, "spam.db"), bolt.Options{})
+	require.NoError(t, err, "new bolt storage")
+
+	teardown = func() {
+		_ = b.Close()
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+	return b, teardown
+}
@@ -0,0 +1,191 @@
+// Package spam implements naive Bayes classifier trained on comments moderated by admins.
+// Store keeps per-site token counters, trained documents and comments flagged as suspicious.
+// Service tokenizes comments, trains the model and calculates spam probability. Everything works offline.
+package spam
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+)
+
+// Document is a trained comment
+type Document struct {
+	ID     string   `json:"id"`
+	Spam   bool     `json:"spam"`
+	Tokens []string `json:"tokens"`
+}
+
+// WordCount keeps number of spam and ham documents with the token
+type WordCount struct {
+	Spam int `json:"spam"`
+	Ham  int `json:"ham"`
+}
+
+// Counts is a part of the model needed to classify a document
+type Counts struct {
+	Spam  int                  // number of spam documents
+	Ham   int                  // number of ham documents
+	Words map[string]WordCount // counts of requested tokens, missing tokens never seen
+}
+
+// Suspect is a comment flagged by classifier
+type Suspect struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Probability float64   `json:"probability"`
+	Timestamp   time.Time `json:"time"`
+}
+
+// Store defines interface to keep the model and suspicious comments
+type Store interface {
+	Learn(siteID string, doc Document, replace bool) error // add document, relabel known one if replace set
+	Counts(siteID string, tokens []string) (Counts, error)
+	Flag(siteID string, suspect Suspect) error
+	Unflag(siteID, id string) error
+	Suspects(siteID string) ([]Suspect, error)
+	Close() error
+}
+
+// DefaultThreshold used if Service.Threshold not set
+const DefaultThreshold = 0.9
+
+// DefaultMinDocs used if Service.MinDocs not set
+const DefaultMinDocs = 10
+
+const (
+	minTokenLen = 2
+	maxTokenLen = 32
+	maxTokens   = 500
+)
+
+// Service wraps Store with tokenizer and classifier
+type Service struct {
+	Store     Store
+	Threshold float64 // min probability to flag comment as suspicious
+	MinDocs   int     // min number of documents in each class before classifier starts to flag anything
+}
+
+// Probability returns probability of the text to be spam. Returns 0 until the model has enough documents.
+func (s *Service) Probability(siteID, text string) (float64, error) {
+	tokens, err := Tokenize(text)
+	if err != nil {
+		return 0, err
+	}
+	counts, err := s.Store.Counts(siteID, tokens)
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get spam model for %s", siteID)
+	}
+	minDocs := s.MinDocs
+	if minDocs <= 0 {
+		minDocs = DefaultMinDocs
+	}
+	if counts.Spam < minDocs || counts.Ham < minDocs {
+		return 0, nil
+	}
+	return classify(counts, tokens), nil
+}
+
+// Suspicious checks if probability high enough to flag comment
+func (s *Service) Suspicious(probability float64) bool {
+	threshold := s.Threshold
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return probability >= threshold
+}
+
+// Learn trains the model with comment, relabels it if already trained with other label
+func (s *Service) Learn(siteID, id, text string, spam bool) error {
+	return s.learn(siteID, id, text, spam, true)
+}
+
+// LearnNew trains the model with comment unless it already trained
+func (s *Service) LearnNew(siteID, id, text string, spam bool) error {
+	return s.learn(siteID, id, text, spam, false)
+}
+
+// Flag marks comment as suspicious
+func (s *Service) Flag(siteID string, suspect Suspect) error {
+	return s.Store.Flag(siteID, suspect)
+}
+
+// Unflag removes comment from suspicious
+func (s *Service) Unflag(siteID, id string) error {
+	return s.Store.Unflag(siteID, id)
+}
+
+// Suspects returns all suspicious comments of the site
+func (s *Service) Suspects(siteID string) ([]Suspect, error) {
+	return s.Store.Suspects(siteID)
+}
+
+// Close store
+func (s *Service) Close() error {
+	return s.Store.Close()
+}
+
+func (s *Service) learn(siteID, id, text string, spam, replace bool) error {
+	tokens, err := Tokenize(text)
+	if err != nil {
+		return err
+	}
+	return s.Store.Learn(siteID, Document{ID: id, Spam: spam, Tokens: tokens}, replace)
+}
+
+// Tokenize splits comment's html to unique lowercase words, hosts of links added as "link:host" tokens
+func Tokenize(text string) ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't parse comment text")
+	}
+
+	seen := map[string]bool{}
+	res := []string{}
+	add := func(token string) {
+		if len(res) >= maxTokens || seen[token] {
+			return
+		}
+		seen[token] = true
+		res = append(res, token)
+	}
+
+	doc.Find("a[href]").Each(func(_ int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		href = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(href), "https://"), "http://")
+		if host := strings.SplitN(href, "/", 2)[0]; host != "" {
+			add("link:" + strings.TrimPrefix(host, "www."))
+		}
+	})
+
+	words := strings.FieldsFunc(strings.ToLower(doc.Text()), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if l := utf8.RuneCountInString(w); l >= minTokenLen && l <= maxTokenLen {
+			add(w)
+		}
+	}
+	return res, nil
+}
+
+// classify calculates spam probability with Laplace smoothing. Tokens never seen in training ignored.
+func classify(counts Counts, tokens []string) float64 {
+	spamDocs, hamDocs := float64(counts.Spam), float64(counts.Ham)
+	logOdds := math.Log(spamDocs / hamDocs)
+	for _, t := range tokens {
+		wc, ok := counts.Words[t]
+		if !ok || (wc.Spam == 0 && wc.Ham == 0) {
+			continue
+		}
+		pSpam := (float64(wc.Spam) + 1) / (spamDocs + 2)
+		pHam := (float64(wc.Ham) + 1) / (hamDocs + 2)
+		logOdds += math.Log(pSpam / pHam)
+	}
+	return 1 / (1 + math.Exp(-logOdds))
+}
@@ -0,0 +1,80 @@
+package spam
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tbl := []struct {
+		text   string
+		tokens []string
+	}{
+		{"", []string{}},
+		{"<p>Hello, World! hello a</p>", []string{"hello", "world"}},
+		{`<p>buy <a href="https://www.Example.com/path?q=1">here</a></p>`, []string{"link:example.com", "buy", "here"}},
+		{`<a href="/relative">x</a> Привет мир 42`, []string{"привет", "мир", "42"}},
+	}
+	for i, tt := range tbl {
+		tokens, err := Tokenize(tt.text)
+		require.NoError(t, err)
+		assert.Equal(t, tt.tokens, tokens, "case #%d", i)
+	}
+}
+
+func TestService_Probability(t *testing.T) {
+	b, teardown := prepareBoltSpamStorageTest(t)
+	defer teardown()
+	s := Service{Store: b, MinDocs: 5}
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Learn("site", fmt.Sprintf("spam%d", i),
+			fmt.Sprintf(`cheap pills casino bonus <a href="http://spam.example.com/%d">click</a>`, i), true))
+	}
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Learn("site", fmt.Sprintf("ham%d", i), "great episode, thanks for the discussion about go", false))
+	}
+
+	p, err := s.Probability("site", "cheap casino bonus")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, p, "not enough ham documents")
+
+	require.NoError(t, s.LearnNew("site", "ham4", "interesting discussion about rust and go", false))
+	require.NoError(t, s.LearnNew("site", "spam0", "interesting discussion", false), "known document not relabeled")
+
+	p, err = s.Probability("site", `cheap casino <a href="http://spam.example.com">bonus</a>`)
+	require.NoError(t, err)
+	assert.True(t, s.Suspicious(p), "spam probability %v", p)
+
+	p, err = s.Probability("site", "thanks for the episode, the discussion about go was great")
+	require.NoError(t, err)
+	assert.False(t, s.Suspicious(p), "ham probability %v", p)
+	assert.True(t, p < 0.1)
+
+	p, err = s.Probability("site", "completely unknown words")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, p, 0.01, "prior only")
+
+	p, err = s.Probability("other", "cheap casino bonus")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, p, "nothing trained for other site")
+
+	// relabel all spam as ham, classifier should follow
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Learn("site", fmt.Sprintf("spam%d", i), "cheap pills casino bonus", false))
+	}
+	p, err = s.Probability("site", "cheap casino bonus")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, p, "no spam documents left")
+}
+
+func TestService_Suspicious(t *testing.T) {
+	s := Service{}
+	assert.True(t, s.Suspicious(0.95))
+	assert.False(t, s.Suspicious(0.85))
+	s.Threshold = 0.8
+	assert.True(t, s.Suspicious(0.85))
+}
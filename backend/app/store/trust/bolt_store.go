@@ -0,0 +1,102 @@
+package trust
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// versionKey keeps version of site's stats in the site's bucket, can't clash with user ids
+const versionKey = "::version"
+
+// Bolt implements Store with bolt DB. Each site has its own top-level bucket with stats keyed by user id.
+type Bolt struct {
+	fileName string
+	db       *bolt.DB
+}
+
+// NewBoltStorage makes bolt trust store
+func NewBoltStorage(fileName string, options bolt.Options) (*Bolt, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	return &Bolt{db: db, fileName: fileName}, nil
+}
+
+// Get returns stats for the user, empty if nothing stored yet
+func (b *Bolt) Get(siteID, userID string) (stats Stats, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		return b.load(bkt, userID, &stats)
+	})
+	return stats, err
+}
+
+// Update loads stats, calls fn to modify them and saves the result in a single transaction
+func (b *Bolt) Update(siteID, userID string, fn func(stats *Stats)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(siteID))
+		if err != nil {
+			return errors.Wrapf(err, "failed to create bucket %s", siteID)
+		}
+		stats := Stats{}
+		if err = b.load(bkt, userID, &stats); err != nil {
+			return err
+		}
+		fn(&stats)
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return errors.Wrapf(err, "can't marshal stats for %s", userID)
+		}
+		return errors.Wrapf(bkt.Put([]byte(userID), data), "can't put stats for %s", userID)
+	})
+}
+
+// Version returns version of site's stats, 0 if not set yet
+func (b *Bolt) Version(siteID string) (version int, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(siteID))
+		if bkt == nil {
+			return nil
+		}
+		data := bkt.Get([]byte(versionKey))
+		if data == nil {
+			return nil
+		}
+		if version, err = strconv.Atoi(string(data)); err != nil {
+			return errors.Wrapf(err, "can't parse stats version for %s", siteID)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// SetVersion sets version of site's stats
+func (b *Bolt) SetVersion(siteID string, version int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(siteID))
+		if err != nil {
+			return errors.Wrapf(err, "failed to create bucket %s", siteID)
+		}
+		return errors.Wrapf(bkt.Put([]byte(versionKey), []byte(strconv.Itoa(version))), "can't put stats version for %s", siteID)
+	})
+}
+
+// Close bolt store
+func (b *Bolt) Close() error {
+	return errors.Wrapf(b.db.Close(), "failed to close %s", b.fileName)
+}
+
+func (b *Bolt) load(bkt *bolt.Bucket, userID string, stats *Stats) error {
+	data := bkt.Get([]byte(userID))
+	if data == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(data, stats), "can't unmarshal stats for %s", userID)
+}
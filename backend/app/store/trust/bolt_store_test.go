@@ -0,0 +1,79 @@
+package trust
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore_GetUpdate(t *testing.T) {
+	b, teardown := prepareBoltTrustStorageTest(t)
+	defer teardown()
+
+	stats, err := b.Get("site1", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats, "empty stats for unknown site")
+
+	ts := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	err = b.Update("site1", "user1", func(stats *Stats) {
+		stats.FirstSeen = ts
+		stats.Comments = 2
+	})
+	require.NoError(t, err)
+	err = b.Update("site1", "user1", func(stats *Stats) { stats.Score += 5 })
+	require.NoError(t, err)
+
+	stats, err = b.Get("site1", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Comments)
+	assert.Equal(t, 5, stats.Score)
+	assert.True(t, ts.Equal(stats.FirstSeen))
+
+	stats, err = b.Get("site1", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats, "empty stats for unknown user")
+
+	stats, err = b.Get("site2", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats, "stats separated by site")
+
+	assert.NoError(t, b.Close())
+}
+
+func TestBoltStore_Version(t *testing.T) {
+	b, teardown := prepareBoltTrustStorageTest(t)
+	defer teardown()
+
+	version, err := b.Version("site1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, version, "no version for unknown site")
+
+	require.NoError(t, b.SetVersion("site1", 3))
+	version, err = b.Version("site1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	version, err = b.Version("site2")
+	require.NoError(t, err)
+	assert.Equal(t, 0, version, "version separated by site")
+}
+
+func prepareBoltTrustStorageTest(t *testing.T) (b *Bolt, teardown func()) {
+	loc, err := ioutil.TempDir("", "test_trust_r42")
+	require.NoError(t, err, "failed to make temp dir")
+
+	b, err = NewBoltStorage(path.Join(loc, "trust.db"), bolt.Options{})
+	require.NoError(t, err, "new bolt storage")
+
+	teardown = func() {
+		_ = b.Close()
+		assert.NoError(t, os.RemoveAll(loc))
+	}
+	return b, teardown
+}
@@ -0,0 +1,256 @@
+// Package trust calculates users trust levels from their history on the site.
+// Store keeps per-site users stats updated incrementally, Service enclose Store and
+// turns stats into levels and capabilities. Service is the one consumer should use.
+package trust
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Level defines how much the user is trusted on the site
+type Level int
+
+// enum of all trust levels, from the lowest to the highest
+const (
+	LevelNew     Level = 0 // new or misbehaving user, everything restricted
+	LevelBasic   Level = 1 // allowed to post links
+	LevelMember  Level = 2 // allowed to post images and bypass pre-moderation
+	LevelTrusted Level = 3 // all of the above plus extended edit window
+)
+
+// blockPenalty is how many deleted comments a single block counts for
+const blockPenalty = 3
+
+// StatsVersion is the version of stats calculation, should be increased on each change of it.
+// Stats of sites rebuilt by older version (or never rebuilt) considered outdated.
+const StatsVersion = 1
+
+// Stats keeps user's history on the site
+type Stats struct {
+	FirstSeen time.Time `json:"first_seen"`         // time of the first comment on the site
+	Comments  int       `json:"comments"`           // number of active (not deleted) comments
+	Score     int       `json:"score"`              // cumulative score of active comments
+	Deleted   int       `json:"deleted"`            // number of comments deleted by admins
+	Blocks    int       `json:"blocks"`             // number of blocks
+	Override  *Level    `json:"override,omitempty"` // level set by admin, overrides calculated one
+}
+
+// Requirement defines conditions user should meet to reach the level
+type Requirement struct {
+	Age        time.Duration // min time since the first comment
+	Comments   int           // min number of comments
+	Score      int           // min cumulative score
+	MaxPenalty int           // max number of deletions and weighted blocks
+}
+
+// Capabilities defines what user with given level allowed to do
+type Capabilities struct {
+	Links        bool          // can post links
+	Images       bool          // can post images
+	Premoderated bool          // comments hidden until approved by admin
+	EditDuration time.Duration // edit window, 0 means default
+}
+
+// Store defines interface to keep users stats
+type Store interface {
+	Get(siteID, userID string) (Stats, error)                  // get stats, empty for unknown user
+	Update(siteID, userID string, fn func(stats *Stats)) error // atomically load, modify and save stats
+	Version(siteID string) (int, error)                        // get version of site's stats, 0 if never set
+	SetVersion(siteID string, version int) error               // set version of site's stats
+	Close() error
+}
+
+// DefaultRequirements used for LevelBasic, LevelMember and LevelTrusted if Service.Requirements not set
+var DefaultRequirements = []Requirement{
+	{Age: 0, Comments: 3, Score: 0, MaxPenalty: 2},
+	{Age: 7 * 24 * time.Hour, Comments: 10, Score: 5, MaxPenalty: 1},
+	{Age: 30 * 24 * time.Hour, Comments: 50, Score: 25, MaxPenalty: 0},
+}
+
+// Service wraps Store and calculates levels from the stats
+type Service struct {
+	Store         Store
+	Requirements  []Requirement // requirements for levels above LevelNew, in ascending order
+	Premoderation bool          // premoderate comments from users below LevelMember
+	EditDuration  time.Duration // edit window for LevelTrusted users
+}
+
+// Valid checks if level is one of known levels
+func (l Level) Valid() bool {
+	return l >= LevelNew && l <= LevelTrusted
+}
+
+// String returns level's name
+func (l Level) String() string {
+	switch l {
+	case LevelNew:
+		return "new"
+	case LevelBasic:
+		return "basic"
+	case LevelMember:
+		return "member"
+	case LevelTrusted:
+		return "trusted"
+	}
+	return "unknown"
+}
+
+// Level returns calculated or overridden level of the user
+func (s *Service) Level(siteID, userID string) (Level, error) {
+	stats, err := s.Store.Get(siteID, userID)
+	if err != nil {
+		return LevelNew, errors.Wrapf(err, "can't get trust stats for %s", userID)
+	}
+	return s.level(stats, time.Now()), nil
+}
+
+// Capabilities returns what user with given level allowed to do
+func (s *Service) Capabilities(level Level) Capabilities {
+	res := Capabilities{
+		Links:        level >= LevelBasic,
+		Images:       level >= LevelMember,
+		Premoderated: s.Premoderation && level < LevelMember,
+	}
+	if level >= LevelTrusted {
+		res.EditDuration = s.EditDuration
+	}
+	return res
+}
+
+// OnComment records new comment made by the user
+func (s *Service) OnComment(siteID, userID string, ts time.Time) error {
+	return s.Store.Update(siteID, userID, func(stats *Stats) {
+		stats.Comments++
+		if stats.FirstSeen.IsZero() || ts.Before(stats.FirstSeen) {
+			stats.FirstSeen = ts
+		}
+	})
+}
+
+// OnVote records score change of user's comment
+func (s *Service) OnVote(siteID, userID string, delta int) error {
+	return s.Store.Update(siteID, userID, func(stats *Stats) { stats.Score += delta })
+}
+
+// OnDelete records deletion of user's comment with given score. Deletions made by admin count as penalty.
+func (s *Service) OnDelete(siteID, userID string, score int, byAdmin bool) error {
+	return s.Store.Update(siteID, userID, func(stats *Stats) {
+		if stats.Comments > 0 {
+			stats.Comments--
+		}
+		stats.Score -= score
+		if byAdmin {
+			stats.Deleted++
+		}
+	})
+}
+
+// OnBlock records user's block
+func (s *Service) OnBlock(siteID, userID string) error {
+	return s.Store.Update(siteID, userID, func(stats *Stats) { stats.Blocks++ })
+}
+
+// SetOverride sets level for the user regardless of the stats
+func (s *Service) SetOverride(siteID, userID string, level Level) error {
+	if !level.Valid() {
+		return errors.Errorf("invalid trust level %d", level)
+	}
+	return s.Store.Update(siteID, userID, func(stats *Stats) { stats.Override = &level })
+}
+
+// ResetOverride removes level set by admin, level calculated from the stats again
+func (s *Service) ResetOverride(siteID, userID string) error {
+	return s.Store.Update(siteID, userID, func(stats *Stats) { stats.Override = nil })
+}
+
+// Merge adds stats of fromID to toID and clears stats of fromID, the same way as comments of fromID moved to toID.
+// The lower override wins, so restricted user can't escape the restriction by the merge.
+func (s *Service) Merge(siteID, fromID, toID string) error {
+	from, err := s.Store.Get(siteID, fromID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get stats for %s", fromID)
+	}
+	err = s.Store.Update(siteID, toID, func(stats *Stats) {
+		stats.Comments += from.Comments
+		stats.Score += from.Score
+		if !from.FirstSeen.IsZero() && (stats.FirstSeen.IsZero() || from.FirstSeen.Before(stats.FirstSeen)) {
+			stats.FirstSeen = from.FirstSeen
+		}
+		stats.Deleted += from.Deleted
+		stats.Blocks += from.Blocks
+		if from.Override != nil && (stats.Override == nil || *from.Override < *stats.Override) {
+			stats.Override = from.Override
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can't update stats for %s", toID)
+	}
+	return s.Store.Update(siteID, fromID, func(stats *Stats) { *stats = Stats{} })
+}
+
+// Rebuild replaces comments-based stats with values calculated from the full history.
+// Penalties and overrides can't be restored from comments and kept as-is.
+func (s *Service) Rebuild(siteID, userID string, history Stats) error {
+	return s.Store.Update(siteID, userID, func(stats *Stats) {
+		stats.FirstSeen = history.FirstSeen
+		stats.Comments = history.Comments
+		stats.Score = history.Score
+		if history.Blocks > stats.Blocks {
+			stats.Blocks = history.Blocks
+		}
+	})
+}
+
+// Outdated checks if stats of the site never rebuilt or rebuilt by older version of calculation
+func (s *Service) Outdated(siteID string) (bool, error) {
+	version, err := s.Store.Version(siteID)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't get stats version for %s", siteID)
+	}
+	return version < StatsVersion, nil
+}
+
+// MarkRebuilt records stats of the site rebuilt by the current version of calculation
+func (s *Service) MarkRebuilt(siteID string) error {
+	return s.Store.SetVersion(siteID, StatsVersion)
+}
+
+// Invalidate marks stats of the site outdated, i.e. after the site's comments replaced by import
+func (s *Service) Invalidate(siteID string) error {
+	return s.Store.SetVersion(siteID, 0)
+}
+
+// Close store
+func (s *Service) Close() error {
+	return s.Store.Close()
+}
+
+func (s *Service) level(stats Stats, now time.Time) Level {
+	if stats.Override != nil {
+		return *stats.Override
+	}
+
+	reqs := s.Requirements
+	if len(reqs) == 0 {
+		reqs = DefaultRequirements
+	}
+
+	res := LevelNew
+	for i, r := range reqs {
+		if i >= int(LevelTrusted) || !r.met(stats, now) {
+			break
+		}
+		res = Level(i + 1)
+	}
+	return res
+}
+
+func (r Requirement) met(stats Stats, now time.Time) bool {
+	if stats.FirstSeen.IsZero() || now.Sub(stats.FirstSeen) < r.Age {
+		return false
+	}
+	penalty := stats.Deleted + stats.Blocks*blockPenalty
+	return stats.Comments >= r.Comments && stats.Score >= r.Score && penalty <= r.MaxPenalty
+}
@@ -0,0 +1,205 @@
+package trust
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Level(t *testing.T) {
+	now := time.Now()
+	tbl := []struct {
+		stats Stats
+		level Level
+	}{
+		{Stats{}, LevelNew},
+		{Stats{FirstSeen: now, Comments: 2}, LevelNew},
+		{Stats{FirstSeen: now, Comments: 3}, LevelBasic},
+		{Stats{FirstSeen: now, Comments: 3, Score: -1}, LevelNew},
+		{Stats{FirstSeen: now.Add(-8 * 24 * time.Hour), Comments: 10, Score: 5}, LevelMember},
+		{Stats{FirstSeen: now.Add(-6 * 24 * time.Hour), Comments: 10, Score: 5}, LevelBasic},
+		{Stats{FirstSeen: now.Add(-8 * 24 * time.Hour), Comments: 10, Score: 5, Deleted: 2}, LevelBasic},
+		{Stats{FirstSeen: now.Add(-8 * 24 * time.Hour), Comments: 10, Score: 5, Blocks: 1}, LevelNew},
+		{Stats{FirstSeen: now.Add(-31 * 24 * time.Hour), Comments: 50, Score: 25}, LevelTrusted},
+		{Stats{FirstSeen: now.Add(-31 * 24 * time.Hour), Comments: 50, Score: 25, Deleted: 1}, LevelMember},
+		{Stats{Override: levelPtr(LevelTrusted)}, LevelTrusted},
+		{Stats{FirstSeen: now.Add(-31 * 24 * time.Hour), Comments: 50, Score: 25, Override: levelPtr(LevelNew)}, LevelNew},
+	}
+
+	s := Service{}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.level, s.level(tt.stats, now), "case #%d", i)
+	}
+
+	s.Requirements = []Requirement{{Comments: 1}}
+	assert.Equal(t, LevelBasic, s.level(Stats{FirstSeen: now, Comments: 100, Score: 100}, now), "custom requirements")
+}
+
+func TestService_Events(t *testing.T) {
+	b, teardown := prepareBoltTrustStorageTest(t)
+	defer teardown()
+	s := Service{Store: b}
+
+	ts := time.Now().Add(-8 * 24 * time.Hour)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.OnComment("site", "user1", ts.Add(time.Duration(i)*time.Hour)))
+	}
+	require.NoError(t, s.OnVote("site", "user1", 3))
+	require.NoError(t, s.OnVote("site", "user1", 3))
+	require.NoError(t, s.OnVote("site", "user1", -1))
+
+	stats, err := b.Get("site", "user1")
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(stats.FirstSeen))
+	assert.Equal(t, 10, stats.Comments)
+	assert.Equal(t, 5, stats.Score)
+
+	level, err := s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelMember, level)
+
+	require.NoError(t, s.OnDelete("site", "user1", 1, false))
+	level, err = s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelBasic, level, "9 comments with score 4")
+
+	require.NoError(t, s.OnComment("site", "user1", time.Now()))
+	require.NoError(t, s.OnVote("site", "user1", 1))
+	level, err = s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelMember, level)
+
+	require.NoError(t, s.OnDelete("site", "user1", 0, true))
+	require.NoError(t, s.OnComment("site", "user1", time.Now()))
+	level, err = s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelMember, level, "single deletion tolerated")
+
+	require.NoError(t, s.OnBlock("site", "user1"))
+	level, err = s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelNew, level)
+
+	require.NoError(t, s.SetOverride("site", "user1", LevelTrusted))
+	level, err = s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelTrusted, level)
+	assert.Error(t, s.SetOverride("site", "user1", Level(10)))
+
+	require.NoError(t, s.ResetOverride("site", "user1"))
+	level, err = s.Level("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, LevelNew, level)
+}
+
+func TestService_Rebuild(t *testing.T) {
+	b, teardown := prepareBoltTrustStorageTest(t)
+	defer teardown()
+	s := Service{Store: b}
+
+	require.NoError(t, s.OnDelete("site", "user1", 0, true))
+	require.NoError(t, s.SetOverride("site", "user1", LevelBasic))
+
+	ts := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Rebuild("site", "user1", Stats{FirstSeen: ts, Comments: 7, Score: 12, Blocks: 1}))
+
+	stats, err := b.Get("site", "user1")
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(stats.FirstSeen))
+	assert.Equal(t, 7, stats.Comments)
+	assert.Equal(t, 12, stats.Score)
+	assert.Equal(t, 1, stats.Deleted, "penalty kept")
+	assert.Equal(t, 1, stats.Blocks)
+	assert.Equal(t, LevelBasic, *stats.Override, "override kept")
+}
+
+func TestService_Merge(t *testing.T) {
+	b, teardown := prepareBoltTrustStorageTest(t)
+	defer teardown()
+	s := Service{Store: b}
+
+	require.NoError(t, s.OnDelete("site", "user1", 0, true))
+	require.NoError(t, s.OnBlock("site", "user1"))
+	require.NoError(t, s.SetOverride("site", "user1", LevelNew))
+	require.NoError(t, s.OnDelete("site", "user2", 0, true))
+	require.NoError(t, s.SetOverride("site", "user2", LevelTrusted))
+	require.NoError(t, s.SetOverride("site", "user3", LevelMember))
+
+	ts := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.OnComment("site", "user1", ts))
+	require.NoError(t, s.OnComment("site", "user1", ts.Add(time.Hour)))
+	require.NoError(t, s.OnVote("site", "user1", 3))
+	require.NoError(t, s.OnComment("site", "user2", ts.Add(time.Minute)))
+	require.NoError(t, s.OnVote("site", "user2", 1))
+
+	require.NoError(t, s.Merge("site", "user1", "user2"))
+	stats, err := b.Get("site", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Comments, "comments of both users")
+	assert.Equal(t, 4, stats.Score, "score of both users")
+	assert.True(t, ts.Equal(stats.FirstSeen), "the earliest first seen")
+	assert.Equal(t, 2, stats.Deleted)
+	assert.Equal(t, 1, stats.Blocks)
+	assert.Equal(t, LevelNew, *stats.Override, "the lower override wins")
+	stats, err = b.Get("site", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats, "stats of merged user cleared")
+
+	require.NoError(t, s.Merge("site", "user3", "user4"))
+	stats, err = b.Get("site", "user4")
+	require.NoError(t, err)
+	assert.Equal(t, LevelMember, *stats.Override, "override moved")
+	require.NoError(t, s.Merge("site", "user5", "user4"))
+	stats, err = b.Get("site", "user4")
+	require.NoError(t, err)
+	assert.Equal(t, LevelMember, *stats.Override, "override kept")
+}
+
+func TestService_Outdated(t *testing.T) {
+	b, teardown := prepareBoltTrustStorageTest(t)
+	defer teardown()
+	s := Service{Store: b}
+
+	outdated, err := s.Outdated("site")
+	require.NoError(t, err)
+	assert.True(t, outdated, "never rebuilt")
+
+	require.NoError(t, s.MarkRebuilt("site"))
+	outdated, err = s.Outdated("site")
+	require.NoError(t, err)
+	assert.False(t, outdated)
+
+	require.NoError(t, b.SetVersion("site", StatsVersion-1))
+	outdated, err = s.Outdated("site")
+	require.NoError(t, err)
+	assert.True(t, outdated, "rebuilt by older version")
+
+	require.NoError(t, s.MarkRebuilt("site"))
+	require.NoError(t, s.Invalidate("site"))
+	outdated, err = s.Outdated("site")
+	require.NoError(t, err)
+	assert.True(t, outdated, "invalidated")
+}
+
+func TestService_Capabilities(t *testing.T) {
+	s := Service{Premoderation: true, EditDuration: time.Hour}
+	assert.Equal(t, Capabilities{Premoderated: true}, s.Capabilities(LevelNew))
+	assert.Equal(t, Capabilities{Links: true, Premoderated: true}, s.Capabilities(LevelBasic))
+	assert.Equal(t, Capabilities{Links: true, Images: true}, s.Capabilities(LevelMember))
+	assert.Equal(t, Capabilities{Links: true, Images: true, EditDuration: time.Hour}, s.Capabilities(LevelTrusted))
+
+	s.Premoderation = false
+	assert.Equal(t, Capabilities{}, s.Capabilities(LevelNew))
+}
+
+func TestLevel_String(t *testing.T) {
+	assert.Equal(t, "new", LevelNew.String())
+	assert.Equal(t, "trusted", LevelTrusted.String())
+	assert.Equal(t, "unknown", Level(7).String())
+	assert.True(t, LevelMember.Valid())
+	assert.False(t, Level(-1).Valid())
+}
+
+func levelPtr(l Level) *Level { return &l }
@@ -9,21 +9,48 @@ import (
 	"hash/crc64"
 	"io"
 	"regexp"
+	"time"
 
 	log "github.com/go-pkgz/lgr"
 )
 
 // User holds user-related info
 type User struct {
-	Name              string `json:"name"`
-	ID                string `json:"id"`
-	Picture           string `json:"picture"`
-	IP                string `json:"ip,omitempty"`
-	Admin             bool   `json:"admin"`
-	Blocked           bool   `json:"block,omitempty"`
-	Verified          bool   `json:"verified,omitempty"`
-	EmailSubscription bool   `json:"email_subscription,omitempty"`
-	SiteID            string `json:"site_id,omitempty"`
+	Name                 string `json:"name"`
+	ID                   string `json:"id"`
+	Picture              string `json:"picture"`
+	IP                   string `json:"ip,omitempty"`
+	Admin                bool   `json:"admin"`
+	Blocked              bool   `json:"block,omitempty"`
+	Verified             bool   `json:"verified,omitempty"`
+	EmailSubscription    bool   `json:"email_subscription,omitempty"`
+	TelegramSubscription bool   `json:"telegram_subscription,omitempty"`
+	SiteID               string `json:"site_id,omitempty"`
+	TrustLevel           int    `json:"trust_level,omitempty"`
+}
+
+// Follower is the user subscribed to all new comments of the post
+type Follower struct {
+	UserID     string
+	Email      string
+	FollowSite bool // follows the whole site, not the post itself
+}
+
+// PushSubscription is the browser's Web Push subscription, as returned by PushManager.subscribe()
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"` // user agent's public key, base64url encoded P-256 point
+		Auth   string `json:"auth"`   // authentication secret, base64url encoded
+	} `json:"keys"`
+}
+
+// Session is user's login session identified by id of its JWT, the id kept across refreshes of the token
+type Session struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`   // login time
+	Refreshed time.Time `json:"refreshed"` // last time the token issued
+	Expires   time.Time `json:"expires"`   // time the session can't be refreshed after
 }
 
 var reValidSha = regexp.MustCompile("^[a-fA-F0-9]{40}$")
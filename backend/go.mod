@@ -36,4 +36,5 @@ require (
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 	golang.org/x/image v0.0.0-20210504121937-7319ad40d33e
 	golang.org/x/net v0.0.0-20210423184538-5f58ad60dda6
+	golang.org/x/oauth2 v0.0.0-20210427180440-81ed05c6b58c
 )